@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alexciechonski/BigTableLite/pkg/backup"
+	"github.com/alexciechonski/BigTableLite/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const usage = `usage: backup <command> [flags]
+
+Commands:
+  create   back up a shard's data directory
+  list     list the backups of a shard
+  restore  rebuild a shard's data directory from a backup
+  verify   check a backup's files against their checksums
+
+Run "backup <command> -h" for the flags of a command.
+`
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	command := os.Args[1]
+
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	targetDir := flags.String("target", "", "Directory holding the backups")
+	shardID := flags.Int("shard-id", -1, "Shard ID")
+	dataDir := flags.String("data-dir", "./data", "Base data directory; the shard's is <data-dir>/shard<N>")
+	id := flags.Int("id", 0, "Backup ID (for restore and verify)")
+	checkpointAddr := flags.String("checkpoint-addr", "", "Address of a running shard to checkpoint and back up (for create)")
+
+	switch command {
+	case "create", "list", "restore", "verify":
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	flags.Parse(os.Args[2:])
+
+	if *targetDir == "" {
+		log.Fatal("target required")
+	}
+	if *shardID < 0 {
+		log.Fatal("shard-id required")
+	}
+	target, err := backup.NewLocalTarget(*targetDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	shardDir := filepath.Join(*dataDir, fmt.Sprintf("shard%d", *shardID))
+
+	switch command {
+	case "create":
+		src := shardDir
+		if *checkpointAddr != "" {
+			// A running shard's directory changes under the backup, so back
+			// up a checkpoint of it instead
+			src, err = checkpoint(*checkpointAddr, *shardID)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		m, uploaded, err := backup.Create(target, *shardID, src)
+		if *checkpointAddr != "" {
+			os.RemoveAll(src)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Created backup %d of shard %d: %d files, %d uploaded\n", m.ID, m.Shard, len(m.Files), uploaded)
+
+	case "list":
+		backups, err := backup.List(target, *shardID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, m := range backups {
+			var size int64
+			for _, f := range m.Files {
+				size += f.Size
+			}
+			fmt.Printf("%d\t%s\t%d files\t%d bytes\n", m.ID, m.Created.Format(time.RFC3339), len(m.Files), size)
+		}
+
+	case "restore":
+		if *id <= 0 {
+			log.Fatal("id required")
+		}
+		if err := backup.Restore(target, *shardID, *id, shardDir); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Restored backup %d of shard %d to %s\n", *id, *shardID, shardDir)
+
+	case "verify":
+		if *id <= 0 {
+			log.Fatal("id required")
+		}
+		if err := backup.Verify(target, *shardID, *id); err != nil {
+			log.Fatalf("backup %d of shard %d is damaged:\n%v", *id, *shardID, err)
+		}
+		fmt.Printf("Backup %d of shard %d is intact\n", *id, *shardID)
+	}
+}
+
+// checkpoint asks the shard server at addr to checkpoint into a new
+// directory of its checkpoint_dir and returns its path. The backup tool must
+// run on the shard's host to read it.
+func checkpoint(addr string, shardID int) (string, error) {
+	name := fmt.Sprintf("shard%d-%d", shardID, time.Now().UnixNano())
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to shard: %w", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	resp, err := proto.NewBigTableLiteClient(conn).Checkpoint(ctx, &proto.CheckpointRequest{Dir: name})
+	if err != nil {
+		return "", err
+	}
+	if !resp.Success {
+		return "", fmt.Errorf("checkpoint failed: %s", resp.Message)
+	}
+	return resp.Dir, nil
+}
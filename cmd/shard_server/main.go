@@ -15,6 +15,7 @@ import (
 	"github.com/alexciechonski/BigTableLite/pkg/server"
 	"github.com/alexciechonski/BigTableLite/pkg/storage"
 	"github.com/alexciechonski/BigTableLite/proto"
+	"github.com/redis/go-redis/v9"
 )
 
 func CreateDataDirectory(dataBaseDir string, shardID int) (string, string, error) {
@@ -32,6 +33,54 @@ func CreateDataDirectory(dataBaseDir string, shardID int) (string, string, error
 	return shardDir, walFile, nil
 }
 
+// openEngine opens the shard's storage: a Redis server if use_redis is set,
+// otherwise an SSTable engine in shardDir tuned by the config.
+func openEngine(cfg *config.Config, shardDir, walFile string) (storage.Engine, error) {
+	if cfg.UseRedis {
+		return storage.NewRedisEngine(redis.NewClient(&redis.Options{Addr: cfg.RedisAddr})), nil
+	}
+
+	backend, err := storage.ParseBackend(cfg.SSTableBackend)
+	if err != nil {
+		return nil, err
+	}
+	strategy, err := storage.ParseCompactionStrategy(cfg.CompactionStrategy)
+	if err != nil {
+		return nil, err
+	}
+	compression, err := storage.ParseCompression(cfg.BlockCompression)
+	if err != nil {
+		return nil, err
+	}
+	mergeOperator, err := storage.ParseMergeOperator(cfg.MergeOperator)
+	if err != nil {
+		return nil, err
+	}
+	opts := storage.DefaultOptions()
+	opts.Backend = backend
+	opts.CompactionStrategy = strategy
+	opts.BloomBitsPerKey = cfg.BloomBitsPerKey
+	opts.Compression = compression
+	opts.BlockCacheBytes = cfg.BlockCacheBytes
+	opts.MergeOperator = mergeOperator
+	if len(cfg.ColumnFamilies) > 0 {
+		opts.ColumnFamilies = make(map[string]storage.GCPolicy)
+		for family, cf := range cfg.ColumnFamilies {
+			mode, err := storage.ParseGCMode(cf.GCMode)
+			if err != nil {
+				return nil, fmt.Errorf("column family %s: %w", family, err)
+			}
+			opts.ColumnFamilies[family] = storage.GCPolicy{MaxVersions: cf.MaxVersions, MaxAge: cf.MaxAge, Mode: mode}
+		}
+	}
+
+	engine, err := storage.NewSSTableEngineWithOptions(shardDir, walFile, opts)
+	if err != nil {
+		return nil, err
+	}
+	return engine, nil
+}
+
 func main() {
 	shardID := flag.Int("shard-id", -1, "Shard ID")
 	flag.Parse()
@@ -60,14 +109,19 @@ func main() {
 		log.Fatal(err)
 	}
 
-	engine, err := storage.NewSSTableEngine(shardDir, walFile)
-    if err != nil {
-        log.Fatal(err)
-    }
+	engine, err := openEngine(cfg, shardDir, walFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := server.RegisterEngineMetrics(shard.ID, engine); err != nil {
+		log.Printf("failed to register engine metrics: %v", err)
+	}
 
 	kafkaProducer := server.NewKafkaProducer(cfg.KafkaAddress, "db-updates")
 
-	handler := server.NewWithSSTable(engine, kafkaProducer, *shardID)
+	handler := server.New(engine, kafkaProducer, *shardID)
+	handler.SetCheckpointDir(cfg.CheckpointDir)
 
 	grpcSrv := server.NewGRPCServer()
 	proto.RegisterBigTableLiteServer(grpcSrv, handler)
@@ -94,6 +148,7 @@ func main() {
 	log.Println("Shutting down shard")
 
 	grpcSrv.GracefulStop()
+	engine.Close()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	metricsSrv.Shutdown(ctx)
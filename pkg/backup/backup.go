@@ -0,0 +1,306 @@
+// Package backup copies shard data directories to a Target and restores
+// them. SSTables never change once written, so a backup only uploads the
+// files that no earlier backup of the shard holds.
+//
+// A target holds, for each shard N:
+//
+//	shardN/files/<sha256>       the contents of a backed-up file
+//	shardN/backups/<id>.json    the Manifest of backup <id>
+//
+// Files are stored under the SHA-256 of their contents, so a file kept
+// across many backups is stored once, and every restore and verification
+// checks what it reads against that checksum. A backup's manifest is
+// written after all of its files, so an interrupted backup is never listed.
+// Backups of one shard must not run concurrently.
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Manifest lists the files of one backup
+type Manifest struct {
+	ID      int       `json:"id"`
+	Shard   int       `json:"shard"`
+	Created time.Time `json:"created"`
+	Files   []File    `json:"files"`
+}
+
+// File is one file of a backed-up data directory
+type File struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func objectName(shard int, sum string) string {
+	return fmt.Sprintf("shard%d/files/%s", shard, sum)
+}
+
+func manifestPrefix(shard int) string {
+	return fmt.Sprintf("shard%d/backups/", shard)
+}
+
+func manifestName(shard, id int) string {
+	return fmt.Sprintf("%s%06d.json", manifestPrefix(shard), id)
+}
+
+// Create backs up every file of the data directory dir as the next backup
+// of shard, numbered one past the newest, and reports how many files it
+// uploaded. dir must not change while it is read: back up a stopped shard,
+// or a checkpoint of a running one.
+func Create(target Target, shard int, dir string) (*Manifest, int, error) {
+	backups, err := List(target, shard)
+	if err != nil {
+		return nil, 0, err
+	}
+	held := make(map[string]bool)
+	id := 1
+	for _, m := range backups {
+		for _, f := range m.Files {
+			held[f.SHA256] = true
+		}
+		id = max(id, m.ID+1)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	manifest := &Manifest{ID: id, Shard: shard, Created: time.Now().UTC()}
+	uploaded := 0
+	for _, entry := range entries {
+		// Temporary files belong to writes that never committed
+		if !entry.Type().IsRegular() || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		file, err := checksumFile(path)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !held[file.SHA256] {
+			if err := upload(target, shard, path, file); err != nil {
+				return nil, 0, fmt.Errorf("cannot upload %s: %w", path, err)
+			}
+			held[file.SHA256] = true
+			uploaded++
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+	if len(manifest.Files) == 0 {
+		return nil, 0, fmt.Errorf("no files to back up in %s", dir)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := target.Put(manifestName(shard, id), bytes.NewReader(data)); err != nil {
+		return nil, 0, err
+	}
+	return manifest, uploaded, nil
+}
+
+// List returns the backups of shard, oldest first
+func List(target Target, shard int) ([]*Manifest, error) {
+	names, err := target.List(manifestPrefix(shard))
+	if err != nil {
+		return nil, err
+	}
+	var backups []*Manifest
+	for _, name := range names {
+		m, err := readManifest(target, name)
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, m)
+	}
+	return backups, nil
+}
+
+// Load returns the manifest of backup id of shard
+func Load(target Target, shard, id int) (*Manifest, error) {
+	return readManifest(target, manifestName(shard, id))
+}
+
+func readManifest(target Target, name string) (*Manifest, error) {
+	r, err := target.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	m := &Manifest{}
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, fmt.Errorf("corrupt backup manifest %s: %w", name, err)
+	}
+	for _, f := range m.Files {
+		if f.Name == "" || f.Name == "." || f.Name == ".." || filepath.Base(f.Name) != f.Name {
+			return nil, fmt.Errorf("corrupt backup manifest %s: invalid file name %q", name, f.Name)
+		}
+	}
+	return m, nil
+}
+
+// Restore rebuilds the data directory dest, which must not exist yet, from
+// backup id of shard. Every file is checked against its checksum as it is
+// copied. The directory is assembled in a new temporary directory next to
+// dest and renamed into place, so a failed restore leaves dest missing.
+func Restore(target Target, shard, id int, dest string) error {
+	m, err := Load(target, shard, id)
+	if err != nil {
+		return err
+	}
+
+	dest = filepath.Clean(dest)
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("restore directory %s already exists", dest)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dest), filepath.Base(dest)+".tmp-")
+	if err != nil {
+		return err
+	}
+	// MkdirTemp creates the directory private to the user
+	if err := os.Chmod(tmp, 0755); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+
+	for _, f := range m.Files {
+		if err := restoreFile(target, shard, f, filepath.Join(tmp, f.Name)); err != nil {
+			os.RemoveAll(tmp)
+			return fmt.Errorf("cannot restore %s: %w", f.Name, err)
+		}
+	}
+	if err := syncDir(tmp); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(dest))
+}
+
+func restoreFile(target Target, shard int, f File, path string) error {
+	r, err := target.Get(objectName(shard, f.SHA256))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, newVerifyingReader(r, f))
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Verify reads every file of backup id of shard from the target and checks
+// its size and checksum, reporting every file that is missing or damaged.
+func Verify(target Target, shard, id int) error {
+	m, err := Load(target, shard, id)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, f := range m.Files {
+		if err := verifyFile(target, shard, f); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func verifyFile(target Target, shard int, f File) error {
+	r, err := target.Get(objectName(shard, f.SHA256))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	_, err = io.Copy(io.Discard, newVerifyingReader(r, f))
+	return err
+}
+
+// checksumFile sizes and hashes the file at path
+func checksumFile(path string) (File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return File{}, err
+	}
+	return File{Name: filepath.Base(path), Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// upload stores the file at path, failing rather than storing contents
+// that no longer match the checksum taken before
+func upload(target Target, shard int, path string, file File) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return target.Put(objectName(shard, file.SHA256), newVerifyingReader(f, file))
+}
+
+// verifyingReader passes a file's contents through, failing at the end
+// instead of returning io.EOF if they do not match the file's size and
+// checksum
+type verifyingReader struct {
+	r    io.Reader
+	want File
+	hash hash.Hash
+	size int64
+}
+
+func newVerifyingReader(r io.Reader, want File) *verifyingReader {
+	return &verifyingReader{r: r, want: want, hash: sha256.New()}
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.hash.Write(p[:n])
+	v.size += int64(n)
+	if err == io.EOF {
+		if v.size != v.want.Size {
+			return n, fmt.Errorf("size mismatch: expected %d bytes, got %d", v.want.Size, v.size)
+		}
+		if sum := hex.EncodeToString(v.hash.Sum(nil)); sum != v.want.SHA256 {
+			return n, fmt.Errorf("checksum mismatch: expected %s, got %s", v.want.SHA256, sum)
+		}
+	}
+	return n, err
+}
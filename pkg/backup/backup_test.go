@@ -0,0 +1,206 @@
+package backup
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alexciechonski/BigTableLite/pkg/storage"
+)
+
+// checkpointShard writes keys to a shard engine, flushing after each one so
+// every key lands in its own SSTable, and checkpoints it into a new directory
+func checkpointShard(t *testing.T, engine *storage.SSTableEngine, keys ...string) string {
+	t.Helper()
+	for _, key := range keys {
+		if err := engine.Put([]byte(key), []byte("value of "+key)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := engine.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	dir := filepath.Join(t.TempDir(), "checkpoint")
+	if err := engine.Checkpoint(dir); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	return dir
+}
+
+func expectRestored(t *testing.T, target Target, id int, present, absent []string) {
+	t.Helper()
+	dest := filepath.Join(t.TempDir(), "shard0")
+	if err := Restore(target, 0, id, dest); err != nil {
+		t.Fatalf("Restore of backup %d failed: %v", id, err)
+	}
+	engine, err := storage.NewSSTableEngine(dest, filepath.Join(dest, "wal.log"))
+	if err != nil {
+		t.Fatalf("failed to open restored shard: %v", err)
+	}
+	defer engine.DestroySSTableEngine()
+	for _, key := range present {
+		if value, found, err := engine.Get([]byte(key)); err != nil || !found || string(value) != "value of "+key {
+			t.Errorf("backup %d: expected %s, got %q, %v, %v", id, key, value, found, err)
+		}
+	}
+	for _, key := range absent {
+		if _, found, _ := engine.Get([]byte(key)); found {
+			t.Errorf("backup %d: did not expect %s", id, key)
+		}
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	engine, err := storage.NewSSTableEngine(dir, filepath.Join(dir, "wal.log"))
+	if err != nil {
+		t.Fatalf("failed to create SSTable engine: %v", err)
+	}
+	defer engine.DestroySSTableEngine()
+	target, err := NewLocalTarget(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalTarget failed: %v", err)
+	}
+
+	first, uploaded, err := Create(target, 0, checkpointShard(t, engine, "a", "b"))
+	if err != nil {
+		t.Fatalf("first backup failed: %v", err)
+	}
+	if first.ID != 1 || uploaded != len(first.Files) {
+		t.Fatalf("expected backup 1 to upload all %d files, got ID %d and %d uploads", len(first.Files), first.ID, uploaded)
+	}
+
+	// The second backup shares the tables of a and b and the empty WAL with
+	// the first, and only uploads the new table and the MANIFEST
+	second, uploaded, err := Create(target, 0, checkpointShard(t, engine, "c"))
+	if err != nil {
+		t.Fatalf("second backup failed: %v", err)
+	}
+	if second.ID != 2 || uploaded != 2 {
+		t.Fatalf("expected backup 2 to upload 2 files, got ID %d and %d uploads", second.ID, uploaded)
+	}
+
+	backups, err := List(target, 0)
+	if err != nil || len(backups) != 2 || backups[0].ID != 1 || backups[1].ID != 2 {
+		t.Fatalf("unexpected backups: %v, %v", backups, err)
+	}
+	for _, id := range []int{1, 2} {
+		if err := Verify(target, 0, id); err != nil {
+			t.Errorf("Verify of backup %d failed: %v", id, err)
+		}
+	}
+
+	expectRestored(t, target, 1, []string{"a", "b"}, []string{"c"})
+	expectRestored(t, target, 2, []string{"a", "b", "c"}, nil)
+
+	// A path that merely looks like a staging directory is left alone
+	dest := filepath.Join(t.TempDir(), "shard0")
+	bystander := filepath.Join(dest+".tmp", "file")
+	if err := os.MkdirAll(filepath.Dir(bystander), 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := os.WriteFile(bystander, []byte("keep"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := Restore(target, 0, 2, dest); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if _, err := os.Stat(bystander); err != nil {
+		t.Errorf("Restore removed %s: %v", bystander, err)
+	}
+	if matches, _ := filepath.Glob(dest + ".tmp-*"); len(matches) != 0 {
+		t.Errorf("Restore left staging directories behind: %v", matches)
+	}
+
+	if err := Restore(target, 0, 3, filepath.Join(t.TempDir(), "shard0")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected restoring a missing backup to fail with ErrNotExist, got %v", err)
+	}
+	if err := Restore(target, 0, 1, t.TempDir()); err == nil {
+		t.Error("expected restoring over an existing directory to fail")
+	}
+}
+
+func TestVerifyDetectsDamage(t *testing.T) {
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "sstable_0001.sst"), []byte("table"), 0644)
+	os.WriteFile(filepath.Join(src, "MANIFEST"), []byte("manifest"), 0644)
+	os.WriteFile(filepath.Join(src, "MANIFEST.tmp"), []byte("uncommitted"), 0644)
+
+	root := t.TempDir()
+	target, err := NewLocalTarget(root)
+	if err != nil {
+		t.Fatalf("NewLocalTarget failed: %v", err)
+	}
+	m, _, err := Create(target, 3, src)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if len(m.Files) != 2 {
+		t.Fatalf("expected temporary files to be skipped, got %v", m.Files)
+	}
+
+	// Damage the table's object
+	var table File
+	for _, f := range m.Files {
+		if f.Name == "sstable_0001.sst" {
+			table = f
+		}
+	}
+	object := filepath.Join(root, filepath.FromSlash(objectName(3, table.SHA256)))
+	if err := os.WriteFile(object, []byte("tablf"), 0644); err != nil {
+		t.Fatalf("failed to damage backup: %v", err)
+	}
+
+	err = Verify(target, 3, m.ID)
+	if err == nil || !strings.Contains(err.Error(), "sstable_0001.sst: checksum mismatch") {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+	dest := filepath.Join(t.TempDir(), "shard3")
+	if err := Restore(target, 3, m.ID, dest); err == nil {
+		t.Fatal("expected restoring a damaged backup to fail")
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("expected a failed restore to leave no directory, got %v", err)
+	}
+	if matches, _ := filepath.Glob(dest + ".tmp-*"); len(matches) != 0 {
+		t.Errorf("expected a failed restore to remove its staging directory, got %v", matches)
+	}
+}
+
+func TestLocalTarget(t *testing.T) {
+	target, err := NewLocalTarget(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalTarget failed: %v", err)
+	}
+
+	for _, name := range []string{"a/x", "a/y", "b/z"} {
+		if err := target.Put(name, strings.NewReader(name)); err != nil {
+			t.Fatalf("Put(%s) failed: %v", name, err)
+		}
+	}
+	names, err := target.List("a/")
+	if err != nil || strings.Join(names, ",") != "a/x,a/y" {
+		t.Fatalf("expected a/x and a/y, got %v, %v", names, err)
+	}
+
+	r, err := target.Get("b/z")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "b/z" {
+		t.Errorf("expected b/z, got %q", data)
+	}
+
+	if _, err := target.Get("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+	if err := target.Put("../escape", strings.NewReader("")); err == nil {
+		t.Error("expected a name outside the target to be rejected")
+	}
+}
@@ -0,0 +1,130 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Target stores backups as named objects. Names are slash-separated paths
+// such as "shard0/files/<sha256>". Objects are written once and never
+// modified, so a target only needs whole-object puts and gets.
+type Target interface {
+	// Put stores the contents of r as the object name, replacing any
+	// object of that name. A failed Put leaves no partial object behind.
+	Put(name string, r io.Reader) error
+
+	// Get opens the object name. The error wraps fs.ErrNotExist if there
+	// is no such object.
+	Get(name string) (io.ReadCloser, error)
+
+	// List returns the names of the objects starting with prefix, sorted
+	List(prefix string) ([]string, error)
+}
+
+// LocalTarget is a Target in a directory of the local filesystem, with one
+// file per object.
+type LocalTarget struct {
+	root string
+}
+
+var _ Target = (*LocalTarget)(nil)
+
+// NewLocalTarget stores backups under root, creating it if needed.
+func NewLocalTarget(root string) (*LocalTarget, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &LocalTarget{root: root}, nil
+}
+
+// path maps an object name to its file, rejecting names that would escape
+// the root
+func (t *LocalTarget) path(name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if name == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid object name %q", name)
+	}
+	return filepath.Join(t.root, clean), nil
+}
+
+// Put writes the object to a temporary file, syncs it and renames it into
+// place.
+func (t *LocalTarget) Put(name string, r io.Reader) error {
+	path, err := t.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func (t *LocalTarget) Get(name string) (io.ReadCloser, error) {
+	path, err := t.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (t *LocalTarget) List(prefix string) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(t.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(t.root, path)
+		if err != nil {
+			return err
+		}
+		if name := filepath.ToSlash(rel); strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	sort.Strings(names)
+	return names, err
+}
+
+// syncDir fsyncs a directory so entries added to it survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
+	"encoding/binary"
 	"flag"
 	"fmt"
 	"log"
+	"strconv"
 	"time"
 
 	"github.com/alexciechonski/BigTableLite/proto"
@@ -24,9 +26,15 @@ func closeConnections(conns map[int]*grpc.ClientConn) {
 }
 
 func main() {
-	operation := flag.String("op", "get", "Operation: 'set' or 'get'")
-	key := flag.String("key", "test", "Key")
-	value := flag.String("value", "hello", "Value (for set operation)")
+	operation := flag.String("op", "get", "Operation: 'set', 'get', 'delete', 'deleterange', 'merge', 'setcell' or 'readrow'")
+	key := flag.String("key", "test", "Key (the row key for setcell and readrow, the start of the range for deleterange)")
+	end := flag.String("end", "", "End of the range deleterange deletes, exclusive")
+	value := flag.String("value", "hello", "Value (for set), or operand (for merge): an integer, sent as 8 bytes big-endian for int64add, unless -raw is set")
+	raw := flag.Bool("raw", false, "Send the merge operand as the bytes of -value (for stringappend and max)")
+	family := flag.String("family", "", "Column family (for setcell, and to restrict readrow)")
+	qualifier := flag.String("qualifier", "", "Column qualifier (for setcell)")
+	ttl := flag.Duration("ttl", 0, "Time after which a set expires; 0 keeps it until deleted")
+	latest := flag.Int("latest", 0, "Versions of each column readrow returns; 0 returns all of them")
 	flag.Parse()
 
 	// load config
@@ -78,8 +86,9 @@ func main() {
 	switch *operation {
 	case "set":
 		resp, err := client.Set(ctx, &proto.SetRequest{
-			Key:   *key,
-			Value: *value,
+			Key:       []byte(*key),
+			Value:     []byte(*value),
+			TtlMillis: ttl.Milliseconds(),
 		})
 		if err != nil {
 			log.Fatalf("Set failed: %v", err)
@@ -88,7 +97,7 @@ func main() {
 
 	case "get":
 		resp, err := client.Get(ctx, &proto.GetRequest{
-			Key: *key,
+			Key: []byte(*key),
 		})
 		if err != nil {
 			log.Fatalf("Get failed: %v", err)
@@ -100,7 +109,7 @@ func main() {
 		}
 	
 	case "delete":
-		resp, err := client.Delete(ctx, &proto.DeleteRequest{Key: *key})
+		resp, err := client.Delete(ctx, &proto.DeleteRequest{Key: []byte(*key)})
 		if err != nil {
 			log.Fatalf("Delete failed: %v", err)
 		}
@@ -111,7 +120,71 @@ func main() {
 			fmt.Printf("Delete response: Success=false, Message=%s\n", resp.Message)
 		}
 
+	case "deleterange":
+		// Keys are sharded by hash, so every shard may hold part of the range
+		for _, sd := range cluster.Shards {
+			resp, err := clients[sd.ID].DeleteRange(ctx, &proto.DeleteRangeRequest{
+				StartKey: []byte(*key),
+				EndKey:   []byte(*end),
+			})
+			if err != nil {
+				log.Fatalf("DeleteRange on shard %d failed: %v", sd.ID, err)
+			}
+			fmt.Printf("DeleteRange response from shard %d: Success=%v, Message=%s\n", sd.ID, resp.Success, resp.Message)
+		}
+
+	case "merge":
+		operand := []byte(*value)
+		if !*raw {
+			n, err := strconv.ParseInt(*value, 10, 64)
+			if err != nil {
+				log.Fatalf("merge operand %q is not an integer; use -raw to send it as bytes", *value)
+			}
+			operand = binary.BigEndian.AppendUint64(nil, uint64(n))
+		}
+		resp, err := client.Merge(ctx, &proto.MergeRequest{
+			Key:     []byte(*key),
+			Operand: operand,
+		})
+		if err != nil {
+			log.Fatalf("Merge failed: %v", err)
+		}
+		fmt.Printf("Merge response: Success=%v, Message=%s\n", resp.Success, resp.Message)
+
+	case "setcell":
+		resp, err := client.MutateRow(ctx, &proto.MutateRowRequest{
+			RowKey: []byte(*key),
+			Mutations: []*proto.Mutation{{
+				Type:            proto.MutationType_SET_CELL,
+				Family:          *family,
+				Qualifier:       []byte(*qualifier),
+				TimestampMicros: -1,
+				Value:           []byte(*value),
+			}},
+		})
+		if err != nil {
+			log.Fatalf("MutateRow failed: %v", err)
+		}
+		fmt.Printf("MutateRow response: Success=%v, Message=%s\n", resp.Success, resp.Message)
+
+	case "readrow":
+		req := &proto.ReadRowRequest{RowKey: []byte(*key), LatestN: int32(*latest)}
+		if *family != "" {
+			req.Families = []string{*family}
+		}
+		resp, err := client.ReadRow(ctx, req)
+		if err != nil {
+			log.Fatalf("ReadRow failed: %v", err)
+		}
+		if !resp.Found {
+			fmt.Printf("ReadRow response: Found=false, Message=%s\n", resp.Message)
+			break
+		}
+		for _, cell := range resp.Cells {
+			fmt.Printf("%s:%s @%d = %s\n", cell.Family, cell.Qualifier, cell.TimestampMicros, cell.Value)
+		}
+
 	default:
-		log.Fatalf("Unknown operation: %s. Use 'set' or 'get'", *operation)
+		log.Fatalf("Unknown operation: %s. Use 'set', 'get', 'delete', 'deleterange', 'merge', 'setcell' or 'readrow'", *operation)
 	}
 }
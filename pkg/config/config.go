@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"time"
     "path/filepath"
 
 	"gopkg.in/yaml.v3"
@@ -18,6 +19,50 @@ type Config struct {
     ShardCount      int    `yaml:"shard_count"`
     ShardConfigPath string `yaml:"shard_config_path"`
     KafkaAddress    string `yaml:"kafka_address"`
+
+    // SSTableBackend is the storage engine implementation: "cpp" (default
+    // in cgo builds) or "go". Both read and write the same data directory.
+    SSTableBackend string `yaml:"sstable_backend"`
+
+    // CompactionStrategy is "size-tiered" (default) or "leveled"
+    CompactionStrategy string `yaml:"compaction_strategy"`
+
+    // BloomBitsPerKey sizes per-SSTable Bloom filters; 0 keeps the default
+    // and a negative value disables them
+    BloomBitsPerKey int `yaml:"bloom_bits_per_key"`
+
+    // BlockCompression is the SSTable block codec: "none" (default) or "zlib"
+    BlockCompression string `yaml:"block_compression"`
+
+    // BlockCacheBytes is the per-shard block cache budget; 0 keeps the
+    // default and a negative value disables the cache
+    BlockCacheBytes int64 `yaml:"block_cache_bytes"`
+
+    // MergeOperator is the built-in operator the Merge RPC folds operands
+    // with: "int64add", "stringappend" or "max". Empty disables Merge.
+    MergeOperator string `yaml:"merge_operator"`
+
+    // CheckpointDir is the only directory the Checkpoint RPC writes into;
+    // it must be on the same filesystem as DataDir. Empty disables the RPC.
+    CheckpointDir string `yaml:"checkpoint_dir"`
+
+    // ColumnFamilies sets the garbage-collection policy of cell versions by
+    // column family name; families not listed keep every version
+    ColumnFamilies map[string]ColumnFamilyConfig `yaml:"column_families"`
+}
+
+// ColumnFamilyConfig is the GC policy of one column family
+type ColumnFamilyConfig struct {
+    // MaxVersions keeps the newest versions of each column; 0 sets no limit
+    MaxVersions int `yaml:"max_versions"`
+
+    // MaxAge keeps versions younger than a duration such as "168h"; 0 sets
+    // no limit
+    MaxAge time.Duration `yaml:"max_age"`
+
+    // GCMode is "union" (default), collecting versions past either limit,
+    // or "intersection", collecting only those past both
+    GCMode string `yaml:"gc_mode"`
 }
 
 func Load() (*Config, error) {
@@ -62,6 +107,11 @@ func (c *Config) ApplyEnv() {
     override("REDIS_ADDR", &c.RedisAddr)
     override("SHARD_CONFIG_PATH", &c.ShardConfigPath)
     override("KAFKA_ADDRESS", &c.KafkaAddress)
+    override("SSTABLE_BACKEND", &c.SSTableBackend)
+    override("COMPACTION_STRATEGY", &c.CompactionStrategy)
+    override("BLOCK_COMPRESSION", &c.BlockCompression)
+    override("MERGE_OPERATOR", &c.MergeOperator)
+    override("CHECKPOINT_DIR", &c.CheckpointDir)
 
     if v, ok := os.LookupEnv("SHARD_COUNT"); ok {
         if i, err := strconv.Atoi(v); err == nil {
@@ -69,6 +119,18 @@ func (c *Config) ApplyEnv() {
         }
     }
 
+    if v, ok := os.LookupEnv("BLOOM_BITS_PER_KEY"); ok {
+        if i, err := strconv.Atoi(v); err == nil {
+            c.BloomBitsPerKey = i
+        }
+    }
+
+    if v, ok := os.LookupEnv("BLOCK_CACHE_BYTES"); ok {
+        if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+            c.BlockCacheBytes = i
+        }
+    }
+
     if v, ok := os.LookupEnv("USE_REDIS"); ok {
         c.UseRedis = (v == "true" || v == "1")
     }
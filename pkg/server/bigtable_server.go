@@ -2,46 +2,60 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/alexciechonski/BigTableLite/pkg/storage"
 	"github.com/alexciechonski/BigTableLite/proto"
-	"github.com/redis/go-redis/v9"
 )
 
+// errCellKey rejects flat writes to the keys MutateRow stores cells under,
+// which would corrupt the cells ReadRow returns
+var errCellKey = errors.New("keys starting with \\x00c are reserved for cells; use MutateRow")
+
 type BigTableLiteServer struct {
 	proto.UnimplementedBigTableLiteServer
-	engine *storage.SSTableEngine
+	engine   storage.Engine
 	producer *KafkaProducer
-	redis  *redis.Client
-	shardID int
+	shardID  int
+
+	// checkpointDir is the directory Checkpoint writes into; empty
+	// disables checkpoints
+	checkpointDir string
 }
 
-func NewWithSSTable(engine *storage.SSTableEngine, producer *KafkaProducer, shardID int) *BigTableLiteServer {
+// New serves the RPCs from engine. Writes are published to producer if it
+// is not nil.
+func New(engine storage.Engine, producer *KafkaProducer, shardID int) *BigTableLiteServer {
 	return &BigTableLiteServer{
-        engine:   engine,
-        producer: producer,
-        shardID:  shardID,
-    }
+		engine:   engine,
+		producer: producer,
+		shardID:  shardID,
+	}
 }
 
-func NewWithRedis(redis *redis.Client) *BigTableLiteServer {
-	return &BigTableLiteServer{redis: redis}
+// SetCheckpointDir lets the Checkpoint RPC write checkpoints into new
+// directories under dir, and nowhere else.
+func (s *BigTableLiteServer) SetCheckpointDir(dir string) {
+	s.checkpointDir = dir
 }
 
 func (s *BigTableLiteServer) Set(ctx context.Context, req *proto.SetRequest) (*proto.SetResponse, error) {
 	start := time.Now()
 	defer ObserveLatency("Set", start)
 
-	var err error
-	if s.redis != nil {
-		err = s.redis.Set(ctx, req.Key, req.Value, 0).Err()
-	} else {
-		err = s.engine.Put(req.Key, req.Value)
+	if storage.IsCellKey(req.Key) {
+		IncError("Set")
+		return &proto.SetResponse{Success: false, Message: errCellKey.Error()}, nil
 	}
 
+	err := s.engine.PutWithTTL(req.Key, req.Value, time.Duration(req.TtlMillis)*time.Millisecond)
+
 	if s.producer != nil {
-        go s.producer.PublishEvent(s.shardID, "SET", req.Key, req.Value)
+        go s.producer.PublishEvent(s.shardID, "SET", string(req.Key), string(req.Value))
     }
 
 	if err != nil {
@@ -57,20 +71,6 @@ func (s *BigTableLiteServer) Get(ctx context.Context, req *proto.GetRequest) (*p
 	start := time.Now()
 	defer ObserveLatency("Get", start)
 
-	if s.redis != nil {
-		val, err := s.redis.Get(ctx, req.Key).Result()
-		if err == redis.Nil {
-			IncNotFound("Get")
-			return &proto.GetResponse{Found: false}, nil
-		}
-		if err != nil {
-			IncError("Get")
-			return &proto.GetResponse{Found: false}, nil
-		}
-		IncSuccess("Get")
-		return &proto.GetResponse{Found: true, Value: val}, nil
-	}
-
 	val, found, err := s.engine.Get(req.Key)
 	if err != nil {
 		IncError("Get")
@@ -89,13 +89,13 @@ func (s *BigTableLiteServer) Delete(ctx context.Context, req *proto.DeleteReques
 	start := time.Now()
 	defer ObserveLatency("Delete", start)
 
-	var err error
-	if s.redis != nil {
-		_, err = s.redis.Del(ctx, req.Key).Result()
-	} else {
-		err = s.engine.Delete(req.Key)
+	if storage.IsCellKey(req.Key) {
+		IncError("Delete")
+		return &proto.DeleteResponse{Success: false, Message: errCellKey.Error()}, nil
 	}
 
+	err := s.engine.Delete(req.Key)
+
 	if err != nil {
 		IncError("Delete")
 		return &proto.DeleteResponse{Success: false}, nil
@@ -104,3 +104,191 @@ func (s *BigTableLiteServer) Delete(ctx context.Context, req *proto.DeleteReques
 	IncSuccess("Delete")
 	return &proto.DeleteResponse{Success: true}, nil
 }
+
+func (s *BigTableLiteServer) DeleteRange(ctx context.Context, req *proto.DeleteRangeRequest) (*proto.DeleteRangeResponse, error) {
+	start := time.Now()
+	defer ObserveLatency("DeleteRange", start)
+
+	if storage.OverlapsCells(req.StartKey, req.EndKey) {
+		IncError("DeleteRange")
+		return &proto.DeleteRangeResponse{Success: false, Message: "range overlaps the keys reserved for cells; delete cells with MutateRow"}, nil
+	}
+
+	if err := s.engine.DeleteRange(req.StartKey, req.EndKey); err != nil {
+		IncError("DeleteRange")
+		return &proto.DeleteRangeResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	IncSuccess("DeleteRange")
+	return &proto.DeleteRangeResponse{Success: true}, nil
+}
+
+// merger is implemented by engines that fold operands into values with a
+// merge operator
+type merger interface {
+	Merge(key, operand []byte) error
+}
+
+func (s *BigTableLiteServer) Merge(ctx context.Context, req *proto.MergeRequest) (*proto.MergeResponse, error) {
+	start := time.Now()
+	defer ObserveLatency("Merge", start)
+
+	engine, ok := s.engine.(merger)
+	if !ok {
+		IncError("Merge")
+		return &proto.MergeResponse{Success: false, Message: "storage engine does not support merge"}, nil
+	}
+	if storage.IsCellKey(req.Key) {
+		IncError("Merge")
+		return &proto.MergeResponse{Success: false, Message: errCellKey.Error()}, nil
+	}
+	if err := engine.Merge(req.Key, req.Operand); err != nil {
+		IncError("Merge")
+		return &proto.MergeResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	IncSuccess("Merge")
+	return &proto.MergeResponse{Success: true}, nil
+}
+
+// mutationFromProto converts a MutateRow mutation to the storage one
+func mutationFromProto(m *proto.Mutation) (storage.Mutation, error) {
+	switch m.Type {
+	case proto.MutationType_SET_CELL:
+		return storage.SetCell(m.Family, m.Qualifier, m.TimestampMicros, m.Value), nil
+	case proto.MutationType_DELETE_CELL:
+		return storage.DeleteCell(m.Family, m.Qualifier, m.TimestampMicros), nil
+	case proto.MutationType_DELETE_COLUMN:
+		return storage.DeleteColumn(m.Family, m.Qualifier), nil
+	case proto.MutationType_DELETE_FAMILY:
+		return storage.DeleteFamily(m.Family), nil
+	case proto.MutationType_DELETE_ROW:
+		return storage.DeleteRow(), nil
+	}
+	return storage.Mutation{}, fmt.Errorf("unknown mutation type %d", m.Type)
+}
+
+func (s *BigTableLiteServer) MutateRow(ctx context.Context, req *proto.MutateRowRequest) (*proto.MutateRowResponse, error) {
+	start := time.Now()
+	defer ObserveLatency("MutateRow", start)
+
+	mutations := make([]storage.Mutation, 0, len(req.Mutations))
+	for _, m := range req.Mutations {
+		mutation, err := mutationFromProto(m)
+		if err != nil {
+			IncError("MutateRow")
+			return &proto.MutateRowResponse{Success: false, Message: err.Error()}, nil
+		}
+		mutations = append(mutations, mutation)
+	}
+
+	if err := storage.MutateRow(s.engine, req.RowKey, mutations); err != nil {
+		IncError("MutateRow")
+		return &proto.MutateRowResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	IncSuccess("MutateRow")
+	return &proto.MutateRowResponse{Success: true}, nil
+}
+
+func (s *BigTableLiteServer) ReadRow(ctx context.Context, req *proto.ReadRowRequest) (*proto.ReadRowResponse, error) {
+	start := time.Now()
+	defer ObserveLatency("ReadRow", start)
+
+	filter := &storage.RowFilter{
+		Families:       req.Families,
+		StartTimestamp: req.StartTimestampMicros,
+		EndTimestamp:   req.EndTimestampMicros,
+		LatestN:        int(req.LatestN),
+	}
+	for _, c := range req.Columns {
+		filter.Columns = append(filter.Columns, storage.Column{Family: c.Family, Qualifier: c.Qualifier})
+	}
+
+	cells, err := storage.ReadRow(s.engine, req.RowKey, filter)
+	if err != nil {
+		IncError("ReadRow")
+		return &proto.ReadRowResponse{Found: false, Message: err.Error()}, nil
+	}
+	if len(cells) == 0 {
+		IncNotFound("ReadRow")
+		return &proto.ReadRowResponse{Found: false}, nil
+	}
+
+	resp := &proto.ReadRowResponse{Found: true}
+	for _, c := range cells {
+		resp.Cells = append(resp.Cells, &proto.Cell{
+			Family:          c.Family,
+			Qualifier:       c.Qualifier,
+			TimestampMicros: c.Timestamp,
+			Value:           c.Value,
+		})
+	}
+	IncSuccess("ReadRow")
+	return resp, nil
+}
+
+// checkpointer is implemented by engines that can write a copy of their
+// data to a directory
+type checkpointer interface {
+	Checkpoint(dir string) error
+}
+
+func (s *BigTableLiteServer) Checkpoint(ctx context.Context, req *proto.CheckpointRequest) (*proto.CheckpointResponse, error) {
+	start := time.Now()
+	defer ObserveLatency("Checkpoint", start)
+
+	engine, ok := s.engine.(checkpointer)
+	if !ok {
+		IncError("Checkpoint")
+		return &proto.CheckpointResponse{Success: false, Message: "storage engine does not support checkpoints"}, nil
+	}
+	if s.checkpointDir == "" {
+		IncError("Checkpoint")
+		return &proto.CheckpointResponse{Success: false, Message: "checkpoints are disabled: no checkpoint_dir configured"}, nil
+	}
+	// The caller only names a directory inside checkpointDir, so it cannot
+	// make the server write anywhere else
+	if req.Dir == "" || !filepath.IsLocal(req.Dir) {
+		IncError("Checkpoint")
+		return &proto.CheckpointResponse{Success: false, Message: fmt.Sprintf("invalid checkpoint directory %q: must be a relative path inside checkpoint_dir", req.Dir)}, nil
+	}
+
+	dir, err := filepath.Abs(filepath.Join(s.checkpointDir, req.Dir))
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(dir), 0755)
+	}
+	if err == nil {
+		err = engine.Checkpoint(dir)
+	}
+	if err != nil {
+		IncError("Checkpoint")
+		return &proto.CheckpointResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	IncSuccess("Checkpoint")
+	return &proto.CheckpointResponse{Success: true, Dir: dir}, nil
+}
+
+// compacter is implemented by engines that can be compacted on demand
+type compacter interface {
+	Compact() error
+}
+
+func (s *BigTableLiteServer) Compact(ctx context.Context, req *proto.CompactRequest) (*proto.CompactResponse, error) {
+	start := time.Now()
+	defer ObserveLatency("Compact", start)
+
+	engine, ok := s.engine.(compacter)
+	if !ok {
+		IncError("Compact")
+		return &proto.CompactResponse{Success: false, Message: "storage engine does not support compaction"}, nil
+	}
+	if err := engine.Compact(); err != nil {
+		IncError("Compact")
+		return &proto.CompactResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	IncSuccess("Compact")
+	return &proto.CompactResponse{Success: true}, nil
+}
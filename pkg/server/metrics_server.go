@@ -2,8 +2,10 @@ package server
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/alexciechonski/BigTableLite/pkg/storage"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -44,3 +46,104 @@ func IncError(method string) {
 func IncNotFound(method string) {
 	reqCount.WithLabelValues(method, "not_found").Inc()
 }
+
+var (
+	sstableCountDesc = prometheus.NewDesc(
+		"sstable_count", "Number of live SSTables.", []string{"shard"}, nil)
+	sstableBytesDesc = prometheus.NewDesc(
+		"sstable_bytes", "Total size of live SSTables in bytes.", []string{"shard"}, nil)
+	memtableBytesDesc = prometheus.NewDesc(
+		"memtable_bytes", "Approximate size of the memtable in bytes.", []string{"shard"}, nil)
+	compactionsDesc = prometheus.NewDesc(
+		"compactions_total", "Number of completed compactions.", []string{"shard"}, nil)
+	compactionBytesReadDesc = prometheus.NewDesc(
+		"compaction_bytes_read_total", "Bytes read from compaction inputs.", []string{"shard"}, nil)
+	compactionBytesWrittenDesc = prometheus.NewDesc(
+		"compaction_bytes_written_total", "Bytes written to compaction outputs.", []string{"shard"}, nil)
+	compactionEntriesDroppedDesc = prometheus.NewDesc(
+		"compaction_entries_dropped_total", "Overwritten entries dropped by compaction.", []string{"shard"}, nil)
+	compactionTombstonesDroppedDesc = prometheus.NewDesc(
+		"compaction_tombstones_dropped_total", "Tombstones dropped by compaction.", []string{"shard"}, nil)
+	compactionEntriesExpiredDesc = prometheus.NewDesc(
+		"compaction_entries_expired_total", "Values removed by compaction because their TTL passed.", []string{"shard"}, nil)
+	keysExpiredDesc = prometheus.NewDesc(
+		"keys_expired_total", "Lookups that found a key's TTL passed; on Redis, its expired_keys.", []string{"shard"}, nil)
+	compactionSecondsDesc = prometheus.NewDesc(
+		"compaction_seconds_total", "Time spent compacting.", []string{"shard"}, nil)
+	bloomFilterHitsDesc = prometheus.NewDesc(
+		"bloom_filter_hits_total", "Table lookups where the Bloom filter matched the key.", []string{"shard"}, nil)
+	bloomFilterMissesDesc = prometheus.NewDesc(
+		"bloom_filter_misses_total", "Table lookups skipped because the Bloom filter ruled the key out.", []string{"shard"}, nil)
+	blockCacheHitsDesc = prometheus.NewDesc(
+		"block_cache_hits", "Data block reads served from the block cache.", []string{"shard"}, nil)
+	blockCacheMissesDesc = prometheus.NewDesc(
+		"block_cache_misses", "Data block reads that went to disk.", []string{"shard"}, nil)
+	blockCacheUsageDesc = prometheus.NewDesc(
+		"block_cache_usage_bytes", "Bytes of data blocks held in the block cache.", []string{"shard"}, nil)
+	blockCacheCapacityDesc = prometheus.NewDesc(
+		"block_cache_capacity_bytes", "Configured block cache budget in bytes.", []string{"shard"}, nil)
+)
+
+// engineCollector reads storage engine counters at scrape time
+type engineCollector struct {
+	shard  string
+	engine storage.Engine
+}
+
+// RegisterEngineMetrics exports the engine's table and compaction counters
+// under the given shard label.
+func RegisterEngineMetrics(shardID int, engine storage.Engine) error {
+	return prometheus.Register(&engineCollector{shard: strconv.Itoa(shardID), engine: engine})
+}
+
+func (c *engineCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sstableCountDesc
+	ch <- sstableBytesDesc
+	ch <- memtableBytesDesc
+	ch <- compactionsDesc
+	ch <- compactionBytesReadDesc
+	ch <- compactionBytesWrittenDesc
+	ch <- compactionEntriesDroppedDesc
+	ch <- compactionTombstonesDroppedDesc
+	ch <- compactionEntriesExpiredDesc
+	ch <- keysExpiredDesc
+	ch <- compactionSecondsDesc
+	ch <- bloomFilterHitsDesc
+	ch <- bloomFilterMissesDesc
+	ch <- blockCacheHitsDesc
+	ch <- blockCacheMissesDesc
+	ch <- blockCacheUsageDesc
+	ch <- blockCacheCapacityDesc
+}
+
+func (c *engineCollector) Collect(ch chan<- prometheus.Metric) {
+	stats, err := c.engine.Stats()
+	if err != nil {
+		return
+	}
+
+	gauge := func(desc *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, c.shard)
+	}
+	counter := func(desc *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, v, c.shard)
+	}
+
+	gauge(sstableCountDesc, float64(stats.SSTables))
+	gauge(sstableBytesDesc, float64(stats.SSTableBytes))
+	gauge(memtableBytesDesc, float64(stats.MemtableBytes))
+	counter(compactionsDesc, float64(stats.Compactions))
+	counter(compactionBytesReadDesc, float64(stats.CompactionBytesRead))
+	counter(compactionBytesWrittenDesc, float64(stats.CompactionBytesWritten))
+	counter(compactionEntriesDroppedDesc, float64(stats.CompactionEntriesDropped))
+	counter(compactionTombstonesDroppedDesc, float64(stats.CompactionTombstonesDropped))
+	counter(compactionEntriesExpiredDesc, float64(stats.CompactionEntriesExpired))
+	counter(keysExpiredDesc, float64(stats.KeysExpired))
+	counter(compactionSecondsDesc, stats.CompactionTime.Seconds())
+	counter(bloomFilterHitsDesc, float64(stats.FilterHits))
+	counter(bloomFilterMissesDesc, float64(stats.FilterMisses))
+	gauge(blockCacheHitsDesc, float64(stats.BlockCacheHits))
+	gauge(blockCacheMissesDesc, float64(stats.BlockCacheMisses))
+	gauge(blockCacheUsageDesc, float64(stats.BlockCacheUsage))
+	gauge(blockCacheCapacityDesc, float64(stats.BlockCacheCapacity))
+}
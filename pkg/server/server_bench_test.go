@@ -5,6 +5,7 @@ import (
     "os"
     "testing"
 
+    "github.com/alexciechonski/BigTableLite/pkg/storage"
     "github.com/alexciechonski/BigTableLite/proto"
     "github.com/go-redis/redismock/v9"
     "github.com/redis/go-redis/v9"
@@ -28,13 +29,11 @@ func BenchmarkServerRedisSet(b *testing.B) {
         mock.ExpectSet("bench-key", "bench-value", 0).SetVal("OK")
     }
 
-    server := &BigTableLiteServer{
-        redis: rdb, // Changed from redisClient to redis
-    }
+    server := New(storage.NewRedisEngine(rdb), nil, 0)
 
     req := &proto.SetRequest{
-        Key:   "bench-key",
-        Value: "bench-value",
+        Key:   []byte("bench-key"),
+        Value: []byte("bench-value"),
     }
 
     b.ResetTimer()
@@ -1,12 +1,18 @@
 package server
 
 import (
+    "bytes"
     "context"
     "os"
+    "path/filepath"
+    "strings"
     "testing"
+    "time"
 
+    "github.com/alexciechonski/BigTableLite/pkg/storage"
     "github.com/alexciechonski/BigTableLite/proto"
     "github.com/go-redis/redismock/v9"
+    "github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestSet(t *testing.T) {
@@ -26,8 +32,8 @@ func TestSet(t *testing.T) {
     }
 
     _, err := server.Set(ctx, &proto.SetRequest{
-        Key:   "key1",
-        Value: "value1",
+        Key:   []byte("key1"),
+        Value: []byte("value1"),
     })
 
     if err != nil {
@@ -57,7 +63,7 @@ func TestGet(t *testing.T) {
         mock.ExpectGet("hello").SetVal("world")
     }
 
-    resp, err := server.Get(ctx, &proto.GetRequest{Key: "hello"})
+    resp, err := server.Get(ctx, &proto.GetRequest{Key: []byte("hello")})
     if err != nil {
         t.Fatalf("unexpected error: %v", err)
     }
@@ -66,7 +72,7 @@ func TestGet(t *testing.T) {
         t.Fatalf("expected key to be found")
     }
 
-    if resp.Value != "world" {
+    if string(resp.Value) != "world" {
         t.Fatalf("expected 'world', got %s", resp.Value)
     }
 
@@ -75,4 +81,342 @@ func TestGet(t *testing.T) {
             t.Fatalf("unmet redis expectations: %v", err)
         }
     }
-}
\ No newline at end of file
+}
+func TestSSTableBinaryRoundTrip(t *testing.T) {
+    server := newTestSSTableServer(t)
+    ctx := context.Background()
+
+    key := []byte{'k', 0x00, 0xfe}
+    value := []byte{0x00, 'v', 0x00}
+
+    setResp, err := server.Set(ctx, &proto.SetRequest{Key: key, Value: value})
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if !setResp.Success {
+        t.Fatalf("set failed: %s", setResp.Message)
+    }
+
+    resp, err := server.Get(ctx, &proto.GetRequest{Key: key})
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if !resp.Found {
+        t.Fatalf("expected key to be found")
+    }
+    if !bytes.Equal(resp.Value, value) {
+        t.Fatalf("expected %x, got %x", value, resp.Value)
+    }
+
+    // The truncated key must not alias the binary one
+    resp, err = server.Get(ctx, &proto.GetRequest{Key: []byte("k")})
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if resp.Found {
+        t.Fatalf("expected truncated key to be absent")
+    }
+}
+
+func TestSetWithTTL(t *testing.T) {
+    server := newTestSSTableServer(t)
+    ctx := context.Background()
+
+    setResp, err := server.Set(ctx, &proto.SetRequest{Key: []byte("k"), Value: []byte("v"), TtlMillis: 1})
+    if err != nil || !setResp.Success {
+        t.Fatalf("set failed: %v, %v", setResp, err)
+    }
+    time.Sleep(10 * time.Millisecond)
+    resp, err := server.Get(ctx, &proto.GetRequest{Key: []byte("k")})
+    if err != nil || resp.Found {
+        t.Fatalf("expected the key to have expired, got %v, %v", resp, err)
+    }
+
+    setResp, err = server.Set(ctx, &proto.SetRequest{Key: []byte("k"), Value: []byte("v"), TtlMillis: -1})
+    if err != nil || setResp.Success {
+        t.Fatalf("expected a negative TTL to be rejected, got %v, %v", setResp, err)
+    }
+}
+
+func TestDeleteRange(t *testing.T) {
+    server := newTestSSTableServer(t)
+    ctx := context.Background()
+
+    for _, key := range []string{"tenant1/a", "tenant1/b", "tenant2/a"} {
+        server.Set(ctx, &proto.SetRequest{Key: []byte(key), Value: []byte("v")})
+    }
+    resp, err := server.DeleteRange(ctx, &proto.DeleteRangeRequest{StartKey: []byte("tenant1/"), EndKey: []byte("tenant2/")})
+    if err != nil || !resp.Success {
+        t.Fatalf("DeleteRange failed: %v, %v", resp, err)
+    }
+    for key, want := range map[string]bool{"tenant1/a": false, "tenant1/b": false, "tenant2/a": true} {
+        get, err := server.Get(ctx, &proto.GetRequest{Key: []byte(key)})
+        if err != nil || get.Found != want {
+            t.Errorf("expected %s found=%v, got %v, %v", key, want, get, err)
+        }
+    }
+
+    resp, err = server.DeleteRange(ctx, &proto.DeleteRangeRequest{StartKey: []byte("b"), EndKey: []byte("a")})
+    if err != nil || resp.Success {
+        t.Fatalf("expected a reversed range to be rejected, got %v, %v", resp, err)
+    }
+}
+
+func TestMerge(t *testing.T) {
+    dir := t.TempDir()
+    opts := storage.DefaultOptions()
+    opts.MergeOperator = storage.StringAppendOperator
+    engine, err := storage.NewSSTableEngineWithOptions(dir, filepath.Join(dir, "wal.log"), opts)
+    if err != nil {
+        t.Fatalf("failed to create SSTable engine: %v", err)
+    }
+    defer engine.DestroySSTableEngine()
+    server := New(engine, nil, 0)
+    ctx := context.Background()
+
+    server.Set(ctx, &proto.SetRequest{Key: []byte("list"), Value: []byte("a")})
+    for _, operand := range []string{"b", "c"} {
+        resp, err := server.Merge(ctx, &proto.MergeRequest{Key: []byte("list"), Operand: []byte(operand)})
+        if err != nil || !resp.Success {
+            t.Fatalf("Merge failed: %v, %v", resp, err)
+        }
+    }
+    get, err := server.Get(ctx, &proto.GetRequest{Key: []byte("list")})
+    if err != nil || !get.Found || string(get.Value) != "abc" {
+        t.Fatalf("expected abc, got %v, %v", get, err)
+    }
+
+    // Engines without a merge operator report it
+    resp, err := newTestSSTableServer(t).Merge(ctx, &proto.MergeRequest{Key: []byte("list"), Operand: []byte("d")})
+    if err != nil || resp.Success {
+        t.Fatalf("expected Merge without an operator to fail, got %v, %v", resp, err)
+    }
+    resp, err = New(storage.NewMemoryEngine(), nil, 0).Merge(ctx, &proto.MergeRequest{Key: []byte("list"), Operand: []byte("d")})
+    if err != nil || resp.Success {
+        t.Fatalf("expected Merge to fail on a memory engine, got %v, %v", resp, err)
+    }
+}
+
+func TestEngineCollector(t *testing.T) {
+    server := newTestSSTableServer(t)
+    ctx := context.Background()
+
+    if _, err := server.Set(ctx, &proto.SetRequest{Key: []byte("k"), Value: []byte("v")}); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    collector := &engineCollector{shard: "0", engine: server.engine}
+    if n := testutil.CollectAndCount(collector); n != 17 {
+        t.Fatalf("expected 17 engine metrics, got %d", n)
+    }
+
+    expected := `
+# HELP sstable_count Number of live SSTables.
+# TYPE sstable_count gauge
+sstable_count{shard="0"} 0
+`
+    if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "sstable_count"); err != nil {
+        t.Fatalf("unexpected sstable_count: %v", err)
+    }
+}
+
+func TestServerOnMemoryEngine(t *testing.T) {
+    server := New(storage.NewMemoryEngine(), nil, 0)
+    ctx := context.Background()
+
+    if resp, err := server.Set(ctx, &proto.SetRequest{Key: []byte("k"), Value: []byte("v")}); err != nil || !resp.Success {
+        t.Fatalf("set failed: %v, %v", resp, err)
+    }
+    resp, err := server.Get(ctx, &proto.GetRequest{Key: []byte("k")})
+    if err != nil || !resp.Found || string(resp.Value) != "v" {
+        t.Fatalf("expected k=v, got %v, %v", resp, err)
+    }
+    if resp, err := server.Delete(ctx, &proto.DeleteRequest{Key: []byte("k")}); err != nil || !resp.Success {
+        t.Fatalf("delete failed: %v, %v", resp, err)
+    }
+    resp, err = server.Get(ctx, &proto.GetRequest{Key: []byte("k")})
+    if err != nil || resp.Found {
+        t.Fatalf("expected k to be deleted, got %v, %v", resp, err)
+    }
+}
+
+func TestMutateAndReadRow(t *testing.T) {
+    server := newTestSSTableServer(t)
+    ctx := context.Background()
+
+    mutate, err := server.MutateRow(ctx, &proto.MutateRowRequest{
+        RowKey: []byte("row"),
+        Mutations: []*proto.Mutation{
+            {Type: proto.MutationType_SET_CELL, Family: "f", Qualifier: []byte("a"), TimestampMicros: 1, Value: []byte("old")},
+            {Type: proto.MutationType_SET_CELL, Family: "f", Qualifier: []byte("a"), TimestampMicros: 2, Value: []byte("new")},
+            {Type: proto.MutationType_SET_CELL, Family: "g", Qualifier: []byte("b"), TimestampMicros: 1, Value: []byte("v")},
+        },
+    })
+    if err != nil || !mutate.Success {
+        t.Fatalf("MutateRow failed: %v, %v", mutate, err)
+    }
+
+    resp, err := server.ReadRow(ctx, &proto.ReadRowRequest{RowKey: []byte("row"), Families: []string{"f"}})
+    if err != nil || !resp.Found {
+        t.Fatalf("ReadRow failed: %v, %v", resp, err)
+    }
+    if len(resp.Cells) != 2 || string(resp.Cells[0].Value) != "new" || resp.Cells[0].TimestampMicros != 2 {
+        t.Fatalf("unexpected cells: %v", resp.Cells)
+    }
+
+    resp, err = server.ReadRow(ctx, &proto.ReadRowRequest{RowKey: []byte("row"), LatestN: 1})
+    if err != nil || len(resp.Cells) != 2 || string(resp.Cells[0].Value) != "new" || string(resp.Cells[1].Value) != "v" {
+        t.Fatalf("unexpected latest cells: %v, %v", resp, err)
+    }
+    resp, err = server.ReadRow(ctx, &proto.ReadRowRequest{RowKey: []byte("row"), Families: []string{"f"}, EndTimestampMicros: 2})
+    if err != nil || len(resp.Cells) != 1 || string(resp.Cells[0].Value) != "old" {
+        t.Fatalf("unexpected cells before timestamp 2: %v, %v", resp, err)
+    }
+
+    mutate, err = server.MutateRow(ctx, &proto.MutateRowRequest{
+        RowKey:    []byte("row"),
+        Mutations: []*proto.Mutation{{Type: proto.MutationType_DELETE_ROW}},
+    })
+    if err != nil || !mutate.Success {
+        t.Fatalf("MutateRow failed: %v, %v", mutate, err)
+    }
+    resp, err = server.ReadRow(ctx, &proto.ReadRowRequest{RowKey: []byte("row")})
+    if err != nil || resp.Found {
+        t.Fatalf("expected the row to be deleted, got %v, %v", resp, err)
+    }
+
+    // Invalid mutations are reported, not applied
+    mutate, err = server.MutateRow(ctx, &proto.MutateRowRequest{
+        RowKey:    []byte("row"),
+        Mutations: []*proto.Mutation{{Type: proto.MutationType_SET_CELL, Family: "bad family"}},
+    })
+    if err != nil || mutate.Success {
+        t.Fatalf("expected MutateRow to fail, got %v, %v", mutate, err)
+    }
+}
+
+func TestFlatWritesCannotTouchCells(t *testing.T) {
+    server := newTestSSTableServer(t)
+    ctx := context.Background()
+
+    mutate, err := server.MutateRow(ctx, &proto.MutateRowRequest{
+        RowKey:    []byte("row"),
+        Mutations: []*proto.Mutation{{Type: proto.MutationType_SET_CELL, Family: "f", Qualifier: []byte("q"), TimestampMicros: 1, Value: []byte("v")}},
+    })
+    if err != nil || !mutate.Success {
+        t.Fatalf("MutateRow failed: %v, %v", mutate, err)
+    }
+    cellKey := storage.EncodeCellKey([]byte("row"), "f", []byte("q"), 1)
+
+    set, err := server.Set(ctx, &proto.SetRequest{Key: cellKey, Value: []byte("clobbered")})
+    if err != nil || set.Success {
+        t.Errorf("expected Set of a cell key to be rejected, got %v, %v", set, err)
+    }
+    del, err := server.Delete(ctx, &proto.DeleteRequest{Key: cellKey})
+    if err != nil || del.Success {
+        t.Errorf("expected Delete of a cell key to be rejected, got %v, %v", del, err)
+    }
+    for _, r := range [][2]string{{"", "\xff"}, {"\x00c", "\x00d"}, {"\x00b", "\x00c\x01"}, {"\x00cz", "a"}} {
+        resp, err := server.DeleteRange(ctx, &proto.DeleteRangeRequest{StartKey: []byte(r[0]), EndKey: []byte(r[1])})
+        if err != nil || resp.Success {
+            t.Errorf("expected DeleteRange [%q, %q) to be rejected, got %v, %v", r[0], r[1], resp, err)
+        }
+    }
+
+    // Ranges that stop short of the cells, or start past them, still work
+    for _, r := range [][2]string{{"", "\x00c"}, {"\x00d", "z"}} {
+        resp, err := server.DeleteRange(ctx, &proto.DeleteRangeRequest{StartKey: []byte(r[0]), EndKey: []byte(r[1])})
+        if err != nil || !resp.Success {
+            t.Errorf("expected DeleteRange [%q, %q) to succeed, got %v, %v", r[0], r[1], resp, err)
+        }
+    }
+
+    resp, err := server.ReadRow(ctx, &proto.ReadRowRequest{RowKey: []byte("row")})
+    if err != nil || len(resp.Cells) != 1 || string(resp.Cells[0].Value) != "v" {
+        t.Fatalf("expected the cell to be untouched, got %v, %v", resp, err)
+    }
+}
+
+func TestCheckpoint(t *testing.T) {
+    server := newTestSSTableServer(t)
+    ctx := context.Background()
+
+    server.Set(ctx, &proto.SetRequest{Key: []byte("k"), Value: []byte("v")})
+
+    // Without a checkpoint directory the RPC is disabled
+    resp, err := server.Checkpoint(ctx, &proto.CheckpointRequest{Dir: "checkpoint"})
+    if err != nil || resp.Success {
+        t.Fatalf("expected Checkpoint to be disabled, got %v, %v", resp, err)
+    }
+
+    root := t.TempDir()
+    server.SetCheckpointDir(root)
+    resp, err = server.Checkpoint(ctx, &proto.CheckpointRequest{Dir: "shard0/checkpoint"})
+    if err != nil || !resp.Success {
+        t.Fatalf("Checkpoint failed: %v, %v", resp, err)
+    }
+    dir := filepath.Join(root, "shard0", "checkpoint")
+    if resp.Dir != dir {
+        t.Fatalf("expected the checkpoint in %s, got %s", dir, resp.Dir)
+    }
+
+    // Paths leaving the checkpoint directory are rejected
+    outside := t.TempDir()
+    for _, name := range []string{"", filepath.Join(outside, "checkpoint"), "../checkpoint", "shard0/../../checkpoint"} {
+        resp, err := server.Checkpoint(ctx, &proto.CheckpointRequest{Dir: name})
+        if err != nil || resp.Success {
+            t.Errorf("expected Checkpoint into %q to be rejected, got %v, %v", name, resp, err)
+        }
+    }
+    if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+        t.Errorf("expected nothing written outside the checkpoint directory, got %v", entries)
+    }
+
+    engine, err := storage.NewSSTableEngine(dir, filepath.Join(dir, "wal.log"))
+    if err != nil {
+        t.Fatalf("failed to open checkpoint: %v", err)
+    }
+    defer engine.DestroySSTableEngine()
+    if value, found, err := engine.Get([]byte("k")); err != nil || !found || string(value) != "v" {
+        t.Fatalf("expected k=v in the checkpoint, got %q, %v, %v", value, found, err)
+    }
+
+    // Engines without checkpoints report it
+    memory := New(storage.NewMemoryEngine(), nil, 0)
+    memory.SetCheckpointDir(root)
+    resp, err = memory.Checkpoint(ctx, &proto.CheckpointRequest{Dir: "memory"})
+    if err != nil || resp.Success {
+        t.Fatalf("expected Checkpoint to fail on a memory engine, got %v, %v", resp, err)
+    }
+}
+
+func TestCompact(t *testing.T) {
+    server := newTestSSTableServer(t)
+    ctx := context.Background()
+    engine := server.engine.(*storage.SSTableEngine)
+
+    for i := 0; i < 3; i++ {
+        server.Set(ctx, &proto.SetRequest{Key: []byte("k"), Value: []byte{byte('0' + i)}})
+        if err := engine.Flush(); err != nil {
+            t.Fatalf("Flush failed: %v", err)
+        }
+    }
+    resp, err := server.Compact(ctx, &proto.CompactRequest{})
+    if err != nil || !resp.Success {
+        t.Fatalf("Compact failed: %v, %v", resp, err)
+    }
+    stats, err := engine.Stats()
+    if err != nil || stats.SSTables != 1 {
+        t.Fatalf("expected one table after Compact, got %+v, %v", stats, err)
+    }
+    get, err := server.Get(ctx, &proto.GetRequest{Key: []byte("k")})
+    if err != nil || string(get.Value) != "2" {
+        t.Fatalf("expected k=2 after Compact, got %v, %v", get, err)
+    }
+
+    // Engines without compaction report it
+    resp, err = New(storage.NewMemoryEngine(), nil, 0).Compact(ctx, &proto.CompactRequest{})
+    if err != nil || resp.Success {
+        t.Fatalf("expected Compact to fail on a memory engine, got %v, %v", resp, err)
+    }
+}
@@ -3,25 +3,23 @@ package server
 import (
     "context"
     "os"
+    "path/filepath"
     "testing"
     "time"
 
+    "github.com/alexciechonski/BigTableLite/pkg/storage"
     "github.com/go-redis/redismock/v9"
     "github.com/redis/go-redis/v9"
 )
 
 func newTestRedisServer(rdb *redis.Client) *BigTableLiteServer {
-    return &BigTableLiteServer{
-        redis: rdb,
-    }
+    return New(storage.NewRedisEngine(rdb), nil, 0)
 }
 
 func newMockServer(t *testing.T) (*BigTableLiteServer, redismock.ClientMock) {
     db, mock := redismock.NewClientMock()
 
-    return &BigTableLiteServer{
-        redis: db,
-    }, mock
+    return New(storage.NewRedisEngine(db), nil, 0), mock
 }
 
 func newLocalRedisServer(t *testing.T) *BigTableLiteServer {
@@ -39,7 +37,17 @@ func newLocalRedisServer(t *testing.T) *BigTableLiteServer {
         t.Skip("Redis not running locally — skipping integration test")
     }
 
-    return &BigTableLiteServer{
-        redis: rdb,
+    return New(storage.NewRedisEngine(rdb), nil, 0)
+}
+
+func newTestSSTableServer(t *testing.T) *BigTableLiteServer {
+    dir := t.TempDir()
+
+    engine, err := storage.NewSSTableEngine(dir, filepath.Join(dir, "wal.log"))
+    if err != nil {
+        t.Fatalf("failed to create SSTable engine: %v", err)
     }
-}
\ No newline at end of file
+    t.Cleanup(engine.DestroySSTableEngine)
+
+    return New(engine, nil, 0)
+}
@@ -39,7 +39,7 @@ func (s *Shard) Close() error {
 	return nil
 }
 
-func (s *Shard) Put(key, value string) error {
+func (s *Shard) Put(key, value []byte) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -50,7 +50,7 @@ func (s *Shard) Put(key, value string) error {
 	return s.Engine.Put(key, value)
 }
 
-func (s *Shard) Delete(key string) error {
+func (s *Shard) Delete(key []byte) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -61,12 +61,12 @@ func (s *Shard) Delete(key string) error {
 	return s.Engine.Delete(key)
 }
 
-func (s *Shard) Get(key string) (string, bool, error) {
+func (s *Shard) Get(key []byte) ([]byte, bool, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if s.Engine == nil {
-		return "", false, fmt.Errorf("shard %d is not initialized", s.ID)
+		return nil, false, fmt.Errorf("shard %d is not initialized", s.ID)
 	}
 
 	return s.Engine.Get(key)
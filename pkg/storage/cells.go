@@ -0,0 +1,409 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Bigtable-style cells: a value is addressed by row key, column family,
+// column qualifier and timestamp. Cells are stored as ordinary engine keys
+// under cellKeyPrefix, encoded so that byte order groups them by row, then
+// family, then qualifier, with the newest timestamp first:
+//
+//	cellKeyPrefix <row> 00 01 <family> 00 01 <qualifier> 00 01 <^timestamp>
+//
+// Row, family and qualifier bytes are escaped (00 becomes 00 FF), so the
+// 00 01 terminator sorts below any continuation and a shorter component
+// sorts before a longer one it prefixes. The timestamp is 8 bytes big
+// endian, inverted. Flat keys written with Put must not start with
+// cellKeyPrefix, or they overwrite cells; the server rejects them.
+const cellKeyPrefix = "\x00c"
+
+// IsCellKey reports whether key lies in the key space reserved for cells.
+func IsCellKey(key []byte) bool {
+	return bytes.HasPrefix(key, []byte(cellKeyPrefix))
+}
+
+// OverlapsCells reports whether [start, end) holds any key reserved for
+// cells.
+func OverlapsCells(start, end []byte) bool {
+	return bytes.Compare(start, prefixEnd([]byte(cellKeyPrefix))) < 0 && bytes.Compare(end, []byte(cellKeyPrefix)) > 0
+}
+
+// ServerTimestamp asks MutateRow to stamp a cell with the current time.
+const ServerTimestamp int64 = -1
+
+// Cell is one timestamped value of a column.
+type Cell struct {
+	Family    string
+	Qualifier []byte
+	Timestamp int64 // microseconds since the Unix epoch
+	Value     []byte
+}
+
+func appendEscaped(dst, component []byte) []byte {
+	for _, b := range component {
+		if b == 0x00 {
+			dst = append(dst, 0x00, 0xFF)
+		} else {
+			dst = append(dst, b)
+		}
+	}
+	return append(dst, 0x00, 0x01)
+}
+
+// readEscaped decodes one escaped component from the start of key
+func readEscaped(key []byte) (component, rest []byte, ok bool) {
+	for i := 0; i+1 < len(key); i++ {
+		if key[i] != 0x00 {
+			component = append(component, key[i])
+			continue
+		}
+		switch key[i+1] {
+		case 0x01:
+			return component, key[i+2:], true
+		case 0xFF:
+			component = append(component, 0x00)
+			i++
+		default:
+			return nil, nil, false
+		}
+	}
+	return nil, nil, false
+}
+
+// rowKeyPrefix is the prefix every cell of row shares
+func rowKeyPrefix(row []byte) []byte {
+	return appendEscaped([]byte(cellKeyPrefix), row)
+}
+
+// columnKeyPrefix is the prefix every version of a column shares
+func columnKeyPrefix(row []byte, family string, qualifier []byte) []byte {
+	prefix := appendEscaped(rowKeyPrefix(row), []byte(family))
+	return appendEscaped(prefix, qualifier)
+}
+
+// EncodeCellKey returns the engine key a cell is stored under.
+func EncodeCellKey(row []byte, family string, qualifier []byte, timestamp int64) []byte {
+	return binary.BigEndian.AppendUint64(columnKeyPrefix(row, family, qualifier), ^uint64(timestamp))
+}
+
+// DecodeCellKey splits an engine key written by EncodeCellKey. ok is false
+// for keys that are not cell keys.
+func DecodeCellKey(key []byte) (row []byte, family string, qualifier []byte, timestamp int64, ok bool) {
+	if !bytes.HasPrefix(key, []byte(cellKeyPrefix)) {
+		return nil, "", nil, 0, false
+	}
+	rest := key[len(cellKeyPrefix):]
+	row, rest, ok = readEscaped(rest)
+	if !ok {
+		return nil, "", nil, 0, false
+	}
+	familyBytes, rest, ok := readEscaped(rest)
+	if !ok {
+		return nil, "", nil, 0, false
+	}
+	qualifier, rest, ok = readEscaped(rest)
+	if !ok || len(rest) != 8 {
+		return nil, "", nil, 0, false
+	}
+	if row == nil {
+		row = []byte{}
+	}
+	if qualifier == nil {
+		qualifier = []byte{}
+	}
+	return row, string(familyBytes), qualifier, int64(^binary.BigEndian.Uint64(rest)), true
+}
+
+// validFamily reports whether name is a usable column family name: like
+// Bigtable, non-empty and made of letters, digits, '_', '-' and '.'
+func validFamily(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '_' || c == '-' || c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+type mutationKind int
+
+const (
+	mutationSetCell mutationKind = iota
+	mutationDeleteCell
+	mutationDeleteColumn
+	mutationDeleteFamily
+	mutationDeleteRow
+)
+
+// Mutation is one change to a row, applied by MutateRow.
+type Mutation struct {
+	kind      mutationKind
+	family    string
+	qualifier []byte
+	timestamp int64
+	value     []byte
+}
+
+// SetCell writes value to a column at timestamp, or at the current time if
+// timestamp is ServerTimestamp. Other versions of the column are kept.
+func SetCell(family string, qualifier []byte, timestamp int64, value []byte) Mutation {
+	return Mutation{kind: mutationSetCell, family: family, qualifier: qualifier, timestamp: timestamp, value: value}
+}
+
+// DeleteCell deletes the version of a column written at timestamp.
+func DeleteCell(family string, qualifier []byte, timestamp int64) Mutation {
+	return Mutation{kind: mutationDeleteCell, family: family, qualifier: qualifier, timestamp: timestamp}
+}
+
+// DeleteColumn deletes every version of a column.
+func DeleteColumn(family string, qualifier []byte) Mutation {
+	return Mutation{kind: mutationDeleteColumn, family: family, qualifier: qualifier}
+}
+
+// DeleteFamily deletes every column of a family.
+func DeleteFamily(family string) Mutation {
+	return Mutation{kind: mutationDeleteFamily, family: family}
+}
+
+// DeleteRow deletes every cell of the row.
+func DeleteRow() Mutation {
+	return Mutation{kind: mutationDeleteRow}
+}
+
+func (m Mutation) validate() error {
+	if m.kind != mutationDeleteRow && !validFamily(m.family) {
+		return fmt.Errorf("invalid column family %q", m.family)
+	}
+	if m.kind == mutationSetCell && m.timestamp < ServerTimestamp {
+		return fmt.Errorf("invalid timestamp %d", m.timestamp)
+	}
+	if m.kind == mutationDeleteCell && m.timestamp < 0 {
+		return fmt.Errorf("invalid timestamp %d", m.timestamp)
+	}
+	return nil
+}
+
+// rangeIterable is implemented by engines that can iterate over a key range
+// without copying anything outside it
+type rangeIterable interface {
+	NewRangeIterator(lower, upper []byte) (Iterator, error)
+}
+
+// prefixEnd returns the smallest key above every key starting with prefix,
+// or nil if there is none
+func prefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] != 0xFF {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// newPrefixIterator returns an iterator over at least the keys starting
+// with prefix, covering only them where the engine allows it
+func newPrefixIterator(engine Engine, prefix []byte) (Iterator, error) {
+	if ranged, ok := engine.(rangeIterable); ok {
+		return ranged.NewRangeIterator(prefix, prefixEnd(prefix))
+	}
+	return engine.NewIterator()
+}
+
+// keysWithPrefix lists the keys of the engine that start with prefix
+func keysWithPrefix(engine Engine, prefix []byte) ([][]byte, error) {
+	it, err := newPrefixIterator(engine, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var keys [][]byte
+	for it.Seek(prefix); it.Valid(); it.Next() {
+		key := it.Key()
+		if !bytes.HasPrefix(key, prefix) {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// MutateRow applies mutations to row in order, as a single engine write:
+// on engines with atomic batches readers see all of them or none. Deletes
+// that cover several cells read the row first, so a cell written by a
+// concurrent MutateRow may survive them.
+func MutateRow(engine Engine, row []byte, mutations []Mutation) error {
+	if len(mutations) == 0 {
+		return errors.New("no mutations")
+	}
+	for _, m := range mutations {
+		if err := m.validate(); err != nil {
+			return err
+		}
+	}
+
+	var existing [][]byte
+	needsRow := false
+	for _, m := range mutations {
+		if m.kind == mutationDeleteColumn || m.kind == mutationDeleteFamily || m.kind == mutationDeleteRow {
+			needsRow = true
+		}
+	}
+	if needsRow {
+		keys, err := keysWithPrefix(engine, rowKeyPrefix(row))
+		if err != nil {
+			return err
+		}
+		existing = keys
+	}
+
+	// Cells set earlier in the same call are deleted by later mutations too
+	var batch Batch
+	var written [][]byte
+	deleteMatching := func(prefix []byte) {
+		for _, keys := range [][][]byte{existing, written} {
+			for _, key := range keys {
+				if bytes.HasPrefix(key, prefix) {
+					batch.Delete(key)
+				}
+			}
+		}
+	}
+	now := time.Now().UnixMicro()
+	for _, m := range mutations {
+		switch m.kind {
+		case mutationSetCell:
+			timestamp := m.timestamp
+			if timestamp == ServerTimestamp {
+				timestamp = now
+			}
+			key := EncodeCellKey(row, m.family, m.qualifier, timestamp)
+			batch.Put(key, m.value)
+			written = append(written, key)
+		case mutationDeleteCell:
+			batch.Delete(EncodeCellKey(row, m.family, m.qualifier, m.timestamp))
+		case mutationDeleteColumn:
+			deleteMatching(columnKeyPrefix(row, m.family, m.qualifier))
+		case mutationDeleteFamily:
+			deleteMatching(appendEscaped(rowKeyPrefix(row), []byte(m.family)))
+		case mutationDeleteRow:
+			deleteMatching(rowKeyPrefix(row))
+		}
+	}
+	return engine.Write(&batch)
+}
+
+// Column names a column of a row.
+type Column struct {
+	Family    string
+	Qualifier []byte
+}
+
+// RowFilter selects the cells ReadRow returns: those in any of Families or
+// in any of Columns, or in the whole row if both are empty, narrowed down
+// to the versions in the time range and then to the newest LatestN of each
+// column. A nil filter selects every version of the whole row.
+type RowFilter struct {
+	Families []string
+	Columns  []Column
+
+	// StartTimestamp and EndTimestamp bound the versions returned to
+	// [StartTimestamp, EndTimestamp); an EndTimestamp of 0 sets no upper
+	// bound.
+	StartTimestamp int64
+	EndTimestamp   int64
+
+	// LatestN, if positive, keeps only the newest LatestN versions of each
+	// column. LatestN 1 reads the current value of every column.
+	LatestN int
+}
+
+func (f *RowFilter) matches(family string, qualifier []byte) bool {
+	if f == nil || (len(f.Families) == 0 && len(f.Columns) == 0) {
+		return true
+	}
+	for _, name := range f.Families {
+		if name == family {
+			return true
+		}
+	}
+	for _, column := range f.Columns {
+		if column.Family == family && bytes.Equal(column.Qualifier, qualifier) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *RowFilter) inTimeRange(timestamp int64) bool {
+	if f == nil {
+		return true
+	}
+	return timestamp >= f.StartTimestamp && (f.EndTimestamp == 0 || timestamp < f.EndTimestamp)
+}
+
+// gcPolicySource is implemented by engines that garbage collect cells by
+// column family, so reads can hide versions not yet collected
+type gcPolicySource interface {
+	GCPolicy(family string) (GCPolicy, bool)
+}
+
+// ReadRow returns the cells of row that filter selects, in key order: by
+// family, then qualifier, then newest timestamp first. Versions the GC
+// policy of their family collects are never returned, even before a
+// compaction removes them. A row with no cells reads as an empty slice.
+func ReadRow(engine Engine, row []byte, filter *RowFilter) ([]Cell, error) {
+	prefix := rowKeyPrefix(row)
+	it, err := newPrefixIterator(engine, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	policies, _ := engine.(gcPolicySource)
+	now := time.Now().UnixMicro()
+
+	var cells []Cell
+	var column []byte
+	var kept, returned int // versions of column kept by GC, and returned
+	for it.Seek(prefix); it.Valid(); it.Next() {
+		key := it.Key()
+		if !bytes.HasPrefix(key, prefix) {
+			break
+		}
+		_, family, qualifier, timestamp, ok := DecodeCellKey(key)
+		if !ok || !filter.matches(family, qualifier) {
+			continue
+		}
+		if current := key[:len(key)-8]; !bytes.Equal(current, column) {
+			column = current
+			kept, returned = 0, 0
+		}
+
+		if policies != nil {
+			if policy, found := policies.GCPolicy(family); found && policy.collects(kept, timestamp, now) {
+				continue
+			}
+		}
+		kept++
+		if !filter.inTimeRange(timestamp) || (filter != nil && filter.LatestN > 0 && returned >= filter.LatestN) {
+			continue
+		}
+		returned++
+		cells = append(cells, Cell{Family: family, Qualifier: qualifier, Timestamp: timestamp, Value: it.Value()})
+	}
+	return cells, nil
+}
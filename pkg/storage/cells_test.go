@@ -0,0 +1,364 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestCellKey_RoundTripAndOrder(t *testing.T) {
+	type cell struct {
+		row, family, qualifier string
+		timestamp              int64
+	}
+	// In the order their keys must sort
+	cells := []cell{
+		{"", "f", "", 0},
+		{"r", "a", "q", 5},
+		{"r", "a", "q", 1},
+		{"r", "a", "q\x00", 9},
+		{"r", "a", "qa", 9},
+		{"r", "ab", "", 9},
+		{"r", "b", "q", 1},
+		{"r\x00", "a", "q", 1},
+		{"r\x00\x00", "a", "q", 1},
+		{"r\x01", "a", "q", 1},
+		{"ra", "a", "q", 1},
+	}
+
+	var keys []string
+	for _, c := range cells {
+		key := EncodeCellKey([]byte(c.row), c.family, []byte(c.qualifier), c.timestamp)
+		row, family, qualifier, timestamp, ok := DecodeCellKey(key)
+		if !ok || string(row) != c.row || family != c.family || string(qualifier) != c.qualifier || timestamp != c.timestamp {
+			t.Fatalf("Decoded %+v as %q %q %q %d (ok=%v)", c, row, family, qualifier, timestamp, ok)
+		}
+		keys = append(keys, string(key))
+	}
+	if !sort.StringsAreSorted(keys) {
+		t.Fatalf("Cell keys do not sort in row, family, qualifier, newest-first order")
+	}
+
+	for _, key := range []string{"plain", cellKeyPrefix, cellKeyPrefix + "r\x00\x01f\x00\x01q\x00\x01short"} {
+		if _, _, _, _, ok := DecodeCellKey([]byte(key)); ok {
+			t.Errorf("Decoded %q as a cell key", key)
+		}
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for prefix, want := range map[string]string{"ab": "ac", "a\xff": "b", "\x00c": "\x00d"} {
+		if got := prefixEnd([]byte(prefix)); string(got) != want {
+			t.Errorf("prefixEnd(%q) = %q, want %q", prefix, got, want)
+		}
+	}
+	if got := prefixEnd([]byte("\xff\xff")); got != nil {
+		t.Errorf("Expected no end for an all-0xFF prefix, got %q", got)
+	}
+}
+
+func expectCells(t *testing.T, got []Cell, want []string) {
+	t.Helper()
+	var formatted []string
+	for _, c := range got {
+		formatted = append(formatted, fmt.Sprintf("%s:%s@%d=%s", c.Family, c.Qualifier, c.Timestamp, c.Value))
+	}
+	expectEntries(t, "cells", formatted, want)
+}
+
+func TestCells_MutateAndReadRow(t *testing.T) {
+	for name, open := range engineFactories {
+		t.Run(name, func(t *testing.T) {
+			engine := open(t)
+			defer engine.Close()
+
+			row := []byte("user#1")
+			err := MutateRow(engine, row, []Mutation{
+				SetCell("info", []byte("name"), 10, []byte("ann")),
+				SetCell("info", []byte("name"), 20, []byte("anne")),
+				SetCell("info", []byte("email"), 10, []byte("a@x")),
+				SetCell("stats", []byte("visits"), 10, []byte("3")),
+			})
+			if err != nil {
+				t.Fatalf("MutateRow failed: %v", err)
+			}
+			// A neighbouring row and a flat key stay out of the row
+			MutateRow(engine, []byte("user#10"), []Mutation{SetCell("info", []byte("name"), 1, []byte("bob"))})
+			engine.Put([]byte("user#1"), []byte("flat"))
+
+			cells, err := ReadRow(engine, row, nil)
+			if err != nil {
+				t.Fatalf("ReadRow failed: %v", err)
+			}
+			expectCells(t, cells, []string{
+				"info:email@10=a@x", "info:name@20=anne", "info:name@10=ann", "stats:visits@10=3",
+			})
+
+			cells, _ = ReadRow(engine, row, &RowFilter{Families: []string{"stats"}})
+			expectCells(t, cells, []string{"stats:visits@10=3"})
+			cells, _ = ReadRow(engine, row, &RowFilter{Columns: []Column{{Family: "info", Qualifier: []byte("name")}}})
+			expectCells(t, cells, []string{"info:name@20=anne", "info:name@10=ann"})
+
+			// Deletes apply in order, including to cells set in the same call
+			err = MutateRow(engine, row, []Mutation{
+				DeleteCell("info", []byte("name"), 20),
+				SetCell("stats", []byte("likes"), 5, []byte("1")),
+				DeleteFamily("stats"),
+				SetCell("stats", []byte("visits"), 11, []byte("4")),
+			})
+			if err != nil {
+				t.Fatalf("MutateRow failed: %v", err)
+			}
+			cells, _ = ReadRow(engine, row, nil)
+			expectCells(t, cells, []string{"info:email@10=a@x", "info:name@10=ann", "stats:visits@11=4"})
+
+			if err := MutateRow(engine, row, []Mutation{DeleteColumn("info", []byte("email"))}); err != nil {
+				t.Fatalf("MutateRow failed: %v", err)
+			}
+			cells, _ = ReadRow(engine, row, &RowFilter{Families: []string{"info"}})
+			expectCells(t, cells, []string{"info:name@10=ann"})
+
+			if err := MutateRow(engine, row, []Mutation{DeleteRow()}); err != nil {
+				t.Fatalf("MutateRow failed: %v", err)
+			}
+			if cells, _ = ReadRow(engine, row, nil); len(cells) != 0 {
+				t.Errorf("Expected the row to be empty, got %d cells", len(cells))
+			}
+			cells, _ = ReadRow(engine, []byte("user#10"), nil)
+			expectCells(t, cells, []string{"info:name@1=bob"})
+			expectGet(t, engine, "user#1", "flat", true)
+		})
+	}
+}
+
+func TestCells_ServerTimestampAndValidation(t *testing.T) {
+	engine := NewMemoryEngine()
+	defer engine.Close()
+
+	if err := MutateRow(engine, []byte("r"), []Mutation{SetCell("f", []byte("q"), ServerTimestamp, []byte("v"))}); err != nil {
+		t.Fatalf("MutateRow failed: %v", err)
+	}
+	cells, _ := ReadRow(engine, []byte("r"), nil)
+	if len(cells) != 1 || cells[0].Timestamp <= 0 || !bytes.Equal(cells[0].Value, []byte("v")) {
+		t.Fatalf("Expected one cell stamped by the server, got %+v", cells)
+	}
+
+	invalid := [][]Mutation{
+		nil,
+		{SetCell("", []byte("q"), 1, nil)},
+		{SetCell("bad family", []byte("q"), 1, nil)},
+		{SetCell("f", []byte("q"), -2, nil)},
+		{DeleteCell("f", []byte("q"), ServerTimestamp)},
+	}
+	for i, mutations := range invalid {
+		if err := MutateRow(engine, []byte("r"), mutations); err == nil {
+			t.Errorf("Expected mutation set %d to be rejected", i)
+		}
+	}
+}
+
+func TestCells_VersionFilters(t *testing.T) {
+	for name, open := range engineFactories {
+		t.Run(name, func(t *testing.T) {
+			engine := open(t)
+			defer engine.Close()
+
+			var mutations []Mutation
+			for _, ts := range []int64{10, 20, 30, 40} {
+				mutations = append(mutations,
+					SetCell("f", []byte("a"), ts, []byte(fmt.Sprint("a", ts))),
+					SetCell("f", []byte("b"), ts, []byte(fmt.Sprint("b", ts))))
+			}
+			if err := MutateRow(engine, []byte("r"), mutations); err != nil {
+				t.Fatalf("MutateRow failed: %v", err)
+			}
+
+			cells, _ := ReadRow(engine, []byte("r"), &RowFilter{LatestN: 1})
+			expectCells(t, cells, []string{"f:a@40=a40", "f:b@40=b40"})
+			cells, _ = ReadRow(engine, []byte("r"), &RowFilter{Families: []string{"f"}, LatestN: 2})
+			expectCells(t, cells, []string{"f:a@40=a40", "f:a@30=a30", "f:b@40=b40", "f:b@30=b30"})
+			cells, _ = ReadRow(engine, []byte("r"), &RowFilter{
+				Columns:        []Column{{Family: "f", Qualifier: []byte("a")}},
+				StartTimestamp: 15,
+				EndTimestamp:   35,
+			})
+			expectCells(t, cells, []string{"f:a@30=a30", "f:a@20=a20"})
+			cells, _ = ReadRow(engine, []byte("r"), &RowFilter{StartTimestamp: 15, EndTimestamp: 35, LatestN: 1})
+			expectCells(t, cells, []string{"f:a@30=a30", "f:b@30=b30"})
+			cells, _ = ReadRow(engine, []byte("r"), &RowFilter{StartTimestamp: 40})
+			expectCells(t, cells, []string{"f:a@40=a40", "f:b@40=b40"})
+		})
+	}
+}
+
+func TestGCPolicy_Collects(t *testing.T) {
+	const now = int64(10_000_000_000)
+	hour := time.Hour.Microseconds()
+	tests := []struct {
+		policy    GCPolicy
+		newer     int
+		timestamp int64
+		want      bool
+	}{
+		{GCPolicy{}, 100, 0, false},
+		{GCPolicy{MaxVersions: 2}, 1, 0, false},
+		{GCPolicy{MaxVersions: 2}, 2, now, true},
+		{GCPolicy{MaxAge: time.Hour}, 0, now - hour + 1, false},
+		{GCPolicy{MaxAge: time.Hour}, 0, now - hour - 1, true},
+		{GCPolicy{MaxVersions: 2, MaxAge: time.Hour}, 2, now, true},
+		{GCPolicy{MaxVersions: 2, MaxAge: time.Hour}, 0, 0, true},
+		{GCPolicy{MaxVersions: 2, MaxAge: time.Hour, Mode: GCIntersection}, 2, now, false},
+		{GCPolicy{MaxVersions: 2, MaxAge: time.Hour, Mode: GCIntersection}, 0, 0, false},
+		{GCPolicy{MaxVersions: 2, MaxAge: time.Hour, Mode: GCIntersection}, 2, 0, true},
+		{GCPolicy{MaxVersions: 2, Mode: GCIntersection}, 2, now, true},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.collects(tt.newer, tt.timestamp, now); got != tt.want {
+			t.Errorf("%+v collects(%d, %d) = %v, want %v", tt.policy, tt.newer, tt.timestamp, got, tt.want)
+		}
+	}
+}
+
+// countCells counts the cells of row physically left in the engine, which
+// a plain iterator returns whatever their family's GC policy
+func countCells(t *testing.T, engine Engine, row string) int {
+	t.Helper()
+	keys, err := keysWithPrefix(engine, rowKeyPrefix([]byte(row)))
+	if err != nil {
+		t.Fatalf("Iterating failed: %v", err)
+	}
+	return len(keys)
+}
+
+func TestCells_CompactionEnforcesGCPolicies(t *testing.T) {
+	snapshotBackends(t, func(t *testing.T, opts Options) {
+		opts.ColumnFamilies = map[string]GCPolicy{
+			"recent": {MaxVersions: 2},
+			"fresh":  {MaxAge: time.Hour},
+			"both":   {MaxVersions: 1, MaxAge: time.Hour, Mode: GCIntersection},
+		}
+		engine := setupIteratorEngine(t, opts)
+		if policy, ok := engine.GCPolicy("recent"); !ok || policy.MaxVersions != 2 {
+			t.Fatalf("Expected the recent family policy, got %+v, %v", policy, ok)
+		}
+
+		now := time.Now().UnixMicro()
+		old := now - 2*time.Hour.Microseconds()
+		err := MutateRow(engine, []byte("r"), []Mutation{
+			SetCell("recent", []byte("q"), 1, []byte("v1")),
+			SetCell("recent", []byte("q"), 2, []byte("v2")),
+			SetCell("recent", []byte("q"), 3, []byte("v3")),
+			SetCell("fresh", []byte("q"), old, []byte("old")),
+			SetCell("fresh", []byte("q"), now, []byte("new")),
+			SetCell("both", []byte("q"), old-1, []byte("older")),
+			SetCell("both", []byte("q"), old, []byte("old")),
+			SetCell("both", []byte("q"), now, []byte("new")),
+			SetCell("other", []byte("q"), 1, []byte("kept")),
+			SetCell("other", []byte("q"), 2, []byte("kept")),
+		})
+		if err != nil {
+			t.Fatalf("MutateRow failed: %v", err)
+		}
+
+		// Reads hide collected versions before any compaction
+		want := []string{
+			fmt.Sprintf("both:q@%d=new", now),
+			fmt.Sprintf("fresh:q@%d=new", now),
+			"other:q@2=kept", "other:q@1=kept",
+			"recent:q@3=v3", "recent:q@2=v2",
+		}
+		cells, _ := ReadRow(engine, []byte("r"), nil)
+		expectCells(t, cells, want)
+		if n := countCells(t, engine, "r"); n != 10 {
+			t.Fatalf("Expected 10 cells before compaction, got %d", n)
+		}
+
+		if err := engine.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		if err := engine.Compact(); err != nil {
+			t.Fatalf("Compact failed: %v", err)
+		}
+		if n := countCells(t, engine, "r"); n != 6 {
+			t.Errorf("Expected 6 cells after compaction, got %d", n)
+		}
+		stats, _ := engine.Stats()
+		if stats.CompactionEntriesDropped != 4 {
+			t.Errorf("Expected 4 versions collected, got %d", stats.CompactionEntriesDropped)
+		}
+		cells, _ = ReadRow(engine, []byte("r"), nil)
+		expectCells(t, cells, want)
+	})
+}
+
+// Versions written after the oldest snapshot do not count towards the
+// versions a policy keeps, so the snapshot still finds its own newest ones.
+func TestCells_GCRespectsSnapshots(t *testing.T) {
+	snapshotBackends(t, func(t *testing.T, opts Options) {
+		opts.ColumnFamilies = map[string]GCPolicy{"f": {MaxVersions: 2}}
+		engine := setupIteratorEngine(t, opts)
+		set := func(ts int64) {
+			if err := MutateRow(engine, []byte("r"), []Mutation{SetCell("f", []byte("q"), ts, []byte("v"))}); err != nil {
+				t.Fatalf("MutateRow failed: %v", err)
+			}
+		}
+		set(1)
+		set(2)
+		set(3)
+		snap, err := engine.Snapshot()
+		if err != nil {
+			t.Fatalf("Snapshot failed: %v", err)
+		}
+		set(4)
+		set(5)
+
+		compact := func() {
+			t.Helper()
+			if err := engine.Flush(); err != nil {
+				t.Fatalf("Flush failed: %v", err)
+			}
+			if err := engine.Compact(); err != nil {
+				t.Fatalf("Compact failed: %v", err)
+			}
+		}
+		compact()
+		if n := countCells(t, engine, "r"); n != 4 {
+			t.Errorf("Expected 4 cells while the snapshot is held, got %d", n)
+		}
+		for _, ts := range []int64{2, 3} {
+			if _, found, _ := snap.Get(EncodeCellKey([]byte("r"), "f", []byte("q"), ts)); !found {
+				t.Errorf("Snapshot lost the version at %d", ts)
+			}
+		}
+
+		snap.Release()
+		set(6) // a write gives the compaction something to do
+		compact()
+		if n := countCells(t, engine, "r"); n != 2 {
+			t.Errorf("Expected 2 cells after the snapshot is released, got %d", n)
+		}
+	})
+}
+
+func TestCells_InvalidGCPolicies(t *testing.T) {
+	invalid := []map[string]GCPolicy{
+		{"bad family": {MaxVersions: 1}},
+		{"f": {MaxVersions: -1}},
+		{"f": {MaxAge: -time.Second}},
+		{"f": {MaxVersions: 1, Mode: "either"}},
+	}
+	for _, families := range invalid {
+		testDir := t.TempDir()
+		opts := DefaultOptions()
+		opts.ColumnFamilies = families
+		if _, err := NewSSTableEngineWithOptions(testDir, filepath.Join(testDir, "wal.txt"), opts); err == nil {
+			t.Errorf("Expected an error for column families %+v", families)
+		}
+	}
+	if _, err := ParseGCMode("both"); err == nil {
+		t.Errorf("Expected ParseGCMode to reject an unknown name")
+	}
+}
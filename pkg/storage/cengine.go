@@ -0,0 +1,275 @@
+//go:build cgo
+
+package storage
+
+/*
+#cgo CXXFLAGS: -std=c++11 -I${SRCDIR}/../../sstable
+#cgo LDFLAGS: -L${SRCDIR}/../../sstable -lsstable -lstdc++ -lz
+#include "../../sstable/sstable.h"
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"time"
+	"unsafe"
+)
+
+// cgoEnabled reports whether the C++ backend is linked into this build.
+const cgoEnabled = true
+
+// cEngine is the engineCore implemented by the C++ library in sstable/.
+type cEngine struct {
+	handle *C.sstable_engine
+}
+
+func newCEngine(dataDir string, opts Options) (engineCore, error) {
+	cOpts := opts.toC()
+	cDir := C.CString(dataDir)
+	defer C.free(unsafe.Pointer(cDir))
+	handle := C.sstable_init(cDir, &cOpts)
+	if handle == nil {
+		return nil, errors.New("failed to initialize sstable")
+	}
+	c := &cEngine{handle: handle}
+	for family, policy := range opts.ColumnFamilies {
+		cFamily, cFamilyLen := cBytes([]byte(family))
+		intersection := C.bool(policy.Mode == GCIntersection)
+		if !C.sstable_set_gc_policy(handle, cFamily, cFamilyLen, C.uint64_t(policy.MaxVersions),
+			C.int64_t(policy.MaxAge.Microseconds()), intersection) {
+			c.close()
+			return nil, errors.New("sstable_set_gc_policy failed")
+		}
+	}
+	return c, nil
+}
+
+func (c *cEngine) put(seq uint64, key, value []byte, expiresAt int64) error {
+	cKey, cKeyLen := cBytes(key)
+	cVal, cValLen := cBytes(value)
+	if !C.sstable_put_expiring(c.handle, C.uint64_t(seq), cKey, cKeyLen, cVal, cValLen, C.int64_t(expiresAt)) {
+		return errors.New("sstable_put failed")
+	}
+	return nil
+}
+
+func (c *cEngine) delete(seq uint64, key []byte) error {
+	cKey, cKeyLen := cBytes(key)
+	if !C.sstable_delete(c.handle, C.uint64_t(seq), cKey, cKeyLen) {
+		return errors.New("sstable_delete failed")
+	}
+	return nil
+}
+
+func (c *cEngine) merge(seq uint64, key, operand []byte) error {
+	cKey, cKeyLen := cBytes(key)
+	cOperand, cOperandLen := cBytes(operand)
+	if !C.sstable_merge(c.handle, C.uint64_t(seq), cKey, cKeyLen, cOperand, cOperandLen) {
+		return errors.New("sstable_merge failed")
+	}
+	return nil
+}
+
+func (c *cEngine) deleteRange(seq uint64, start, end []byte) error {
+	cStart, cStartLen := cBytes(start)
+	cEnd, cEndLen := cBytes(end)
+	if !C.sstable_delete_range(c.handle, C.uint64_t(seq), cStart, cStartLen, cEnd, cEndLen) {
+		return errors.New("sstable_delete_range failed")
+	}
+	return nil
+}
+
+func (c *cEngine) get(key []byte, seq uint64) ([]byte, bool, error) {
+	cKey, cKeyLen := cBytes(key)
+
+	var bytes C.sstable_bytes
+	result := C.sstable_get(c.handle, cKey, cKeyLen, C.uint64_t(seq), &bytes)
+	defer C.sstable_free_bytes(&bytes)
+
+	switch {
+	case result == C.SSTABLE_GET_ERROR:
+		return nil, false, errors.New("sstable_get failed: cannot open an SSTable")
+	case result != C.SSTABLE_GET_FOUND || bytes.data == nil:
+		return nil, false, nil
+	}
+
+	return C.GoBytes(unsafe.Pointer(bytes.data), C.int(bytes.len)), true, nil
+}
+
+func (c *cEngine) setVisibleSeq(seq uint64) {
+	C.sstable_set_visible_seq(c.handle, C.uint64_t(seq))
+}
+
+func (c *cEngine) lastSequence() uint64 {
+	return uint64(C.sstable_last_seq(c.handle))
+}
+
+func (c *cEngine) acquireSnapshot() uint64 {
+	return uint64(C.sstable_snapshot_acquire(c.handle))
+}
+
+func (c *cEngine) releaseSnapshot(seq uint64) {
+	C.sstable_snapshot_release(c.handle, C.uint64_t(seq))
+}
+
+func (c *cEngine) needsFlush() bool {
+	return bool(C.sstable_needs_flush(c.handle))
+}
+
+func (c *cEngine) memtableEmpty() bool {
+	return bool(C.sstable_memtable_empty(c.handle))
+}
+
+func (c *cEngine) makeImmutable() bool {
+	return bool(C.sstable_make_immutable(c.handle))
+}
+
+func (c *cEngine) numImmutable() int {
+	return int(C.sstable_num_immutable(c.handle))
+}
+
+func (c *cEngine) flushImmutable() error {
+	if !C.sstable_flush_immutable(c.handle) {
+		return errors.New("sstable_flush_immutable failed")
+	}
+	return nil
+}
+
+func (c *cEngine) compact() error {
+	if !C.sstable_compact(c.handle) {
+		return errors.New("sstable_compact failed")
+	}
+	return nil
+}
+
+func (c *cEngine) checkpoint(dir string) error {
+	cDir := C.CString(dir)
+	defer C.free(unsafe.Pointer(cDir))
+	if !C.sstable_checkpoint(c.handle, cDir) {
+		return errors.New("sstable_checkpoint failed")
+	}
+	return nil
+}
+
+func (c *cEngine) stats() (EngineStats, error) {
+	var s C.sstable_stats
+	if !C.sstable_get_stats(c.handle, &s) {
+		return EngineStats{}, errors.New("sstable_get_stats failed")
+	}
+
+	return EngineStats{
+		SSTables:                    uint64(s.num_sstables),
+		SSTableBytes:                uint64(s.sstable_bytes),
+		MemtableBytes:               uint64(s.memtable_bytes),
+		ImmutableMemtables:          uint64(s.immutable_memtables),
+		Compactions:                 uint64(s.compactions),
+		CompactionBytesRead:         uint64(s.compaction_bytes_read),
+		CompactionBytesWritten:      uint64(s.compaction_bytes_written),
+		CompactionEntriesDropped:    uint64(s.compaction_entries_dropped),
+		CompactionTombstonesDropped: uint64(s.compaction_tombstones_dropped),
+		CompactionEntriesExpired:    uint64(s.compaction_entries_expired),
+		KeysExpired:                 uint64(s.keys_expired),
+		CompactionTime:              time.Duration(s.compaction_micros) * time.Microsecond,
+		FilterHits:                  uint64(s.filter_hits),
+		FilterMisses:                uint64(s.filter_misses),
+		TableCacheHits:              uint64(s.table_cache_hits),
+		TableCacheMisses:            uint64(s.table_cache_misses),
+		BlockCacheHits:              uint64(s.block_cache_hits),
+		BlockCacheMisses:            uint64(s.block_cache_misses),
+		BlockCacheUsage:             uint64(s.block_cache_usage),
+		BlockCacheCapacity:          uint64(s.block_cache_capacity),
+	}, nil
+}
+
+func (c *cEngine) newIterator(seq uint64, lower, upper []byte) (coreIterator, error) {
+	cLower, cLowerLen := cBytes(lower)
+	cUpper, cUpperLen := cBytes(upper)
+	handle := C.sstable_iter_new_bounded(c.handle, C.uint64_t(seq), cLower, cLowerLen, cUpper, cUpperLen)
+	if handle == nil {
+		return nil, errors.New("sstable_iter_new_bounded failed")
+	}
+	return &cIterator{handle: handle}, nil
+}
+
+func (c *cEngine) setFlushCrashPoint(point int) {
+	C.sstable_set_flush_crash_point(c.handle, C.int(point))
+}
+
+func (c *cEngine) close() {
+	C.sstable_destroy(c.handle)
+	c.handle = nil
+}
+
+// cIterator wraps an sstable_iterator.
+type cIterator struct {
+	handle *C.sstable_iterator
+}
+
+func (it *cIterator) valid() bool { return bool(C.sstable_iter_valid(it.handle)) }
+func (it *cIterator) seekToFirst() { C.sstable_iter_seek_to_first(it.handle) }
+func (it *cIterator) seekToLast()  { C.sstable_iter_seek_to_last(it.handle) }
+func (it *cIterator) next()        { C.sstable_iter_next(it.handle) }
+func (it *cIterator) prev()        { C.sstable_iter_prev(it.handle) }
+
+func (it *cIterator) seek(key []byte) {
+	cKey, cKeyLen := cBytes(key)
+	C.sstable_iter_seek(it.handle, cKey, cKeyLen)
+}
+
+func (it *cIterator) key() []byte {
+	var out C.sstable_bytes
+	if !C.sstable_iter_key(it.handle, &out) {
+		return nil
+	}
+	return C.GoBytes(unsafe.Pointer(out.data), C.int(out.len))
+}
+
+func (it *cIterator) value() []byte {
+	var out C.sstable_bytes
+	if !C.sstable_iter_value(it.handle, &out) {
+		return nil
+	}
+	return C.GoBytes(unsafe.Pointer(out.data), C.int(out.len))
+}
+
+func (it *cIterator) close() {
+	C.sstable_iter_destroy(it.handle)
+	it.handle = nil
+}
+
+// toC converts validated opts to the C options struct.
+func (o Options) toC() C.sstable_options {
+	var c C.sstable_options
+	C.sstable_default_options(&c)
+	if o.CompactionStrategy == CompactionLeveled {
+		c.compaction_strategy = C.SSTABLE_COMPACTION_LEVELED
+	} else {
+		c.compaction_strategy = C.SSTABLE_COMPACTION_SIZE_TIERED
+	}
+	c.level0_file_trigger = C.uint32_t(o.Level0FileTrigger)
+	c.level1_max_bytes = C.uint64_t(o.Level1MaxBytes)
+	c.level_size_ratio = C.uint32_t(o.LevelSizeRatio)
+	c.target_file_size = C.uint64_t(o.TargetFileSize)
+	c.bloom_bits_per_key = C.uint32_t(o.bloomBitsPerKey())
+	c.block_size = C.uint32_t(o.BlockSize)
+	c.max_open_tables = C.uint32_t(o.MaxOpenTables)
+	c.block_cache_bytes = C.uint64_t(o.blockCacheBytes())
+	if o.Compression == CompressionZlib {
+		c.compression = C.SSTABLE_COMPRESSION_ZLIB
+	} else {
+		c.compression = C.SSTABLE_COMPRESSION_NONE
+	}
+	mergeOperator, _ := builtinMergeOperator(o.MergeOperator)
+	c.merge_operator = C.int(mergeOperator)
+	return c
+}
+
+// cBytes returns a C view of b without copying. The pointer is only valid for
+// the duration of the cgo call it is passed to, which must not retain it.
+func cBytes(b []byte) (*C.char, C.size_t) {
+	if len(b) == 0 {
+		return nil, 0
+	}
+	return (*C.char)(unsafe.Pointer(&b[0])), C.size_t(len(b))
+}
@@ -0,0 +1,12 @@
+//go:build !cgo
+
+package storage
+
+import "errors"
+
+// cgoEnabled reports whether the C++ backend is linked into this build.
+const cgoEnabled = false
+
+func newCEngine(dataDir string, opts Options) (engineCore, error) {
+	return nil, errors.New(`the "cpp" backend needs a cgo build; use the "go" backend`)
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Checkpoint writes a consistent copy of the engine to dir, which must not
+// exist yet. The memtable is flushed first, every live SSTable is
+// hard-linked into dir and the MANIFEST is copied, so dir must be on the
+// same filesystem as the data directory; a checkpoint only takes space once
+// compactions replace the tables it shares. Writes logged while the flush
+// ran are carried over by copying the WAL and its sealed segments under
+// their own names, so the checkpoint opens with
+//
+//	NewSSTableEngine(dir, filepath.Join(dir, filepath.Base(walPath)))
+//
+// The checkpoint is assembled in a new temporary directory beside dir and
+// renamed into place, so dir either holds a whole checkpoint or does not
+// exist. Checkpoint never removes anything it did not create.
+func (e *SSTableEngine) Checkpoint(dir string) error {
+	if err := e.acquire(); err != nil {
+		return err
+	}
+	defer e.closeMu.RUnlock()
+
+	dir = filepath.Clean(dir)
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("checkpoint directory %s already exists", dir)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), filepath.Base(dir)+".tmp-")
+	if err != nil {
+		return err
+	}
+	// MkdirTemp creates the directory private to the engine's user
+	if err := os.Chmod(tmp, 0755); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := e.writeCheckpoint(tmp); err != nil {
+		os.RemoveAll(tmp)
+		return fmt.Errorf("checkpoint failed: %w", err)
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(dir))
+}
+
+// writeCheckpoint fills the empty directory dir with the engine's tables,
+// MANIFEST and WAL
+func (e *SSTableEngine) writeCheckpoint(dir string) error {
+	// Flushing first keeps the WAL tail short
+	if err := e.flushAll(); err != nil {
+		return err
+	}
+
+	// Holding flushMu and mu stops flushes and writes, so the WAL copied
+	// holds exactly the writes the linked tables are missing
+	e.flushMu.Lock()
+	defer e.flushMu.Unlock()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.core.checkpoint(dir); err != nil {
+		return err
+	}
+
+	logs := append([]string(nil), e.recovered...)
+	for _, segments := range e.sealed {
+		logs = append(logs, segments...)
+	}
+	logs = append(logs, e.walPath)
+	for _, path := range logs {
+		if err := copyFile(path, filepath.Join(dir, filepath.Base(path))); err != nil {
+			return err
+		}
+	}
+	return syncDir(dir)
+}
+
+// copyFile copies src to the new file dst and syncs it
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestSSTableEngine_Checkpoint(t *testing.T) {
+	snapshotBackends(t, func(t *testing.T, opts Options) {
+		dir := t.TempDir()
+		engine, err := NewSSTableEngineWithOptions(dir, filepath.Join(dir, "wal.txt"), opts)
+		if err != nil {
+			t.Fatalf("Failed to create SSTable engine: %v", err)
+		}
+		defer engine.DestroySSTableEngine()
+
+		if err := engine.Put([]byte("flushed"), []byte("v1")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := engine.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		if err := engine.Put([]byte("memtable"), []byte("v2")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := engine.DeleteRange([]byte("a"), []byte("b")); err != nil {
+			t.Fatalf("DeleteRange failed: %v", err)
+		}
+
+		// A path that merely looks like a staging directory is left alone
+		checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+		bystander := filepath.Join(checkpoint+".tmp", "file")
+		if err := os.MkdirAll(filepath.Dir(bystander), 0755); err != nil {
+			t.Fatalf("MkdirAll failed: %v", err)
+		}
+		if err := os.WriteFile(bystander, []byte("keep"), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+
+		if err := engine.Checkpoint(checkpoint); err != nil {
+			t.Fatalf("Checkpoint failed: %v", err)
+		}
+		if err := engine.Checkpoint(checkpoint); err == nil {
+			t.Error("Expected a checkpoint into an existing directory to fail")
+		}
+		if _, err := os.Stat(bystander); err != nil {
+			t.Errorf("Checkpoint removed %s: %v", bystander, err)
+		}
+		if matches, _ := filepath.Glob(checkpoint + ".tmp-*"); len(matches) != 0 {
+			t.Errorf("Checkpoint left staging directories behind: %v", matches)
+		}
+
+		// Later writes and compactions do not reach the checkpoint, even
+		// once the tables it links are deleted from the engine
+		if err := engine.Put([]byte("flushed"), []byte("v3")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := engine.Put([]byte("later"), []byte("v4")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := engine.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		if err := engine.Compact(); err != nil {
+			t.Fatalf("Compact failed: %v", err)
+		}
+
+		restored, err := NewSSTableEngineWithOptions(checkpoint, filepath.Join(checkpoint, "wal.txt"), opts)
+		if err != nil {
+			t.Fatalf("Failed to open checkpoint: %v", err)
+		}
+		defer restored.DestroySSTableEngine()
+		expectValues(t, restored, map[string]string{"flushed": "v1", "memtable": "v2"})
+		if _, found, _ := restored.Get([]byte("later")); found {
+			t.Error("Checkpoint sees a write made after it")
+		}
+		expectValues(t, engine, map[string]string{"flushed": "v3", "later": "v4"})
+	})
+}
+
+func TestSSTableEngine_CheckpointDuringWrites(t *testing.T) {
+	snapshotBackends(t, func(t *testing.T, opts Options) {
+		dir := t.TempDir()
+		engine, err := NewSSTableEngineWithOptions(dir, filepath.Join(dir, "wal.txt"), opts)
+		if err != nil {
+			t.Fatalf("Failed to create SSTable engine: %v", err)
+		}
+		defer engine.DestroySSTableEngine()
+
+		const n = 2000
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				if err := engine.Put([]byte(fmt.Sprintf("key%05d", i)), []byte("value")); err != nil {
+					t.Errorf("Put failed: %v", err)
+					return
+				}
+			}
+		}()
+
+		checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+		err = engine.Checkpoint(checkpoint)
+		wg.Wait()
+		if err != nil {
+			t.Fatalf("Checkpoint failed: %v", err)
+		}
+
+		// Writes are sequential, so the checkpoint holds a prefix of them
+		restored, err := NewSSTableEngineWithOptions(checkpoint, filepath.Join(checkpoint, "wal.txt"), opts)
+		if err != nil {
+			t.Fatalf("Failed to open checkpoint: %v", err)
+		}
+		defer restored.DestroySSTableEngine()
+		missing := -1
+		for i := 0; i < n; i++ {
+			_, found, err := restored.Get([]byte(fmt.Sprintf("key%05d", i)))
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			if !found && missing < 0 {
+				missing = i
+			} else if found && missing >= 0 {
+				t.Fatalf("Checkpoint has key%05d but not key%05d", i, missing)
+			}
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var errEngineClosed = errors.New("engine closed")
+
+var (
+	_ Engine = (*SSTableEngine)(nil)
+	_ Engine = (*RedisEngine)(nil)
+	_ Engine = (*MemoryEngine)(nil)
+)
+
+// Engine is a key-value store the server can run on. Keys and values are
+// arbitrary bytes. Implementations are safe for concurrent use.
+type Engine interface {
+	// Get returns the value of key, or false if it is not set.
+	Get(key []byte) ([]byte, bool, error)
+	Put(key, value []byte) error
+	// PutWithTTL is Put for a value that expires ttl from now; from then
+	// on Get and iterators no longer see the key. A ttl of 0 never expires.
+	PutWithTTL(key, value []byte, ttl time.Duration) error
+	Delete(key []byte) error
+	// DeleteRange deletes every key k with start <= k < end. It fails if
+	// end is not after start.
+	DeleteRange(start, end []byte) error
+
+	// Write applies the batch's operations in order. Engines that log
+	// writes make the whole batch durable with a single sync.
+	Write(batch *Batch) error
+
+	// NewIterator returns an iterator over a snapshot of the live keys.
+	NewIterator() (Iterator, error)
+
+	// Stats reports the engine's counters. Fields an engine has no notion
+	// of are left at zero.
+	Stats() (EngineStats, error)
+
+	// Close releases the engine. It must not be used afterwards.
+	Close() error
+}
+
+// Iterator walks an Engine's keys in order over the snapshot taken when it
+// was created. A new iterator is unpositioned: call SeekToFirst, SeekToLast
+// or Seek before reading from it. An iterator must not be used by several
+// goroutines at once, and must be closed before its engine.
+type Iterator interface {
+	Valid() bool
+	SeekToFirst()
+	SeekToLast()
+	// Seek positions the iterator at the first key not smaller than key.
+	Seek(key []byte)
+	Next()
+	Prev()
+	// Key and Value return copies, or nil if the iterator is not valid.
+	Key() []byte
+	Value() []byte
+	Close()
+}
+
+// batchOp is one write in a Batch.
+type batchOp struct {
+	delete bool
+	key    []byte
+	value  []byte
+	ttl    time.Duration
+}
+
+// Batch collects writes to apply together with Engine.Write. The zero value
+// is an empty batch. A Batch is not safe for concurrent use.
+type Batch struct {
+	ops []batchOp
+}
+
+// Put adds a write of value to key. The batch keeps copies of both.
+func (b *Batch) Put(key, value []byte) {
+	b.PutWithTTL(key, value, 0)
+}
+
+// PutWithTTL adds a write of value to key that expires ttl after the batch
+// is written, or never if ttl is 0. Write rejects a negative ttl.
+func (b *Batch) PutWithTTL(key, value []byte, ttl time.Duration) {
+	b.ops = append(b.ops, batchOp{
+		key:   append([]byte(nil), key...),
+		value: append([]byte(nil), value...),
+		ttl:   ttl,
+	})
+}
+
+// Delete adds a deletion of key.
+func (b *Batch) Delete(key []byte) {
+	b.ops = append(b.ops, batchOp{delete: true, key: append([]byte(nil), key...)})
+}
+
+// Len returns the number of operations in the batch.
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Reset empties the batch so it can be reused.
+func (b *Batch) Reset() {
+	b.ops = b.ops[:0]
+}
+
+// validate rejects operations no engine can apply
+func (b *Batch) validate() error {
+	for _, op := range b.ops {
+		if op.ttl < 0 {
+			return fmt.Errorf("invalid TTL %v", op.ttl)
+		}
+	}
+	return nil
+}
+
+// validateRange rejects a DeleteRange whose range is empty or reversed
+func validateRange(start, end []byte) error {
+	if bytes.Compare(start, end) >= 0 {
+		return fmt.Errorf("invalid range [%q, %q)", start, end)
+	}
+	return nil
+}
+
+// expiresAt is when a value written now with ttl expires, in microseconds
+// since the Unix epoch, or 0 if it never does
+func expiresAt(now time.Time, ttl time.Duration) int64 {
+	if ttl == 0 {
+		return 0
+	}
+	return now.Add(ttl).UnixMicro()
+}
+
+// newSnapshotIterator returns an Iterator over records already in key
+// order, for engines that take their snapshot by copying it out. It reuses
+// the SSTable engine's iterator with the records as its only source.
+func newSnapshotIterator(run sortedRun) Iterator {
+	return &SSTableIterator{iter: &mergingIterator{sources: []internalIterator{newRunIterator(run)}}}
+}
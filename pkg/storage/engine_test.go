@@ -0,0 +1,311 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+)
+
+// engineFactories open a fresh instance of every Engine that runs without
+// external services.
+var engineFactories = map[string]func(t *testing.T) Engine{
+	"memory": func(t *testing.T) Engine {
+		return NewMemoryEngine()
+	},
+	"sstable": func(t *testing.T) Engine {
+		dir := t.TempDir()
+		engine, err := NewSSTableEngine(dir, filepath.Join(dir, "wal.txt"))
+		if err != nil {
+			t.Fatalf("Failed to create SSTable engine: %v", err)
+		}
+		return engine
+	},
+}
+
+func expectGet(t *testing.T, engine Engine, key, want string, wantFound bool) {
+	t.Helper()
+	value, found, err := engine.Get([]byte(key))
+	if err != nil {
+		t.Fatalf("Get(%q) failed: %v", key, err)
+	}
+	if found != wantFound || string(value) != want {
+		t.Errorf("Get(%q) = %q, %v; want %q, %v", key, value, found, want, wantFound)
+	}
+}
+
+func TestEngine_Contract(t *testing.T) {
+	for name, open := range engineFactories {
+		t.Run(name, func(t *testing.T) {
+			engine := open(t)
+			defer engine.Close()
+
+			if err := engine.Put([]byte("a"), []byte("1")); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+			if err := engine.Put([]byte("b"), []byte("2")); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+			if err := engine.Delete([]byte("b")); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+			expectGet(t, engine, "a", "1", true)
+			expectGet(t, engine, "b", "", false)
+			expectGet(t, engine, "missing", "", false)
+
+			var batch Batch
+			batch.Put([]byte("c"), []byte("3"))
+			batch.Put([]byte("d"), []byte("4"))
+			batch.Delete([]byte("a"))
+			batch.Put([]byte("c"), []byte("5"))
+			if err := engine.Write(&batch); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			expectGet(t, engine, "a", "", false)
+			expectGet(t, engine, "c", "5", true)
+			expectGet(t, engine, "d", "4", true)
+
+			it, err := engine.NewIterator()
+			if err != nil {
+				t.Fatalf("NewIterator failed: %v", err)
+			}
+			defer it.Close()
+
+			// Writes after the iterator was created are not visible to it
+			if err := engine.Put([]byte("e"), []byte("6")); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+
+			it.SeekToFirst()
+			expectEntries(t, "forward", collect(it, true), []string{"c=5", "d=4"})
+			it.SeekToLast()
+			expectEntries(t, "backward", collect(it, false), []string{"d=4", "c=5"})
+			it.Seek([]byte("cc"))
+			expectEntries(t, "seek", collect(it, true), []string{"d=4"})
+
+			if _, err := engine.Stats(); err != nil {
+				t.Fatalf("Stats failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestEngine_TTL(t *testing.T) {
+	for name, open := range engineFactories {
+		t.Run(name, func(t *testing.T) {
+			engine := open(t)
+			defer engine.Close()
+
+			engine.Put([]byte("shadowed"), []byte("old"))
+			if err := engine.PutWithTTL([]byte("shadowed"), []byte("new"), time.Millisecond); err != nil {
+				t.Fatalf("PutWithTTL failed: %v", err)
+			}
+			engine.PutWithTTL([]byte("short"), []byte("1"), time.Millisecond)
+			engine.PutWithTTL([]byte("long"), []byte("2"), time.Hour)
+			engine.PutWithTTL([]byte("plain"), []byte("3"), 0)
+			var batch Batch
+			batch.PutWithTTL([]byte("batched"), []byte("4"), time.Millisecond)
+			batch.PutWithTTL([]byte("revived"), []byte("5"), time.Millisecond)
+			if err := engine.Write(&batch); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			time.Sleep(10 * time.Millisecond)
+
+			// An expired value hides older versions of its key, like a delete
+			expectGet(t, engine, "shadowed", "", false)
+			expectGet(t, engine, "short", "", false)
+			expectGet(t, engine, "batched", "", false)
+			expectGet(t, engine, "long", "2", true)
+			expectGet(t, engine, "plain", "3", true)
+			if stats, err := engine.Stats(); err != nil || stats.KeysExpired != 3 {
+				t.Errorf("Expected 3 lookups to find a key expired, got %d, %v", stats.KeysExpired, err)
+			}
+
+			engine.Put([]byte("revived"), []byte("6"))
+			it, err := engine.NewIterator()
+			if err != nil {
+				t.Fatalf("NewIterator failed: %v", err)
+			}
+			defer it.Close()
+			it.SeekToFirst()
+			expectEntries(t, "forward", collect(it, true), []string{"long=2", "plain=3", "revived=6"})
+			it.SeekToLast()
+			expectEntries(t, "backward", collect(it, false), []string{"revived=6", "plain=3", "long=2"})
+
+			if err := engine.PutWithTTL([]byte("k"), []byte("v"), -time.Second); err == nil {
+				t.Errorf("Expected a negative TTL to be rejected")
+			}
+			batch.Reset()
+			batch.PutWithTTL([]byte("k"), []byte("v"), -time.Second)
+			if err := engine.Write(&batch); err == nil {
+				t.Errorf("Expected a batch with a negative TTL to be rejected")
+			}
+			expectGet(t, engine, "k", "", false)
+		})
+	}
+}
+
+func TestEngine_DeleteRange(t *testing.T) {
+	for name, open := range engineFactories {
+		t.Run(name, func(t *testing.T) {
+			engine := open(t)
+			defer engine.Close()
+
+			for _, key := range []string{"a", "b", "b2", "c", "d"} {
+				engine.Put([]byte(key), []byte(key))
+			}
+			if err := engine.DeleteRange([]byte("b"), []byte("d")); err != nil {
+				t.Fatalf("DeleteRange failed: %v", err)
+			}
+			expectGet(t, engine, "b", "", false)
+			expectGet(t, engine, "c", "", false)
+
+			// The end of the range is kept, and later writes are not deleted
+			engine.Put([]byte("b2"), []byte("new"))
+			it, err := engine.NewIterator()
+			if err != nil {
+				t.Fatalf("NewIterator failed: %v", err)
+			}
+			defer it.Close()
+			it.SeekToFirst()
+			expectEntries(t, "forward", collect(it, true), []string{"a=a", "b2=new", "d=d"})
+			it.SeekToLast()
+			expectEntries(t, "backward", collect(it, false), []string{"d=d", "b2=new", "a=a"})
+
+			for _, r := range [][2]string{{"d", "b"}, {"b", "b"}} {
+				if err := engine.DeleteRange([]byte(r[0]), []byte(r[1])); err == nil {
+					t.Errorf("Expected DeleteRange(%q, %q) to be rejected", r[0], r[1])
+				}
+			}
+			expectGet(t, engine, "d", "d", true)
+		})
+	}
+}
+
+func TestBatch_Reset(t *testing.T) {
+	var batch Batch
+	key := []byte("k")
+	batch.Put(key, []byte("v"))
+	batch.Delete([]byte("x"))
+	key[0] = 'z'
+	if batch.Len() != 2 || string(batch.ops[0].key) != "k" {
+		t.Fatalf("Expected the batch to keep copies of 2 operations, got %+v", batch.ops)
+	}
+	batch.Reset()
+	if batch.Len() != 0 {
+		t.Fatalf("Expected an empty batch after Reset, got %d operations", batch.Len())
+	}
+}
+
+func TestSSTableEngine_WriteBatchRecovery(t *testing.T) {
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, "wal.txt")
+	engine, err := NewSSTableEngine(dir, walPath)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable engine: %v", err)
+	}
+
+	if err := engine.Put([]byte("old"), []byte("v")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	var batch Batch
+	batch.Put([]byte("k1"), []byte("v1"))
+	batch.Put([]byte("k2"), []byte("v2"))
+	batch.Delete([]byte("old"))
+	if err := engine.Write(&batch); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	engine.Close()
+
+	engine, err = NewSSTableEngine(dir, walPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen SSTable engine: %v", err)
+	}
+	defer engine.Close()
+	expectGet(t, engine, "k1", "v1", true)
+	expectGet(t, engine, "k2", "v2", true)
+	expectGet(t, engine, "old", "", false)
+}
+
+func TestMemoryEngine_Closed(t *testing.T) {
+	engine := NewMemoryEngine()
+	engine.Close()
+	if err := engine.Put([]byte("k"), []byte("v")); err == nil {
+		t.Fatalf("Expected Put on a closed engine to fail")
+	}
+	if _, _, err := engine.Get([]byte("k")); err == nil {
+		t.Fatalf("Expected Get on a closed engine to fail")
+	}
+}
+
+func TestRedisEngine(t *testing.T) {
+	client, mock := redismock.NewClientMock()
+	engine := NewRedisEngine(client)
+	defer engine.Close()
+
+	mock.ExpectSet("k", "v", 0).SetVal("OK")
+	mock.ExpectSet("t", "v", 90*time.Second).SetVal("OK")
+	mock.ExpectGet("k").SetVal("v")
+	mock.ExpectGet("missing").RedisNil()
+	mock.ExpectDel("k").SetVal(1)
+
+	if err := engine.Put([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	// Redis expires keys itself
+	if err := engine.PutWithTTL([]byte("t"), []byte("v"), 90*time.Second); err != nil {
+		t.Fatalf("PutWithTTL failed: %v", err)
+	}
+	expectGet(t, engine, "k", "v", true)
+	expectGet(t, engine, "missing", "", false)
+	if err := engine.Delete([]byte("k")); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	mock.ExpectTxPipeline()
+	mock.ExpectSet("a", "1", 0).SetVal("OK")
+	mock.ExpectDel("b").SetVal(1)
+	mock.ExpectSet("c", "3", 1500*time.Millisecond).SetVal("OK")
+	mock.ExpectTxPipelineExec()
+
+	var batch Batch
+	batch.Put([]byte("a"), []byte("1"))
+	batch.Delete([]byte("b"))
+	batch.PutWithTTL([]byte("c"), []byte("3"), 1500*time.Millisecond)
+	if err := engine.Write(&batch); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// Keys come back from SCAN unordered, possibly twice, and may be gone
+	// by the time their values are read
+	mock.ExpectScan(0, "*", redisScanCount).SetVal([]string{"c", "a"}, 7)
+	mock.ExpectScan(7, "*", redisScanCount).SetVal([]string{"b", "a"}, 0)
+	mock.ExpectMGet("a", "b", "c").SetVal([]interface{}{"1", nil, "3"})
+
+	it, err := engine.NewIterator()
+	if err != nil {
+		t.Fatalf("NewIterator failed: %v", err)
+	}
+	defer it.Close()
+	it.SeekToFirst()
+	expectEntries(t, "redis", collect(it, true), []string{"a=1", "c=3"})
+
+	// DeleteRange deletes what each SCAN page holds in the range
+	mock.ExpectScan(0, "*", redisScanCount).SetVal([]string{"c", "a", "d"}, 3)
+	mock.ExpectDel("c", "a").SetVal(2)
+	mock.ExpectScan(3, "*", redisScanCount).SetVal([]string{"e"}, 0)
+	if err := engine.DeleteRange([]byte("a"), []byte("d")); err != nil {
+		t.Fatalf("DeleteRange failed: %v", err)
+	}
+
+	// Redis counts the keys it expired, lazily or in the background
+	mock.ExpectInfo("stats").SetVal("# Stats\r\nexpired_keys:12\r\nevicted_keys:0\r\n")
+	if stats, err := engine.Stats(); err != nil || stats.KeysExpired != 12 {
+		t.Errorf("Expected 12 expired keys, got %d, %v", stats.KeysExpired, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("Unmet redis expectations: %v", err)
+	}
+}
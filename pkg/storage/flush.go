@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alexciechonski/BigTableLite/pkg/wal"
+)
+
+// A full memtable is sealed rather than flushed inline: it becomes an
+// immutable memtable that reads still see, and the WAL holding its writes is
+// renamed to a numbered segment (<wal path>.000001, ...). A background
+// goroutine writes immutable memtables to SSTables oldest first and deletes
+// each one's segments once its table is committed. Writes only wait when
+// Options.MaxImmutableMemtables memtables are already waiting.
+
+// walSegmentPath names the sealed WAL segment with the given number.
+func walSegmentPath(walPath string, n uint64) string {
+	return fmt.Sprintf("%s.%06d", walPath, n)
+}
+
+// listWALSegments returns the sealed segments of walPath oldest first, and
+// the number to give the next one.
+func listWALSegments(walPath string) ([]string, uint64, error) {
+	matches, err := filepath.Glob(walPath + ".*")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var numbers []uint64
+	for _, match := range matches {
+		n, err := strconv.ParseUint(strings.TrimPrefix(match, walPath+"."), 10, 64)
+		if err == nil {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	segments := make([]string, len(numbers))
+	next := uint64(1)
+	for i, n := range numbers {
+		segments[i] = walSegmentPath(walPath, n)
+		next = n + 1
+	}
+	return segments, next, nil
+}
+
+// sealMemtable turns the memtable into an immutable memtable and moves the
+// WAL aside as its segment. Once the WAL is renamed the memtable is sealed
+// even if starting the next WAL fails; the next write retries that. Must be
+// called with e.mu held.
+func (e *SSTableEngine) sealMemtable() error {
+	if e.core.memtableEmpty() {
+		return nil
+	}
+
+	walPath := e.walPath
+	segment := walSegmentPath(walPath, e.nextSegment)
+	e.nextSegment++
+
+	err := e.wal.Close()
+	e.wal = nil
+	if err != nil {
+		e.openWAL()
+		return err
+	}
+	if err := os.Rename(walPath, segment); err != nil {
+		e.openWAL()
+		return fmt.Errorf("cannot seal WAL: %w", err)
+	}
+
+	e.core.makeImmutable()
+	e.sealed = append(e.sealed, append(e.recovered, segment))
+	e.recovered = nil
+
+	if err := e.openWAL(); err != nil {
+		return fmt.Errorf("cannot start a new WAL: %w", err)
+	}
+	return syncDir(filepath.Dir(walPath))
+}
+
+// openWAL starts appending to the WAL at e.walPath. Must be called with e.mu
+// held.
+func (e *SSTableEngine) openWAL() error {
+	if e.walOpenFault != nil {
+		return e.walOpenFault
+	}
+	w, err := wal.NewWal(e.walPath)
+	if err != nil {
+		return err
+	}
+	e.wal = w
+	return nil
+}
+
+// waitForRoom blocks while too many immutable memtables are waiting to be
+// flushed. Must be called with e.mu held.
+func (e *SSTableEngine) waitForRoom() error {
+	stalled := false
+	for e.bgErr == nil && e.core.numImmutable() >= e.maxImmutable {
+		if !stalled {
+			e.writeStalls++
+			stalled = true
+		}
+		e.room.Wait()
+	}
+	return e.bgErr
+}
+
+// scheduleFlush seals the full memtable and wakes the flush goroutine. Must
+// be called with e.mu held.
+func (e *SSTableEngine) scheduleFlush() error {
+	if err := e.sealMemtable(); err != nil {
+		return err
+	}
+	select {
+	case e.flushCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// flushOldest writes the oldest immutable memtable to an SSTable and retires
+// the WAL segments holding its writes. It reports false if there was nothing
+// to flush.
+func (e *SSTableEngine) flushOldest() (bool, error) {
+	e.flushMu.Lock()
+	defer e.flushMu.Unlock()
+
+	if e.core.numImmutable() == 0 {
+		return false, nil
+	}
+	if err := e.core.flushImmutable(); err != nil {
+		return false, err
+	}
+	if e.flushCrashPoint == flushCrashBeforeWALRetire {
+		return false, errSimulatedCrash
+	}
+
+	e.mu.Lock()
+	segments := e.sealed[0]
+	e.sealed = e.sealed[1:]
+	e.room.Broadcast()
+	e.mu.Unlock()
+
+	// The table is durable and recorded in the MANIFEST, so the segments are
+	// no longer needed to recover its contents. Make their removal durable
+	// too: a segment resurrected by a crash would replay stale writes over
+	// newer tables.
+	for _, segment := range segments {
+		if err := os.Remove(segment); err != nil && !os.IsNotExist(err) {
+			return true, err
+		}
+	}
+	return true, syncDir(filepath.Dir(e.walPath))
+}
+
+// flushLoop flushes immutable memtables in the background until flushCh is
+// closed. A failed flush stops the loop and fails later writes.
+func (e *SSTableEngine) flushLoop() {
+	defer close(e.flushDone)
+	for range e.flushCh {
+		for {
+			flushed, err := e.flushOldest()
+			if err != nil {
+				e.mu.Lock()
+				e.bgErr = fmt.Errorf("background flush failed: %w", err)
+				e.room.Broadcast()
+				e.mu.Unlock()
+				return
+			}
+			if !flushed {
+				break
+			}
+		}
+	}
+}
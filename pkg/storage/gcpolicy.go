@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// GCMode combines the two limits of a GCPolicy when both are set.
+type GCMode string
+
+const (
+	// GCUnion collects a version once it breaks either limit.
+	GCUnion GCMode = "union"
+
+	// GCIntersection collects a version only once it breaks both limits.
+	GCIntersection GCMode = "intersection"
+)
+
+// GCPolicy decides which versions of the cells of a column family are
+// garbage. Compaction removes them, and ReadRow never returns them even
+// before that. The zero policy keeps every version.
+type GCPolicy struct {
+	// MaxVersions keeps the newest MaxVersions versions of each column; 0
+	// sets no limit.
+	MaxVersions int
+
+	// MaxAge keeps versions whose timestamp is less than MaxAge old; 0
+	// sets no limit.
+	MaxAge time.Duration
+
+	// Mode applies when both limits are set; empty means GCUnion.
+	Mode GCMode
+}
+
+// ParseGCMode validates a GC mode name as used in config.yml. An empty name
+// selects GCUnion.
+func ParseGCMode(name string) (GCMode, error) {
+	switch GCMode(name) {
+	case "":
+		return GCUnion, nil
+	case GCUnion, GCIntersection:
+		return GCMode(name), nil
+	}
+	return "", fmt.Errorf("unknown GC mode %q", name)
+}
+
+func (p GCPolicy) validate() error {
+	if p.MaxVersions < 0 || p.MaxAge < 0 {
+		return fmt.Errorf("invalid GC policy %+v", p)
+	}
+	_, err := ParseGCMode(string(p.Mode))
+	return err
+}
+
+// collects reports whether the policy collects a version written at
+// timestamp when newer versions of its column are kept, at time now. Times
+// are in microseconds.
+func (p GCPolicy) collects(newer int, timestamp, now int64) bool {
+	tooMany := p.MaxVersions > 0 && newer >= p.MaxVersions
+	tooOld := p.MaxAge > 0 && timestamp < now-p.MaxAge.Microseconds()
+	if p.Mode == GCIntersection && p.MaxVersions > 0 && p.MaxAge > 0 {
+		return tooMany && tooOld
+	}
+	return tooMany || tooOld
+}
+
+// cellCollector applies column family GC policies to the entries of a
+// compaction, which come in key order with the versions of each key newest
+// first. Versions written after the oldest snapshot are left out of the
+// count of newer versions, so a snapshot never loses a version its own
+// reads would return. It follows CellCollector in sstable/gc.cpp.
+type cellCollector struct {
+	policies         map[string]GCPolicy
+	now              int64
+	smallestSnapshot uint64
+
+	column  []byte // column of the current key, up to its timestamp
+	counted int    // versions of column kept so far
+	key     string
+	collect bool // whether the current key is collected
+}
+
+// collected reports whether the policy of its family collects key. Every
+// version of a key shares the decision made for its newest one.
+func (c *cellCollector) collected(key string, seq uint64, entry memEntry) bool {
+	if key == c.key && c.column != nil {
+		return c.collect
+	}
+	c.key = key
+	c.collect = false
+	_, family, _, timestamp, ok := DecodeCellKey([]byte(key))
+	policy, found := c.policies[family]
+	if !ok || !found {
+		c.column = nil
+		return false
+	}
+	column := []byte(key[:len(key)-8])
+	if string(column) != string(c.column) {
+		c.column = column
+		c.counted = 0
+	}
+
+	// Tombstones are left to the tombstone rules
+	if entry.deleted {
+		return false
+	}
+	c.collect = policy.collects(c.counted, timestamp, c.now)
+	if !c.collect && seq <= c.smallestSnapshot {
+		c.counted++
+	}
+	return c.collect
+}
@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// tableCache is an LRU cache of open tables keyed by file number, like the
+// C++ TableCache. Tables are reference counted, so one evicted while a
+// lookup is using it stays open until that lookup is done.
+type tableCache struct {
+	mu       sync.Mutex
+	capacity int
+	blocks   *blockCache
+	lru      *list.List // of *table, most recently used first
+	entries  map[uint32]*list.Element
+	hits     atomic.Uint64
+	misses   atomic.Uint64
+}
+
+func newTableCache(capacity int, blocks *blockCache) *tableCache {
+	return &tableCache{
+		capacity: capacity,
+		blocks:   blocks,
+		lru:      list.New(),
+		entries:  make(map[uint32]*list.Element),
+	}
+}
+
+// find returns the open table, opening it on a miss. The caller must unref
+// it when done.
+func (c *tableCache) find(meta *tableMeta) (*table, error) {
+	c.mu.Lock()
+	if el, ok := c.entries[meta.number]; ok {
+		c.lru.MoveToFront(el)
+		t := el.Value.(*table)
+		t.ref()
+		c.mu.Unlock()
+		c.hits.Add(1)
+		return t, nil
+	}
+	c.mu.Unlock()
+
+	// Open outside the lock so a slow open does not block cached lookups.
+	// Two lookups racing on the same table may both open it; the first
+	// insert wins.
+	c.misses.Add(1)
+	t, err := openTable(meta.path, meta.number, c.blocks)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[meta.number]; ok {
+		t.unref()
+		t = el.Value.(*table)
+		t.ref()
+		return t, nil
+	}
+	t.ref() // one reference for the cache, one for the caller
+	c.entries[meta.number] = c.lru.PushFront(t)
+	for c.lru.Len() > c.capacity {
+		old := c.lru.Remove(c.lru.Back()).(*table)
+		delete(c.entries, old.number)
+		old.unref()
+	}
+	return t, nil
+}
+
+// evict drops a table whose file is about to be deleted
+func (c *tableCache) evict(number uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[number]; ok {
+		c.lru.Remove(el)
+		delete(c.entries, number)
+		el.Value.(*table).unref()
+	}
+}
+
+// close drops every cached table
+func (c *tableCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		el.Value.(*table).unref()
+	}
+	c.lru.Init()
+	c.entries = make(map[uint32]*list.Element)
+}
+
+// blockKey identifies a cached block by table number and block offset.
+type blockKey struct {
+	number uint32
+	offset uint64
+}
+
+type cachedBlock struct {
+	key   blockKey
+	block []byte
+}
+
+// blockCache is an LRU cache of decompressed data blocks bounded by their
+// total size, like the C++ BlockCache. A capacity of 0 disables it. Cached
+// blocks are shared and must not be modified.
+type blockCache struct {
+	mu       sync.Mutex
+	capacity uint64
+	usage    uint64
+	lru      *list.List // of *cachedBlock, most recently used first
+	entries  map[blockKey]*list.Element
+	hits     atomic.Uint64
+	misses   atomic.Uint64
+}
+
+func newBlockCache(capacity uint64) *blockCache {
+	return &blockCache{
+		capacity: capacity,
+		lru:      list.New(),
+		entries:  make(map[blockKey]*list.Element),
+	}
+}
+
+// lookup returns the cached block at offset in table number, or nil
+func (c *blockCache) lookup(number uint32, offset uint64) []byte {
+	if c.capacity == 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[blockKey{number, offset}]
+	if !ok {
+		c.misses.Add(1)
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	c.hits.Add(1)
+	return el.Value.(*cachedBlock).block
+}
+
+// insert caches a block, evicting the least recently used blocks to make
+// room
+func (c *blockCache) insert(number uint32, offset uint64, block []byte) {
+	// A block bigger than the whole budget would only flush the cache
+	if uint64(len(block)) > c.capacity {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := blockKey{number, offset}
+	if _, ok := c.entries[key]; ok {
+		return // Another lookup cached it first
+	}
+	c.entries[key] = c.lru.PushFront(&cachedBlock{key: key, block: block})
+	c.usage += uint64(len(block))
+	for c.usage > c.capacity {
+		c.erase(c.lru.Back())
+	}
+}
+
+// eraseTable drops every block of a table whose file is about to be deleted
+func (c *blockCache) eraseTable(number uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*cachedBlock).key.number == number {
+			c.erase(el)
+		}
+		el = next
+	}
+}
+
+func (c *blockCache) currentUsage() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.usage
+}
+
+// erase removes one entry. Caller holds c.mu.
+func (c *blockCache) erase(el *list.Element) {
+	b := c.lru.Remove(el).(*cachedBlock)
+	delete(c.entries, b.key)
+	c.usage -= uint64(len(b.block))
+}
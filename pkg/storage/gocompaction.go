@@ -0,0 +1,639 @@
+package storage
+
+import (
+	"container/heap"
+	"os"
+	"slices"
+	"time"
+)
+
+// Compaction in the Go backend follows sstable/compaction.cpp: the same
+// size-tiered and leveled pickers, the same merge and the same rules for
+// dropping tombstones, so either backend can continue the other's layout.
+
+const (
+	stcsMinThreshold  = 4
+	stcsMaxThreshold  = 32
+	stcsBucketLow     = 0.5
+	stcsBucketHigh    = 1.5
+	stcsMinTableBytes = 4 * memtableFlushThreshold
+)
+
+// compactionStats are the cumulative counters reported through Stats.
+type compactionStats struct {
+	compactions       uint64
+	bytesRead         uint64
+	bytesWritten      uint64
+	entriesDropped    uint64
+	tombstonesDropped uint64
+	entriesExpired    uint64
+	elapsed           time.Duration
+}
+
+func similarSize(size uint64, bucketAvg float64) bool {
+	if size < stcsMinTableBytes && bucketAvg < stcsMinTableBytes {
+		return true
+	}
+	return float64(size) >= bucketAvg*stcsBucketLow && float64(size) <= bucketAvg*stcsBucketHigh
+}
+
+// pickSizeTiered picks the longest run of consecutive similarly sized
+// level-0 tables, preferring smaller tables on a tie.
+func pickSizeTiered(tables []*tableMeta) []*tableMeta {
+	var best []*tableMeta
+	var bestAvg float64
+
+	for i := 0; i < len(tables); {
+		total := float64(tables[i].fileSize)
+		j := i + 1
+		for j < len(tables) && j-i < stcsMaxThreshold && similarSize(tables[j].fileSize, total/float64(j-i)) {
+			total += float64(tables[j].fileSize)
+			j++
+		}
+
+		count := j - i
+		avg := total / float64(count)
+		if count >= stcsMinThreshold && (count > len(best) || (count == len(best) && avg < bestAvg)) {
+			best = tables[i:j]
+			bestAvg = avg
+		}
+		i = j
+	}
+	return best
+}
+
+// compactionJob is one unit of compaction work.
+type compactionJob struct {
+	// Tables merged by the job, ordered oldest to newest
+	inputs []*tableMeta
+	// Tables outside the job that may hold older data for its keys; a
+	// tombstone covered by one of them has to be kept
+	older       []*tableMeta
+	outputLevel int
+	// Split the output into tables of about TargetFileSize
+	split bool
+	// Move the single input to outputLevel without rewriting it
+	trivialMove bool
+}
+
+func addOverlapping(v *version, level int, lo, hi string, out []*tableMeta) []*tableMeta {
+	for _, t := range v.levels[level] {
+		if tableOverlaps(t, lo, hi) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// keyRange returns the range covered by tables
+func keyRange(tables []*tableMeta) (lo, hi string) {
+	for i, t := range tables {
+		if i == 0 || t.smallest < lo {
+			lo = t.smallest
+		}
+		if i == 0 || t.largest > hi {
+			hi = t.largest
+		}
+	}
+	return lo, hi
+}
+
+func (o Options) levelMaxBytes(level int) uint64 {
+	max := uint64(o.Level1MaxBytes)
+	for i := 1; i < level; i++ {
+		max *= uint64(o.LevelSizeRatio)
+	}
+	return max
+}
+
+func pickSizeTieredJob(v *version) *compactionJob {
+	job := &compactionJob{inputs: pickSizeTiered(v.levels[0])}
+	if len(job.inputs) == 0 {
+		return nil
+	}
+	for _, t := range v.levels[0] {
+		if t.seq < job.inputs[0].seq {
+			job.older = append(job.older, t)
+		}
+	}
+	for level := 1; level < numLevels; level++ {
+		job.older = append(job.older, v.levels[level]...)
+	}
+	return job
+}
+
+// pickLeveledJob merges level 0 into level 1 once it holds
+// Level0FileTrigger tables; otherwise the level furthest over its budget
+// pushes one table, chosen round-robin through its key space, into the
+// next level. Must be called with e.compactionMu held.
+func (e *goEngine) pickLeveledJob(v *version) *compactionJob {
+	source := -1
+	var sourceInputs []*tableMeta
+	if len(v.levels[0]) >= e.opts.Level0FileTrigger {
+		source = 0
+		sourceInputs = v.levels[0]
+	} else {
+		bestScore := 1.0
+		for level := 1; level < numLevels-1; level++ {
+			score := float64(v.levelBytes(level)) / float64(e.opts.levelMaxBytes(level))
+			if score >= bestScore {
+				bestScore = score
+				source = level
+			}
+		}
+		if source < 0 {
+			return nil
+		}
+
+		tables := v.levels[source]
+		picked := tables[0]
+		for _, t := range tables {
+			if t.smallest > e.compactPointer[source] {
+				picked = t
+				break
+			}
+		}
+		sourceInputs = []*tableMeta{picked}
+		e.compactPointer[source] = picked.largest
+	}
+
+	lo, hi := keyRange(sourceInputs)
+	targetInputs := addOverlapping(v, source+1, lo, hi, nil)
+
+	// Tables in the target level are older than anything in the source
+	job := &compactionJob{
+		inputs:      append(targetInputs[:len(targetInputs):len(targetInputs)], sourceInputs...),
+		outputLevel: source + 1,
+		split:       true,
+		trivialMove: len(sourceInputs) == 1 && len(targetInputs) == 0,
+	}
+	lo, hi = keyRange(job.inputs)
+	for level := source + 2; level < numLevels; level++ {
+		job.older = addOverlapping(v, level, lo, hi, job.older)
+	}
+	return job
+}
+
+// mergeHeap orders table iterators by key, then newest version first, then
+// newest input first for versions written before sequence numbers were
+// recorded. An iterator's index in the inputs doubles as its age.
+type mergeItem struct {
+	iter *tableIterator
+	age  int
+}
+
+type mergeHeap []mergeItem
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if h[i].iter.key() != h[j].iter.key() {
+		return h[i].iter.key() < h[j].iter.key()
+	}
+	if h[i].iter.seq() != h[j].iter.seq() {
+		return h[i].iter.seq() > h[j].iter.seq()
+	}
+	return h[i].age > h[j].age
+}
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x any)   { *h = append(*h, x.(mergeItem)) }
+func (h *mergeHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// advance steps the top iterator and restores the heap order
+func (h *mergeHeap) advance() {
+	(*h)[0].iter.next()
+	if (*h)[0].iter.valid() {
+		heap.Fix(h, 0)
+	} else {
+		heap.Pop(h)
+	}
+}
+
+// versionFilter picks out, from the versions of each key newest first, the
+// ones no reader can see any more: those overwritten by a newer version at
+// or below the oldest snapshot. With merging set, a merge operand does not
+// overwrite the versions it applies to.
+type versionFilter struct {
+	smallestSnapshot uint64
+	merging          bool
+	hasKey           bool
+	key              string
+	hiding           bool // a newer version of key hides the older ones
+}
+
+func (f *versionFilter) shadowed(key string, seq uint64, entry memEntry) bool {
+	if !f.hasKey || key != f.key {
+		f.key = key
+		f.hasKey = true
+		f.hiding = false
+	}
+	hidden := f.hiding
+	if seq <= f.smallestSnapshot && !(f.merging && entry.merge) {
+		f.hiding = true
+	}
+	return hidden
+}
+
+// foldOperands folds the merge operand entry, the version of key a read at
+// the oldest snapshot starts from, with the older versions of key in the
+// merge heap, taking them out of it. Folding stops at the first version
+// that is not an operand. The result is a value if that version is a value
+// or a tombstone, or if no older table may hold the key; otherwise it is a
+// single operand. A value that expires stays in the heap, as the operands
+// apply to whatever is below it once it has expired. It also returns the
+// number of versions folded away.
+func foldOperands(h *mergeHeap, key string, entry memEntry, operator MergeOperator,
+	rangeDels []rangeTombstone, now int64, older []*tableMeta) (memEntry, uint64) {
+	operands := [][]byte{entry.value}
+	var base []byte
+	var folded uint64
+	complete, expiring := false, false
+	for !complete && h.Len() > 0 && (*h)[0].iter.key() == key {
+		seq := (*h)[0].iter.seq()
+		next := (*h)[0].iter.entry()
+		deleted := rangeDeleted(rangeDels, key, seq) || !next.live(now)
+		if expiring = !deleted && !next.merge && next.expiresAt != 0; expiring {
+			break
+		}
+		h.advance()
+		folded++
+		switch {
+		case deleted:
+			complete = true
+		case next.merge:
+			operands = append(operands, next.value)
+		default:
+			base = next.value
+			complete = true
+		}
+	}
+
+	// Whatever is left of the key is hidden by the value
+	if complete {
+		for h.Len() > 0 && (*h)[0].iter.key() == key {
+			h.advance()
+			folded++
+		}
+	}
+	slices.Reverse(operands)
+	merged := memEntry{value: operator.Merge(base, operands)}
+	merged.merge = expiring || (!complete && !tombstoneShadowsNothing(key, older))
+	return merged, folded
+}
+
+// tombstoneShadowsNothing reports whether no table older than the
+// compaction could still hold a value for key, so its tombstone can go.
+func tombstoneShadowsNothing(key string, older []*tableMeta) bool {
+	for _, t := range older {
+		if key >= t.smallest && key <= t.largest {
+			return false
+		}
+	}
+	return true
+}
+
+// outputSet holds the tables a compaction writes under fresh file numbers.
+// They stay invisible until the MANIFEST records them.
+type outputSet struct {
+	e       *goEngine
+	writer  *tableWriter
+	current *tableMeta
+	tables  []*tableMeta
+}
+
+// open starts a new output table if none is open
+func (o *outputSet) open() error {
+	if o.writer != nil {
+		return nil
+	}
+	o.e.mu.Lock()
+	o.e.sstableCounter++
+	number := o.e.sstableCounter
+	o.e.mu.Unlock()
+
+	o.current = &tableMeta{number: number, path: tablePath(o.e.dir, number)}
+	w, err := newTableWriter(o.current.path, o.e.opts)
+	if err != nil {
+		return err
+	}
+	o.writer = w
+	return nil
+}
+
+// close finishes the open output table, if any. Outputs must be durable
+// before the MANIFEST can refer to them.
+func (o *outputSet) close(seq uint64) error {
+	if o.writer == nil {
+		return nil
+	}
+	err := o.writer.finish()
+	if err == nil {
+		err = o.writer.sync()
+	}
+	o.current.seq = seq
+	o.current.fileSize = o.writer.fileSize()
+	o.current.smallest = o.writer.smallest
+	o.current.largest = o.writer.largest
+	o.tables = append(o.tables, o.current)
+	o.writer = nil
+	return err
+}
+
+// abandon removes every output written so far
+func (o *outputSet) abandon() {
+	if o.writer != nil {
+		o.writer.abandon()
+		o.writer = nil
+	}
+	for _, t := range o.tables {
+		os.Remove(t.path)
+	}
+	o.tables = nil
+}
+
+// installVersion swaps the job's inputs for its outputs in the current
+// version and persists the new table set. rangeDels are the range
+// tombstones whose covered data the merge dropped. Must be called with e.mu
+// held.
+func (e *goEngine) installVersion(job *compactionJob, outputs []*tableMeta, rangeDels []rangeTombstone) error {
+	inputs := make(map[*tableMeta]bool, len(job.inputs))
+	for _, t := range job.inputs {
+		inputs[t] = true
+	}
+	edit := &versionEdit{}
+	for level := range e.current.levels {
+		for _, t := range e.current.levels[level] {
+			if inputs[t] {
+				edit.deleted = append(edit.deleted, levelNumber{level, t.number})
+			}
+		}
+	}
+	for _, t := range outputs {
+		edit.added = append(edit.added, levelTable{job.outputLevel, t})
+	}
+
+	// A range tombstone has deleted everything it covers once every table
+	// that could hold a key in its range went through the merge
+	for _, rd := range rangeDels {
+		settled := true
+		for level := range e.current.levels {
+			for _, t := range e.current.levels[level] {
+				if !inputs[t] && tableOverlaps(t, rd.start, rd.end) {
+					settled = false
+				}
+			}
+		}
+		if settled {
+			edit.deletedRangeDels = append(edit.deletedRangeDels, rd.seq)
+		}
+	}
+	return e.applyVersion(edit)
+}
+
+func (e *goEngine) runCompaction(job *compactionJob) error {
+	if job.trivialMove {
+		// Nothing is rewritten; the table keeps its file and only the
+		// recorded table set changes
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if err := e.installVersion(job, job.inputs, nil); err != nil {
+			return err
+		}
+		e.compactionStats.compactions++
+		return nil
+	}
+
+	start := time.Now()
+	var bytesRead, outputSeq uint64
+	var inputs []*table
+	defer func() {
+		for _, t := range inputs {
+			t.unref()
+		}
+	}()
+	h := &mergeHeap{}
+	for i, meta := range job.inputs {
+		t, err := openTable(meta.path, meta.number, nil)
+		if err != nil {
+			return err
+		}
+		inputs = append(inputs, t)
+		iter := newTableIterator(t)
+		iter.seekToFirst()
+		if iter.valid() {
+			*h = append(*h, mergeItem{iter: iter, age: i})
+		}
+		bytesRead += meta.fileSize
+		outputSeq = max(outputSeq, meta.seq)
+	}
+	heap.Init(h)
+
+	// Range tombstones no snapshot reads below delete what they cover
+	e.mu.Lock()
+	snapshot := e.smallestSnapshot()
+	rangeDels := visibleRangeTombstones(nil, e.current.rangeDels, snapshot)
+	e.mu.Unlock()
+
+	outputs := &outputSet{e: e}
+	operator := e.opts.MergeOperator
+	filter := versionFilter{smallestSnapshot: snapshot, merging: operator != nil}
+	now := time.Now().UnixMicro()
+	collector := cellCollector{
+		policies:         e.opts.ColumnFamilies,
+		now:              now,
+		smallestSnapshot: snapshot,
+	}
+	var previousKey string
+	var entriesDropped, tombstonesDropped, entriesExpired uint64
+	for h.Len() > 0 {
+		key := (*h)[0].iter.key()
+		seq := (*h)[0].iter.seq()
+		entry := (*h)[0].iter.entry()
+		h.advance()
+
+		// Versions overwritten before the oldest snapshot are seen by no one
+		if filter.shadowed(key, seq, entry) {
+			entriesDropped++
+			continue
+		}
+		// Nor are versions a range tombstone below the oldest snapshot deletes
+		if rangeDeleted(rangeDels, key, seq) {
+			entriesDropped++
+			continue
+		}
+		// Every snapshot reads the operands below the oldest one together,
+		// so they are folded into one
+		if entry.merge && operator != nil && seq <= snapshot {
+			var folded uint64
+			entry, folded = foldOperands(h, key, entry, operator, rangeDels, now, job.older)
+			entriesDropped += folded
+		}
+		newestVersion := key != previousKey
+		previousKey = key
+
+		// An expired value reads exactly like a tombstone at its sequence
+		// number, so it becomes one and the tombstone rule below applies
+		expired := !entry.deleted && !entry.live(now)
+		if expired {
+			entriesExpired++
+			entry = memEntry{deleted: true}
+		}
+
+		// Cells their family's policy collects go; a tombstone takes the
+		// place of the newest version if an older table may hold the key
+		if collector.collected(key, seq, entry) {
+			if !newestVersion || tombstoneShadowsNothing(key, job.older) {
+				entriesDropped++
+				continue
+			}
+			entry = memEntry{deleted: true}
+		}
+		if entry.deleted && seq <= snapshot && tombstoneShadowsNothing(key, job.older) {
+			if !expired {
+				tombstonesDropped++
+			}
+			continue
+		}
+
+		// Split outputs between keys, so a key's versions stay in one table
+		if job.split && outputs.writer != nil && key != outputs.writer.largest &&
+			outputs.writer.fileSize() >= uint64(e.opts.TargetFileSize) {
+			if err := outputs.close(outputSeq); err != nil {
+				outputs.abandon()
+				return err
+			}
+		}
+		if err := outputs.open(); err != nil {
+			outputs.abandon()
+			return err
+		}
+		outputs.writer.add(key, seq, entry)
+	}
+	err := outputs.close(outputSeq)
+	if err == nil {
+		err = syncDir(e.dir)
+	}
+	if err != nil {
+		outputs.abandon()
+		return err
+	}
+
+	e.mu.Lock()
+	if err := e.installVersion(job, outputs.tables, rangeDels); err != nil {
+		e.mu.Unlock()
+		outputs.abandon()
+		return err
+	}
+	stats := &e.compactionStats
+	stats.compactions++
+	stats.bytesRead += bytesRead
+	for _, t := range outputs.tables {
+		stats.bytesWritten += t.fileSize
+	}
+	stats.entriesDropped += entriesDropped
+	stats.tombstonesDropped += tombstonesDropped
+	stats.entriesExpired += entriesExpired
+	stats.elapsed += time.Since(start)
+
+	// The inputs are deleted once no reader still sees them
+	e.obsolete = append(e.obsolete, job.inputs...)
+	e.deleteObsoleteTables()
+	e.mu.Unlock()
+	return nil
+}
+
+// compactOnce picks and runs one compaction for the configured strategy. It
+// reports false if there was nothing to do.
+func (e *goEngine) compactOnce() (bool, error) {
+	e.compactionMu.Lock()
+	defer e.compactionMu.Unlock()
+
+	e.mu.Lock()
+	v := e.current
+	e.mu.Unlock()
+
+	var job *compactionJob
+	if e.opts.CompactionStrategy == CompactionLeveled {
+		job = e.pickLeveledJob(v)
+	} else {
+		job = pickSizeTieredJob(v)
+	}
+	if job == nil {
+		return false, nil
+	}
+	return true, e.runCompaction(job)
+}
+
+// compactAll merges every live table into a single sorted run, dropping all
+// shadowed data. Size-tiered engines get one level-0 table; leveled engines
+// get non-overlapping tables in the deepest populated level.
+func (e *goEngine) compactAll() error {
+	e.compactionMu.Lock()
+	defer e.compactionMu.Unlock()
+
+	job := &compactionJob{}
+	e.mu.Lock()
+	// Deeper levels hold older data, so they go first
+	for level := numLevels - 1; level >= 0; level-- {
+		tables := e.current.levels[level]
+		job.inputs = append(job.inputs, tables...)
+		if job.outputLevel == 0 && level > 0 && len(tables) > 0 {
+			job.outputLevel = level
+		}
+	}
+	if len(job.inputs) == 0 {
+		// With no tables, range tombstones have nothing left to delete
+		defer e.mu.Unlock()
+		rangeDels := visibleRangeTombstones(nil, e.current.rangeDels, e.smallestSnapshot())
+		if len(rangeDels) == 0 {
+			return nil
+		}
+		return e.installVersion(job, nil, rangeDels)
+	}
+	e.mu.Unlock()
+
+	if e.opts.CompactionStrategy == CompactionLeveled {
+		job.outputLevel = max(job.outputLevel, 1)
+		job.split = true
+	} else {
+		job.outputLevel = 0
+	}
+	return e.runCompaction(job)
+}
+
+// compactionLoop runs compactions in the background while the picker finds
+// work, until the engine is closed.
+func (e *goEngine) compactionLoop() {
+	defer close(e.compactionDone)
+	for {
+		select {
+		case <-e.shutdown:
+			return
+		case <-e.compactionCh:
+		}
+
+		for {
+			select {
+			case <-e.shutdown:
+				return
+			default:
+			}
+			if ran, err := e.compactOnce(); !ran || err != nil {
+				break
+			}
+		}
+	}
+}
+
+// scheduleCompaction wakes the background compaction after the table set
+// changed.
+func (e *goEngine) scheduleCompaction() {
+	select {
+	case e.compactionCh <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,568 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// goEngine is the engineCore written in Go, for builds without cgo. It is a
+// port of the C++ engine in sstable/ and keeps its data directory in the
+// same format, so either backend can open what the other wrote.
+type goEngine struct {
+	dir  string
+	opts Options
+
+	tables *tableCache
+	blocks *blockCache
+
+	// mu guards the memtables, the table set, the sequence numbers and the
+	// MANIFEST
+	mu              sync.Mutex
+	mem             *memtable
+	immutables      []sealedMemtable // oldest first
+	current         *version
+	sstableCounter  uint32
+	manifest        *os.File
+	manifestSize    uint64
+	flushCrashPoint int
+	compactionStats compactionStats
+
+	// Highest write sequence number seen, the newest one reads may see, and
+	// the number of snapshots pinning each sequence number
+	lastSeq    uint64
+	visibleSeq uint64
+	snapshots  map[uint64]int
+
+	// Versions in use by lookups and iterators being set up, and tables a
+	// compaction replaced that are deleted once no pinned version lists them
+	pinned   map[*version]int
+	obsolete []*tableMeta
+
+	filterHits   atomic.Uint64
+	filterMisses atomic.Uint64
+
+	// Point lookups that found the key's value expired
+	keysExpired atomic.Uint64
+
+	// flushMu serialises flushes so memtables are written in order
+	flushMu sync.Mutex
+
+	// compactionMu serialises compactions and guards compactPointer, the
+	// largest key last compacted out of each level
+	compactionMu   sync.Mutex
+	compactPointer [numLevels]string
+
+	compactionCh   chan struct{}
+	shutdown       chan struct{}
+	compactionDone chan struct{}
+}
+
+func newGoEngine(dataDir string, opts Options) (engineCore, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, err
+	}
+
+	blocks := newBlockCache(uint64(opts.blockCacheBytes()))
+	e := &goEngine{
+		dir:            dataDir,
+		opts:           opts,
+		tables:         newTableCache(opts.MaxOpenTables, blocks),
+		blocks:         blocks,
+		mem:            newMemtable(),
+		snapshots:      make(map[uint64]int),
+		pinned:         make(map[*version]int),
+		compactionCh:   make(chan struct{}, 1),
+		shutdown:       make(chan struct{}),
+		compactionDone: make(chan struct{}),
+	}
+
+	// Load the table set, dropping files left behind by a crash
+	if err := e.loadVersion(); err != nil {
+		if e.manifest != nil {
+			e.manifest.Close()
+		}
+		return nil, err
+	}
+	e.visibleSeq = e.lastSeq
+
+	go e.compactionLoop()
+	e.scheduleCompaction()
+	return e, nil
+}
+
+func (e *goEngine) put(seq uint64, key, value []byte, expiresAt int64) error {
+	entry := memEntry{value: make([]byte, len(value)), expiresAt: expiresAt}
+	copy(entry.value, value)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.mem.add(string(key), seq, entry)
+	e.lastSeq = max(e.lastSeq, seq)
+	return nil
+}
+
+// delete records a tombstone, since the key may live in an older SSTable
+func (e *goEngine) delete(seq uint64, key []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.mem.add(string(key), seq, memEntry{deleted: true})
+	e.lastSeq = max(e.lastSeq, seq)
+	return nil
+}
+
+// merge records a merge operand, which reads fold into the older versions
+func (e *goEngine) merge(seq uint64, key, operand []byte) error {
+	entry := memEntry{merge: true, value: make([]byte, len(operand))}
+	copy(entry.value, operand)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.mem.add(string(key), seq, entry)
+	e.lastSeq = max(e.lastSeq, seq)
+	return nil
+}
+
+// deleteRange records a range tombstone over [start, end)
+func (e *goEngine) deleteRange(seq uint64, start, end []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.mem.addRangeTombstone(string(start), string(end), seq)
+	e.lastSeq = max(e.lastSeq, seq)
+	return nil
+}
+
+func (e *goEngine) setVisibleSeq(seq uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.visibleSeq = max(e.visibleSeq, seq)
+	e.lastSeq = max(e.lastSeq, seq)
+}
+
+func (e *goEngine) lastSequence() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.lastSeq
+}
+
+// acquireSnapshot pins the visible sequence number. Taking it under e.mu
+// means no flush or compaction can have dropped a version it sees: they
+// only ever drop versions hidden by a newer one that was already visible.
+func (e *goEngine) acquireSnapshot() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.snapshots[e.visibleSeq]++
+	return e.visibleSeq
+}
+
+func (e *goEngine) releaseSnapshot(seq uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.snapshots[seq]--; e.snapshots[seq] <= 0 {
+		delete(e.snapshots, seq)
+	}
+}
+
+// smallestSnapshot is the oldest sequence number a read may still happen
+// at: that of the oldest snapshot, or the newest write if there is none.
+// Must be called with e.mu held.
+func (e *goEngine) smallestSnapshot() uint64 {
+	smallest := e.lastSeq
+	for seq := range e.snapshots {
+		smallest = min(smallest, seq)
+	}
+	return smallest
+}
+
+// pin keeps the tables of v on disk until unpin. Must be called with e.mu
+// held.
+func (e *goEngine) pin(v *version) {
+	e.pinned[v]++
+}
+
+func (e *goEngine) unpin(v *version) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.pinned[v]--; e.pinned[v] == 0 {
+		delete(e.pinned, v)
+		e.deleteObsoleteTables()
+	}
+}
+
+// deleteObsoleteTables removes the replaced tables that neither the current
+// version nor a pinned one lists. Must be called with e.mu held.
+func (e *goEngine) deleteObsoleteTables() {
+	if len(e.obsolete) == 0 {
+		return
+	}
+
+	live := make(map[uint32]bool)
+	mark := func(v *version) {
+		for level := range v.levels {
+			for _, t := range v.levels[level] {
+				live[t.number] = true
+			}
+		}
+	}
+	mark(e.current)
+	for v := range e.pinned {
+		mark(v)
+	}
+
+	remaining := e.obsolete[:0]
+	for _, t := range e.obsolete {
+		if live[t.number] {
+			remaining = append(remaining, t)
+			continue
+		}
+		e.tables.evict(t.number)
+		e.blocks.eraseTable(t.number)
+		os.Remove(t.path)
+	}
+	e.obsolete = remaining
+}
+
+// get checks the memtables, then the SSTables from newest to oldest,
+// stopping at the newest record for the key visible at seq whether it is a
+// value or a tombstone. A newer range tombstone over the key deletes it.
+// Merge operands on the way are folded into the value below them.
+func (e *goEngine) get(key []byte, seq uint64) ([]byte, bool, error) {
+	k := string(key)
+
+	e.mu.Lock()
+	seq = min(seq, e.visibleSeq)
+	v := e.current
+	covering := max(coveringSeq(e.mem.rangeDels, k, seq), coveringSeq(v.rangeDels, k, seq))
+	for _, imm := range e.immutables {
+		covering = max(covering, coveringSeq(imm.rangeDels, k, seq))
+	}
+	read := &pointRead{
+		key:      k,
+		seq:      seq,
+		covering: covering,
+		now:      time.Now().UnixMicro(),
+		operator: e.opts.MergeOperator,
+	}
+	read.search(e.mem)
+	for i := len(e.immutables) - 1; !read.done && i >= 0; i-- {
+		read.search(e.immutables[i].run)
+	}
+	if !read.done {
+		e.pin(v)
+	}
+	e.mu.Unlock()
+	if read.done {
+		return e.finishRead(read)
+	}
+	defer e.unpin(v)
+
+	// Level 0 tables may overlap, so each one is checked; deeper levels
+	// have at most one candidate table each
+	var candidates []*tableMeta
+	for i := len(v.levels[0]) - 1; i >= 0; i-- {
+		if t := v.levels[0][i]; k >= t.smallest && k <= t.largest {
+			candidates = append(candidates, t)
+		}
+	}
+	for level := 1; level < numLevels; level++ {
+		if t := v.findTable(level, k); t != nil {
+			candidates = append(candidates, t)
+		}
+	}
+
+	for _, meta := range candidates {
+		if read.done {
+			break
+		}
+		// Skipping a table that cannot be opened would return an older
+		// version, or none, as if it were current
+		t, err := e.tables.find(meta)
+		if err != nil {
+			return nil, false, fmt.Errorf("cannot open SSTable %d: %w", meta.number, err)
+		}
+
+		// The filter rules most absent keys out without reading a block
+		if !t.mayContain(k) {
+			e.filterMisses.Add(1)
+			t.unref()
+			continue
+		}
+		if t.hasFilter() {
+			e.filterHits.Add(1)
+		}
+		read.search(t)
+		t.unref()
+	}
+	return e.finishRead(read)
+}
+
+// finishRead returns the value a point read found, counting it if it ended
+// at an expired value
+func (e *goEngine) finishRead(read *pointRead) ([]byte, bool, error) {
+	if read.expired {
+		e.keysExpired.Add(1)
+	}
+	value, found := read.result()
+	return value, found, nil
+}
+
+func (e *goEngine) needsFlush() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.mem.size >= memtableFlushThreshold
+}
+
+func (e *goEngine) memtableEmpty() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.mem.empty()
+}
+
+func (e *goEngine) makeImmutable() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.mem.empty() {
+		return false
+	}
+	e.immutables = append(e.immutables, e.mem.seal())
+	e.mem = newMemtable()
+	return true
+}
+
+func (e *goEngine) numImmutable() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.immutables)
+}
+
+// flushImmutable writes the oldest immutable memtable's records to a new
+// level-0 table and moves its range tombstones into the version. A memtable
+// holding only range tombstones needs no table.
+func (e *goEngine) flushImmutable() error {
+	e.flushMu.Lock()
+	defer e.flushMu.Unlock()
+
+	e.mu.Lock()
+	if len(e.immutables) == 0 {
+		e.mu.Unlock()
+		return nil
+	}
+	imm := e.immutables[0]
+	var number uint32
+	if len(imm.run) > 0 {
+		e.sstableCounter++
+		number = e.sstableCounter
+	}
+	crashPoint := e.flushCrashPoint
+	snapshot := e.smallestSnapshot()
+	e.mu.Unlock()
+
+	edit := &versionEdit{addedRangeDels: imm.rangeDels}
+	var meta *tableMeta
+	if len(imm.run) > 0 {
+		var err error
+		meta, err = e.writeLevel0Table(imm.run, number, crashPoint, snapshot)
+		if err != nil {
+			return err
+		}
+		edit.added = []levelTable{{0, meta}}
+	}
+
+	e.mu.Lock()
+
+	// The table only becomes live once the MANIFEST records it, and
+	// replaces the immutable memtable in the same step
+	if err := e.applyVersion(edit); err != nil {
+		e.mu.Unlock()
+		if meta != nil {
+			os.Remove(meta.path)
+		}
+		return err
+	}
+	e.immutables = e.immutables[1:]
+	e.mu.Unlock()
+
+	// A new table may complete a size tier or fill level 0
+	e.scheduleCompaction()
+	return nil
+}
+
+// writeLevel0Table writes the table under a temporary name and makes it
+// durable before it takes its real name, so a crash never leaves a
+// half-written file that looks like a table. Reads keep using the immutable
+// memtable meanwhile.
+func (e *goEngine) writeLevel0Table(run sortedRun, number uint32, crashPoint int, snapshot uint64) (*tableMeta, error) {
+	filename := tablePath(e.dir, number)
+	tmp := filename + ".tmp"
+	w, err := newTableWriter(tmp, e.opts)
+	if err != nil {
+		return nil, err
+	}
+	// Versions no snapshot can see any more are left out; tombstones stay,
+	// as older tables may hold values they shadow. Merge operands are left
+	// for compaction to fold.
+	filter := versionFilter{smallestSnapshot: snapshot, merging: e.opts.MergeOperator != nil}
+	var maxSeq uint64
+	for _, record := range run {
+		maxSeq = max(maxSeq, record.seq)
+		if !filter.shadowed(record.key, record.seq, record.entry) {
+			w.add(record.key, record.seq, record.entry)
+		}
+	}
+	if err := w.finish(); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if crashPoint == flushCrashAfterWrite {
+		return nil, errSimulatedCrash
+	}
+	if err := w.sync(); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if crashPoint == flushCrashAfterSync {
+		return nil, errSimulatedCrash
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if crashPoint == flushCrashAfterRename {
+		return nil, errSimulatedCrash
+	}
+	if err := syncDir(e.dir); err != nil {
+		os.Remove(filename)
+		return nil, err
+	}
+	if crashPoint == flushCrashAfterDirSync {
+		return nil, errSimulatedCrash
+	}
+
+	// Memtables hold ever newer writes, so the table is newer than any
+	// flushed before it
+	return &tableMeta{
+		number:   number,
+		seq:      maxSeq,
+		path:     filename,
+		fileSize: w.fileSize(),
+		smallest: w.smallest,
+		largest:  w.largest,
+	}, nil
+}
+
+func (e *goEngine) compact() error {
+	return e.compactAll()
+}
+
+func (e *goEngine) stats() (EngineStats, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var s EngineStats
+	for level := range e.current.levels {
+		s.SSTables += uint64(len(e.current.levels[level]))
+		s.SSTableBytes += e.current.levelBytes(level)
+	}
+	s.MemtableBytes = uint64(e.mem.size)
+	s.ImmutableMemtables = uint64(len(e.immutables))
+
+	c := e.compactionStats
+	s.Compactions = c.compactions
+	s.CompactionBytesRead = c.bytesRead
+	s.CompactionBytesWritten = c.bytesWritten
+	s.CompactionEntriesDropped = c.entriesDropped
+	s.CompactionTombstonesDropped = c.tombstonesDropped
+	s.CompactionEntriesExpired = c.entriesExpired
+	s.CompactionTime = c.elapsed
+
+	s.FilterHits = e.filterHits.Load()
+	s.FilterMisses = e.filterMisses.Load()
+	s.KeysExpired = e.keysExpired.Load()
+	s.TableCacheHits = e.tables.hits.Load()
+	s.TableCacheMisses = e.tables.misses.Load()
+	s.BlockCacheHits = e.blocks.hits.Load()
+	s.BlockCacheMisses = e.blocks.misses.Load()
+	s.BlockCacheUsage = e.blocks.currentUsage()
+	s.BlockCacheCapacity = e.blocks.capacity
+	return s, nil
+}
+
+// newIterator merges a copy of the memtable's part of [lower, upper) with
+// the immutable memtables and every table of the current version, as of
+// seq, hiding what the range tombstones visible at seq delete. It holds the
+// open tables rather than the version, which keeps their files readable
+// after a compaction deletes them without making the compaction wait for
+// the iterator.
+func (e *goEngine) newIterator(seq uint64, lower, upper []byte) (coreIterator, error) {
+	e.mu.Lock()
+	seq = min(seq, e.visibleSeq)
+	mem := e.mem.recordsIn(lower, upper)
+	sources := []internalIterator{newVisibleIterator(newRunIterator(mem), seq)}
+	lookupFrom := []versionSource{mem}
+	rangeDels := visibleRangeTombstones(nil, e.mem.rangeDels, seq)
+	for i := len(e.immutables) - 1; i >= 0; i-- {
+		sources = append(sources, newVisibleIterator(newRunIterator(e.immutables[i].run), seq))
+		lookupFrom = append(lookupFrom, e.immutables[i].run)
+		rangeDels = visibleRangeTombstones(rangeDels, e.immutables[i].rangeDels, seq)
+	}
+	v := e.current
+	rangeDels = visibleRangeTombstones(rangeDels, v.rangeDels, seq)
+	e.pin(v)
+	e.mu.Unlock()
+	defer e.unpin(v)
+
+	metas := make([]*tableMeta, 0, len(v.levels[0]))
+	for i := len(v.levels[0]) - 1; i >= 0; i-- {
+		metas = append(metas, v.levels[0][i])
+	}
+	for level := 1; level < numLevels; level++ {
+		metas = append(metas, v.levels[level]...)
+	}
+
+	iter := &mergingIterator{
+		lower:      string(lower),
+		upper:      upper,
+		sources:    sources,
+		rangeDels:  rangeDels,
+		now:        time.Now().UnixMicro(),
+		seq:        seq,
+		operator:   e.opts.MergeOperator,
+		lookupFrom: lookupFrom,
+	}
+	for _, meta := range metas {
+		t, err := e.tables.find(meta)
+		if err != nil {
+			iter.close()
+			return nil, err
+		}
+		iter.tables = append(iter.tables, t)
+		iter.sources = append(iter.sources, newVisibleIterator(newTableIterator(t), seq))
+		iter.lookupFrom = append(iter.lookupFrom, t)
+	}
+	return iter, nil
+}
+
+func (e *goEngine) setFlushCrashPoint(point int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.flushCrashPoint = point
+}
+
+// close stops background compaction, waiting for a running one to finish,
+// and releases every open file
+func (e *goEngine) close() {
+	close(e.shutdown)
+	<-e.compactionDone
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// No reader is left to hold an obsolete table
+	e.deleteObsoleteTables()
+	e.tables.close()
+	if e.manifest != nil {
+		e.manifest.Close()
+		e.manifest = nil
+	}
+}
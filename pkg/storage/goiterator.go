@@ -0,0 +1,389 @@
+package storage
+
+// Ordered iteration over the Go backend, ported from sstable/iterator.cpp.
+// Each source only shows, for every key, its newest version at or below the
+// sequence number the iterator reads at. Sources are ordered newest first
+// (memtable, immutable memtables from newest to oldest, level 0 from newest
+// to oldest, then each deeper level), and for every key only the entry from
+// the newest source that holds it is considered. Keys whose newest entry is
+// a tombstone, or older than a range tombstone over the key, are skipped.
+// A newest entry that is a merge operand is folded with the older versions
+// of its key, which are looked up in the sources' memtables and tables.
+//
+// Iteration keeps every source positioned relative to the current key.
+// Going forward, each source sits at its first entry not smaller than the
+// key; going backward, at its last entry not larger than it. Changing
+// direction re-seeks every source.
+
+// internalIterator walks one source of entries in internal key order, so
+// every version of a key comes newest first. Tombstones are included. seek
+// positions at the newest version of the first key not smaller than target.
+type internalIterator interface {
+	valid() bool
+	key() string
+	seq() uint64
+	entry() memEntry
+	seekToFirst()
+	seekToLast()
+	seek(target string)
+	next()
+	prev()
+}
+
+// runIterator walks a sortedRun.
+type runIterator struct {
+	run sortedRun
+	pos int // len(run) when not positioned
+}
+
+func newRunIterator(run sortedRun) *runIterator {
+	return &runIterator{run: run, pos: len(run)}
+}
+
+func (it *runIterator) valid() bool        { return it.pos < len(it.run) }
+func (it *runIterator) key() string        { return it.run[it.pos].key }
+func (it *runIterator) seq() uint64        { return it.run[it.pos].seq }
+func (it *runIterator) entry() memEntry    { return it.run[it.pos].entry }
+func (it *runIterator) seekToFirst()       { it.pos = 0 }
+func (it *runIterator) seek(target string) { it.pos = it.run.search(target) }
+
+func (it *runIterator) seekToLast() {
+	it.pos = len(it.run) - 1
+	if it.pos < 0 {
+		it.pos = len(it.run)
+	}
+}
+
+func (it *runIterator) next() {
+	if it.valid() {
+		it.pos++
+	}
+}
+
+func (it *runIterator) prev() {
+	if !it.valid() {
+		return
+	}
+	if it.pos == 0 {
+		it.pos = len(it.run)
+	} else {
+		it.pos--
+	}
+}
+
+// visibleIterator shows one entry per key of its source: the newest version
+// at or below seq. Keys with no such version are skipped. The current
+// record is kept aside, since going backward leaves the source positioned
+// before it.
+type visibleIterator struct {
+	source  internalIterator
+	seqNum  uint64
+	forward bool
+	ok      bool
+	cur     memRecord
+}
+
+func newVisibleIterator(source internalIterator, seq uint64) *visibleIterator {
+	return &visibleIterator{source: source, seqNum: seq}
+}
+
+func (it *visibleIterator) valid() bool     { return it.ok }
+func (it *visibleIterator) key() string     { return it.cur.key }
+func (it *visibleIterator) seq() uint64     { return it.cur.seq }
+func (it *visibleIterator) entry() memEntry { return it.cur.entry }
+
+func (it *visibleIterator) seekToFirst() {
+	it.source.seekToFirst()
+	it.findNext()
+}
+
+func (it *visibleIterator) seekToLast() {
+	it.source.seekToLast()
+	it.findPrev()
+}
+
+func (it *visibleIterator) seek(target string) {
+	it.source.seek(target)
+	it.findNext()
+}
+
+func (it *visibleIterator) next() {
+	if !it.ok {
+		return
+	}
+	if !it.forward {
+		it.source.seek(it.cur.key)
+	}
+	for it.source.valid() && it.source.key() == it.cur.key {
+		it.source.next()
+	}
+	it.findNext()
+}
+
+func (it *visibleIterator) prev() {
+	if !it.ok {
+		return
+	}
+	if it.forward {
+		for it.source.valid() && it.source.key() == it.cur.key {
+			it.source.prev()
+		}
+	}
+	it.findPrev()
+}
+
+func (it *visibleIterator) take() {
+	it.cur = memRecord{key: it.source.key(), seq: it.source.seq(), entry: it.source.entry()}
+	it.ok = true
+}
+
+// findNext settles on the first visible version. Versions come newest
+// first, so it is the first one not newer than seqNum; the source stays on
+// it.
+func (it *visibleIterator) findNext() {
+	it.forward = true
+	it.ok = false
+	for it.source.valid() && it.source.seq() > it.seqNum {
+		it.source.next()
+	}
+	if it.source.valid() {
+		it.take()
+	}
+}
+
+// findPrev settles on the visible version of the previous key. Going
+// backward, versions come oldest first: it is the last one not newer than
+// seqNum before the key changes. The source ends up on the key before.
+func (it *visibleIterator) findPrev() {
+	it.forward = false
+	it.ok = false
+	for it.source.valid() {
+		if it.ok && it.source.key() != it.cur.key {
+			return
+		}
+		if it.source.seq() <= it.seqNum {
+			it.take()
+		}
+		it.source.prev()
+	}
+}
+
+// mergingIterator is the coreIterator of the Go backend. It holds a
+// reference to every table it reads, released by close.
+type mergingIterator struct {
+	sources   []internalIterator // newest first
+	tables    []*table
+	rangeDels []rangeTombstone // visible to the iterator
+	now       int64            // values expired by then read as deleted
+	forward   bool
+	ok        bool
+	curKey    string
+	curVal    []byte
+
+	// What merge operands are folded with: the memtables and tables behind
+	// sources, in the same order, read at seq
+	seq        uint64
+	operator   MergeOperator
+	lookupFrom []versionSource
+
+	// Keys outside [lower, upper) are never settled on; a nil upper leaves
+	// the iterator unbounded above
+	lower string
+	upper []byte
+}
+
+// live reports whether the newest entry of a key, which source is at, is a
+// value neither expired nor deleted by a range tombstone
+func (it *mergingIterator) live(source internalIterator) bool {
+	return source.entry().live(it.now) && !rangeDeleted(it.rangeDels, source.key(), source.seq())
+}
+
+// settle makes the newest entry of a key, which source is at, the current
+// one. A merge operand is folded with the older versions of the key.
+func (it *mergingIterator) settle(source internalIterator) {
+	it.curKey = source.key()
+	it.curVal = source.entry().value
+	it.ok = true
+	if !source.entry().merge || it.operator == nil {
+		return
+	}
+	read := &pointRead{
+		key:      it.curKey,
+		seq:      it.seq,
+		covering: coveringSeq(it.rangeDels, it.curKey, it.seq),
+		now:      it.now,
+		operator: it.operator,
+	}
+	for _, from := range it.lookupFrom {
+		if read.search(from); read.done {
+			break
+		}
+	}
+	it.curVal, _ = read.result()
+}
+
+// findNextLive settles on the smallest key any source is at, skipping
+// deleted and expired keys
+func (it *mergingIterator) findNextLive() {
+	it.ok = false
+	for {
+		var newest internalIterator
+		for _, source := range it.sources {
+			if source.valid() && (newest == nil || source.key() < newest.key()) {
+				newest = source
+			}
+		}
+		if newest == nil || (it.upper != nil && newest.key() >= string(it.upper)) {
+			return
+		}
+		if it.live(newest) {
+			it.settle(newest)
+			return
+		}
+
+		// Step every source past the deleted key
+		key := newest.key()
+		for _, source := range it.sources {
+			if source.valid() && source.key() == key {
+				source.next()
+			}
+		}
+	}
+}
+
+// findPrevLive settles on the largest key any source is at, skipping
+// deleted and expired keys
+func (it *mergingIterator) findPrevLive() {
+	it.ok = false
+	for {
+		var newest internalIterator
+		for _, source := range it.sources {
+			if source.valid() && (newest == nil || source.key() > newest.key()) {
+				newest = source
+			}
+		}
+		if newest == nil || newest.key() < it.lower {
+			return
+		}
+		if it.live(newest) {
+			it.settle(newest)
+			return
+		}
+
+		key := newest.key()
+		for _, source := range it.sources {
+			if source.valid() && source.key() == key {
+				source.prev()
+			}
+		}
+	}
+}
+
+func (it *mergingIterator) valid() bool {
+	return it.ok
+}
+
+func (it *mergingIterator) seekToFirst() {
+	for _, source := range it.sources {
+		source.seek(it.lower)
+	}
+	it.forward = true
+	it.findNextLive()
+}
+
+func (it *mergingIterator) seekToLast() {
+	for _, source := range it.sources {
+		if it.upper == nil {
+			source.seekToLast()
+			continue
+		}
+		// The last entry before upper
+		source.seek(string(it.upper))
+		if source.valid() {
+			source.prev()
+		} else {
+			source.seekToLast()
+		}
+	}
+	it.forward = false
+	it.findPrevLive()
+}
+
+func (it *mergingIterator) seek(key []byte) {
+	target := max(string(key), it.lower)
+	for _, source := range it.sources {
+		source.seek(target)
+	}
+	it.forward = true
+	it.findNextLive()
+}
+
+func (it *mergingIterator) next() {
+	if !it.ok {
+		return
+	}
+	if !it.forward {
+		// Sources behind the current key move up to it
+		for _, source := range it.sources {
+			source.seek(it.curKey)
+		}
+		it.forward = true
+	}
+	for _, source := range it.sources {
+		if source.valid() && source.key() == it.curKey {
+			source.next()
+		}
+	}
+	it.findNextLive()
+}
+
+func (it *mergingIterator) prev() {
+	if !it.ok {
+		return
+	}
+	if !it.forward {
+		for _, source := range it.sources {
+			if source.valid() && source.key() == it.curKey {
+				source.prev()
+			}
+		}
+	} else {
+		// Move every source to its last entry before the current key
+		for _, source := range it.sources {
+			source.seek(it.curKey)
+			if source.valid() {
+				source.prev()
+			} else {
+				source.seekToLast()
+			}
+		}
+		it.forward = false
+	}
+	it.findPrevLive()
+}
+
+func (it *mergingIterator) key() []byte {
+	if !it.ok {
+		return nil
+	}
+	return []byte(it.curKey)
+}
+
+func (it *mergingIterator) value() []byte {
+	if !it.ok {
+		return nil
+	}
+	value := make([]byte, len(it.curVal))
+	copy(value, it.curVal)
+	return value
+}
+
+func (it *mergingIterator) close() {
+	for _, t := range it.tables {
+		t.unref()
+	}
+	it.tables = nil
+	it.sources = nil
+	it.lookupFrom = nil
+	it.ok = false
+}
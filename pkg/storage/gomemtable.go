@@ -0,0 +1,259 @@
+package storage
+
+import (
+	"math"
+	"sort"
+)
+
+// memtableFlushThreshold is the memtable size at which the Go backend asks
+// for a flush, the same as the C++ engine's MEMTABLE_FLUSH_THRESHOLD.
+const memtableFlushThreshold = 1 << 20
+
+// memEntry is a memtable slot or SSTable record: either a live value, a
+// tombstone that shadows any older value of the same key, or a merge
+// operand that applies to the older versions.
+type memEntry struct {
+	deleted bool
+	merge   bool
+	value   []byte
+	// expiresAt is when the value expires, in microseconds since the Unix
+	// epoch; 0 if it never does. An expired value shadows older versions
+	// just like a tombstone.
+	expiresAt int64
+}
+
+// live reports whether the entry holds a value that has not expired by now
+func (e memEntry) live(now int64) bool {
+	return !e.deleted && (e.expiresAt == 0 || now < e.expiresAt)
+}
+
+// memRecord is an entry with its key and the sequence number of the write
+// that stored it. Records read from tables written before sequence numbers
+// were stored have seq 0.
+type memRecord struct {
+	key   string
+	seq   uint64
+	entry memEntry
+}
+
+// rangeTombstone deletes every version of the keys in [start, end) written
+// before seq. It lives with the memtable it was written to until that is
+// flushed, then with the version until a compaction has removed everything
+// it covers.
+type rangeTombstone struct {
+	start string
+	end   string
+	seq   uint64
+}
+
+// coveringSeq returns the sequence number of the newest tombstone visible at
+// seq whose range holds key, or 0 if there is none. Versions of key older
+// than it are deleted.
+func coveringSeq(tombstones []rangeTombstone, key string, seq uint64) uint64 {
+	var covering uint64
+	for _, t := range tombstones {
+		if t.seq <= seq && key >= t.start && key < t.end {
+			covering = max(covering, t.seq)
+		}
+	}
+	return covering
+}
+
+// visibleRangeTombstones appends the tombstones visible at seq to dst
+func visibleRangeTombstones(dst, tombstones []rangeTombstone, seq uint64) []rangeTombstone {
+	for _, t := range tombstones {
+		if t.seq <= seq {
+			dst = append(dst, t)
+		}
+	}
+	return dst
+}
+
+// rangeDeleted reports whether one of tombstones deletes version seq of key
+func rangeDeleted(tombstones []rangeTombstone, key string, seq uint64) bool {
+	for _, t := range tombstones {
+		if seq < t.seq && key >= t.start && key < t.end {
+			return true
+		}
+	}
+	return false
+}
+
+// rangeTombstoneSize is what a range tombstone counts towards the flush
+// threshold, as the C++ engine counts it
+func rangeTombstoneSize(t rangeTombstone) int {
+	return len(t.start) + len(t.end) + 8
+}
+
+// internalLess orders records by key, then newest version first.
+func internalLess(key string, seq uint64, otherKey string, otherSeq uint64) bool {
+	if key != otherKey {
+		return key < otherKey
+	}
+	return seq > otherSeq
+}
+
+// memEntrySize is what an entry counts towards the flush threshold: the key,
+// a length word and the value, as the C++ engine counts it.
+func memEntrySize(key string, entry memEntry) int {
+	return len(key) + 4 + len(entry.value)
+}
+
+const memtableMaxHeight = 12
+
+type memNode struct {
+	memRecord
+	next []*memNode
+}
+
+// memtable is a skip list holding every version written to each key,
+// newest first. It is not synchronised; goEngine guards it with its mutex.
+// Entry values are never modified once inserted, so records handed out keep
+// their contents.
+type memtable struct {
+	head      *memNode
+	height    int
+	rnd       uint64
+	len       int
+	size      int
+	rangeDels []rangeTombstone
+}
+
+func newMemtable() *memtable {
+	return &memtable{
+		head:   &memNode{next: make([]*memNode, memtableMaxHeight)},
+		height: 1,
+		rnd:    0x2545f4914f6cdd1d,
+	}
+}
+
+// randomHeight picks a node height, each level a quarter as likely as the
+// one below
+func (m *memtable) randomHeight() int {
+	height := 1
+	for height < memtableMaxHeight {
+		m.rnd ^= m.rnd << 13
+		m.rnd ^= m.rnd >> 7
+		m.rnd ^= m.rnd << 17
+		if m.rnd&3 != 0 {
+			break
+		}
+		height++
+	}
+	return height
+}
+
+// seek returns the first node not before version seq of key. If prev is
+// not nil it receives the last node before that one on every level.
+func (m *memtable) seek(key string, seq uint64, prev []*memNode) *memNode {
+	x := m.head
+	for level := m.height - 1; level >= 0; level-- {
+		for next := x.next[level]; next != nil && internalLess(next.key, next.seq, key, seq); next = x.next[level] {
+			x = next
+		}
+		if prev != nil {
+			prev[level] = x
+		}
+	}
+	return x.next[0]
+}
+
+// add inserts version seq of key. Older versions stay until a flush finds
+// no snapshot that can see them.
+func (m *memtable) add(key string, seq uint64, entry memEntry) {
+	var prev [memtableMaxHeight]*memNode
+	node := m.seek(key, seq, prev[:])
+	if node != nil && node.key == key && node.seq == seq {
+		return
+	}
+
+	height := m.randomHeight()
+	for level := m.height; level < height; level++ {
+		prev[level] = m.head
+	}
+	if height > m.height {
+		m.height = height
+	}
+	node = &memNode{memRecord: memRecord{key: key, seq: seq, entry: entry}, next: make([]*memNode, height)}
+	for level := 0; level < height; level++ {
+		node.next[level] = prev[level].next[level]
+		prev[level].next[level] = node
+	}
+	m.len++
+	m.size += memEntrySize(key, entry)
+}
+
+// addRangeTombstone records a deletion of [start, end) at seq
+func (m *memtable) addRangeTombstone(start, end string, seq uint64) {
+	t := rangeTombstone{start: start, end: end, seq: seq}
+	m.rangeDels = append(m.rangeDels, t)
+	m.size += rangeTombstoneSize(t)
+}
+
+func (m *memtable) empty() bool {
+	return m.len == 0 && len(m.rangeDels) == 0
+}
+
+// get returns the newest version of key at or below seq, and its sequence
+// number
+func (m *memtable) get(key string, seq uint64) (memEntry, uint64, bool) {
+	node := m.seek(key, seq, nil)
+	if node == nil || node.key != key {
+		return memEntry{}, 0, false
+	}
+	return node.entry, node.seq, true
+}
+
+// records copies the entries out in key order
+func (m *memtable) records() sortedRun {
+	run := make(sortedRun, 0, m.len)
+	for x := m.head.next[0]; x != nil; x = x.next[0] {
+		run = append(run, x.memRecord)
+	}
+	return run
+}
+
+// recordsIn copies the entries of the keys in [lower, upper) out in key
+// order; a nil upper copies every key from lower on
+func (m *memtable) recordsIn(lower, upper []byte) sortedRun {
+	var run sortedRun
+	for x := m.seek(string(lower), math.MaxUint64, nil); x != nil; x = x.next[0] {
+		if upper != nil && x.key >= string(upper) {
+			break
+		}
+		run = append(run, x.memRecord)
+	}
+	return run
+}
+
+// seal copies the memtable out as an immutable memtable
+func (m *memtable) seal() sealedMemtable {
+	return sealedMemtable{run: m.records(), rangeDels: m.rangeDels}
+}
+
+// sealedMemtable is an immutable memtable waiting to be flushed: its
+// records and the range tombstones written to it.
+type sealedMemtable struct {
+	run       sortedRun
+	rangeDels []rangeTombstone
+}
+
+// sortedRun is a read-only slice of records in internal key order: a sealed
+// memtable or a decoded data block.
+type sortedRun []memRecord
+
+// search returns the index of the newest version of the first key not
+// smaller than key
+func (r sortedRun) search(key string) int {
+	return sort.Search(len(r), func(i int) bool { return r[i].key >= key })
+}
+
+// get returns the newest version of key at or below seq, and its sequence
+// number
+func (r sortedRun) get(key string, seq uint64) (memEntry, uint64, bool) {
+	i := sort.Search(len(r), func(i int) bool { return !internalLess(r[i].key, r[i].seq, key, seq) })
+	if i < len(r) && r[i].key == key {
+		return r[i].entry, r[i].seq, true
+	}
+	return memEntry{}, 0, false
+}
@@ -0,0 +1,785 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+)
+
+// The Go backend reads and writes the SSTable format of sstable/table.cpp,
+// including the Bloom filters of bloom.cpp and the block codecs of
+// compression.cpp; see there for the layout. The C++ engine stores
+// fixed-width fields in host byte order, which is little-endian on every
+// platform either backend is built for.
+
+const (
+	tableFooterMagic   = 0x3242545353544c42
+	tableFilterMagic   = 0x53535442464c5452
+	tableFormatVersion = 6
+
+	// Footer of version 2 and later tables:
+	// <filter_start><index_start><version><magic>
+	tableFooterSize = 8 + 8 + 4 + 8
+
+	// Value length marking a tombstone record, which has no value bytes
+	tombstoneValueLen = math.MaxUint32
+
+	// Value length marking a value that expires, followed by the expiry
+	// time and the real length
+	expiringValueLen = math.MaxUint32 - 1
+
+	// Value length marking a merge operand, followed by the real length
+	mergeValueLen = math.MaxUint32 - 2
+
+	// Block codecs, as recorded in each block header
+	blockCodecNone = 0
+	blockCodecZlib = 1
+)
+
+var errCorruptTable = errors.New("corrupt sstable")
+
+// tablePath names the SSTable with the given file number
+func tablePath(dir string, number uint32) string {
+	return filepath.Join(dir, fmt.Sprintf("sstable_%04d.sst", number))
+}
+
+// syncFile fsyncs a file that has already been written and closed.
+func syncFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// tableMeta describes one live SSTable.
+type tableMeta struct {
+	number   uint32
+	seq      uint64 // highest write sequence number; orders level 0
+	fileSize uint64
+	smallest string
+	largest  string
+	path     string
+}
+
+// blockHandle locates one data block, as recorded in a table's index.
+type blockHandle struct {
+	lastKey string
+	offset  uint64
+	size    uint32
+}
+
+// bloomHash is the Murmur-like hash LevelDB uses for its filters.
+func bloomHash(key string) uint32 {
+	const seed = 0xbc9f1d34
+	const m = 0xc6a4a793
+	n := len(key)
+	h := uint32(seed) ^ uint32(n)*m
+
+	i := 0
+	for ; i+4 <= n; i += 4 {
+		h += uint32(key[i]) | uint32(key[i+1])<<8 | uint32(key[i+2])<<16 | uint32(key[i+3])<<24
+		h *= m
+		h ^= h >> 16
+	}
+
+	switch n - i {
+	case 3:
+		h += uint32(key[i+2]) << 16
+		fallthrough
+	case 2:
+		h += uint32(key[i+1]) << 8
+		fallthrough
+	case 1:
+		h += uint32(key[i])
+		h *= m
+		h ^= h >> 24
+	}
+	return h
+}
+
+// bloomBuild builds a filter from key hashes. The number of probes goes in
+// the last byte. Returns nil if filters are disabled.
+func bloomBuild(hashes []uint32, bitsPerKey int) []byte {
+	if bitsPerKey == 0 || len(hashes) == 0 {
+		return nil
+	}
+
+	k := uint32(float64(bitsPerKey) * 0.69)
+	k = max(1, min(k, 30))
+
+	bits := max(len(hashes)*bitsPerKey, 64)
+	size := (bits + 7) / 8
+	bits = size * 8
+
+	filter := make([]byte, size, size+1)
+	for _, h := range hashes {
+		delta := h>>17 | h<<15
+		for j := uint32(0); j < k; j++ {
+			bitpos := uint64(h) % uint64(bits)
+			filter[bitpos/8] |= 1 << (bitpos % 8)
+			h += delta
+		}
+	}
+	return append(filter, byte(k))
+}
+
+// bloomMayContain is false only if the filtered table definitely does not
+// hold key.
+func bloomMayContain(filter []byte, key string) bool {
+	if len(filter) < 2 {
+		return true
+	}
+
+	bits := uint64(len(filter)-1) * 8
+	k := uint32(filter[len(filter)-1])
+	if k > 30 {
+		return true // Reserved for other encodings
+	}
+
+	h := bloomHash(key)
+	delta := h>>17 | h<<15
+	for j := uint32(0); j < k; j++ {
+		bitpos := uint64(h) % bits
+		if filter[bitpos/8]&(1<<(bitpos%8)) == 0 {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
+
+// compressBlock compresses a block with codec, storing it as is when that
+// does not save at least an eighth. Returns the codec used.
+func compressBlock(codec Compression, raw []byte) (byte, []byte) {
+	if codec == CompressionZlib {
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		_, err := w.Write(raw)
+		if err == nil && w.Close() == nil && buf.Len() < len(raw)-len(raw)/8 {
+			return blockCodecZlib, buf.Bytes()
+		}
+	}
+	return blockCodecNone, raw
+}
+
+// decompressBlock undoes compressBlock, checking the result is rawSize bytes
+func decompressBlock(codec byte, data []byte, rawSize uint32) ([]byte, error) {
+	switch codec {
+	case blockCodecNone:
+		if len(data) != int(rawSize) {
+			return nil, errCorruptTable
+		}
+		return data, nil
+	case blockCodecZlib:
+		r, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		out := make([]byte, rawSize)
+		if _, err := io.ReadFull(r, out); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unknown block codec %d", codec)
+}
+
+// tableWriter streams entries, in strictly increasing key order, into a new
+// SSTable cut into blocks of about Options.BlockSize bytes.
+type tableWriter struct {
+	path string
+	file *os.File
+	w    *bufio.Writer
+	err  error
+
+	blockSize       int
+	compression     Compression
+	bloomBitsPerKey int
+
+	block      []byte
+	index      []blockHandle
+	keyHashes  []uint32
+	numEntries uint64
+	offset     uint64
+	smallest   string
+	largest    string
+}
+
+func newTableWriter(path string, opts Options) (*tableWriter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &tableWriter{
+		path:            path,
+		file:            f,
+		w:               bufio.NewWriter(f),
+		blockSize:       opts.BlockSize,
+		compression:     opts.Compression,
+		bloomBitsPerKey: opts.bloomBitsPerKey(),
+	}, nil
+}
+
+// add appends a record. Records must come in internal key order.
+func (w *tableWriter) add(key string, seq uint64, entry memEntry) {
+	// A full block is only cut between keys, so a lookup finds every
+	// version of its key in one block
+	newKey := w.numEntries == 0 || key != w.largest
+	if newKey && len(w.block) >= w.blockSize {
+		w.flushBlock()
+	}
+
+	if w.numEntries == 0 {
+		w.smallest = key
+	}
+	w.largest = key
+	w.numEntries++
+
+	if newKey && w.bloomBitsPerKey > 0 {
+		w.keyHashes = append(w.keyHashes, bloomHash(key))
+	}
+
+	w.block = binary.LittleEndian.AppendUint32(w.block, uint32(len(key)))
+	w.block = append(w.block, key...)
+	w.block = binary.LittleEndian.AppendUint64(w.block, seq)
+	if entry.deleted {
+		w.block = binary.LittleEndian.AppendUint32(w.block, tombstoneValueLen)
+		return
+	}
+	if entry.merge {
+		w.block = binary.LittleEndian.AppendUint32(w.block, mergeValueLen)
+	}
+	if entry.expiresAt != 0 {
+		w.block = binary.LittleEndian.AppendUint32(w.block, expiringValueLen)
+		w.block = binary.LittleEndian.AppendUint64(w.block, uint64(entry.expiresAt))
+	}
+	w.block = binary.LittleEndian.AppendUint32(w.block, uint32(len(entry.value)))
+	w.block = append(w.block, entry.value...)
+}
+
+func (w *tableWriter) write(p []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(p)
+	}
+	w.offset += uint64(len(p))
+}
+
+// flushBlock writes out the open block and records it in the index
+func (w *tableWriter) flushBlock() {
+	if len(w.block) == 0 {
+		return
+	}
+
+	codec, payload := compressBlock(w.compression, w.block)
+	header := binary.LittleEndian.AppendUint32([]byte{codec}, uint32(len(w.block)))
+
+	offset := w.offset
+	w.write(header)
+	w.write(payload)
+	w.index = append(w.index, blockHandle{
+		lastKey: w.largest,
+		offset:  offset,
+		size:    uint32(len(header) + len(payload)),
+	})
+	w.block = w.block[:0]
+}
+
+// finish writes the filter, index and footer and closes the file
+func (w *tableWriter) finish() error {
+	w.flushBlock()
+
+	filterStart := w.offset
+	w.write(bloomBuild(w.keyHashes, w.bloomBitsPerKey))
+	w.keyHashes = nil
+
+	indexStart := w.offset
+	index := binary.LittleEndian.AppendUint32(nil, uint32(len(w.index)))
+	for _, handle := range w.index {
+		index = binary.LittleEndian.AppendUint32(index, uint32(len(handle.lastKey)))
+		index = append(index, handle.lastKey...)
+		index = binary.LittleEndian.AppendUint64(index, handle.offset)
+		index = binary.LittleEndian.AppendUint32(index, handle.size)
+	}
+	index = binary.LittleEndian.AppendUint64(index, filterStart)
+	index = binary.LittleEndian.AppendUint64(index, indexStart)
+	index = binary.LittleEndian.AppendUint32(index, tableFormatVersion)
+	index = binary.LittleEndian.AppendUint64(index, tableFooterMagic)
+	w.write(index)
+
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	if err := w.file.Close(); w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+// sync forces the finished file to disk
+func (w *tableWriter) sync() error {
+	return syncFile(w.path)
+}
+
+// abandon closes and removes an unfinished table
+func (w *tableWriter) abandon() {
+	w.file.Close()
+	os.Remove(w.path)
+}
+
+func (w *tableWriter) fileSize() uint64 {
+	return w.offset
+}
+
+// readRange reads exactly size bytes at offset
+func readRange(f *os.File, offset, size uint64) ([]byte, error) {
+	buf := make([]byte, size)
+	if _, err := f.ReadAt(buf, int64(offset)); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// tableFooter holds the section offsets read from the end of a table.
+type tableFooter struct {
+	version     uint32
+	filterStart uint64
+	indexStart  uint64
+	indexEnd    uint64
+}
+
+// readFooter understands every table version; see table.cpp.
+func readFooter(f *os.File, fileSize uint64) (tableFooter, error) {
+	var footer tableFooter
+	if fileSize < 8 {
+		return footer, errCorruptTable
+	}
+
+	tailSize := min(fileSize, tableFooterSize)
+	raw, err := readRange(f, fileSize-tailSize, tailSize)
+	if err != nil {
+		return footer, err
+	}
+	magic := binary.LittleEndian.Uint64(raw[tailSize-8:])
+
+	switch magic {
+	case tableFooterMagic:
+		if fileSize < tableFooterSize {
+			return footer, errCorruptTable
+		}
+		footer.filterStart = binary.LittleEndian.Uint64(raw[0:])
+		footer.indexStart = binary.LittleEndian.Uint64(raw[8:])
+		footer.version = binary.LittleEndian.Uint32(raw[16:])
+		footer.indexEnd = fileSize - tableFooterSize
+		if footer.version < 2 || footer.version > tableFormatVersion {
+			return footer, fmt.Errorf("unsupported sstable version %d", footer.version)
+		}
+	case tableFilterMagic:
+		if fileSize < 3*8 {
+			return footer, errCorruptTable
+		}
+		footer.filterStart = binary.LittleEndian.Uint64(raw[tailSize-24:])
+		footer.indexStart = binary.LittleEndian.Uint64(raw[tailSize-16:])
+		footer.version = 1
+		footer.indexEnd = fileSize - 3*8
+	default:
+		// The last word of a version 0 table is its index offset
+		footer.filterStart = magic
+		footer.indexStart = magic
+		footer.indexEnd = fileSize - 8
+	}
+
+	if footer.filterStart > footer.indexStart || footer.indexStart > footer.indexEnd {
+		return footer, errCorruptTable
+	}
+	return footer, nil
+}
+
+// byteReader decodes the fixed-width fields of the on-disk formats
+type byteReader struct {
+	buf []byte
+	pos int
+	ok  bool
+}
+
+func newByteReader(buf []byte) *byteReader {
+	return &byteReader{buf: buf, ok: true}
+}
+
+func (r *byteReader) take(n int) []byte {
+	if !r.ok || len(r.buf)-r.pos < n {
+		r.ok = false
+		return nil
+	}
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *byteReader) u8() uint8 {
+	if b := r.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *byteReader) u32() uint32 {
+	if b := r.take(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *byteReader) u64() uint64 {
+	if b := r.take(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+// str reads a u32 length followed by that many bytes
+func (r *byteReader) str() string {
+	n := r.u32()
+	return string(r.take(int(n)))
+}
+
+func (r *byteReader) done() bool {
+	return r.pos == len(r.buf)
+}
+
+// readBlockIndex loads a table's block index. Tables before version 2 index
+// every key; each entry becomes a block holding that single record.
+func readBlockIndex(f *os.File, footer tableFooter) ([]blockHandle, error) {
+	raw, err := readRange(f, footer.indexStart, footer.indexEnd-footer.indexStart)
+	if err != nil {
+		return nil, err
+	}
+
+	r := newByteReader(raw)
+	count := r.u32()
+	var index []blockHandle
+	for i := uint32(0); i < count && r.ok; i++ {
+		handle := blockHandle{lastKey: r.str(), offset: r.u64()}
+		if footer.version >= 2 {
+			handle.size = r.u32()
+		}
+		index = append(index, handle)
+	}
+	if !r.ok {
+		return nil, errCorruptTable
+	}
+
+	if footer.version < 2 {
+		for i := range index {
+			end := footer.filterStart
+			if i+1 < len(index) {
+				end = index[i+1].offset
+			}
+			index[i].size = uint32(end - index[i].offset)
+		}
+	}
+	return index, nil
+}
+
+// parseRecord decodes the record at pos in a block of a table of the given
+// format version, returning the position after it. The key and value alias
+// block.
+func parseRecord(block []byte, pos int, version uint32) (key []byte, seq uint64, entry memEntry, next int, ok bool) {
+	r := &byteReader{buf: block, pos: pos, ok: true}
+	key = r.take(int(r.u32()))
+	if version >= 4 {
+		seq = r.u64()
+	}
+	valueLen := r.u32()
+	if !r.ok {
+		return nil, 0, memEntry{}, pos, false
+	}
+	if valueLen == tombstoneValueLen {
+		return key, seq, memEntry{deleted: true}, r.pos, true
+	}
+	if version >= 6 && valueLen == mergeValueLen {
+		entry.merge = true
+		valueLen = r.u32()
+	}
+	if version >= 5 && valueLen == expiringValueLen {
+		entry.expiresAt = int64(r.u64())
+		valueLen = r.u32()
+	}
+	entry.value = r.take(int(valueLen))
+	return key, seq, entry, r.pos, r.ok
+}
+
+// table is an open SSTable with its block index and Bloom filter in memory.
+// Reads use ReadAt, so one table serves concurrent lookups. The file is
+// closed when the last reference is dropped.
+type table struct {
+	file     *os.File
+	number   uint32
+	blocks   *blockCache
+	version  uint32
+	fileSize uint64
+	index    []blockHandle
+	filter   []byte
+	refs     atomic.Int32
+}
+
+// openTable opens the table at path, holding one reference for the caller.
+// Lookups go through blocks when it is not nil; number identifies the
+// table's blocks there.
+func openTable(path string, number uint32, blocks *blockCache) (*table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	t := &table{file: f, number: number, blocks: blocks, fileSize: uint64(info.Size())}
+	footer, err := readFooter(f, t.fileSize)
+	if err == nil {
+		t.filter, err = readRange(f, footer.filterStart, footer.indexStart-footer.filterStart)
+	}
+	if err == nil {
+		t.index, err = readBlockIndex(f, footer)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot open %s: %w", path, err)
+	}
+	t.version = footer.version
+	t.refs.Store(1)
+	return t, nil
+}
+
+func (t *table) ref() {
+	t.refs.Add(1)
+}
+
+func (t *table) unref() {
+	if t.refs.Add(-1) == 0 {
+		t.file.Close()
+	}
+}
+
+func (t *table) mayContain(key string) bool {
+	return bloomMayContain(t.filter, key)
+}
+
+func (t *table) hasFilter() bool {
+	return len(t.filter) > 0
+}
+
+// readBlock reads a data block and undoes its compression, bypassing the
+// block cache
+func (t *table) readBlock(handle blockHandle) ([]byte, error) {
+	raw, err := readRange(t.file, handle.offset, uint64(handle.size))
+	if err != nil || t.version < 3 {
+		return raw, err
+	}
+	r := newByteReader(raw)
+	codec := r.u8()
+	rawSize := r.u32()
+	if !r.ok {
+		return nil, errCorruptTable
+	}
+	return decompressBlock(codec, raw[r.pos:], rawSize)
+}
+
+// block returns a data block, from the block cache if possible. Blocks read
+// from disk are only cached when fillCache is set.
+func (t *table) block(handle blockHandle, fillCache bool) ([]byte, error) {
+	if t.blocks != nil {
+		if block := t.blocks.lookup(t.number, handle.offset); block != nil {
+			return block, nil
+		}
+	}
+	block, err := t.readBlock(handle)
+	if err != nil {
+		return nil, err
+	}
+	if t.blocks != nil && fillCache {
+		t.blocks.insert(t.number, handle.offset, block)
+	}
+	return block, nil
+}
+
+// findBlock returns the index of the only block that can hold key: the
+// first whose last key is not smaller than it
+func (t *table) findBlock(key string) int {
+	return sort.Search(len(t.index), func(i int) bool { return t.index[i].lastKey >= key })
+}
+
+// get looks up the newest version of key at or below seq and returns it with
+// its sequence number. The value aliases
+// a block that may be cached and must not be modified.
+func (t *table) get(key string, seq uint64) (memEntry, uint64, bool) {
+	i := t.findBlock(key)
+	if i == len(t.index) {
+		return memEntry{}, 0, false
+	}
+	block, err := t.block(t.index[i], true)
+	if err != nil {
+		return memEntry{}, 0, false
+	}
+
+	// Records are sorted, so stop once past the key. The first version not
+	// newer than seq is the one visible at seq.
+	target := []byte(key)
+	for pos := 0; pos < len(block); {
+		recordKey, recordSeq, entry, next, ok := parseRecord(block, pos, t.version)
+		if !ok {
+			break
+		}
+		switch cmp := bytes.Compare(recordKey, target); {
+		case cmp == 0 && recordSeq <= seq:
+			return entry, recordSeq, true
+		case cmp > 0:
+			return memEntry{}, 0, false
+		}
+		pos = next
+	}
+	return memEntry{}, 0, false
+}
+
+// decodeBlock parses every record of a block
+func decodeBlock(block []byte, version uint32) (sortedRun, bool) {
+	var run sortedRun
+	for pos := 0; pos < len(block); {
+		key, seq, entry, next, ok := parseRecord(block, pos, version)
+		if !ok {
+			return nil, false
+		}
+		run = append(run, memRecord{key: string(key), seq: seq, entry: entry})
+		pos = next
+	}
+	return run, true
+}
+
+// tableIterator walks the records of a table in key order in either
+// direction, decoding the current block in full so stepping back within it
+// is cheap. Tombstones are returned like any other record.
+type tableIterator struct {
+	table      *table
+	blockIndex int
+	entries    sortedRun
+	pos        int
+	ok         bool
+}
+
+func newTableIterator(t *table) *tableIterator {
+	return &tableIterator{table: t}
+}
+
+// loadBlock decodes block index into entries. Scans do not fill the block
+// cache, so they cannot push out the blocks point lookups rely on.
+func (it *tableIterator) loadBlock(index int) bool {
+	it.ok = false
+	it.entries = nil
+	it.pos = 0
+	if index < 0 || index >= len(it.table.index) {
+		return false
+	}
+	block, err := it.table.block(it.table.index[index], false)
+	if err != nil {
+		return false
+	}
+	entries, ok := decodeBlock(block, it.table.version)
+	if !ok {
+		return false
+	}
+	it.entries = entries
+	it.blockIndex = index
+	return len(entries) > 0
+}
+
+func (it *tableIterator) valid() bool     { return it.ok }
+func (it *tableIterator) key() string     { return it.entries[it.pos].key }
+func (it *tableIterator) seq() uint64     { return it.entries[it.pos].seq }
+func (it *tableIterator) entry() memEntry { return it.entries[it.pos].entry }
+func (it *tableIterator) seekToFirst()    { it.ok = it.loadBlock(0) }
+
+func (it *tableIterator) seekToLast() {
+	it.ok = it.loadBlock(len(it.table.index) - 1)
+	it.pos = max(len(it.entries)-1, 0)
+}
+
+func (it *tableIterator) seek(target string) {
+	it.ok = false
+	if !it.loadBlock(it.table.findBlock(target)) {
+		return
+	}
+	it.pos = it.entries.search(target)
+	if it.pos == len(it.entries) {
+		// Every record in the block is smaller; continue in the next one
+		it.ok = it.loadBlock(it.blockIndex + 1)
+		return
+	}
+	it.ok = true
+}
+
+func (it *tableIterator) next() {
+	if !it.ok {
+		return
+	}
+	if it.pos++; it.pos < len(it.entries) {
+		return
+	}
+	it.ok = it.loadBlock(it.blockIndex + 1)
+}
+
+func (it *tableIterator) prev() {
+	if !it.ok {
+		return
+	}
+	if it.pos > 0 {
+		it.pos--
+		return
+	}
+	if it.blockIndex == 0 {
+		it.ok = false
+		return
+	}
+	it.ok = it.loadBlock(it.blockIndex - 1)
+	it.pos = max(len(it.entries)-1, 0)
+}
+
+// loadTableMeta reads the key range and size of an existing SSTable.
+func loadTableMeta(path string, number uint32) (*tableMeta, error) {
+	t, err := openTable(path, number, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer t.unref()
+
+	meta := &tableMeta{number: number, path: path, fileSize: t.fileSize}
+	if len(t.index) == 0 {
+		return meta, nil
+	}
+
+	// The smallest key is the first record of the first block
+	block, err := t.readBlock(t.index[0])
+	if err != nil {
+		return nil, err
+	}
+	key, _, _, _, ok := parseRecord(block, 0, t.version)
+	if !ok {
+		return nil, errCorruptTable
+	}
+	meta.smallest = string(key)
+	meta.largest = t.index[len(t.index)-1].lastKey
+	return meta, nil
+}
@@ -0,0 +1,560 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The Go backend keeps the live table set in the same append-only MANIFEST
+// as sstable/version.cpp, which documents the record format, and migrates
+// the same older layouts (a TABLES snapshot, or no record at all).
+
+// numLevels is the number of levels in the table layout, as in the C++
+// engine.
+const numLevels = 7
+
+const (
+	manifestTagCounter     = 1
+	manifestTagLastSeq     = 2
+	manifestTagAddTable    = 3
+	manifestTagDeleteTable = 4
+	manifestTagAddRangeDel = 5
+	manifestTagDelRangeDel = 6
+
+	manifestMaxBytes = 4 << 20
+)
+
+var errTableMissing = errors.New("a table listed in the MANIFEST is missing")
+
+// version is an immutable snapshot of the live table set. Level 0 is
+// ordered oldest to newest by seq; deeper levels are ordered by smallest
+// key and never overlap. rangeDels are the flushed range tombstones, which
+// may cover data in any table.
+type version struct {
+	levels    [numLevels][]*tableMeta
+	rangeDels []rangeTombstone
+}
+
+func (v *version) clone() *version {
+	next := &version{rangeDels: append([]rangeTombstone(nil), v.rangeDels...)}
+	for level := range v.levels {
+		next.levels[level] = append([]*tableMeta(nil), v.levels[level]...)
+	}
+	return next
+}
+
+// sortLevel puts a level in its canonical order
+func (v *version) sortLevel(level int) {
+	tables := v.levels[level]
+	if level == 0 {
+		sort.Slice(tables, func(i, j int) bool { return tables[i].seq < tables[j].seq })
+	} else {
+		sort.Slice(tables, func(i, j int) bool { return tables[i].smallest < tables[j].smallest })
+	}
+}
+
+func (v *version) levelBytes(level int) uint64 {
+	var total uint64
+	for _, t := range v.levels[level] {
+		total += t.fileSize
+	}
+	return total
+}
+
+// findTable returns the only table of a non-overlapping level that may
+// contain key
+func (v *version) findTable(level int, key string) *tableMeta {
+	tables := v.levels[level]
+	i := sort.Search(len(tables), func(i int) bool { return tables[i].largest >= key })
+	if i == len(tables) || key < tables[i].smallest {
+		return nil
+	}
+	return tables[i]
+}
+
+func tableOverlaps(t *tableMeta, lo, hi string) bool {
+	return !(t.largest < lo || t.smallest > hi)
+}
+
+type levelTable struct {
+	level int
+	table *tableMeta
+}
+
+type levelNumber struct {
+	level  int
+	number uint32
+}
+
+// versionEdit is a change from one version to the next, as recorded in the
+// MANIFEST. applyVersion fills in the counters.
+type versionEdit struct {
+	sstableCounter uint32
+	lastSeq        uint64
+	added          []levelTable
+	deleted        []levelNumber
+
+	// Range tombstones flushed, and those removed by sequence number
+	addedRangeDels   []rangeTombstone
+	deletedRangeDels []uint64
+}
+
+// apply applies an edit in place; levels it adds to are re-sorted
+func (v *version) apply(edit *versionEdit) {
+	for _, d := range edit.deleted {
+		tables := v.levels[d.level][:0]
+		for _, t := range v.levels[d.level] {
+			if t.number != d.number {
+				tables = append(tables, t)
+			}
+		}
+		v.levels[d.level] = tables
+	}
+	var touched [numLevels]bool
+	for _, a := range edit.added {
+		v.levels[a.level] = append(v.levels[a.level], a.table)
+		touched[a.level] = true
+	}
+	for level := range touched {
+		if touched[level] {
+			v.sortLevel(level)
+		}
+	}
+
+	for _, seq := range edit.deletedRangeDels {
+		tombstones := v.rangeDels[:0]
+		for _, t := range v.rangeDels {
+			if t.seq != seq {
+				tombstones = append(tombstones, t)
+			}
+		}
+		v.rangeDels = tombstones
+	}
+	v.rangeDels = append(v.rangeDels, edit.addedRangeDels...)
+}
+
+func appendString(dst []byte, s string) []byte {
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(s)))
+	return append(dst, s...)
+}
+
+func encodeEdit(edit *versionEdit) []byte {
+	payload := []byte{manifestTagCounter}
+	payload = binary.LittleEndian.AppendUint32(payload, edit.sstableCounter)
+	payload = append(payload, manifestTagLastSeq)
+	payload = binary.LittleEndian.AppendUint64(payload, edit.lastSeq)
+	for _, d := range edit.deleted {
+		payload = append(payload, manifestTagDeleteTable, byte(d.level))
+		payload = binary.LittleEndian.AppendUint32(payload, d.number)
+	}
+	for _, a := range edit.added {
+		payload = append(payload, manifestTagAddTable, byte(a.level))
+		payload = binary.LittleEndian.AppendUint32(payload, a.table.number)
+		payload = binary.LittleEndian.AppendUint64(payload, a.table.seq)
+		payload = binary.LittleEndian.AppendUint64(payload, a.table.fileSize)
+		payload = appendString(payload, a.table.smallest)
+		payload = appendString(payload, a.table.largest)
+	}
+	for _, seq := range edit.deletedRangeDels {
+		payload = append(payload, manifestTagDelRangeDel)
+		payload = binary.LittleEndian.AppendUint64(payload, seq)
+	}
+	for _, t := range edit.addedRangeDels {
+		payload = append(payload, manifestTagAddRangeDel)
+		payload = binary.LittleEndian.AppendUint64(payload, t.seq)
+		payload = appendString(payload, t.start)
+		payload = appendString(payload, t.end)
+	}
+	return payload
+}
+
+func decodeEdit(dir string, payload []byte) (*versionEdit, error) {
+	edit := &versionEdit{}
+	r := newByteReader(payload)
+	for r.ok && !r.done() {
+		switch r.u8() {
+		case manifestTagCounter:
+			edit.sstableCounter = r.u32()
+		case manifestTagLastSeq:
+			edit.lastSeq = r.u64()
+		case manifestTagAddTable:
+			level := int(r.u8())
+			t := &tableMeta{number: r.u32(), seq: r.u64(), fileSize: r.u64(), smallest: r.str(), largest: r.str()}
+			if level >= numLevels {
+				return nil, errors.New("bad level in MANIFEST")
+			}
+			t.path = tablePath(dir, t.number)
+			edit.added = append(edit.added, levelTable{level, t})
+		case manifestTagDeleteTable:
+			d := levelNumber{level: int(r.u8()), number: r.u32()}
+			if d.level >= numLevels {
+				return nil, errors.New("bad level in MANIFEST")
+			}
+			edit.deleted = append(edit.deleted, d)
+		case manifestTagAddRangeDel:
+			t := rangeTombstone{seq: r.u64(), start: r.str(), end: r.str()}
+			edit.addedRangeDels = append(edit.addedRangeDels, t)
+		case manifestTagDelRangeDel:
+			edit.deletedRangeDels = append(edit.deletedRangeDels, r.u64())
+		default:
+			return nil, errors.New("unknown MANIFEST tag")
+		}
+	}
+	if !r.ok {
+		return nil, errors.New("truncated MANIFEST edit")
+	}
+	return edit, nil
+}
+
+// encodeRecord frames an edit as <u32 length><u32 crc32><payload>
+func encodeRecord(edit *versionEdit) []byte {
+	payload := encodeEdit(edit)
+	record := binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))
+	record = binary.LittleEndian.AppendUint32(record, crc32.ChecksumIEEE(payload))
+	return append(record, payload...)
+}
+
+func (e *goEngine) manifestPath() string {
+	return filepath.Join(e.dir, "MANIFEST")
+}
+
+func (e *goEngine) tablesPath() string {
+	return filepath.Join(e.dir, "TABLES")
+}
+
+// writeSnapshot replaces the MANIFEST with a single edit that adds every
+// table and range tombstone in v and reopens it for appending
+func (e *goEngine) writeSnapshot(v *version) error {
+	edit := &versionEdit{sstableCounter: e.sstableCounter, lastSeq: e.lastSeq, addedRangeDels: v.rangeDels}
+	for level := range v.levels {
+		for _, t := range v.levels[level] {
+			edit.added = append(edit.added, levelTable{level, t})
+		}
+	}
+	record := encodeRecord(edit)
+
+	path := e.manifestPath()
+	tmp := path + ".tmp"
+	err := os.WriteFile(tmp, record, 0644)
+	if err == nil {
+		err = syncFile(tmp)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err == nil {
+		err = syncDir(e.dir)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if e.manifest != nil {
+		e.manifest.Close()
+	}
+	e.manifest = f
+	e.manifestSize = uint64(len(record))
+	return nil
+}
+
+// applyVersion logs edit to the MANIFEST and installs the resulting version
+// as current. Must be called with e.mu held so edits are logged in the
+// order versions are installed. On failure the current version is
+// unchanged.
+func (e *goEngine) applyVersion(edit *versionEdit) error {
+	edit.sstableCounter = e.sstableCounter
+	edit.lastSeq = e.lastSeq
+
+	next := e.current.clone()
+	next.apply(edit)
+
+	if e.manifestSize >= manifestMaxBytes {
+		// Start a new log from the resulting version instead of growing it
+		if err := e.writeSnapshot(next); err != nil {
+			return err
+		}
+	} else {
+		record := encodeRecord(edit)
+		_, err := e.manifest.Write(record)
+		if err == nil {
+			err = e.manifest.Sync()
+		}
+		if err != nil {
+			// A partial record may have been written; make the next edit
+			// start a new log rather than append after it
+			e.manifestSize = manifestMaxBytes
+			return err
+		}
+		e.manifestSize += uint64(len(record))
+	}
+
+	e.current = next
+	return nil
+}
+
+// checkpoint hard-links the tables of the current version into dir and
+// copies the MANIFEST describing them. The version stays pinned until its
+// tables are linked, so a compaction cannot delete them first.
+func (e *goEngine) checkpoint(dir string) error {
+	// The MANIFEST is read under mu, so it describes exactly this version
+	e.mu.Lock()
+	manifest, err := os.ReadFile(e.manifestPath())
+	v := e.current
+	if err == nil {
+		e.pin(v)
+	}
+	e.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	defer e.unpin(v)
+
+	for level := range v.levels {
+		for _, t := range v.levels[level] {
+			if err := os.Link(t.path, tablePath(dir, t.number)); err != nil {
+				return err
+			}
+		}
+	}
+
+	path := filepath.Join(dir, "MANIFEST")
+	if err := os.WriteFile(path, manifest, 0644); err != nil {
+		return err
+	}
+	return syncFile(path)
+}
+
+// replayManifest rebuilds the table set from the MANIFEST records. A torn
+// final record never committed and is ignored.
+func (e *goEngine) replayManifest(contents []byte, v *version) error {
+	r := newByteReader(contents)
+	for !r.done() {
+		length := r.u32()
+		crc := r.u32()
+		payload := r.take(int(length))
+		if !r.ok {
+			return nil // Torn final record
+		}
+		if crc32.ChecksumIEEE(payload) != crc {
+			// Only the last record can be torn by a crash during an append;
+			// damage anywhere else would lose committed edits
+			if r.done() {
+				return nil
+			}
+			return errors.New("corrupt MANIFEST record")
+		}
+
+		edit, err := decodeEdit(e.dir, payload)
+		if err != nil {
+			return err
+		}
+		v.apply(edit)
+		e.sstableCounter = max(e.sstableCounter, edit.sstableCounter)
+		e.lastSeq = max(e.lastSeq, edit.lastSeq)
+	}
+	return nil
+}
+
+// parseTableName parses "sstable_<number>.sst" followed by suffix; anything
+// else in the data directory is ignored
+func parseTableName(name, suffix string) (uint32, bool) {
+	rest, ok := strings.CutPrefix(name, "sstable_")
+	if !ok {
+		return 0, false
+	}
+	digits, ok := strings.CutSuffix(rest, ".sst"+suffix)
+	if !ok {
+		return 0, false
+	}
+	number, err := strconv.ParseUint(digits, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(number), true
+}
+
+// listTableFiles lists the file numbers of every SSTable in dir, or of
+// every table a flush was still writing under its temporary name
+func listTableFiles(dir, suffix string) ([]uint32, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var numbers []uint32
+	for _, entry := range entries {
+		if number, ok := parseTableName(entry.Name(), suffix); ok {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	return numbers, nil
+}
+
+// recoverLegacyCompaction settles a compaction interrupted in a directory
+// written before the table set was recorded, from the marker listing the
+// inputs still to delete.
+func recoverLegacyCompaction(dir string) {
+	tmp := filepath.Join(dir, "compaction.tmp")
+	marker := filepath.Join(dir, "COMPACTION_PENDING")
+
+	_, err := os.Stat(tmp)
+	outputPending := err == nil
+	if contents, err := os.ReadFile(marker); err == nil && !outputPending {
+		for _, field := range strings.Fields(string(contents)) {
+			number, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				break
+			}
+			os.Remove(tablePath(dir, uint32(number)))
+		}
+	}
+	os.Remove(tmp)
+	os.Remove(marker)
+}
+
+func (e *goEngine) loadTable(number uint32, seq uint64) (*tableMeta, error) {
+	t, err := loadTableMeta(tablePath(e.dir, number), number)
+	if err != nil {
+		return nil, err
+	}
+	t.seq = seq
+	return t, nil
+}
+
+// loadTablesFile reads the table set from a TABLES snapshot file:
+//
+//	counter <highest file number> <last seq>
+//	<level> <file number> <seq>
+//	...
+func (e *goEngine) loadTablesFile(contents []byte, v *version) error {
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "counter" {
+			if len(fields) >= 3 {
+				counter, _ := strconv.ParseUint(fields[1], 10, 32)
+				e.sstableCounter = uint32(counter)
+				e.lastSeq, _ = strconv.ParseUint(fields[2], 10, 64)
+			}
+			continue
+		}
+
+		if len(fields) < 3 {
+			return errors.New("malformed TABLES file")
+		}
+		level, err1 := strconv.Atoi(fields[0])
+		number, err2 := strconv.ParseUint(fields[1], 10, 32)
+		seq, err3 := strconv.ParseUint(fields[2], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil || level < 0 || level >= numLevels {
+			return errors.New("malformed TABLES file")
+		}
+		t, err := e.loadTable(uint32(number), seq)
+		if err != nil {
+			return err
+		}
+		v.levels[level] = append(v.levels[level], t)
+	}
+	return scanner.Err()
+}
+
+// loadUnrecordedTables loads a directory created before any table set was
+// recorded. It holds only flushed level-0 tables, whose file numbers give
+// their age.
+func (e *goEngine) loadUnrecordedTables(v *version) {
+	recoverLegacyCompaction(e.dir)
+	onDisk, err := listTableFiles(e.dir, "")
+	if err != nil {
+		return
+	}
+	for _, number := range onDisk {
+		t, err := e.loadTable(number, uint64(number))
+		if err != nil {
+			continue
+		}
+		v.levels[0] = append(v.levels[0], t)
+		e.lastSeq = max(e.lastSeq, uint64(number))
+	}
+}
+
+// loadVersion recovers the table set from the data directory, removes
+// tables no committed edit lists and starts a new MANIFEST.
+func (e *goEngine) loadVersion() error {
+	// A flush that crashed before renaming its table never committed; its
+	// data is still in the WAL
+	temp, err := listTableFiles(e.dir, ".tmp")
+	if err != nil {
+		return err
+	}
+	for _, number := range temp {
+		os.Remove(tablePath(e.dir, number) + ".tmp")
+	}
+
+	v := &version{}
+	recorded := true
+	if manifest, err := os.ReadFile(e.manifestPath()); err == nil {
+		if err := e.replayManifest(manifest, v); err != nil {
+			return err
+		}
+	} else if tables, err := os.ReadFile(e.tablesPath()); err == nil {
+		if err := e.loadTablesFile(tables, v); err != nil {
+			return err
+		}
+	} else {
+		e.loadUnrecordedTables(v)
+		recorded = false
+	}
+	for level := range v.levels {
+		v.sortLevel(level)
+	}
+
+	onDisk, err := listTableFiles(e.dir, "")
+	if err != nil {
+		return err
+	}
+	present := make(map[uint32]bool, len(onDisk))
+	for _, number := range onDisk {
+		present[number] = true
+	}
+	live := make(map[uint32]bool)
+	for level := range v.levels {
+		for _, t := range v.levels[level] {
+			if !present[t.number] {
+				return fmt.Errorf("%w: %s", errTableMissing, t.path)
+			}
+			live[t.number] = true
+		}
+	}
+
+	// Anything not in the table set never committed or was already replaced
+	for _, number := range onDisk {
+		if recorded && !live[number] {
+			os.Remove(tablePath(e.dir, number))
+		}
+		e.sstableCounter = max(e.sstableCounter, number)
+	}
+
+	// Start a fresh log; this also drops a torn final record
+	e.current = v
+	if err := e.writeSnapshot(v); err != nil {
+		return err
+	}
+	os.Remove(e.tablesPath())
+	return nil
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MemoryEngine is an Engine that keeps everything in a map and loses it on
+// Close. It is meant for tests and for running the server without storage.
+// Expired values stay in the map, unseen, until they are overwritten or
+// deleted.
+type MemoryEngine struct {
+	mu     sync.RWMutex
+	data   map[string]memEntry
+	closed bool
+
+	keysExpired atomic.Uint64
+}
+
+// NewMemoryEngine returns an empty in-memory engine.
+func NewMemoryEngine() *MemoryEngine {
+	return &MemoryEngine{data: make(map[string]memEntry)}
+}
+
+func (m *MemoryEngine) Get(key []byte) ([]byte, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return nil, false, errEngineClosed
+	}
+	entry, ok := m.data[string(key)]
+	if !ok {
+		return nil, false, nil
+	}
+	if !entry.live(time.Now().UnixMicro()) {
+		if !entry.deleted {
+			m.keysExpired.Add(1)
+		}
+		return nil, false, nil
+	}
+	return append([]byte{}, entry.value...), true, nil
+}
+
+func (m *MemoryEngine) Put(key, value []byte) error {
+	return m.PutWithTTL(key, value, 0)
+}
+
+func (m *MemoryEngine) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	var b Batch
+	b.PutWithTTL(key, value, ttl)
+	return m.Write(&b)
+}
+
+func (m *MemoryEngine) Delete(key []byte) error {
+	var b Batch
+	b.Delete(key)
+	return m.Write(&b)
+}
+
+// DeleteRange removes the keys in the range from the map, atomically.
+func (m *MemoryEngine) DeleteRange(start, end []byte) error {
+	if err := validateRange(start, end); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return errEngineClosed
+	}
+	for key := range m.data {
+		if key >= string(start) && key < string(end) {
+			delete(m.data, key)
+		}
+	}
+	return nil
+}
+
+// Write applies the batch atomically: readers see all of it or none.
+func (m *MemoryEngine) Write(batch *Batch) error {
+	if err := batch.validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return errEngineClosed
+	}
+	now := time.Now()
+	for _, op := range batch.ops {
+		if op.delete {
+			delete(m.data, string(op.key))
+		} else {
+			// Stored values are never modified, so snapshots can share them
+			m.data[string(op.key)] = memEntry{
+				value:     append([]byte{}, op.value...),
+				expiresAt: expiresAt(now, op.ttl),
+			}
+		}
+	}
+	return nil
+}
+
+// NewIterator copies the keys out, so its cost grows with the engine.
+func (m *MemoryEngine) NewIterator() (Iterator, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return nil, errEngineClosed
+	}
+	now := time.Now().UnixMicro()
+	run := make(sortedRun, 0, len(m.data))
+	for key, entry := range m.data {
+		if entry.live(now) {
+			run = append(run, memRecord{key: key, entry: memEntry{value: entry.value}})
+		}
+	}
+	sort.Slice(run, func(i, j int) bool { return run[i].key < run[j].key })
+	return newSnapshotIterator(run), nil
+}
+
+// Stats reports the stored bytes as memtable bytes, and the lookups that
+// found a key expired.
+func (m *MemoryEngine) Stats() (EngineStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return EngineStats{}, errEngineClosed
+	}
+	s := EngineStats{KeysExpired: m.keysExpired.Load()}
+	for key, entry := range m.data {
+		s.MemtableBytes += uint64(memEntrySize(key, entry))
+	}
+	return s, nil
+}
+
+func (m *MemoryEngine) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	m.data = nil
+	return nil
+}
@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+)
+
+// MergeOperator combines the operands written by SSTableEngine.Merge with
+// the value of their key. Operands are stored as they are written and only
+// folded when the key is read or compacted, so a merge never reads the key
+// first.
+//
+// Compaction may fold some operands of a key without knowing its value and
+// store the result as a single operand. Merge must therefore be
+// associative, with a missing value acting as its identity: folding
+// operands a and b onto nil, then the result onto v, must equal folding a
+// and b onto v.
+type MergeOperator interface {
+	// Name identifies the operator, as in merge_operator in config.yml
+	Name() string
+
+	// Merge folds operands, oldest first, onto existing, which is nil if
+	// the key has no value
+	Merge(existing []byte, operands [][]byte) []byte
+
+	// ValidateOperand rejects an operand Merge cannot fold before it is
+	// written
+	ValidateOperand(operand []byte) error
+}
+
+// The built-in merge operators. The C++ backend implements them too, in
+// sstable/merge.cpp; other operators need the Go backend.
+var (
+	// Int64AddOperator adds signed 64-bit integers stored as 8 bytes,
+	// big-endian. A value that is not 8 bytes long counts as 0.
+	Int64AddOperator MergeOperator = int64AddOperator{}
+
+	// StringAppendOperator appends each operand to the value.
+	StringAppendOperator MergeOperator = stringAppendOperator{}
+
+	// MaxOperator keeps the largest of the value and the operands, compared
+	// as byte strings.
+	MaxOperator MergeOperator = maxOperator{}
+)
+
+// ParseMergeOperator returns the built-in operator with the given name, as
+// set by merge_operator in config.yml. An empty name selects no operator.
+func ParseMergeOperator(name string) (MergeOperator, error) {
+	for _, op := range []MergeOperator{Int64AddOperator, StringAppendOperator, MaxOperator} {
+		if op.Name() == name {
+			return op, nil
+		}
+	}
+	if name == "" {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown merge operator %q", name)
+}
+
+// Merge operators of the C API, matching the SSTABLE_MERGE_* constants
+const (
+	mergeOperatorNone = iota
+	mergeOperatorInt64Add
+	mergeOperatorStringAppend
+	mergeOperatorMax
+)
+
+// builtinMergeOperator returns the C API constant of op, or false if op is
+// not one of the built-in operators.
+func builtinMergeOperator(op MergeOperator) (int, bool) {
+	switch op.(type) {
+	case nil:
+		return mergeOperatorNone, true
+	case int64AddOperator:
+		return mergeOperatorInt64Add, true
+	case stringAppendOperator:
+		return mergeOperatorStringAppend, true
+	case maxOperator:
+		return mergeOperatorMax, true
+	}
+	return 0, false
+}
+
+type int64AddOperator struct{}
+
+func (int64AddOperator) Name() string { return "int64add" }
+
+func (int64AddOperator) Merge(existing []byte, operands [][]byte) []byte {
+	var sum uint64
+	if len(existing) == 8 {
+		sum = binary.BigEndian.Uint64(existing)
+	}
+	for _, operand := range operands {
+		if len(operand) == 8 {
+			sum += binary.BigEndian.Uint64(operand)
+		}
+	}
+	return binary.BigEndian.AppendUint64(nil, sum)
+}
+
+func (int64AddOperator) ValidateOperand(operand []byte) error {
+	if len(operand) != 8 {
+		return fmt.Errorf("int64add operand must be 8 bytes, got %d", len(operand))
+	}
+	return nil
+}
+
+type stringAppendOperator struct{}
+
+func (stringAppendOperator) Name() string { return "stringappend" }
+
+func (stringAppendOperator) Merge(existing []byte, operands [][]byte) []byte {
+	return slices.Concat(append([][]byte{existing}, operands...)...)
+}
+
+func (stringAppendOperator) ValidateOperand([]byte) error { return nil }
+
+type maxOperator struct{}
+
+func (maxOperator) Name() string { return "max" }
+
+func (maxOperator) Merge(existing []byte, operands [][]byte) []byte {
+	largest := existing
+	for _, operand := range operands {
+		if bytes.Compare(operand, largest) > 0 {
+			largest = operand
+		}
+	}
+	return bytes.Clone(largest)
+}
+
+func (maxOperator) ValidateOperand([]byte) error { return nil }
+
+// pointRead follows the versions of one key that a read at seq sees, newest
+// first, over sources from newest to oldest. It stops at the first version
+// that is not a merge operand, collecting the operands on the way, and
+// folds them into the key's value. It follows PointRead in
+// sstable/merge.cpp.
+type pointRead struct {
+	key      string
+	seq      uint64 // the next version wanted is at or below seq
+	covering uint64 // versions older than this are range deleted
+	now      int64
+	operator MergeOperator // nil reads an operand as a plain value
+
+	done     bool
+	expired  bool   // the read ended at a value whose TTL had passed
+	base     []byte // the value the operands apply to, if hasBase
+	hasBase  bool
+	operands [][]byte // newest first
+}
+
+// versionSource is a memtable, sealed memtable or table a pointRead looks
+// versions up in.
+type versionSource interface {
+	get(key string, seq uint64) (memEntry, uint64, bool)
+}
+
+// search takes the versions of the key source holds until the read is done
+func (r *pointRead) search(source versionSource) {
+	for !r.done {
+		entry, seq, found := source.get(r.key, r.seq)
+		if !found {
+			return
+		}
+		r.add(entry, seq)
+	}
+}
+
+// add takes the next older version of the key. Values are copied, since
+// those of tables alias their blocks.
+func (r *pointRead) add(entry memEntry, seq uint64) {
+	switch {
+	case seq < r.covering:
+		r.done = true
+	case !entry.live(r.now):
+		r.expired = !entry.deleted
+		r.done = true
+	case !entry.merge || r.operator == nil:
+		r.base = bytes.Clone(entry.value)
+		r.hasBase = true
+		r.done = true
+	default:
+		r.operands = append(r.operands, bytes.Clone(entry.value))
+		if seq == 0 {
+			r.done = true
+		} else {
+			r.seq = seq - 1
+		}
+	}
+}
+
+// result returns the value of the key, or false if it has none
+func (r *pointRead) result() ([]byte, bool) {
+	if len(r.operands) == 0 {
+		if r.hasBase && r.base == nil {
+			return []byte{}, true
+		}
+		return r.base, r.hasBase
+	}
+	operands := slices.Clone(r.operands)
+	slices.Reverse(operands)
+	return r.operator.Merge(r.base, operands), true
+}
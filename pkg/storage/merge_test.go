@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+)
+
+func int64Bytes(n int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(n))
+}
+
+func TestMergeOperators(t *testing.T) {
+	tests := []struct {
+		operator MergeOperator
+		existing []byte
+		operands [][]byte
+		want     []byte
+	}{
+		{Int64AddOperator, nil, [][]byte{int64Bytes(5), int64Bytes(-7)}, int64Bytes(-2)},
+		{Int64AddOperator, int64Bytes(40), [][]byte{int64Bytes(2)}, int64Bytes(42)},
+		{Int64AddOperator, []byte("not a number"), [][]byte{int64Bytes(3)}, int64Bytes(3)},
+		{StringAppendOperator, nil, [][]byte{[]byte("a,"), []byte("b,")}, []byte("a,b,")},
+		{StringAppendOperator, []byte("x,"), [][]byte{[]byte("y,")}, []byte("x,y,")},
+		{MaxOperator, nil, [][]byte{[]byte("b"), []byte("c"), []byte("a")}, []byte("c")},
+		{MaxOperator, []byte("z"), [][]byte{[]byte("y")}, []byte("z")},
+	}
+	for _, tt := range tests {
+		if got := tt.operator.Merge(tt.existing, tt.operands); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s.Merge(%q, %q) = %q, want %q", tt.operator.Name(), tt.existing, tt.operands, got, tt.want)
+		}
+
+		// Compaction folds operands without their value first
+		partial := tt.operator.Merge(nil, tt.operands)
+		if got := tt.operator.Merge(tt.existing, [][]byte{partial}); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: folding %q in two steps gave %q, want %q", tt.operator.Name(), tt.operands, got, tt.want)
+		}
+	}
+
+	if err := Int64AddOperator.ValidateOperand([]byte("7")); err == nil {
+		t.Error("Expected int64add to reject a 1-byte operand")
+	}
+	for _, name := range []string{"int64add", "stringappend", "max"} {
+		if op, err := ParseMergeOperator(name); err != nil || op.Name() != name {
+			t.Errorf("ParseMergeOperator(%q) = %v, %v", name, op, err)
+		}
+	}
+	if _, err := ParseMergeOperator("sum"); err == nil {
+		t.Error("Expected an unknown operator to be rejected")
+	}
+}
+
+func TestSSTableEngine_Merge(t *testing.T) {
+	snapshotBackends(t, func(t *testing.T, opts Options) {
+		dir := t.TempDir()
+		walPath := filepath.Join(dir, "wal.txt")
+		opts.MergeOperator = Int64AddOperator
+		open := func() *SSTableEngine {
+			t.Helper()
+			engine, err := NewSSTableEngineWithOptions(dir, walPath, opts)
+			if err != nil {
+				t.Fatalf("Failed to open engine: %v", err)
+			}
+			return engine
+		}
+		add := func(engine *SSTableEngine, key string, n int64) {
+			t.Helper()
+			if err := engine.Merge([]byte(key), int64Bytes(n)); err != nil {
+				t.Fatalf("Merge failed: %v", err)
+			}
+		}
+		check := func(stage string, engine *SSTableEngine, want map[string]int64) {
+			t.Helper()
+			var entries []string
+			for key, n := range want {
+				value, found, err := engine.Get([]byte(key))
+				if err != nil || !found || !bytes.Equal(value, int64Bytes(n)) {
+					t.Errorf("%s: %s = %v (found=%v, err=%v), want %d", stage, key, value, found, err, n)
+				}
+			}
+			it, err := engine.NewIterator()
+			if err != nil {
+				t.Fatalf("NewIterator failed: %v", err)
+			}
+			defer it.Close()
+			for it.SeekToFirst(); it.Valid(); it.Next() {
+				entries = append(entries, string(it.Key()))
+				if n, ok := want[string(it.Key())]; !ok || !bytes.Equal(it.Value(), int64Bytes(n)) {
+					t.Errorf("%s: iterator has %s = %v", stage, it.Key(), it.Value())
+				}
+			}
+			if len(entries) != len(want) {
+				t.Errorf("%s: iterator has %v, want %d keys", stage, entries, len(want))
+			}
+		}
+
+		engine := open()
+		if err := engine.Merge([]byte("hits"), []byte("1")); err == nil {
+			t.Error("Expected a malformed operand to be rejected")
+		}
+		add(engine, "hits", 1)
+		add(engine, "hits", 2)
+		engine.Put([]byte("base"), int64Bytes(100))
+		if err := engine.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+
+		// Operands in the memtable apply to values and operands in tables
+		add(engine, "hits", 3)
+		add(engine, "base", -1)
+		engine.Delete([]byte("gone"))
+		add(engine, "gone", 7)
+		snap, err := engine.Snapshot()
+		if err != nil {
+			t.Fatalf("Snapshot failed: %v", err)
+		}
+		add(engine, "hits", 10)
+		want := map[string]int64{"hits": 16, "base": 99, "gone": 7}
+		check("memtable", engine, want)
+		if value, _, _ := snap.Get([]byte("hits")); !bytes.Equal(value, int64Bytes(6)) {
+			t.Errorf("Snapshot reads hits = %v, want 6", value)
+		}
+		snap.Release()
+		engine.DestroySSTableEngine()
+
+		engine = open()
+		check("replayed", engine, want)
+		if err := engine.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		check("flushed", engine, want)
+		if err := engine.Compact(); err != nil {
+			t.Fatalf("Compact failed: %v", err)
+		}
+		check("compacted", engine, want)
+
+		// hits had 4 operands, base a value and an operand, and gone a
+		// tombstone and an operand; each folded into one value
+		stats, _ := engine.Stats()
+		if stats.CompactionEntriesDropped != 5 {
+			t.Errorf("Expected 5 versions folded away, got %d", stats.CompactionEntriesDropped)
+		}
+		add(engine, "hits", 1)
+		want["hits"] = 17
+		check("after compaction", engine, want)
+		engine.DestroySSTableEngine()
+
+		// The folded values are plain values either backend reads
+		other := opts
+		other.Backend = BackendGo
+		other.MergeOperator = nil
+		reopened, err := NewSSTableEngineWithOptions(dir, walPath, other)
+		if err != nil {
+			t.Fatalf("Failed to reopen: %v", err)
+		}
+		defer reopened.DestroySSTableEngine()
+		if value, _, _ := reopened.Get([]byte("base")); !bytes.Equal(value, int64Bytes(99)) {
+			t.Errorf("Compacted base reads %v, want 99", value)
+		}
+		if err := reopened.Merge([]byte("hits"), int64Bytes(1)); err == nil {
+			t.Error("Expected Merge without a merge operator to fail")
+		}
+	})
+}
+
+func TestSSTableEngine_MergeOperatorPerBackend(t *testing.T) {
+	snapshotBackends(t, func(t *testing.T, opts Options) {
+		for _, operator := range []MergeOperator{StringAppendOperator, MaxOperator} {
+			opts.MergeOperator = operator
+			engine, err := NewSSTableEngineWithOptions(t.TempDir(), filepath.Join(t.TempDir(), "wal.txt"), opts)
+			if err != nil {
+				t.Fatalf("Failed to open engine: %v", err)
+			}
+			engine.Put([]byte("k"), []byte("b"))
+			engine.Flush()
+			engine.Merge([]byte("k"), []byte("c"))
+			engine.Merge([]byte("k"), []byte("a"))
+			want := map[MergeOperator]string{StringAppendOperator: "bca", MaxOperator: "c"}[operator]
+			expectValues(t, engine, map[string]string{"k": want})
+			engine.Compact()
+			expectValues(t, engine, map[string]string{"k": want})
+			engine.DestroySSTableEngine()
+		}
+	})
+
+	// Operators other than the built-in ones only run in Go
+	opts := DefaultOptions()
+	opts.Backend = BackendCPP
+	opts.MergeOperator = customOperator{}
+	if err := opts.withDefaults().validate(); err == nil {
+		t.Error("Expected a custom merge operator to need the go backend")
+	}
+}
+
+type customOperator struct{ stringAppendOperator }
+
+func (customOperator) Name() string { return "custom" }
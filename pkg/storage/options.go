@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Backend selects the implementation of the LSM tree behind an
+// SSTableEngine. Both write the same SSTable, MANIFEST and WAL files, so a
+// data directory can be reopened with either.
+type Backend string
+
+const (
+	// BackendCPP is the C++ library in sstable/, linked through cgo.
+	BackendCPP Backend = "cpp"
+
+	// BackendGo is written in Go and needs no cgo, so it is the only
+	// backend in CGO_ENABLED=0 builds.
+	BackendGo Backend = "go"
+)
+
+// DefaultBackend is the C++ backend when it is linked in, and the Go one
+// otherwise.
+func DefaultBackend() Backend {
+	if cgoEnabled {
+		return BackendCPP
+	}
+	return BackendGo
+}
+
+// CompactionStrategy selects how an SSTableEngine merges its tables in the
+// background.
+type CompactionStrategy string
+
+const (
+	// CompactionSizeTiered merges runs of similarly sized tables. It writes
+	// each byte fewer times but keeps more tables around for reads.
+	CompactionSizeTiered CompactionStrategy = "size-tiered"
+
+	// CompactionLeveled keeps tables in non-overlapping levels of growing
+	// size, so a read touches at most one table per level.
+	CompactionLeveled CompactionStrategy = "leveled"
+)
+
+// Compression selects the codec used for newly written SSTable blocks.
+// Every block records its codec, so the setting can change between runs.
+type Compression string
+
+const (
+	// CompressionNone stores blocks as is.
+	CompressionNone Compression = "none"
+
+	// CompressionZlib compresses blocks with zlib. Blocks that do not
+	// shrink by at least an eighth are stored uncompressed.
+	CompressionZlib Compression = "zlib"
+)
+
+// Options tunes an SSTableEngine. Start from DefaultOptions and override
+// individual fields; zero values are replaced by the defaults.
+type Options struct {
+	Backend Backend
+
+	CompactionStrategy CompactionStrategy
+
+	// Leveled compaction only: number of level-0 tables that triggers a
+	// compaction into level 1.
+	Level0FileTrigger int
+
+	// Leveled compaction only: size budget of level 1. Each deeper level
+	// gets LevelSizeRatio times the budget of the level above it.
+	Level1MaxBytes int64
+	LevelSizeRatio int
+
+	// Leveled compaction only: outputs are split into tables of about this
+	// size.
+	TargetFileSize int64
+
+	// BloomBitsPerKey sizes the Bloom filter written into every table. Ten
+	// bits per key give about a 1% false positive rate; a negative value
+	// disables filters.
+	BloomBitsPerKey int
+
+	// BlockSize is the target size of the data blocks each table is cut
+	// into. The table index holds one entry per block.
+	BlockSize int
+
+	// Compression is the codec applied to each data block.
+	Compression Compression
+
+	// MaxOpenTables bounds the LRU cache of open SSTables. Each cached table
+	// keeps a file descriptor and its block index and Bloom filter in
+	// memory.
+	MaxOpenTables int
+
+	// BlockCacheBytes is the memory budget for cached data blocks, shared
+	// by every table of the engine. A negative value disables the cache.
+	BlockCacheBytes int64
+
+	// MaxImmutableMemtables is how many full memtables may wait for the
+	// background flush before writes stall until one is written out.
+	MaxImmutableMemtables int
+
+	// ColumnFamilies holds the GC policy of each column family of the cells
+	// written with MutateRow. Families without one keep every version.
+	ColumnFamilies map[string]GCPolicy
+
+	// MergeOperator folds the operands written with SSTableEngine.Merge.
+	// The C++ backend only runs the built-in operators. Data holding
+	// operands should always be opened with the operator that wrote them:
+	// without one, an operand reads as a plain value.
+	MergeOperator MergeOperator
+}
+
+// DefaultOptions returns the options NewSSTableEngine uses.
+func DefaultOptions() Options {
+	return Options{
+		Backend:            DefaultBackend(),
+		CompactionStrategy: CompactionSizeTiered,
+		Level0FileTrigger:  4,
+		Level1MaxBytes:     10 << 20,
+		LevelSizeRatio:     10,
+		TargetFileSize:     2 << 20,
+		BloomBitsPerKey:    10,
+		BlockSize:          4096,
+		Compression:        CompressionNone,
+		MaxOpenTables:      1000,
+		BlockCacheBytes:    8 << 20,
+
+		MaxImmutableMemtables: 2,
+	}
+}
+
+// ParseBackend validates a backend name as used in config.yml. An empty
+// name selects DefaultBackend.
+func ParseBackend(name string) (Backend, error) {
+	switch Backend(name) {
+	case "":
+		return DefaultBackend(), nil
+	case BackendCPP:
+		if !cgoEnabled {
+			return "", errors.New(`the "cpp" backend needs a cgo build`)
+		}
+		return BackendCPP, nil
+	case BackendGo:
+		return BackendGo, nil
+	}
+	return "", fmt.Errorf("unknown storage backend %q", name)
+}
+
+// ParseCompactionStrategy validates a strategy name as used in config.yml.
+// An empty name selects the default size-tiered strategy.
+func ParseCompactionStrategy(name string) (CompactionStrategy, error) {
+	switch CompactionStrategy(name) {
+	case "":
+		return CompactionSizeTiered, nil
+	case CompactionSizeTiered, CompactionLeveled:
+		return CompactionStrategy(name), nil
+	}
+	return "", fmt.Errorf("unknown compaction strategy %q", name)
+}
+
+// ParseCompression validates a block compression codec name as used in
+// config.yml. An empty name selects no compression.
+func ParseCompression(name string) (Compression, error) {
+	switch Compression(name) {
+	case "":
+		return CompressionNone, nil
+	case CompressionNone, CompressionZlib:
+		return Compression(name), nil
+	}
+	return "", fmt.Errorf("unknown block compression %q", name)
+}
+
+// withDefaults fills zero fields from DefaultOptions.
+func (o Options) withDefaults() Options {
+	d := DefaultOptions()
+	if o.Backend == "" {
+		o.Backend = d.Backend
+	}
+	if o.CompactionStrategy == "" {
+		o.CompactionStrategy = d.CompactionStrategy
+	}
+	if o.Level0FileTrigger == 0 {
+		o.Level0FileTrigger = d.Level0FileTrigger
+	}
+	if o.Level1MaxBytes == 0 {
+		o.Level1MaxBytes = d.Level1MaxBytes
+	}
+	if o.LevelSizeRatio == 0 {
+		o.LevelSizeRatio = d.LevelSizeRatio
+	}
+	if o.TargetFileSize == 0 {
+		o.TargetFileSize = d.TargetFileSize
+	}
+	if o.BloomBitsPerKey == 0 {
+		o.BloomBitsPerKey = d.BloomBitsPerKey
+	}
+	if o.BlockSize == 0 {
+		o.BlockSize = d.BlockSize
+	}
+	if o.Compression == "" {
+		o.Compression = d.Compression
+	}
+	if o.MaxOpenTables == 0 {
+		o.MaxOpenTables = d.MaxOpenTables
+	}
+	if o.BlockCacheBytes == 0 {
+		o.BlockCacheBytes = d.BlockCacheBytes
+	}
+	if o.MaxImmutableMemtables == 0 {
+		o.MaxImmutableMemtables = d.MaxImmutableMemtables
+	}
+	return o
+}
+
+// validate rejects options neither backend can work with. Zero fields must
+// already have been filled in by withDefaults.
+func (o Options) validate() error {
+	switch o.Backend {
+	case BackendCPP, BackendGo:
+	default:
+		return fmt.Errorf("unknown storage backend %q", o.Backend)
+	}
+	switch o.CompactionStrategy {
+	case CompactionSizeTiered, CompactionLeveled:
+	default:
+		return fmt.Errorf("unknown compaction strategy %q", o.CompactionStrategy)
+	}
+	if o.Level0FileTrigger < 0 || o.Level1MaxBytes < 0 || o.LevelSizeRatio < 2 || o.TargetFileSize < 0 {
+		return errors.New("invalid leveled compaction options")
+	}
+	if o.BlockSize < 0 {
+		return errors.New("invalid block size")
+	}
+	if o.MaxOpenTables < 0 {
+		return errors.New("invalid table cache size")
+	}
+	if o.MaxImmutableMemtables < 0 {
+		return errors.New("invalid immutable memtable limit")
+	}
+	switch o.Compression {
+	case CompressionNone, CompressionZlib:
+	default:
+		return fmt.Errorf("unknown block compression %q", o.Compression)
+	}
+	if _, ok := builtinMergeOperator(o.MergeOperator); !ok && o.Backend == BackendCPP {
+		return fmt.Errorf("merge operator %q needs the go backend", o.MergeOperator.Name())
+	}
+	for family, policy := range o.ColumnFamilies {
+		if !validFamily(family) {
+			return fmt.Errorf("invalid column family %q", family)
+		}
+		if err := policy.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bloomBitsPerKey is BloomBitsPerKey with disabled filters as 0.
+func (o Options) bloomBitsPerKey() int {
+	if o.BloomBitsPerKey < 0 {
+		return 0
+	}
+	return o.BloomBitsPerKey
+}
+
+// blockCacheBytes is BlockCacheBytes with a disabled cache as 0.
+func (o Options) blockCacheBytes() int64 {
+	if o.BlockCacheBytes < 0 {
+		return 0
+	}
+	return o.BlockCacheBytes
+}
@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisScanCount is the number of keys asked for per SCAN and MGET round
+// trip while an iterator copies the keyspace out.
+const redisScanCount = 512
+
+// RedisEngine is an Engine backed by a Redis server, which stores every key
+// as a string value.
+type RedisEngine struct {
+	client *redis.Client
+}
+
+// NewRedisEngine adapts client to the Engine interface. Closing the engine
+// closes the client.
+func NewRedisEngine(client *redis.Client) *RedisEngine {
+	return &RedisEngine{client: client}
+}
+
+func (r *RedisEngine) Get(key []byte) ([]byte, bool, error) {
+	value, err := r.client.Get(context.Background(), string(key)).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (r *RedisEngine) Put(key, value []byte) error {
+	return r.PutWithTTL(key, value, 0)
+}
+
+// PutWithTTL hands the TTL to Redis, which expires the key itself.
+func (r *RedisEngine) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	if ttl < 0 {
+		return fmt.Errorf("invalid TTL %v", ttl)
+	}
+	return r.client.Set(context.Background(), string(key), string(value), ttl).Err()
+}
+
+func (r *RedisEngine) Delete(key []byte) error {
+	return r.client.Del(context.Background(), string(key)).Err()
+}
+
+// DeleteRange has no Redis counterpart: it scans the keyspace and deletes
+// the keys in the range redisScanCount at a time. It is not atomic, and
+// keys written into the range while it runs may survive it.
+func (r *RedisEngine) DeleteRange(start, end []byte) error {
+	if err := validateRange(start, end); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	var cursor uint64
+	for {
+		batch, next, err := r.client.Scan(ctx, cursor, "*", redisScanCount).Result()
+		if err != nil {
+			return err
+		}
+		var keys []string
+		for _, key := range batch {
+			if key >= string(start) && key < string(end) {
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) > 0 {
+			if err := r.client.Del(ctx, keys...).Err(); err != nil {
+				return err
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// Write sends the batch as one MULTI/EXEC transaction, so Redis applies it
+// atomically.
+func (r *RedisEngine) Write(batch *Batch) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+	if err := batch.validate(); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, op := range batch.ops {
+			if op.delete {
+				pipe.Del(ctx, string(op.key))
+			} else {
+				pipe.Set(ctx, string(op.key), string(op.value), op.ttl)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// NewIterator copies every key and value out of Redis, which keeps no
+// order of its own. The copy is not a point-in-time snapshot: writes made
+// while it is taken may or may not be included.
+func (r *RedisEngine) NewIterator() (Iterator, error) {
+	ctx := context.Background()
+
+	seen := make(map[string]bool)
+	var keys []string
+	var cursor uint64
+	for {
+		batch, next, err := r.client.Scan(ctx, cursor, "*", redisScanCount).Result()
+		if err != nil {
+			return nil, err
+		}
+		// SCAN may return a key more than once
+		for _, key := range batch {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	sort.Strings(keys)
+
+	run := make(sortedRun, 0, len(keys))
+	for start := 0; start < len(keys); start += redisScanCount {
+		chunk := keys[start:min(start+redisScanCount, len(keys))]
+		values, err := r.client.MGet(ctx, chunk...).Result()
+		if err != nil {
+			return nil, err
+		}
+		for i, value := range values {
+			// Keys deleted since the SCAN come back as nil
+			if s, ok := value.(string); ok {
+				run = append(run, memRecord{key: chunk[i], entry: memEntry{value: []byte(s)}})
+			}
+		}
+	}
+	return newSnapshotIterator(run), nil
+}
+
+// Stats reports the expired_keys counter of INFO stats as KeysExpired.
+// Redis has no tables or compactions, so every other counter is zero.
+func (r *RedisEngine) Stats() (EngineStats, error) {
+	info, err := r.client.Info(context.Background(), "stats").Result()
+	if err != nil {
+		return EngineStats{}, err
+	}
+	var stats EngineStats
+	for _, line := range strings.Split(info, "\n") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(line), "expired_keys:")
+		if !ok {
+			continue
+		}
+		if stats.KeysExpired, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return EngineStats{}, fmt.Errorf("invalid expired_keys in INFO: %q", value)
+		}
+	}
+	return stats, nil
+}
+
+func (r *RedisEngine) Close() error {
+	if err := r.client.Close(); err != nil && !errors.Is(err, redis.ErrClosed) {
+		return err
+	}
+	return nil
+}
@@ -1,61 +1,266 @@
 package storage
 
-/*
-#cgo CXXFLAGS: -std=c++11 -I${SRCDIR}/../../sstable
-#cgo LDFLAGS: -L${SRCDIR}/../../sstable -lsstable -lstdc++
-#include "../../sstable/sstable.h"
-#include <stdlib.h>
-*/
-import "C"
-
 import (
 	"errors"
 	"fmt"
+	"maps"
+	"math"
 	"os"
-	"unsafe"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/alexciechonski/BigTableLite/pkg/wal"
 )
 
+// SSTableEngine is safe for concurrent use by multiple goroutines.
+// Operations on the LSM tree are synchronised by its backend; the engine
+// serialises writes to the WAL and keeps the tree open until every running
+// operation has finished.
 type SSTableEngine struct {
+	// closeMu is held shared by every operation and exclusively by
+	// DestroySSTableEngine, which therefore waits for running operations
+	closeMu     sync.RWMutex
 	initialized bool
+	core        engineCore
 	wal         *wal.WriteAheadLog
+	walPath     string
+
+	// mu guards the WAL, the sealed WAL segments, the sequence number and
+	// the background flush error. Writes hold it while they log and apply
+	// an operation.
+	mu   sync.Mutex
+	room *sync.Cond // signalled when an immutable memtable is flushed
+	seq  uint64     // sequence number of the last logged write
+
+	// WAL segments holding the writes of each immutable memtable, oldest
+	// first, and segments recovered on open whose writes are in the memtable
+	sealed      [][]string
+	recovered   []string
+	nextSegment uint64
+
+	maxImmutable  int
+	families      map[string]GCPolicy
+	mergeOperator MergeOperator
+	writeStalls  uint64
+	bgErr        error
+
+	// flushMu serialises flushing a memtable with retiring its segments
+	flushMu   sync.Mutex
+	flushCh   chan struct{}
+	flushDone chan struct{}
+
+	// Step at which Flush stops as if the process crashed (tests only)
+	flushCrashPoint int
+	// Error opening a new WAL fails with, if not nil (tests only)
+	walOpenFault error
+}
+
+// engineCore is the LSM tree behind an SSTableEngine: memtables, SSTables,
+// compaction and iteration. The engine adds the WAL and the background
+// flush on top. Both backends read and write the same files, so a data
+// directory written by one can be opened by the other.
+type engineCore interface {
+	// put and delete add a version written at seq, which reads only see
+	// once setVisibleSeq publishes it. A put's value expires at expiresAt,
+	// in microseconds since the Unix epoch, unless it is 0.
+	put(seq uint64, key, value []byte, expiresAt int64) error
+	delete(seq uint64, key []byte) error
+	// merge adds a merge operand at seq, which reads fold into the older
+	// versions of key
+	merge(seq uint64, key, operand []byte) error
+	// deleteRange adds a range tombstone at seq, deleting every version of
+	// the keys in [start, end) written before it
+	deleteRange(seq uint64, start, end []byte) error
+	// get reads key as of seq, or as of the newest published write if seq
+	// is newer
+	get(key []byte, seq uint64) ([]byte, bool, error)
+
+	// setVisibleSeq publishes every write up to seq to readers
+	setVisibleSeq(seq uint64)
+	// lastSequence is the highest sequence number written
+	lastSequence() uint64
+	// acquireSnapshot pins the newest published sequence number, keeping
+	// the versions it sees until releaseSnapshot
+	acquireSnapshot() uint64
+	releaseSnapshot(seq uint64)
+
+	// needsFlush reports whether the memtable is full
+	needsFlush() bool
+	memtableEmpty() bool
+	// makeImmutable seals the memtable; false if it was empty
+	makeImmutable() bool
+	numImmutable() int
+	// flushImmutable writes the oldest immutable memtable to an SSTable.
+	// Nothing to flush is not an error.
+	flushImmutable() error
+
+	compact() error
+	// checkpoint hard-links every live table into dir and copies the
+	// MANIFEST describing them. The memtables are left to the WAL.
+	checkpoint(dir string) error
+	stats() (EngineStats, error)
+	// newIterator reads as of seq and only sees the keys in [lower,
+	// upper); a nil upper leaves it unbounded above
+	newIterator(seq uint64, lower, upper []byte) (coreIterator, error)
+	setFlushCrashPoint(point int)
+	close()
+}
+
+// coreIterator is an engineCore's ordered iterator over its live keys. key
+// and value return copies.
+type coreIterator interface {
+	valid() bool
+	seekToFirst()
+	seekToLast()
+	seek(key []byte)
+	next()
+	prev()
+	key() []byte
+	value() []byte
+	close()
+}
+
+// openCore starts the backend selected by opts on dataDir.
+func openCore(dataDir string, opts Options) (engineCore, error) {
+	if opts.Backend == BackendGo {
+		return newGoEngine(dataDir, opts)
+	}
+	return newCEngine(dataDir, opts)
+}
+
+// Steps of a memtable flush, in order, at which tests can simulate a crash.
+// All but the last are inside the backend's flushImmutable; the values match
+// the SSTABLE_FLUSH_CRASH_* constants of the C API.
+const (
+	flushCrashNone = iota
+	flushCrashAfterWrite
+	flushCrashAfterSync
+	flushCrashAfterRename
+	flushCrashAfterDirSync
+	flushCrashBeforeWALRetire
+)
+
+var errSimulatedCrash = errors.New("simulated crash")
+
+// setFlushCrashPoint makes every later memtable flush stop at point.
+func (e *SSTableEngine) setFlushCrashPoint(point int) {
+	e.flushCrashPoint = point
+	cPoint := point
+	if point == flushCrashBeforeWALRetire {
+		cPoint = flushCrashNone
+	}
+	e.core.setFlushCrashPoint(cPoint)
+}
+
+// syncDir fsyncs a directory so entries added to or removed from it survive
+// a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
 }
 
 func NewSSTableEngine(dataDir, WALPath string) (*SSTableEngine, error) {
-	// INIT SSTable (clears memtable)
-    cDir := C.CString(dataDir)
-    defer C.free(unsafe.Pointer(cDir))
-    if !C.sstable_init(cDir) {
-        return nil, errors.New("failed to initialize sstable")
+	return NewSSTableEngineWithOptions(dataDir, WALPath, DefaultOptions())
+}
+
+// NewSSTableEngineWithOptions opens an engine like NewSSTableEngine, tuned by
+// opts. Zero fields in opts take their default values.
+func NewSSTableEngineWithOptions(dataDir, WALPath string, opts Options) (*SSTableEngine, error) {
+	opts = opts.withDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	opts.ColumnFamilies = maps.Clone(opts.ColumnFamilies)
+
+	// INIT SSTable (each engine gets its own memtable)
+    core, err := openCore(dataDir, opts)
+    if err != nil {
+        return nil, err
+    }
+
+    // Segments sealed before a restart still hold writes that never
+    // reached an SSTable; they are replayed before the active WAL
+    segments, nextSegment, err := listWALSegments(WALPath)
+    if err != nil {
+        core.close()
+        return nil, err
     }
 
     // Open WAL
     w, err := wal.NewWal(WALPath)
     if err != nil {
+        core.close()
+        return nil, err
+    }
+
+    engine := &SSTableEngine{
+        core:          core,
+        wal:           w,
+        walPath:       WALPath,
+        recovered:     segments,
+        nextSegment:   nextSegment,
+        maxImmutable:  opts.MaxImmutableMemtables,
+        families:      opts.ColumnFamilies,
+        mergeOperator: opts.MergeOperator,
+    }
+    engine.room = sync.NewCond(&engine.mu)
+
+    // Replay WAL, continuing the sequence numbers from the newest write
+    // either the tables or the WAL hold
+    engine.seq = core.lastSequence()
+    for _, segment := range segments {
+        if err := replayWAL(core, segment, &engine.seq); err != nil {
+            engine.DestroySSTableEngine()
+            return nil, err
+        }
+    }
+    if err := replayWAL(core, WALPath, &engine.seq); err != nil {
+        engine.DestroySSTableEngine()
         return nil, err
     }
+    core.setVisibleSeq(engine.seq)
 
-    engine := &SSTableEngine{wal: w}
+    engine.flushCh = make(chan struct{}, 1)
+    engine.flushDone = make(chan struct{})
+    go engine.flushLoop()
+
+    engine.initialized = true
+    return engine, nil
+}
+
+// replayWAL applies every operation logged in the WAL at path to the
+// memtable at its logged sequence number, raising *seq to the highest one.
+// Operations logged before sequence numbers existed get the next free one.
+func replayWAL(core engineCore, path string, seq *uint64) error {
+    w, err := wal.NewWal(path)
+    if err != nil {
+        return err
+    }
+    defer w.Close()
 
-    // Replay WAL
     err = w.Replay(func(entry []byte) error {
-        op, key, value, err := wal.DeserializeOperation(entry)
+        op, opSeq, expiresAt, key, value, err := wal.DeserializeExpiringOperation(entry)
         if err != nil {
             return err
         }
+        if opSeq == 0 {
+            opSeq = *seq + 1
+        }
+        *seq = max(*seq, opSeq)
 
         if op == "set" {
-            cKey := C.CString(string(key))
-            cVal := C.CString(string(value))
-            C.sstable_put(cKey, cVal)
-            C.free(unsafe.Pointer(cKey))
-            C.free(unsafe.Pointer(cVal))
+            return core.put(opSeq, key, value, expiresAt)
         } else if op == "delete" {
-			cKey := C.CString(string(key))
-			C.sstable_delete(cKey)
-			C.free(unsafe.Pointer(cKey))
+			return core.delete(opSeq, key)
+		} else if op == "delete_range" {
+			return core.deleteRange(opSeq, key, value)
+		} else if op == "merge" {
+			return core.merge(opSeq, key, value)
 		}
         return nil
     })
@@ -63,19 +268,40 @@ func NewSSTableEngine(dataDir, WALPath string) (*SSTableEngine, error) {
     if err != nil {
         // checksum mismatch = safe to ignore
         if !strings.Contains(err.Error(), "checksum mismatch") {
-            return nil, fmt.Errorf("WAL replay failed: %w", err)
+            return fmt.Errorf("WAL replay failed: %w", err)
         }
     }
+    return nil
+}
 
-    engine.initialized = true
-    return engine, nil
+// acquire holds the engine open for one operation, which must call
+// e.closeMu.RUnlock when it is done.
+func (e *SSTableEngine) acquire() error {
+	e.closeMu.RLock()
+	if !e.initialized {
+		e.closeMu.RUnlock()
+		return errors.New("engine not initialized")
+	}
+	return nil
 }
 
 func (e *SSTableEngine) DestroySSTableEngine() {
 	if e == nil {
         return
     }
-    C.sstable_destroy()
+    e.closeMu.Lock()
+    defer e.closeMu.Unlock()
+    // Immutable memtables still waiting are recovered from their WAL
+    // segments on the next open
+    if e.flushCh != nil {
+        close(e.flushCh)
+        <-e.flushDone
+        e.flushCh = nil
+    }
+    if e.core != nil {
+        e.core.close()
+        e.core = nil
+    }
     if e.wal != nil {
         e.wal.Close()
         e.wal = nil
@@ -83,115 +309,243 @@ func (e *SSTableEngine) DestroySSTableEngine() {
     e.initialized = false
 }
 
-func (e *SSTableEngine) Put(key, value string) error {
-	if !e.initialized {
-		return errors.New("engine not initialized")
+// Close destroys the engine, as DestroySSTableEngine does.
+func (e *SSTableEngine) Close() error {
+	e.DestroySSTableEngine()
+	return nil
+}
+
+func (e *SSTableEngine) Put(key, value []byte) error {
+	return e.PutWithTTL(key, value, 0)
+}
+
+// PutWithTTL writes a value that expires ttl from now. The expiry time is
+// logged with the write, so it survives restarts; an expired key reads as
+// deleted until a compaction removes it.
+func (e *SSTableEngine) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	if ttl < 0 {
+		return fmt.Errorf("invalid TTL %v", ttl)
 	}
 
-	// Write to WAL FIRST
-	entry, err := wal.SerializeOperation("set", []byte(key), []byte(value))
-	if err != nil {
+	var expiry int64
+	return e.logAndApply(1, func(seq uint64) ([]byte, error) {
+		expiry = expiresAt(time.Now(), ttl)
+		return wal.SerializeExpiringSet(seq, expiry, key, value)
+	}, func(seq uint64) error {
+		return e.core.put(seq, key, value, expiry)
+	})
+}
+
+// Write logs every operation in the batch with a single WAL append and sync,
+// then applies them to the memtable in order, each under its own sequence
+// number. Readers see the batch only once all of it is applied, and after a
+// crash either all of it or, if the WAL append was torn, a prefix of it is
+// recovered.
+func (e *SSTableEngine) Write(batch *Batch) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+	if err := batch.validate(); err != nil {
+		return err
+	}
+
+	expiries := make([]int64, batch.Len())
+	return e.logAndApply(batch.Len(), func(first uint64) ([]byte, error) {
+		now := time.Now()
+		var entries []byte
+		for i, op := range batch.ops {
+			var entry []byte
+			var err error
+			if op.delete {
+				entry, err = wal.SerializeSequencedOperation("delete", first+uint64(i), op.key, nil)
+			} else {
+				expiries[i] = expiresAt(now, op.ttl)
+				entry, err = wal.SerializeExpiringSet(first+uint64(i), expiries[i], op.key, op.value)
+			}
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry...)
+		}
+		return entries, nil
+	}, func(first uint64) error {
+		for i, op := range batch.ops {
+			var err error
+			if op.delete {
+				err = e.core.delete(first+uint64(i), op.key)
+			} else {
+				err = e.core.put(first+uint64(i), op.key, op.value, expiries[i])
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// logAndApply is the write path shared by every write: under the engine
+// lock, it logs the WAL entries serialize returns for count operations
+// numbered from first, applies them to the memtable with apply and
+// publishes them together. A full memtable is handed to the background
+// flush.
+func (e *SSTableEngine) logAndApply(count int, serialize func(first uint64) ([]byte, error), apply func(first uint64) error) error {
+	if err := e.acquire(); err != nil {
 		return err
 	}
+	defer e.closeMu.RUnlock()
 
-	if err := e.wal.Append(entry); err != nil {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.waitForRoom(); err != nil {
+		return err
+	}
+
+	// Write to WAL first. A seal that could not start a new WAL left none.
+	if e.wal == nil {
+		if err := e.openWAL(); err != nil {
+			return fmt.Errorf("cannot open WAL: %w", err)
+		}
+	}
+	first := e.seq + 1
+	entries, err := serialize(first)
+	if err != nil {
+		return err
+	}
+	if err := e.wal.Append(entries); err != nil {
 		return fmt.Errorf("cannot append to WAL: %w", err)
 	}
+	e.seq = first + uint64(count) - 1
 
 	// Then apply to memtable
-	cKey := C.CString(key)
-	cVal := C.CString(value)
-	defer C.free(unsafe.Pointer(cKey))
-	defer C.free(unsafe.Pointer(cVal))
-
-	if !C.sstable_put(cKey, cVal) {
-		return errors.New("sstable_put failed")
+	if err := apply(first); err != nil {
+		return err
 	}
+	e.core.setVisibleSeq(e.seq)
 
-	// Check if flushing needed
-	if C.sstable_needs_flush() {
-		return e.Flush()
+	if e.core.needsFlush() {
+		return e.scheduleFlush()
 	}
 
 	return nil
 }
 
+// Flush writes the memtable and every immutable memtable to SSTables,
+// blocking until they are durable and their WAL segments are retired.
 func (e *SSTableEngine) Flush() error {
-	if !e.initialized {
-		return errors.New("engine not initialized")
-	}
-
-	if !C.sstable_flush() {
-		return errors.New("sstable_flush failed")
-	}
-
-	// WAL rotation
-	if err := e.wal.Close(); err != nil {
+	if err := e.acquire(); err != nil {
 		return err
 	}
+	defer e.closeMu.RUnlock()
 
-	if err := os.Remove(e.wal.Path()); err != nil {
-		return err
-	}
+	return e.flushAll()
+}
 
-	newWal, err := wal.NewWal(e.wal.Path())
+// flushAll is Flush for callers already holding the engine open
+func (e *SSTableEngine) flushAll() error {
+	e.mu.Lock()
+	err := e.sealMemtable()
+	e.mu.Unlock()
 	if err != nil {
 		return err
 	}
 
-	e.wal = newWal
-	return nil
+	for {
+		flushed, err := e.flushOldest()
+		if err != nil || !flushed {
+			return err
+		}
+	}
 }
 
-func (e *SSTableEngine) Get(key string) (string, bool, error) {
-	if !e.initialized {
-		return "", false, errors.New("engine not initialized")
+func (e *SSTableEngine) Get(key []byte) ([]byte, bool, error) {
+	if err := e.acquire(); err != nil {
+		return nil, false, err
 	}
+	defer e.closeMu.RUnlock()
 
-	cKey := C.CString(key)
-	defer C.free(unsafe.Pointer(cKey))
+	return e.core.get(key, math.MaxUint64)
+}
 
-	var bytes C.sstable_bytes
-	ok := C.sstable_get(cKey, &bytes)
-	defer C.sstable_free_bytes(&bytes)
+func (e *SSTableEngine) Delete(key []byte) error {
+	return e.logAndApply(1, func(seq uint64) ([]byte, error) {
+		return wal.SerializeSequencedOperation("delete", seq, key, nil)
+	}, func(seq uint64) error {
+		return e.core.delete(seq, key)
+	})
+}
 
-	if !ok || bytes.data == nil {
-		return "", false, nil
+// DeleteRange deletes every key in [start, end) with a single range
+// tombstone, however many keys the range holds. The tombstone is logged and
+// applied like any other write; compactions later remove the data it
+// covers, and the tombstone itself once nothing it covers is left.
+func (e *SSTableEngine) DeleteRange(start, end []byte) error {
+	if err := validateRange(start, end); err != nil {
+		return err
 	}
 
-	return C.GoStringN(bytes.data, C.int(bytes.len)), true, nil
+	return e.logAndApply(1, func(seq uint64) ([]byte, error) {
+		return wal.SerializeSequencedOperation("delete_range", seq, start, end)
+	}, func(seq uint64) error {
+		return e.core.deleteRange(seq, start, end)
+	})
 }
 
-func (e *SSTableEngine) Delete(key string) error {
-	if !e.initialized {
-		return errors.New("engine not initialized")
+// Merge applies operand to the value of key with Options.MergeOperator,
+// without reading the key. The operand is logged and stored as is; reads
+// fold it into the value, and compactions fold stored operands together.
+// It fails if the engine has no merge operator or the operator rejects the
+// operand.
+func (e *SSTableEngine) Merge(key, operand []byte) error {
+	if e.mergeOperator == nil {
+		return errors.New("no merge operator configured")
+	}
+	if err := e.mergeOperator.ValidateOperand(operand); err != nil {
+		return err
 	}
 
-	cKey := C.CString(key)
-	defer C.free(unsafe.Pointer(cKey))
+	return e.logAndApply(1, func(seq uint64) ([]byte, error) {
+		return wal.SerializeSequencedOperation("merge", seq, key, operand)
+	}, func(seq uint64) error {
+		return e.core.merge(seq, key, operand)
+	})
+}
 
-	// Write to WAL first
-	entry, err := wal.SerializeOperation("delete", []byte(key), nil)
-	if err != nil {
+// GCPolicy returns the garbage-collection policy of a column family, set
+// with Options.ColumnFamilies.
+func (e *SSTableEngine) GCPolicy(family string) (GCPolicy, bool) {
+	policy, ok := e.families[family]
+	return policy, ok
+}
+
+// Compact merges every SSTable into a single sorted run, dropping
+// overwritten values and tombstones that no open snapshot still reads. The
+// configured compaction strategy already runs in the background after
+// flushes; Compact forces a full compaction and blocks until it finishes.
+func (e *SSTableEngine) Compact() error {
+	if err := e.acquire(); err != nil {
 		return err
 	}
-	
-	if err := e.wal.Append(entry); err != nil {
-		return fmt.Errorf("cannot append to WAL: %w", err)
-	}
+	defer e.closeMu.RUnlock()
 
-	// apply to memtable
-	// cKey := C.CString(key)
-	// defer C.free(unsafe.Pointer(cKey))
+	return e.core.compact()
+}
 
-	if !C.sstable_delete(cKey) {
-		return errors.New("sstable_delete failed")
+// Stats returns the engine's current table and compaction counters.
+func (e *SSTableEngine) Stats() (EngineStats, error) {
+	if err := e.acquire(); err != nil {
+		return EngineStats{}, err
 	}
+	defer e.closeMu.RUnlock()
 
-	// Check if flushing needed
-	if C.sstable_needs_flush() {
-		return e.Flush()
-	}
+	e.mu.Lock()
+	writeStalls := e.writeStalls
+	e.mu.Unlock()
 
-	return nil
+	stats, err := e.core.stats()
+	if err != nil {
+		return EngineStats{}, err
+	}
+	stats.WriteStalls = writeStalls
+	return stats, nil
 }
@@ -14,19 +14,19 @@ func BenchmarkSSTablePut(b *testing.B) {
     b.ResetTimer()
 
     for i := 0; i < b.N; i++ {
-        _ = engine.Put("bench-key", "bench-value")
+        _ = engine.Put([]byte("bench-key"), []byte("bench-value"))
     }
 }
 
 func BenchmarkSSTableGetExisting(b *testing.B) {
     engine, _ := NewSSTableEngine("./benchdata", "./benchdata/wal.txt")
-    _ = engine.Put("bench-key", "bench-value")
+    _ = engine.Put([]byte("bench-key"), []byte("bench-value"))
     b.Cleanup(func() { os.RemoveAll("./benchdata") })
 
     b.ResetTimer()
 
     for i := 0; i < b.N; i++ {
-        engine.Get("bench-key")
+        engine.Get([]byte("bench-key"))
     }
 }
 
@@ -37,7 +37,7 @@ func BenchmarkSSTableGetMissing(b *testing.B) {
     b.ResetTimer()
 
     for i := 0; i < b.N; i++ {
-        engine.Get("key-does-not-exist")
+        engine.Get([]byte("key-does-not-exist"))
     }
 }
 
@@ -49,19 +49,19 @@ func BenchmarkSSTablePutSequential(b *testing.B) {
 
     for i := 0; i < b.N; i++ {
         key := fmt.Sprintf("key-%d", i)
-        _ = engine.Put(key, "value")
+        _ = engine.Put([]byte(key), []byte("value"))
     }
 }
 
 func BenchmarkSSTablePutOverwrite(b *testing.B) {
     engine, _ := NewSSTableEngine("./benchdata", "./benchdata/wal.txt")
-    _ = engine.Put("bench-key", "initial")
+    _ = engine.Put([]byte("bench-key"), []byte("initial"))
     b.Cleanup(func() { os.RemoveAll("./benchdata") })
 
     b.ResetTimer()
 
     for i := 0; i < b.N; i++ {
-        _ = engine.Put("bench-key", "new-value")
+        _ = engine.Put([]byte("bench-key"), []byte("new-value"))
     }
 }
 
@@ -70,7 +70,7 @@ func BenchmarkSSTableFlush(b *testing.B) {
     b.Cleanup(func() { os.RemoveAll("./benchdata") })
 
     for i := 0; i < 10000; i++ {
-        engine.Put(fmt.Sprintf("k-%d", i), "v")
+        engine.Put([]byte(fmt.Sprintf("k-%d", i)), []byte("v"))
     }
 
     b.ResetTimer()
@@ -88,7 +88,7 @@ func BenchmarkSSTablePutLargeValue(b *testing.B) {
     b.ResetTimer()
 
     for i := 0; i < b.N; i++ {
-        _ = engine.Put("large-key", large)
+        _ = engine.Put([]byte("large-key"), []byte(large))
     }
 }
 
@@ -103,12 +103,12 @@ func BenchmarkSSTablePutAndDelete(b *testing.B) {
         key := fmt.Sprintf("key_%d", i)
 
         // Measure Put
-        if err := engine.Put(key, "value"); err != nil {
+        if err := engine.Put([]byte(key), []byte("value")); err != nil {
             b.Fatalf("Put failed: %v", err)
         }
 
         // Measure Delete
-        if err := engine.Delete(key); err != nil {
+        if err := engine.Delete([]byte(key)); err != nil {
             b.Fatalf("Delete failed: %v", err)
         }
     }
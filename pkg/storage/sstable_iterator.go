@@ -0,0 +1,119 @@
+package storage
+
+import "math"
+
+// SSTableIterator walks the live keys of an SSTableEngine in order, merging
+// the memtable with every SSTable. Each key appears once with its newest
+// value; deleted keys are skipped. The iterator sees the engine as it was
+// when NewIterator was called, and must be closed before the engine is
+// destroyed.
+//
+// A new iterator is unpositioned: call SeekToFirst, SeekToLast or Seek
+// before reading from it. Unlike the engine, an iterator must not be used
+// by several goroutines at once.
+type SSTableIterator struct {
+	iter coreIterator
+}
+
+// NewIterator returns an iterator over a snapshot of the engine's contents.
+// The iterator is an *SSTableIterator.
+func (e *SSTableEngine) NewIterator() (Iterator, error) {
+	if err := e.acquire(); err != nil {
+		return nil, err
+	}
+	defer e.closeMu.RUnlock()
+
+	iter, err := e.core.newIterator(math.MaxUint64, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &SSTableIterator{iter: iter}, nil
+}
+
+// NewRangeIterator is NewIterator limited to the keys in [lower, upper); a
+// nil upper leaves it unbounded above. Only the memtable's part of the
+// range is copied, so short scans stay cheap however full the memtable is.
+// Seeks clamp to the range, and the iterator turns invalid at its ends.
+func (e *SSTableEngine) NewRangeIterator(lower, upper []byte) (Iterator, error) {
+	if upper != nil {
+		if err := validateRange(lower, upper); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.acquire(); err != nil {
+		return nil, err
+	}
+	defer e.closeMu.RUnlock()
+
+	iter, err := e.core.newIterator(math.MaxUint64, lower, upper)
+	if err != nil {
+		return nil, err
+	}
+	return &SSTableIterator{iter: iter}, nil
+}
+
+// Valid reports whether the iterator is positioned at a key.
+func (it *SSTableIterator) Valid() bool {
+	return it.iter != nil && it.iter.valid()
+}
+
+// SeekToFirst positions the iterator at the smallest key.
+func (it *SSTableIterator) SeekToFirst() {
+	if it.iter != nil {
+		it.iter.seekToFirst()
+	}
+}
+
+// SeekToLast positions the iterator at the largest key.
+func (it *SSTableIterator) SeekToLast() {
+	if it.iter != nil {
+		it.iter.seekToLast()
+	}
+}
+
+// Seek positions the iterator at the first key not smaller than key.
+func (it *SSTableIterator) Seek(key []byte) {
+	if it.iter != nil {
+		it.iter.seek(key)
+	}
+}
+
+// Next moves to the next key. The iterator becomes invalid past the last key.
+func (it *SSTableIterator) Next() {
+	if it.iter != nil {
+		it.iter.next()
+	}
+}
+
+// Prev moves to the previous key. The iterator becomes invalid before the
+// first key.
+func (it *SSTableIterator) Prev() {
+	if it.iter != nil {
+		it.iter.prev()
+	}
+}
+
+// Key returns a copy of the current key, or nil if the iterator is not valid.
+func (it *SSTableIterator) Key() []byte {
+	if !it.Valid() {
+		return nil
+	}
+	return it.iter.key()
+}
+
+// Value returns a copy of the current value, or nil if the iterator is not
+// valid.
+func (it *SSTableIterator) Value() []byte {
+	if !it.Valid() {
+		return nil
+	}
+	return it.iter.value()
+}
+
+// Close releases the iterator. It is safe to call more than once.
+func (it *SSTableIterator) Close() {
+	if it.iter != nil {
+		it.iter.close()
+		it.iter = nil
+	}
+}
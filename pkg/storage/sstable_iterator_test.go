@@ -0,0 +1,291 @@
+package storage
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func setupIteratorEngine(t *testing.T, opts Options) *SSTableEngine {
+	t.Helper()
+	testDir := filepath.Join(os.TempDir(), "bigtablelite_test", t.Name())
+	os.RemoveAll(testDir)
+	if err := os.MkdirAll(testDir, 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(testDir) })
+
+	engine, err := NewSSTableEngineWithOptions(testDir, filepath.Join(testDir, "wal.txt"), opts)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable engine: %v", err)
+	}
+	t.Cleanup(engine.DestroySSTableEngine)
+	return engine
+}
+
+// collect walks the iterator from its current position in one direction
+func collect(it Iterator, forward bool) []string {
+	var out []string
+	for it.Valid() {
+		out = append(out, string(it.Key())+"="+string(it.Value()))
+		if forward {
+			it.Next()
+		} else {
+			it.Prev()
+		}
+	}
+	return out
+}
+
+func expectEntries(t *testing.T, what string, got, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: got %d entries, want %d", what, len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("%s: entry %d is %q, want %q", what, i, got[i], want[i])
+		}
+	}
+}
+
+func TestSSTableIterator_MergesMemtableAndTables(t *testing.T) {
+	opts := DefaultOptions()
+	opts.BlockSize = 128 // many blocks per table
+	engine := setupIteratorEngine(t, opts)
+
+	// Overwrites and deletes spread over several tables and the memtable
+	rng := rand.New(rand.NewSource(1))
+	model := make(map[string]string)
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 200; i++ {
+			key := fmt.Sprintf("key%04d", rng.Intn(300))
+			if rng.Intn(4) == 0 {
+				if err := engine.Delete([]byte(key)); err != nil {
+					t.Fatalf("Delete failed: %v", err)
+				}
+				delete(model, key)
+				continue
+			}
+			value := fmt.Sprintf("v%d-%d", round, i)
+			if err := engine.Put([]byte(key), []byte(value)); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+			model[key] = value
+		}
+		if round < 4 {
+			if err := engine.Flush(); err != nil {
+				t.Fatalf("Flush failed: %v", err)
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(model))
+	for key := range model {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	want := make([]string, len(keys))
+	for i, key := range keys {
+		want[i] = key + "=" + model[key]
+	}
+	reversed := make([]string, len(want))
+	for i := range want {
+		reversed[i] = want[len(want)-1-i]
+	}
+
+	it, err := engine.NewIterator()
+	if err != nil {
+		t.Fatalf("NewIterator failed: %v", err)
+	}
+	defer it.Close()
+
+	if it.Valid() {
+		t.Fatal("Expected a new iterator to be unpositioned")
+	}
+	it.SeekToFirst()
+	expectEntries(t, "forward scan", collect(it, true), want)
+	it.SeekToLast()
+	expectEntries(t, "backward scan", collect(it, false), reversed)
+
+	// Seek lands on the first live key at or after the target
+	for i := 0; i < 300; i += 7 {
+		target := fmt.Sprintf("key%04d", i)
+		idx := sort.SearchStrings(keys, target)
+		it.Seek([]byte(target))
+		expectEntries(t, "scan from "+target, collect(it, true), want[idx:])
+	}
+
+	// Changing direction returns to the neighbouring keys
+	it.Seek([]byte(keys[len(keys)/2]))
+	for i := 0; i < 10; i++ {
+		it.Next()
+	}
+	it.Prev()
+	if got := string(it.Key()); got != keys[len(keys)/2+9] {
+		t.Fatalf("Prev after Next: got %q, want %q", got, keys[len(keys)/2+9])
+	}
+	it.Prev()
+	it.Next()
+	it.Next()
+	if got := string(it.Key()); got != keys[len(keys)/2+10] {
+		t.Fatalf("Next after Prev: got %q, want %q", got, keys[len(keys)/2+10])
+	}
+
+	it.SeekToFirst()
+	it.Prev()
+	if it.Valid() {
+		t.Fatal("Expected Prev before the first key to invalidate the iterator")
+	}
+}
+
+func TestSSTableIterator_Snapshot(t *testing.T) {
+	engine := setupIteratorEngine(t, DefaultOptions())
+
+	for i := 0; i < 10; i++ {
+		if err := engine.Put([]byte(fmt.Sprintf("key%d", i)), []byte("old")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if i%3 == 2 {
+			if err := engine.Flush(); err != nil {
+				t.Fatalf("Flush failed: %v", err)
+			}
+		}
+	}
+
+	it, err := engine.NewIterator()
+	if err != nil {
+		t.Fatalf("NewIterator failed: %v", err)
+	}
+	defer it.Close()
+
+	// Writes, flushes and compaction after creation stay invisible, and
+	// compaction does not wait for the iterator
+	if err := engine.Put([]byte("key0"), []byte("new")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := engine.Delete([]byte("key5")); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := engine.Put([]byte("key99"), []byte("new")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := engine.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if err := engine.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+
+	var want []string
+	for i := 0; i < 10; i++ {
+		want = append(want, fmt.Sprintf("key%d=old", i))
+	}
+	it.SeekToFirst()
+	expectEntries(t, "snapshot scan", collect(it, true), want)
+
+	// A new iterator sees the changes
+	fresh, err := engine.NewIterator()
+	if err != nil {
+		t.Fatalf("NewIterator failed: %v", err)
+	}
+	defer fresh.Close()
+	fresh.Seek([]byte("key5"))
+	if got := string(fresh.Key()); got != "key6" {
+		t.Fatalf("Expected deleted key5 to be skipped, got %q", got)
+	}
+	fresh.SeekToLast()
+	if got := string(fresh.Key()) + "=" + string(fresh.Value()); got != "key99=new" {
+		t.Fatalf("Expected last entry key99=new, got %q", got)
+	}
+}
+
+func TestSSTableIterator_Range(t *testing.T) {
+	snapshotBackends(t, func(t *testing.T, opts Options) {
+		engine := setupIteratorEngine(t, opts)
+
+		// Half the keys in a table, half in the memtable
+		var want []string
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("key%d", i)
+			if err := engine.Put([]byte(key), []byte("v")); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+			if i >= 3 && i < 7 {
+				want = append(want, key+"=v")
+			}
+			if i == 4 {
+				if err := engine.Flush(); err != nil {
+					t.Fatalf("Flush failed: %v", err)
+				}
+			}
+		}
+		reversed := []string{want[3], want[2], want[1], want[0]}
+
+		it, err := engine.NewRangeIterator([]byte("key3"), []byte("key7"))
+		if err != nil {
+			t.Fatalf("NewRangeIterator failed: %v", err)
+		}
+		defer it.Close()
+
+		it.SeekToFirst()
+		expectEntries(t, "forward scan", collect(it, true), want)
+		it.SeekToLast()
+		expectEntries(t, "backward scan", collect(it, false), reversed)
+
+		// Seeks clamp to the range
+		it.Seek([]byte("a"))
+		expectEntries(t, "scan from below", collect(it, true), want)
+		it.Seek([]byte("key7"))
+		if it.Valid() {
+			t.Fatalf("Expected a seek past the range to be invalid, got %q", it.Key())
+		}
+
+		// Without an upper bound the range runs to the last key
+		open, err := engine.NewRangeIterator([]byte("key8"), nil)
+		if err != nil {
+			t.Fatalf("NewRangeIterator failed: %v", err)
+		}
+		defer open.Close()
+		open.SeekToLast()
+		expectEntries(t, "open range", collect(open, false), []string{"key9=v", "key8=v"})
+
+		if _, err := engine.NewRangeIterator([]byte("b"), []byte("a")); err == nil {
+			t.Fatal("Expected a reversed range to be rejected")
+		}
+	})
+}
+
+func TestSSTableIterator_Empty(t *testing.T) {
+	engine := setupIteratorEngine(t, DefaultOptions())
+	if err := engine.Put([]byte("gone"), []byte("v")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := engine.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if err := engine.Delete([]byte("gone")); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	it, err := engine.NewIterator()
+	if err != nil {
+		t.Fatalf("NewIterator failed: %v", err)
+	}
+	defer it.Close()
+
+	it.SeekToFirst()
+	if it.Valid() {
+		t.Fatalf("Expected no live keys, got %q", it.Key())
+	}
+	it.SeekToLast()
+	if it.Valid() {
+		t.Fatalf("Expected no live keys, got %q", it.Key())
+	}
+	if it.Key() != nil || it.Value() != nil {
+		t.Fatal("Expected nil key and value from an invalid iterator")
+	}
+}
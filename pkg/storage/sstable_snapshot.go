@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+var errSnapshotReleased = errors.New("snapshot released")
+
+// Snapshot is a consistent, read-only view of an SSTableEngine as of the
+// moment it was taken: reads through it see every write made before and none
+// made after, even as flushes and compactions run. Each write is numbered
+// with a sequence number, and a snapshot pins the newest one it sees; the
+// engine keeps the versions the snapshot reads until it is released.
+//
+// A Snapshot is safe for concurrent use. It should be released as soon as
+// it is no longer needed, since it keeps overwritten values on disk.
+type Snapshot struct {
+	engine   *SSTableEngine
+	seq      uint64
+	released atomic.Bool
+}
+
+// Snapshot pins the engine's current contents.
+func (e *SSTableEngine) Snapshot() (*Snapshot, error) {
+	if err := e.acquire(); err != nil {
+		return nil, err
+	}
+	defer e.closeMu.RUnlock()
+
+	return &Snapshot{engine: e, seq: e.core.acquireSnapshot()}, nil
+}
+
+// Seq returns the sequence number of the newest write the snapshot sees.
+func (s *Snapshot) Seq() uint64 {
+	return s.seq
+}
+
+// Get returns the value key had when the snapshot was taken.
+func (s *Snapshot) Get(key []byte) ([]byte, bool, error) {
+	if err := s.engine.acquire(); err != nil {
+		return nil, false, err
+	}
+	defer s.engine.closeMu.RUnlock()
+
+	if s.released.Load() {
+		return nil, false, errSnapshotReleased
+	}
+	return s.engine.core.get(key, s.seq)
+}
+
+// NewIterator returns an iterator over the live keys as of the snapshot.
+// The iterator is an *SSTableIterator and stays usable after the snapshot
+// is released.
+func (s *Snapshot) NewIterator() (Iterator, error) {
+	if err := s.engine.acquire(); err != nil {
+		return nil, err
+	}
+	defer s.engine.closeMu.RUnlock()
+
+	if s.released.Load() {
+		return nil, errSnapshotReleased
+	}
+	iter, err := s.engine.core.newIterator(s.seq, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &SSTableIterator{iter: iter}, nil
+}
+
+// Release unpins the snapshot, letting compactions drop the versions only
+// it could see. It is safe to call more than once, and after the engine is
+// destroyed.
+func (s *Snapshot) Release() {
+	if s.released.Swap(true) {
+		return
+	}
+	if err := s.engine.acquire(); err != nil {
+		return
+	}
+	defer s.engine.closeMu.RUnlock()
+
+	s.engine.core.releaseSnapshot(s.seq)
+}
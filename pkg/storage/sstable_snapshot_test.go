@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/alexciechonski/BigTableLite/pkg/wal"
+)
+
+// snapshotBackends runs test against every backend linked into the build
+func snapshotBackends(t *testing.T, test func(t *testing.T, opts Options)) {
+	backends := []Backend{BackendGo}
+	if cgoEnabled {
+		backends = append([]Backend{BackendCPP}, backends...)
+	}
+	for _, backend := range backends {
+		t.Run(string(backend), func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.Backend = backend
+			test(t, opts)
+		})
+	}
+}
+
+func expectSnapshotGet(t *testing.T, snap *Snapshot, key, want string) {
+	t.Helper()
+	value, found, err := snap.Get([]byte(key))
+	if err != nil {
+		t.Fatalf("Snapshot Get(%s) failed: %v", key, err)
+	}
+	if want == "" {
+		if found {
+			t.Fatalf("Snapshot sees %s=%s, want it absent", key, value)
+		}
+		return
+	}
+	if !found || string(value) != want {
+		t.Fatalf("Snapshot sees %s=%q (found=%v), want %q", key, value, found, want)
+	}
+}
+
+func TestSnapshot_Isolation(t *testing.T) {
+	snapshotBackends(t, func(t *testing.T, opts Options) {
+		engine := setupIteratorEngine(t, opts)
+		for _, key := range []string{"a", "b", "c"} {
+			if err := engine.Put([]byte(key), []byte(key+"1")); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+		snap, err := engine.Snapshot()
+		if err != nil {
+			t.Fatalf("Snapshot failed: %v", err)
+		}
+		defer snap.Release()
+		if snap.Seq() != 3 {
+			t.Errorf("Expected the snapshot at sequence 3, got %d", snap.Seq())
+		}
+
+		engine.Put([]byte("a"), []byte("a2"))
+		engine.Delete([]byte("b"))
+		engine.Put([]byte("d"), []byte("d2"))
+		engine.Put([]byte("a"), []byte("a3"))
+
+		want := []string{"a=a1", "b=b1", "c=c1"}
+		check := func(stage string) {
+			t.Helper()
+			expectSnapshotGet(t, snap, "a", "a1")
+			expectSnapshotGet(t, snap, "b", "b1")
+			expectSnapshotGet(t, snap, "d", "")
+
+			it, err := snap.NewIterator()
+			if err != nil {
+				t.Fatalf("NewIterator failed: %v", err)
+			}
+			defer it.Close()
+			it.SeekToFirst()
+			expectEntries(t, stage+" forward", collect(it, true), want)
+			it.SeekToLast()
+			expectEntries(t, stage+" backward", collect(it, false), []string{"c=c1", "b=b1", "a=a1"})
+
+			// Changing direction skips the versions the snapshot cannot see
+			it.Seek([]byte("b"))
+			it.Prev()
+			it.Next()
+			it.Next()
+			if !it.Valid() || string(it.Key()) != "c" {
+				t.Fatalf("%s: expected to be back at c, valid=%v key=%q", stage, it.Valid(), it.Key())
+			}
+		}
+
+		check("memtable")
+		if err := engine.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		check("flushed")
+		if err := engine.Compact(); err != nil {
+			t.Fatalf("Compact failed: %v", err)
+		}
+		check("compacted")
+
+		// The engine itself reads the newest writes
+		expectValues(t, engine, map[string]string{"a": "a3", "c": "c1", "d": "d2"})
+		if _, found, _ := engine.Get([]byte("b")); found {
+			t.Errorf("Deleted key b found")
+		}
+	})
+}
+
+func TestSnapshot_Release(t *testing.T) {
+	snapshotBackends(t, func(t *testing.T, opts Options) {
+		engine := setupIteratorEngine(t, opts)
+		engine.Put([]byte("key"), []byte("old"))
+		snap, err := engine.Snapshot()
+		if err != nil {
+			t.Fatalf("Snapshot failed: %v", err)
+		}
+		engine.Put([]byte("key"), []byte("new"))
+		if err := engine.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+
+		// While the snapshot is held, compaction keeps the old version
+		if err := engine.Compact(); err != nil {
+			t.Fatalf("Compact failed: %v", err)
+		}
+		stats, _ := engine.Stats()
+		if stats.CompactionEntriesDropped != 0 {
+			t.Errorf("Expected no entries dropped, got %d", stats.CompactionEntriesDropped)
+		}
+		expectSnapshotGet(t, snap, "key", "old")
+
+		// An iterator outlives the snapshot it was created from
+		it, err := snap.NewIterator()
+		if err != nil {
+			t.Fatalf("NewIterator failed: %v", err)
+		}
+		defer it.Close()
+
+		snap.Release()
+		snap.Release()
+		if _, _, err := snap.Get([]byte("key")); err == nil {
+			t.Errorf("Expected Get on a released snapshot to fail")
+		}
+		if _, err := snap.NewIterator(); err == nil {
+			t.Errorf("Expected NewIterator on a released snapshot to fail")
+		}
+
+		engine.Put([]byte("other"), []byte("value"))
+		if err := engine.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		if err := engine.Compact(); err != nil {
+			t.Fatalf("Compact failed: %v", err)
+		}
+		stats, _ = engine.Stats()
+		if stats.CompactionEntriesDropped != 1 {
+			t.Errorf("Expected the old version dropped, got %d entries dropped", stats.CompactionEntriesDropped)
+		}
+		expectValues(t, engine, map[string]string{"key": "new", "other": "value"})
+
+		it.SeekToFirst()
+		expectEntries(t, "released snapshot", collect(it, true), []string{"key=old"})
+	})
+}
+
+// Readers holding a snapshot never see a batch half applied, even while
+// memtables are flushed underneath them.
+func TestSnapshot_BatchesAreAtomic(t *testing.T) {
+	snapshotBackends(t, func(t *testing.T, opts Options) {
+		engine := setupIteratorEngine(t, opts)
+		keys := []string{"x", "y", "z"}
+
+		var wg sync.WaitGroup
+		done := make(chan struct{})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done)
+			for i := 0; i < 300; i++ {
+				var batch Batch
+				for _, key := range keys {
+					batch.Put([]byte(key), []byte(fmt.Sprint(i)))
+				}
+				if err := engine.Write(&batch); err != nil {
+					t.Errorf("Write failed: %v", err)
+					return
+				}
+				if i%50 == 0 {
+					if err := engine.Flush(); err != nil {
+						t.Errorf("Flush failed: %v", err)
+						return
+					}
+				}
+			}
+		}()
+
+		for reader := 0; reader < 4; reader++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					snap, err := engine.Snapshot()
+					if err != nil {
+						t.Errorf("Snapshot failed: %v", err)
+						return
+					}
+					var values []string
+					for _, key := range keys {
+						value, _, err := snap.Get([]byte(key))
+						if err != nil {
+							t.Errorf("Snapshot Get failed: %v", err)
+						}
+						values = append(values, string(value))
+					}
+					snap.Release()
+					if values[0] != values[1] || values[1] != values[2] {
+						t.Errorf("Snapshot saw a partial batch: %v", values)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+func TestSnapshot_SequenceSurvivesRestart(t *testing.T) {
+	snapshotBackends(t, func(t *testing.T, opts Options) {
+		dir := t.TempDir()
+		walPath := filepath.Join(dir, "wal.txt")
+
+		// A WAL written before sequence numbers existed still replays
+		w, err := wal.NewWal(walPath)
+		if err != nil {
+			t.Fatalf("Failed to open WAL: %v", err)
+		}
+		for _, key := range []string{"a", "b"} {
+			entry, _ := wal.SerializeOperation("set", []byte(key), []byte("legacy"))
+			if err := w.Append(entry); err != nil {
+				t.Fatalf("Append failed: %v", err)
+			}
+		}
+		w.Close()
+
+		engine, err := NewSSTableEngineWithOptions(dir, walPath, opts)
+		if err != nil {
+			t.Fatalf("Failed to open engine: %v", err)
+		}
+		engine.Put([]byte("c"), []byte("new"))
+		if err := engine.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		engine.Put([]byte("d"), []byte("new"))
+		engine.DestroySSTableEngine()
+
+		// The numbers continue from both the tables and the WAL
+		engine, err = NewSSTableEngineWithOptions(dir, walPath, opts)
+		if err != nil {
+			t.Fatalf("Failed to reopen engine: %v", err)
+		}
+		defer engine.DestroySSTableEngine()
+		snap, err := engine.Snapshot()
+		if err != nil {
+			t.Fatalf("Snapshot failed: %v", err)
+		}
+		defer snap.Release()
+		if snap.Seq() != 4 {
+			t.Errorf("Expected the snapshot at sequence 4, got %d", snap.Seq())
+		}
+		engine.Put([]byte("a"), []byte("newer"))
+		expectSnapshotGet(t, snap, "a", "legacy")
+		expectSnapshotGet(t, snap, "d", "new")
+		expectValues(t, engine, map[string]string{"a": "newer", "b": "legacy", "c": "new"})
+	})
+}
@@ -1,10 +1,17 @@
 package storage
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"fmt"
+	"math/rand"
+	"time"
 )
 
 func setupTestEngine(t *testing.T) *SSTableEngine {
@@ -33,19 +40,19 @@ func TestSSTableEngine_PutAndGet(t *testing.T) {
 	defer cleanupTestEngine(t, engine)
 
 	// Test basic put and get
-	err := engine.Put("key1", "value1")
+	err := engine.Put([]byte("key1"), []byte("value1"))
 	if err != nil {
 		t.Fatalf("Put failed: %v", err)
 	}
 
-	value, found, err := engine.Get("key1")
+	value, found, err := engine.Get([]byte("key1"))
 	if err != nil {
 		t.Fatalf("Get failed: %v", err)
 	}
 	if !found {
 		t.Error("Expected key to be found")
 	}
-	if value != "value1" {
+	if string(value) != "value1" {
 		t.Errorf("Expected value 'value1', got '%s'", value)
 	}
 }
@@ -54,14 +61,14 @@ func TestSSTableEngine_GetNonExistent(t *testing.T) {
 	engine := setupTestEngine(t)
 	defer cleanupTestEngine(t, engine)
 
-	value, found, err := engine.Get("nonexistent")
+	value, found, err := engine.Get([]byte("nonexistent"))
 	if err != nil {
 		t.Fatalf("Get failed: %v", err)
 	}
 	if found {
 		t.Error("Expected key to not be found")
 	}
-	if value != "" {
+	if string(value) != "" {
 		t.Errorf("Expected empty value, got '%s'", value)
 	}
 }
@@ -71,26 +78,26 @@ func TestSSTableEngine_Overwrite(t *testing.T) {
 	defer cleanupTestEngine(t, engine)
 
 	// Put initial value
-	err := engine.Put("key1", "value1")
+	err := engine.Put([]byte("key1"), []byte("value1"))
 	if err != nil {
 		t.Fatalf("Put failed: %v", err)
 	}
 
 	// Overwrite with new value
-	err = engine.Put("key1", "value2")
+	err = engine.Put([]byte("key1"), []byte("value2"))
 	if err != nil {
 		t.Fatalf("Put failed: %v", err)
 	}
 
 	// Verify new value
-	value, found, err := engine.Get("key1")
+	value, found, err := engine.Get([]byte("key1"))
 	if err != nil {
 		t.Fatalf("Get failed: %v", err)
 	}
 	if !found {
 		t.Error("Expected key to be found")
 	}
-	if value != "value2" {
+	if string(value) != "value2" {
 		t.Errorf("Expected value 'value2', got '%s'", value)
 	}
 }
@@ -110,7 +117,7 @@ func TestSSTableEngine_MultipleKeys(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		err := engine.Put(tc.key, tc.value)
+		err := engine.Put([]byte(tc.key), []byte(tc.value))
 		if err != nil {
 			t.Fatalf("Put failed for key %s: %v", tc.key, err)
 		}
@@ -118,14 +125,14 @@ func TestSSTableEngine_MultipleKeys(t *testing.T) {
 
 	// Get all keys
 	for _, tc := range testCases {
-		value, found, err := engine.Get(tc.key)
+		value, found, err := engine.Get([]byte(tc.key))
 		if err != nil {
 			t.Fatalf("Get failed for key %s: %v", tc.key, err)
 		}
 		if !found {
 			t.Errorf("Expected key %s to be found", tc.key)
 		}
-		if value != tc.value {
+		if string(value) != tc.value {
 			t.Errorf("Expected value '%s' for key %s, got '%s'", tc.value, tc.key, value)
 		}
 	}
@@ -136,7 +143,7 @@ func TestSSTableEngine_Flush(t *testing.T) {
 	defer cleanupTestEngine(t, engine)
 
 	// Put some data
-	err := engine.Put("key1", "value1")
+	err := engine.Put([]byte("key1"), []byte("value1"))
 	if err != nil {
 		t.Fatalf("Put failed: %v", err)
 	}
@@ -148,14 +155,14 @@ func TestSSTableEngine_Flush(t *testing.T) {
 	}
 
 	// Verify data is still accessible after flush
-	value, found, err := engine.Get("key1")
+	value, found, err := engine.Get([]byte("key1"))
 	if err != nil {
 		t.Fatalf("Get failed: %v", err)
 	}
 	if !found {
 		t.Error("Expected key to be found after flush")
 	}
-	if value != "value1" {
+	if string(value) != "value1" {
 		t.Errorf("Expected value 'value1', got '%s'", value)
 	}
 }
@@ -164,19 +171,19 @@ func TestSSTableEngine_EmptyKey(t *testing.T) {
 	engine := setupTestEngine(t)
 	defer cleanupTestEngine(t, engine)
 
-	err := engine.Put("", "empty_key_value")
+	err := engine.Put([]byte(""), []byte("empty_key_value"))
 	if err != nil {
 		t.Fatalf("Put failed: %v", err)
 	}
 
-	value, found, err := engine.Get("")
+	value, found, err := engine.Get([]byte(""))
 	if err != nil {
 		t.Fatalf("Get failed: %v", err)
 	}
 	if !found {
 		t.Error("Expected empty key to be found")
 	}
-	if value != "empty_key_value" {
+	if string(value) != "empty_key_value" {
 		t.Errorf("Expected value 'empty_key_value', got '%s'", value)
 	}
 }
@@ -185,19 +192,19 @@ func TestSSTableEngine_EmptyValue(t *testing.T) {
 	engine := setupTestEngine(t)
 	defer cleanupTestEngine(t, engine)
 
-	err := engine.Put("key1", "")
+	err := engine.Put([]byte("key1"), []byte(""))
 	if err != nil {
 		t.Fatalf("Put failed: %v", err)
 	}
 
-	value, found, err := engine.Get("key1")
+	value, found, err := engine.Get([]byte("key1"))
 	if err != nil {
 		t.Fatalf("Get failed: %v", err)
 	}
 	if !found {
 		t.Error("Expected key to be found")
 	}
-	if value != "" {
+	if string(value) != "" {
 		t.Errorf("Expected empty value, got '%s'", value)
 	}
 }
@@ -212,12 +219,12 @@ func TestSSTableEngine_LargeValue(t *testing.T) {
 		largeValue[i] = byte((i % 255) + 1) // Avoid null bytes (0x00)
 	}
 
-	err := engine.Put("large_key", string(largeValue))
+	err := engine.Put([]byte("large_key"), largeValue)
 	if err != nil {
 		t.Fatalf("Put failed: %v", err)
 	}
 
-	value, found, err := engine.Get("large_key")
+	value, found, err := engine.Get([]byte("large_key"))
 	if err != nil {
 		t.Fatalf("Get failed: %v", err)
 	}
@@ -244,13 +251,13 @@ func TestSStableEngine_Replay(t *testing.T) {
 	}
 
 	// Add entries
-	if err := engine1.Put("a", "1"); err != nil {
+	if err := engine1.Put([]byte("a"), []byte("1")); err != nil {
         t.Fatalf("Put(a) failed: %v", err)
     }
-    if err := engine1.Put("b", "2"); err != nil {
+    if err := engine1.Put([]byte("b"), []byte("2")); err != nil {
         t.Fatalf("Put(b) failed: %v", err)
     }
-    if err := engine1.Put("c", "3"); err != nil {
+    if err := engine1.Put([]byte("c"), []byte("3")); err != nil {
         t.Fatalf("Put(c) failed: %v", err)
     }
 	
@@ -275,21 +282,21 @@ func TestSStableEngine_Replay(t *testing.T) {
 	}
 
 	// Verify recovery
-	val, found, err := engine2.Get("a")
+	val, found, err := engine2.Get([]byte("a"))
 	if err != nil { t.Fatal(err) }
 	if !found { t.Errorf("expected a to be found") }
-	if val != "1" {
+	if string(val) != "1" {
 		t.Errorf("Expected key 'a' to have value '1', got '%s'", val)
 	}
 
-	val, found, err = engine2.Get("b")
+	val, found, err = engine2.Get([]byte("b"))
 	if err != nil { t.Fatal(err) }
 	if !found { t.Errorf("expected b to be found") }
-	if val != "2" {
+	if string(val) != "2" {
 		t.Errorf("Expected key 'b' to have value '2', got '%s'", val)
 	}
 
-	val, found, err = engine2.Get("c")
+	val, found, err = engine2.Get([]byte("c"))
 	if err != nil { t.Fatal(err) }
 	if found { 
 		fmt.Println("val:", val)
@@ -308,19 +315,19 @@ func TestSSTablePutAndDelete(t *testing.T) {
 	defer cleanupTestEngine(t, engine)
 
 	// put arbitrary value
-	err = engine.Put("key1", "value1")
+	err = engine.Put([]byte("key1"), []byte("value1"))
 	if err != nil {
 		t.Fatalf("Put failed: %v", err)
 	}
 
 	// delete
-	err = engine.Delete("key1")
+	err = engine.Delete([]byte("key1"))
 	if err != nil {
 		t.Fatalf("Delete failed: %v", err)
 	}
 
 	// verify it does not exist
-	_, found, err := engine.Get("key1")
+	_, found, err := engine.Get([]byte("key1"))
 	if err != nil {
 		t.Fatalf("Get failed: %v", err)
 	}
@@ -330,3 +337,1812 @@ func TestSSTablePutAndDelete(t *testing.T) {
 }
 
 
+
+func TestSSTableEngine_IndependentInstances(t *testing.T) {
+	baseDir := filepath.Join(os.TempDir(), "bigtablelite_test", t.Name())
+	os.RemoveAll(baseDir)
+	defer os.RemoveAll(baseDir)
+
+	dirA := filepath.Join(baseDir, "a")
+	dirB := filepath.Join(baseDir, "b")
+	os.MkdirAll(dirA, 0755)
+	os.MkdirAll(dirB, 0755)
+
+	engineA, err := NewSSTableEngine(dirA, filepath.Join(dirA, "wal.txt"))
+	if err != nil {
+		t.Fatalf("Failed to create engine A: %v", err)
+	}
+	defer engineA.DestroySSTableEngine()
+
+	if err := engineA.Put([]byte("shared"), []byte("from-a")); err != nil {
+		t.Fatalf("Put on A failed: %v", err)
+	}
+
+	// Opening a second engine must not clear the first one's memtable
+	engineB, err := NewSSTableEngine(dirB, filepath.Join(dirB, "wal.txt"))
+	if err != nil {
+		t.Fatalf("Failed to create engine B: %v", err)
+	}
+	defer engineB.DestroySSTableEngine()
+
+	if err := engineB.Put([]byte("shared"), []byte("from-b")); err != nil {
+		t.Fatalf("Put on B failed: %v", err)
+	}
+
+	value, found, err := engineA.Get([]byte("shared"))
+	if err != nil {
+		t.Fatalf("Get on A failed: %v", err)
+	}
+	if !found || string(value) != "from-a" {
+		t.Errorf("Expected engine A to return 'from-a', got '%s' (found=%v)", value, found)
+	}
+
+	// Flushing one engine must only write into its own data directory
+	if err := engineB.Flush(); err != nil {
+		t.Fatalf("Flush on B failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dirA, "sstable_0001.sst")); !os.IsNotExist(err) {
+		t.Errorf("Expected no SSTable in engine A's directory, stat err: %v", err)
+	}
+
+	value, found, err = engineB.Get([]byte("shared"))
+	if err != nil {
+		t.Fatalf("Get on B failed: %v", err)
+	}
+	if !found || string(value) != "from-b" {
+		t.Errorf("Expected engine B to return 'from-b', got '%s' (found=%v)", value, found)
+	}
+}
+
+func TestSSTableEngine_DeleteFlushedKey(t *testing.T) {
+	engine := setupTestEngine(t)
+	defer cleanupTestEngine(t, engine)
+
+	if err := engine.Put([]byte("key1"), []byte("value1")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := engine.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	// The key now only lives in an SSTable
+	if err := engine.Delete([]byte("key1")); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	_, found, err := engine.Get([]byte("key1"))
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if found {
+		t.Fatalf("Flushed value visible despite deletion")
+	}
+
+	// The tombstone must keep shadowing the value once it is flushed too
+	if err := engine.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	_, found, err = engine.Get([]byte("key1"))
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if found {
+		t.Fatalf("Flushed value resurrected after tombstone flush")
+	}
+
+	// A newer put wins over the tombstone
+	if err := engine.Put([]byte("key1"), []byte("value2")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	value, found, err := engine.Get([]byte("key1"))
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if !found || string(value) != "value2" {
+		t.Errorf("Expected value 'value2', got '%s' (found=%v)", value, found)
+	}
+}
+
+func TestSSTableEngine_DeleteNonExistent(t *testing.T) {
+	engine := setupTestEngine(t)
+	defer cleanupTestEngine(t, engine)
+
+	if err := engine.Delete([]byte("missing")); err != nil {
+		t.Fatalf("Delete of missing key failed: %v", err)
+	}
+
+	_, found, err := engine.Get([]byte("missing"))
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if found {
+		t.Errorf("Expected key to not be found")
+	}
+}
+
+func TestSSTableEngine_BinaryKeysAndValues(t *testing.T) {
+	engine := setupTestEngine(t)
+	testDir := filepath.Join(os.TempDir(), "bigtablelite_test", t.Name())
+	defer cleanupTestEngine(t, engine)
+
+	// Keys that only differ after a NUL byte used to collapse into "a"
+	entries := []struct {
+		key   []byte
+		value []byte
+	}{
+		{[]byte("a"), []byte("plain")},
+		{[]byte("a\x00b"), []byte("v\x00one")},
+		{[]byte("a\x00c"), []byte{0x00, 0x00, 0xff}},
+		{[]byte{0xde, 0xad, 0x00, 0xbe, 0xef}, []byte{}},
+	}
+
+	for _, e := range entries {
+		if err := engine.Put(e.key, e.value); err != nil {
+			t.Fatalf("Put(%q) failed: %v", e.key, err)
+		}
+	}
+
+	verify := func(stage string, eng *SSTableEngine) {
+		for _, e := range entries {
+			value, found, err := eng.Get(e.key)
+			if err != nil {
+				t.Fatalf("%s: Get(%q) failed: %v", stage, e.key, err)
+			}
+			if !found {
+				t.Fatalf("%s: expected key %q to be found", stage, e.key)
+			}
+			if !bytes.Equal(value, e.value) {
+				t.Errorf("%s: expected value %q for key %q, got %q", stage, e.value, e.key, value)
+			}
+		}
+	}
+
+	verify("memtable", engine)
+
+	// Recover from the WAL only
+	engine.DestroySSTableEngine()
+	engine, err := NewSSTableEngine(testDir, filepath.Join(testDir, "wal.txt"))
+	if err != nil {
+		t.Fatalf("Failed to reopen SSTable engine: %v", err)
+	}
+	verify("wal replay", engine)
+
+	if err := engine.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	verify("sstable", engine)
+
+	if err := engine.Delete([]byte("a\x00b")); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, found, _ := engine.Get([]byte("a\x00b")); found {
+		t.Errorf("Expected deleted binary key to be gone")
+	}
+	if _, found, _ := engine.Get([]byte("a")); !found {
+		t.Errorf("Deleting a\\x00b must not affect key a")
+	}
+	engine.DestroySSTableEngine()
+}
+
+func waitForStats(t *testing.T, engine *SSTableEngine, cond func(EngineStats) bool) EngineStats {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats, err := engine.Stats()
+		if err != nil {
+			t.Fatalf("Stats failed: %v", err)
+		}
+		if cond(stats) {
+			return stats
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for engine stats, last: %+v", stats)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSSTableEngine_BackgroundCompaction(t *testing.T) {
+	engine := setupTestEngine(t)
+	defer cleanupTestEngine(t, engine)
+	defer engine.DestroySSTableEngine()
+
+	// Each flush writes one small table; four of them form a size tier
+	for round := 0; round < 4; round++ {
+		for i := 0; i < 10; i++ {
+			key := []byte(fmt.Sprintf("key-%02d", i))
+			value := []byte(fmt.Sprintf("value-%d-%d", i, round))
+			if err := engine.Put(key, value); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+		if err := engine.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+
+	stats := waitForStats(t, engine, func(s EngineStats) bool { return s.Compactions >= 1 })
+	if stats.SSTables != 1 {
+		t.Errorf("Expected 1 SSTable after compaction, got %d", stats.SSTables)
+	}
+	if stats.CompactionEntriesDropped != 30 {
+		t.Errorf("Expected 30 overwritten entries dropped, got %d", stats.CompactionEntriesDropped)
+	}
+
+	// The newest value of every key survives
+	for i := 0; i < 10; i++ {
+		value, found, err := engine.Get([]byte(fmt.Sprintf("key-%02d", i)))
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if !found || string(value) != fmt.Sprintf("value-%d-3", i) {
+			t.Errorf("Expected value-%d-3, got '%s' (found=%v)", i, value, found)
+		}
+	}
+}
+
+func TestSSTableEngine_CompactDropsTombstones(t *testing.T) {
+	engine := setupTestEngine(t)
+	testDir := filepath.Join(os.TempDir(), "bigtablelite_test", t.Name())
+	defer cleanupTestEngine(t, engine)
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := engine.Put([]byte(key), []byte("v-"+key)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := engine.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if err := engine.Delete([]byte("b")); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := engine.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	if err := engine.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+
+	stats, err := engine.Stats()
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.SSTables != 1 {
+		t.Errorf("Expected 1 SSTable after compaction, got %d", stats.SSTables)
+	}
+	if stats.CompactionTombstonesDropped != 1 {
+		t.Errorf("Expected 1 tombstone dropped, got %d", stats.CompactionTombstonesDropped)
+	}
+
+	// Reopen to make sure the compacted table set is what is on disk
+	engine.DestroySSTableEngine()
+	engine, err = NewSSTableEngine(testDir, filepath.Join(testDir, "wal.txt"))
+	if err != nil {
+		t.Fatalf("Failed to reopen SSTable engine: %v", err)
+	}
+	defer engine.DestroySSTableEngine()
+
+	for key, want := range map[string]string{"a": "v-a", "c": "v-c"} {
+		value, found, err := engine.Get([]byte(key))
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if !found || string(value) != want {
+			t.Errorf("Expected %s=%s, got '%s' (found=%v)", key, want, value, found)
+		}
+	}
+	if _, found, _ := engine.Get([]byte("b")); found {
+		t.Errorf("Deleted key resurrected after compaction")
+	}
+}
+
+func TestSSTableEngine_CompactionKeepsNeededTombstones(t *testing.T) {
+	engine := setupTestEngine(t)
+	defer cleanupTestEngine(t, engine)
+	defer engine.DestroySSTableEngine()
+
+	// An old table holding "k" that is too large to share a tier with the
+	// small tables flushed afterwards
+	if err := engine.Put([]byte("k"), []byte("old")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	big := make([]byte, 5*1024*1024)
+	if err := engine.Put([]byte("big"), big); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := engine.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	// Four small tables, the first carrying the tombstone for "k"
+	if err := engine.Delete([]byte("k")); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := engine.Put([]byte(fmt.Sprintf("small-%d", i)), []byte("v")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := engine.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+
+	stats := waitForStats(t, engine, func(s EngineStats) bool { return s.Compactions >= 1 })
+	if stats.SSTables != 2 {
+		t.Errorf("Expected the large table and one compacted table, got %d", stats.SSTables)
+	}
+	if stats.CompactionTombstonesDropped != 0 {
+		t.Errorf("Tombstone shadowing an older table was dropped")
+	}
+	if _, found, _ := engine.Get([]byte("k")); found {
+		t.Errorf("Deleted key resurrected after partial compaction")
+	}
+}
+
+func setupLeveledEngine(t *testing.T, opts Options) (*SSTableEngine, string) {
+	t.Helper()
+	testDir := filepath.Join(os.TempDir(), "bigtablelite_test", t.Name())
+	os.RemoveAll(testDir)
+	if err := os.MkdirAll(testDir, 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(testDir) })
+
+	opts.CompactionStrategy = CompactionLeveled
+	engine, err := NewSSTableEngineWithOptions(testDir, filepath.Join(testDir, "wal.txt"), opts)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable engine: %v", err)
+	}
+	return engine, testDir
+}
+
+func TestSSTableEngine_LeveledCompaction(t *testing.T) {
+	engine, _ := setupLeveledEngine(t, DefaultOptions())
+	defer engine.DestroySSTableEngine()
+
+	// Four overlapping level-0 tables reach the default trigger
+	for round := 0; round < 4; round++ {
+		for i := 0; i < 10; i++ {
+			key := []byte(fmt.Sprintf("key-%02d", i))
+			value := []byte(fmt.Sprintf("value-%d-%d", i, round))
+			if err := engine.Put(key, value); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+		if round == 3 {
+			if err := engine.Delete([]byte("key-05")); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+		}
+		if err := engine.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+
+	stats := waitForStats(t, engine, func(s EngineStats) bool { return s.Compactions >= 1 })
+	if stats.SSTables != 1 {
+		t.Errorf("Expected level 0 merged into one level-1 table, got %d tables", stats.SSTables)
+	}
+	if stats.CompactionEntriesDropped != 30 {
+		t.Errorf("Expected 30 overwritten entries dropped, got %d", stats.CompactionEntriesDropped)
+	}
+	if stats.CompactionTombstonesDropped != 1 {
+		t.Errorf("Expected the tombstone to be dropped at the bottom level, got %d", stats.CompactionTombstonesDropped)
+	}
+
+	for i := 0; i < 10; i++ {
+		value, found, err := engine.Get([]byte(fmt.Sprintf("key-%02d", i)))
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if i == 5 {
+			if found {
+				t.Errorf("Deleted key resurrected after compaction")
+			}
+			continue
+		}
+		if !found || string(value) != fmt.Sprintf("value-%d-3", i) {
+			t.Errorf("Expected value-%d-3, got '%s' (found=%v)", i, value, found)
+		}
+	}
+}
+
+func TestSSTableEngine_LeveledMultipleLevels(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Level0FileTrigger = 2
+	opts.Level1MaxBytes = 4 * 1024
+	opts.TargetFileSize = 1024
+	engine, testDir := setupLeveledEngine(t, opts)
+
+	// Enough data to overflow level 1 so tables get pushed deeper
+	const numKeys = 400
+	for round := 0; round < 4; round++ {
+		for i := round; i < numKeys; i += 4 {
+			key := []byte(fmt.Sprintf("key-%04d", i))
+			if err := engine.Put(key, []byte(fmt.Sprintf("value-%d", i))); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+		if err := engine.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	if err := engine.Delete([]byte("key-0100")); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := engine.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	stats := waitForStats(t, engine, func(s EngineStats) bool { return s.Compactions >= 3 })
+	if stats.SSTables < 2 {
+		t.Errorf("Expected outputs split across several tables, got %d", stats.SSTables)
+	}
+
+	check := func(engine *SSTableEngine) {
+		t.Helper()
+		for i := 0; i < numKeys; i++ {
+			value, found, err := engine.Get([]byte(fmt.Sprintf("key-%04d", i)))
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			if i == 100 {
+				if found {
+					t.Errorf("Deleted key resurrected")
+				}
+				continue
+			}
+			if !found || string(value) != fmt.Sprintf("value-%d", i) {
+				t.Fatalf("Expected value-%d, got '%s' (found=%v)", i, value, found)
+			}
+		}
+	}
+	check(engine)
+
+	// A full compaction keeps every live key and drops the tombstone
+	if err := engine.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	check(engine)
+
+	// The level layout is recovered from disk
+	engine.DestroySSTableEngine()
+	engine, err := NewSSTableEngineWithOptions(testDir, filepath.Join(testDir, "wal.txt"), opts)
+	if err != nil {
+		t.Fatalf("Failed to reopen SSTable engine: %v", err)
+	}
+	defer engine.DestroySSTableEngine()
+	check(engine)
+}
+
+func TestSSTableEngine_UnknownCompactionStrategy(t *testing.T) {
+	testDir := t.TempDir()
+	opts := DefaultOptions()
+	opts.CompactionStrategy = "tiered-leveled"
+	if _, err := NewSSTableEngineWithOptions(testDir, filepath.Join(testDir, "wal.txt"), opts); err == nil {
+		t.Fatalf("Expected an error for an unknown compaction strategy")
+	}
+	if _, err := ParseCompactionStrategy("bogus"); err == nil {
+		t.Fatalf("Expected ParseCompactionStrategy to reject an unknown name")
+	}
+}
+
+func TestSSTableEngine_BloomFilterSkipsTables(t *testing.T) {
+	engine := setupTestEngine(t)
+	defer cleanupTestEngine(t, engine)
+	defer engine.DestroySSTableEngine()
+
+	for i := 0; i < 100; i++ {
+		if err := engine.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte("v")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := engine.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	// Absent keys inside the table's key range are ruled out by the filter
+	for i := 0; i < 100; i++ {
+		if _, found, _ := engine.Get([]byte(fmt.Sprintf("key-%03d-absent", i))); found {
+			t.Fatalf("Found a key that was never written")
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if _, found, _ := engine.Get([]byte(fmt.Sprintf("key-%03d", i))); !found {
+			t.Fatalf("key-%03d not found", i)
+		}
+	}
+
+	stats, err := engine.Stats()
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.FilterMisses < 90 {
+		t.Errorf("Expected most absent keys to be ruled out by the filter, got %d misses", stats.FilterMisses)
+	}
+	if stats.FilterHits < 100 {
+		t.Errorf("Expected every present key to match the filter, got %d hits", stats.FilterHits)
+	}
+}
+
+func TestSSTableEngine_BloomFilterDisabled(t *testing.T) {
+	testDir := t.TempDir()
+	opts := DefaultOptions()
+	opts.BloomBitsPerKey = -1
+	engine, err := NewSSTableEngineWithOptions(testDir, filepath.Join(testDir, "wal.txt"), opts)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable engine: %v", err)
+	}
+	defer engine.DestroySSTableEngine()
+
+	if err := engine.Put([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := engine.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if value, found, _ := engine.Get([]byte("k")); !found || string(value) != "v" {
+		t.Fatalf("Expected k=v, got '%s' (found=%v)", value, found)
+	}
+	if _, found, _ := engine.Get([]byte("j")); found {
+		t.Fatalf("Found a key that was never written")
+	}
+
+	stats, err := engine.Stats()
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.FilterHits != 0 || stats.FilterMisses != 0 {
+		t.Errorf("Expected no filter activity, got %d hits and %d misses", stats.FilterHits, stats.FilterMisses)
+	}
+}
+
+// writeLegacyTable writes a table in the format used before filters were
+// added: data, index and a bare index offset.
+func writeLegacyTable(t *testing.T, path string, keys, values []string) {
+	t.Helper()
+	var buf bytes.Buffer
+	offsets := make([]uint64, len(keys))
+	for i := range keys {
+		offsets[i] = uint64(buf.Len())
+		binary.Write(&buf, binary.LittleEndian, uint32(len(keys[i])))
+		buf.WriteString(keys[i])
+		binary.Write(&buf, binary.LittleEndian, uint32(len(values[i])))
+		buf.WriteString(values[i])
+	}
+	indexStart := uint64(buf.Len())
+	binary.Write(&buf, binary.LittleEndian, uint32(len(keys)))
+	for i := range keys {
+		binary.Write(&buf, binary.LittleEndian, uint32(len(keys[i])))
+		buf.WriteString(keys[i])
+		binary.Write(&buf, binary.LittleEndian, offsets[i])
+	}
+	binary.Write(&buf, binary.LittleEndian, indexStart)
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write legacy table: %v", err)
+	}
+}
+
+func TestSSTableEngine_ReadsTablesWithoutFilter(t *testing.T) {
+	testDir := t.TempDir()
+	writeLegacyTable(t, filepath.Join(testDir, "sstable_0001.sst"),
+		[]string{"a", "b"}, []string{"value-a", "value-b"})
+
+	engine, err := NewSSTableEngine(testDir, filepath.Join(testDir, "wal.txt"))
+	if err != nil {
+		t.Fatalf("Failed to create SSTable engine: %v", err)
+	}
+	defer engine.DestroySSTableEngine()
+
+	for key, want := range map[string]string{"a": "value-a", "b": "value-b"} {
+		value, found, err := engine.Get([]byte(key))
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if !found || string(value) != want {
+			t.Errorf("Expected %s=%s, got '%s' (found=%v)", key, want, value, found)
+		}
+	}
+
+	// Compaction rewrites the table in the current format
+	if err := engine.Put([]byte("c"), []byte("value-c")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := engine.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if err := engine.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if value, found, _ := engine.Get([]byte("a")); !found || string(value) != "value-a" {
+		t.Errorf("Expected a=value-a after compaction, got '%s' (found=%v)", value, found)
+	}
+}
+
+func TestSSTableEngine_BlockFormat(t *testing.T) {
+	testDir := t.TempDir()
+	opts := DefaultOptions()
+	opts.BlockSize = 256
+	engine, err := NewSSTableEngineWithOptions(testDir, filepath.Join(testDir, "wal.txt"), opts)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable engine: %v", err)
+	}
+	defer engine.DestroySSTableEngine()
+
+	// Enough keys to fill many blocks, plus a value larger than a block
+	const numKeys = 500
+	for i := 0; i < numKeys; i++ {
+		if err := engine.Put([]byte(fmt.Sprintf("key-%04d", i)), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	large := bytes.Repeat([]byte("x"), 1000)
+	if err := engine.Put([]byte("key-0250a"), large); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := engine.Delete([]byte("key-0100")); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := engine.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	for i := 0; i < numKeys; i++ {
+		value, found, err := engine.Get([]byte(fmt.Sprintf("key-%04d", i)))
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if i == 100 {
+			if found {
+				t.Errorf("Deleted key found")
+			}
+			continue
+		}
+		if !found || string(value) != fmt.Sprintf("value-%d", i) {
+			t.Fatalf("Expected value-%d, got '%s' (found=%v)", i, value, found)
+		}
+	}
+	if value, found, _ := engine.Get([]byte("key-0250a")); !found || !bytes.Equal(value, large) {
+		t.Errorf("Large value not read back (found=%v, len=%d)", found, len(value))
+	}
+	for _, key := range []string{"a", "key-0000a", "key-0499a", "zzz"} {
+		if _, found, _ := engine.Get([]byte(key)); found {
+			t.Errorf("Found %q, which was never written", key)
+		}
+	}
+
+	// The file ends with a footer carrying the format version and magic
+	data, err := os.ReadFile(filepath.Join(testDir, "sstable_0001.sst"))
+	if err != nil {
+		t.Fatalf("Failed to read table: %v", err)
+	}
+	if magic := binary.LittleEndian.Uint64(data[len(data)-8:]); magic != 0x3242545353544c42 {
+		t.Errorf("Unexpected footer magic %#x", magic)
+	}
+	if version := binary.LittleEndian.Uint32(data[len(data)-12:]); version != 6 {
+		t.Errorf("Expected format version 6, got %d", version)
+	}
+}
+
+func TestSSTableEngine_BlockCompression(t *testing.T) {
+	testDir := t.TempDir()
+	walPath := filepath.Join(testDir, "wal.txt")
+	jsonValue := func(i int) []byte {
+		return []byte(fmt.Sprintf(`{"id":%d,"name":"user-%d","active":true,"tags":["a","b","c"]}`, i, i))
+	}
+
+	opts := DefaultOptions()
+	opts.Compression = CompressionZlib
+	engine, err := NewSSTableEngineWithOptions(testDir, walPath, opts)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable engine: %v", err)
+	}
+	for i := 0; i < 200; i++ {
+		if err := engine.Put([]byte(fmt.Sprintf("key-%04d", i)), jsonValue(i)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := engine.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	engine.DestroySSTableEngine()
+
+	// The first block of the table records the zlib codec
+	data, err := os.ReadFile(filepath.Join(testDir, "sstable_0001.sst"))
+	if err != nil {
+		t.Fatalf("Failed to read table: %v", err)
+	}
+	if data[0] != 1 {
+		t.Errorf("Expected the first block to be zlib compressed, got codec %d", data[0])
+	}
+	rawSize := 0
+	for i := 0; i < 200; i++ {
+		rawSize += len(jsonValue(i))
+	}
+	if len(data) >= rawSize {
+		t.Errorf("Compressed table (%d bytes) is not smaller than its values (%d bytes)", len(data), rawSize)
+	}
+
+	// Reopen without compression; both codecs live in the same directory
+	opts.Compression = CompressionNone
+	engine, err = NewSSTableEngineWithOptions(testDir, walPath, opts)
+	if err != nil {
+		t.Fatalf("Failed to reopen SSTable engine: %v", err)
+	}
+	defer engine.DestroySSTableEngine()
+	for i := 200; i < 400; i++ {
+		if err := engine.Put([]byte(fmt.Sprintf("key-%04d", i)), jsonValue(i)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := engine.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	check := func() {
+		t.Helper()
+		for i := 0; i < 400; i++ {
+			value, found, err := engine.Get([]byte(fmt.Sprintf("key-%04d", i)))
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			if !found || !bytes.Equal(value, jsonValue(i)) {
+				t.Fatalf("Expected %s, got '%s' (found=%v)", jsonValue(i), value, found)
+			}
+		}
+	}
+	check()
+
+	// Compaction reads both codecs and rewrites with the current one
+	if err := engine.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	check()
+}
+
+func TestParseCompression(t *testing.T) {
+	for name, want := range map[string]Compression{"": CompressionNone, "none": CompressionNone, "zlib": CompressionZlib} {
+		got, err := ParseCompression(name)
+		if err != nil || got != want {
+			t.Errorf("ParseCompression(%q) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if _, err := ParseCompression("lz4"); err == nil {
+		t.Errorf("Expected an error for an unsupported codec")
+	}
+}
+
+func TestParseBackend(t *testing.T) {
+	for name, want := range map[string]Backend{"": DefaultBackend(), "go": BackendGo} {
+		got, err := ParseBackend(name)
+		if err != nil || got != want {
+			t.Errorf("ParseBackend(%q) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if _, err := ParseBackend("cpp"); (err == nil) != cgoEnabled {
+		t.Errorf("ParseBackend(\"cpp\") = %v with cgo enabled = %v", err, cgoEnabled)
+	}
+	if _, err := ParseBackend("rocksdb"); err == nil {
+		t.Errorf("Expected an error for an unknown backend")
+	}
+}
+
+// openDeletedFiles lists files under dir that this process still holds open
+// after they were deleted. It returns nil where /proc is unavailable.
+func openDeletedFiles(dir string) []string {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return nil
+	}
+	var deleted []string
+	for _, fd := range fds {
+		target, err := os.Readlink(filepath.Join("/proc/self/fd", fd.Name()))
+		if err == nil && strings.HasPrefix(target, dir) && strings.HasSuffix(target, "(deleted)") {
+			deleted = append(deleted, target)
+		}
+	}
+	return deleted
+}
+
+func TestSSTableEngine_TableCache(t *testing.T) {
+	testDir := t.TempDir()
+	opts := DefaultOptions()
+	opts.MaxOpenTables = 2
+	engine, err := NewSSTableEngineWithOptions(testDir, filepath.Join(testDir, "wal.txt"), opts)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable engine: %v", err)
+	}
+	defer engine.DestroySSTableEngine()
+
+	// Three tables with disjoint key ranges, fewer than a size tier
+	for table := 0; table < 3; table++ {
+		if err := engine.Put([]byte(fmt.Sprintf("t%d-key", table)), []byte(fmt.Sprintf("v%d", table))); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := engine.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+
+	get := func(table int) {
+		t.Helper()
+		value, found, err := engine.Get([]byte(fmt.Sprintf("t%d-key", table)))
+		if err != nil || !found || string(value) != fmt.Sprintf("v%d", table) {
+			t.Fatalf("Expected v%d, got '%s' (found=%v, err=%v)", table, value, found, err)
+		}
+	}
+
+	// Repeated reads of one table hit the cache
+	for i := 0; i < 5; i++ {
+		get(0)
+	}
+	stats, err := engine.Stats()
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.TableCacheMisses != 1 || stats.TableCacheHits != 4 {
+		t.Errorf("Expected 1 miss and 4 hits, got %d misses and %d hits", stats.TableCacheMisses, stats.TableCacheHits)
+	}
+
+	// Cycling through more tables than the cache holds evicts the oldest
+	get(1)
+	get(2)
+	get(0)
+	stats, err = engine.Stats()
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.TableCacheMisses != 4 {
+		t.Errorf("Expected table 0 to be evicted and reopened, got %d misses", stats.TableCacheMisses)
+	}
+
+	// Compaction deletes the inputs and their cache entries with them
+	if err := engine.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if deleted := openDeletedFiles(testDir); len(deleted) != 0 {
+		t.Errorf("Deleted tables are still held open: %v", deleted)
+	}
+	for table := 0; table < 3; table++ {
+		get(table)
+	}
+}
+
+func TestSSTableEngine_BlockCache(t *testing.T) {
+	testDir := t.TempDir()
+	opts := DefaultOptions()
+	opts.BlockSize = 1024
+	opts.BlockCacheBytes = 4 * 1024
+	engine, err := NewSSTableEngineWithOptions(testDir, filepath.Join(testDir, "wal.txt"), opts)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable engine: %v", err)
+	}
+	defer engine.DestroySSTableEngine()
+
+	value := bytes.Repeat([]byte("v"), 100)
+	for i := 0; i < 200; i++ {
+		if err := engine.Put([]byte(fmt.Sprintf("key-%04d", i)), value); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := engine.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	get := func(i int) {
+		t.Helper()
+		got, found, err := engine.Get([]byte(fmt.Sprintf("key-%04d", i)))
+		if err != nil || !found || !bytes.Equal(got, value) {
+			t.Fatalf("key-%04d not read back (found=%v, err=%v)", i, found, err)
+		}
+	}
+
+	// The first read of a block misses, the rest hit
+	for i := 0; i < 5; i++ {
+		get(0)
+	}
+	stats, err := engine.Stats()
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.BlockCacheMisses != 1 || stats.BlockCacheHits != 4 {
+		t.Errorf("Expected 1 miss and 4 hits, got %d misses and %d hits", stats.BlockCacheMisses, stats.BlockCacheHits)
+	}
+	if stats.BlockCacheCapacity != 4*1024 {
+		t.Errorf("Expected a 4096 byte budget, got %d", stats.BlockCacheCapacity)
+	}
+
+	// Reading every block keeps usage within the budget
+	for i := 0; i < 200; i++ {
+		get(i)
+	}
+	stats, err = engine.Stats()
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.BlockCacheUsage == 0 || stats.BlockCacheUsage > stats.BlockCacheCapacity {
+		t.Errorf("Expected usage within (0, %d], got %d", stats.BlockCacheCapacity, stats.BlockCacheUsage)
+	}
+
+	// Compacted tables leave the cache with their files
+	if err := engine.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	stats, err = engine.Stats()
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.BlockCacheUsage != 0 {
+		t.Errorf("Expected an empty cache after compaction, got %d bytes", stats.BlockCacheUsage)
+	}
+	get(199)
+}
+
+func TestSSTableEngine_BlockCacheDisabled(t *testing.T) {
+	testDir := t.TempDir()
+	opts := DefaultOptions()
+	opts.BlockCacheBytes = -1
+	engine, err := NewSSTableEngineWithOptions(testDir, filepath.Join(testDir, "wal.txt"), opts)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable engine: %v", err)
+	}
+	defer engine.DestroySSTableEngine()
+
+	if err := engine.Put([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := engine.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if value, found, _ := engine.Get([]byte("k")); !found || string(value) != "v" {
+			t.Fatalf("Expected k=v, got '%s' (found=%v)", value, found)
+		}
+	}
+
+	stats, err := engine.Stats()
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.BlockCacheHits != 0 || stats.BlockCacheUsage != 0 || stats.BlockCacheCapacity != 0 {
+		t.Errorf("Expected an unused cache, got %+v", stats)
+	}
+}
+
+func expectValues(t *testing.T, engine *SSTableEngine, want map[string]string) {
+	t.Helper()
+	for key, expected := range want {
+		value, found, err := engine.Get([]byte(key))
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if !found || string(value) != expected {
+			t.Errorf("Expected %s=%s, got '%s' (found=%v)", key, expected, value, found)
+		}
+	}
+}
+
+func TestSSTableEngine_ManifestRecovery(t *testing.T) {
+	testDir := t.TempDir()
+	walPath := filepath.Join(testDir, "wal.txt")
+	engine, err := NewSSTableEngine(testDir, walPath)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable engine: %v", err)
+	}
+
+	// Compaction leaves gaps in the file numbers; recovery must not stop at
+	// the first one
+	want := make(map[string]string)
+	for i := 0; i < 6; i++ {
+		key, value := fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)
+		if err := engine.Put([]byte(key), []byte(value)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		want[key] = value
+		if err := engine.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		if i == 2 {
+			if err := engine.Compact(); err != nil {
+				t.Fatalf("Compact failed: %v", err)
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(testDir, "sstable_0001.sst")); !os.IsNotExist(err) {
+		t.Fatalf("Expected compacted table 1 to be gone, got %v", err)
+	}
+	engine.DestroySSTableEngine()
+
+	// A torn final record and a table no record lists are both left over
+	// from an edit that never committed
+	manifest := filepath.Join(testDir, "MANIFEST")
+	f, err := os.OpenFile(manifest, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open MANIFEST: %v", err)
+	}
+	if _, err := f.Write([]byte{200, 0, 0, 0, 1, 2, 3, 4, 5}); err != nil {
+		t.Fatalf("Failed to append to MANIFEST: %v", err)
+	}
+	f.Close()
+	orphan := filepath.Join(testDir, "sstable_0099.sst")
+	if err := os.WriteFile(orphan, []byte("partial"), 0644); err != nil {
+		t.Fatalf("Failed to write orphan table: %v", err)
+	}
+
+	engine, err = NewSSTableEngine(testDir, walPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen SSTable engine: %v", err)
+	}
+	expectValues(t, engine, want)
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("Expected orphaned table to be removed, got %v", err)
+	}
+
+	// New tables must not reuse the orphan's file number
+	if err := engine.Put([]byte("after"), []byte("reopen")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := engine.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(testDir, "sstable_0100.sst")); err != nil {
+		t.Errorf("Expected the flush to write table 100: %v", err)
+	}
+	engine.DestroySSTableEngine()
+
+	engine, err = NewSSTableEngine(testDir, walPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen SSTable engine: %v", err)
+	}
+	defer engine.DestroySSTableEngine()
+	want["after"] = "reopen"
+	expectValues(t, engine, want)
+}
+
+func TestSSTableEngine_ManifestMissingTable(t *testing.T) {
+	testDir := t.TempDir()
+	walPath := filepath.Join(testDir, "wal.txt")
+	engine, err := NewSSTableEngine(testDir, walPath)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable engine: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := engine.Put([]byte(fmt.Sprintf("key%d", i)), []byte("v")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := engine.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	engine.DestroySSTableEngine()
+
+	// Losing a committed table is corruption, not something to skip over
+	if err := os.Remove(filepath.Join(testDir, "sstable_0001.sst")); err != nil {
+		t.Fatalf("Failed to remove table: %v", err)
+	}
+	if _, err := NewSSTableEngine(testDir, walPath); err == nil {
+		t.Fatal("Expected opening with a missing table to fail")
+	}
+}
+
+func TestSSTableEngine_GetFailsOnUnreadableTable(t *testing.T) {
+	snapshotBackends(t, func(t *testing.T, opts Options) {
+		testDir := t.TempDir()
+		walPath := filepath.Join(testDir, "wal.txt")
+		engine, err := NewSSTableEngineWithOptions(testDir, walPath, opts)
+		if err != nil {
+			t.Fatalf("Failed to create SSTable engine: %v", err)
+		}
+		for _, value := range []string{"old", "new"} {
+			if err := engine.Put([]byte("key"), []byte(value)); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+			if err := engine.Flush(); err != nil {
+				t.Fatalf("Flush failed: %v", err)
+			}
+		}
+		engine.DestroySSTableEngine()
+
+		// The table holding the newest version cannot be opened, so Get
+		// must not fall back to the older one
+		if err := os.WriteFile(filepath.Join(testDir, "sstable_0002.sst"), []byte("garbage"), 0644); err != nil {
+			t.Fatalf("Failed to damage table: %v", err)
+		}
+		engine, err = NewSSTableEngineWithOptions(testDir, walPath, opts)
+		if err != nil {
+			t.Fatalf("Failed to reopen SSTable engine: %v", err)
+		}
+		defer engine.DestroySSTableEngine()
+		if value, found, err := engine.Get([]byte("key")); err == nil {
+			t.Fatalf("Expected Get to fail, got %q, %v", value, found)
+		}
+	})
+}
+
+func TestSSTableEngine_MigratesTablesFile(t *testing.T) {
+	testDir := t.TempDir()
+	walPath := filepath.Join(testDir, "wal.txt")
+	engine, err := NewSSTableEngine(testDir, walPath)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable engine: %v", err)
+	}
+	want := map[string]string{"a": "old", "b": "value-b"}
+	for _, kv := range [][2]string{{"a", "old"}, {"b", "value-b"}} {
+		if err := engine.Put([]byte(kv[0]), []byte(kv[1])); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := engine.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	engine.DestroySSTableEngine()
+
+	// Rewrite the directory the way the previous release recorded it
+	if err := os.Remove(filepath.Join(testDir, "MANIFEST")); err != nil {
+		t.Fatalf("Failed to remove MANIFEST: %v", err)
+	}
+	tables := "counter 2 2\n0 1 1\n0 2 2\n"
+	if err := os.WriteFile(filepath.Join(testDir, "TABLES"), []byte(tables), 0644); err != nil {
+		t.Fatalf("Failed to write TABLES: %v", err)
+	}
+
+	engine, err = NewSSTableEngine(testDir, walPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen SSTable engine: %v", err)
+	}
+	defer engine.DestroySSTableEngine()
+	expectValues(t, engine, want)
+	if _, err := os.Stat(filepath.Join(testDir, "MANIFEST")); err != nil {
+		t.Errorf("Expected a MANIFEST after migration: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(testDir, "TABLES")); !os.IsNotExist(err) {
+		t.Errorf("Expected TABLES to be removed, got %v", err)
+	}
+}
+
+func TestSSTableEngine_FlushCrashRecovery(t *testing.T) {
+	steps := []struct {
+		name   string
+		point  int
+		tables uint64 // tables live after recovery
+	}{
+		{"AfterWrite", flushCrashAfterWrite, 1},
+		{"AfterSync", flushCrashAfterSync, 1},
+		{"AfterRename", flushCrashAfterRename, 1},
+		{"AfterDirSync", flushCrashAfterDirSync, 1},
+		{"BeforeWALRetire", flushCrashBeforeWALRetire, 2},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			testDir := t.TempDir()
+			walPath := filepath.Join(testDir, "wal.txt")
+			engine, err := NewSSTableEngine(testDir, walPath)
+			if err != nil {
+				t.Fatalf("Failed to create SSTable engine: %v", err)
+			}
+
+			// One committed table, then writes that only the crashing
+			// flush and the WAL hold
+			for _, key := range []string{"flushed", "shadowed"} {
+				if err := engine.Put([]byte(key), []byte("old")); err != nil {
+					t.Fatalf("Put failed: %v", err)
+				}
+			}
+			if err := engine.Flush(); err != nil {
+				t.Fatalf("Flush failed: %v", err)
+			}
+			if err := engine.Put([]byte("shadowed"), []byte("new")); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+			if err := engine.Put([]byte("pending"), []byte("value")); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+			if err := engine.Delete([]byte("flushed")); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+
+			engine.setFlushCrashPoint(step.point)
+			if err := engine.Flush(); err == nil {
+				t.Fatal("Expected the flush to stop at the crash point")
+			}
+			if step.point == flushCrashAfterWrite {
+				// Unsynced data may not have reached the disk at all
+				tmp := filepath.Join(testDir, "sstable_0002.sst.tmp")
+				info, err := os.Stat(tmp)
+				if err != nil {
+					t.Fatalf("Expected a temporary table: %v", err)
+				}
+				if err := os.Truncate(tmp, info.Size()/2); err != nil {
+					t.Fatalf("Failed to truncate temporary table: %v", err)
+				}
+			}
+			engine.DestroySSTableEngine()
+
+			// Recovery must see every acknowledged write exactly once, from
+			// either the table or the WAL
+			check := func(engine *SSTableEngine) {
+				t.Helper()
+				expectValues(t, engine, map[string]string{"shadowed": "new", "pending": "value"})
+				if _, found, _ := engine.Get([]byte("flushed")); found {
+					t.Error("Expected deleted key to stay deleted")
+				}
+				matches, _ := filepath.Glob(filepath.Join(testDir, "*.tmp"))
+				if len(matches) != 0 {
+					t.Errorf("Expected temporary files to be removed, found %v", matches)
+				}
+			}
+
+			engine, err = NewSSTableEngine(testDir, walPath)
+			if err != nil {
+				t.Fatalf("Failed to reopen SSTable engine: %v", err)
+			}
+			check(engine)
+			stats, err := engine.Stats()
+			if err != nil {
+				t.Fatalf("Stats failed: %v", err)
+			}
+			if stats.SSTables != step.tables {
+				t.Errorf("Expected %d live tables after recovery, got %d", step.tables, stats.SSTables)
+			}
+
+			// The recovered engine flushes normally and survives a clean restart
+			if err := engine.Flush(); err != nil {
+				t.Fatalf("Flush after recovery failed: %v", err)
+			}
+			engine.DestroySSTableEngine()
+			engine, err = NewSSTableEngine(testDir, walPath)
+			if err != nil {
+				t.Fatalf("Failed to reopen SSTable engine: %v", err)
+			}
+			defer engine.DestroySSTableEngine()
+			check(engine)
+		})
+	}
+}
+
+// fillMemtables writes n keys with 4 KB values, enough for about one sealed
+// memtable per 250 keys
+func fillMemtables(engine *SSTableEngine, n int) (map[string]string, error) {
+	want := make(map[string]string)
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%04d", i)
+		value := strings.Repeat(string(rune('a'+i%26)), 4096)
+		if err := engine.Put([]byte(key), []byte(value)); err != nil {
+			return want, err
+		}
+		want[key] = value
+	}
+	return want, nil
+}
+
+func walSegments(t *testing.T, walPath string) []string {
+	t.Helper()
+	segments, _, err := listWALSegments(walPath)
+	if err != nil {
+		t.Fatalf("Failed to list WAL segments: %v", err)
+	}
+	return segments
+}
+
+func TestSSTableEngine_BackgroundFlush(t *testing.T) {
+	engine, testDir := setupLeveledEngine(t, DefaultOptions())
+	defer engine.DestroySSTableEngine()
+	walPath := filepath.Join(testDir, "wal.txt")
+
+	want, err := fillMemtables(engine, 300)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	// Sealed data is readable whether or not it has been flushed yet
+	expectValues(t, engine, want)
+
+	waitForStats(t, engine, func(s EngineStats) bool {
+		return s.SSTables >= 1 && s.ImmutableMemtables == 0
+	})
+	expectValues(t, engine, want)
+
+	// Segments are retired just after the table is committed
+	deadline := time.Now().Add(5 * time.Second)
+	for segments := walSegments(t, walPath); len(segments) != 0; segments = walSegments(t, walPath) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected flushed WAL segments to be removed, found %v", segments)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// A seal that cannot start a new WAL still hands the full memtable and its
+// segment to the flush, and a later write starts the WAL instead
+func TestSSTableEngine_SealWithoutNewWAL(t *testing.T) {
+	engine, testDir := setupLeveledEngine(t, DefaultOptions())
+	walPath := filepath.Join(testDir, "wal.txt")
+
+	fault := errors.New("injected WAL open failure")
+	engine.mu.Lock()
+	engine.walOpenFault = fault
+	engine.mu.Unlock()
+	want, err := fillMemtables(engine, 300)
+	if !errors.Is(err, fault) {
+		t.Fatalf("Expected the seal to fail with the injected error, got %v", err)
+	}
+	if err := engine.Put([]byte("later"), []byte("v")); !errors.Is(err, fault) {
+		t.Fatalf("Expected a write without a WAL to fail, got %v", err)
+	}
+
+	engine.mu.Lock()
+	engine.walOpenFault = nil
+	engine.mu.Unlock()
+	if err := engine.Put([]byte("later"), []byte("v")); err != nil {
+		t.Fatalf("Put failed once the WAL could be opened: %v", err)
+	}
+	want["later"] = "v"
+	if err := engine.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	expectValues(t, engine, want)
+	if segments := walSegments(t, walPath); len(segments) != 0 {
+		t.Errorf("Expected the sealed segment to be retired by the flush, found %v", segments)
+	}
+
+	engine.DestroySSTableEngine()
+	engine, err = NewSSTableEngineWithOptions(testDir, walPath, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Failed to reopen engine: %v", err)
+	}
+	defer engine.DestroySSTableEngine()
+	expectValues(t, engine, want)
+}
+
+func TestSSTableEngine_WriteStall(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxImmutableMemtables = 1
+	engine, _ := setupLeveledEngine(t, opts)
+	defer engine.DestroySSTableEngine()
+
+	// Hold up the background flush so sealed memtables pile up
+	engine.flushMu.Lock()
+	done := make(chan error, 1)
+	var want map[string]string
+	go func() {
+		var err error
+		want, err = fillMemtables(engine, 600)
+		done <- err
+	}()
+
+	stats := waitForStats(t, engine, func(s EngineStats) bool { return s.WriteStalls > 0 })
+	if stats.ImmutableMemtables != 1 {
+		t.Errorf("Expected writes to stall at 1 immutable memtable, got %d", stats.ImmutableMemtables)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Expected writes to stall, but they finished: %v", err)
+	default:
+	}
+
+	engine.flushMu.Unlock()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Writes stayed stalled after the flush resumed")
+	}
+	expectValues(t, engine, want)
+}
+
+func TestSSTableEngine_RecoversSealedMemtables(t *testing.T) {
+	testDir := t.TempDir()
+	walPath := filepath.Join(testDir, "wal.txt")
+	engine, err := NewSSTableEngine(testDir, walPath)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable engine: %v", err)
+	}
+
+	engine.flushMu.Lock()
+	want, err := fillMemtables(engine, 300)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if segments := walSegments(t, walPath); len(segments) != 1 {
+		t.Fatalf("Expected one sealed WAL segment, found %v", segments)
+	}
+
+	// The background flush dies half way through; later writes fail rather
+	// than pile up behind it
+	engine.setFlushCrashPoint(flushCrashAfterWrite)
+	engine.flushMu.Unlock()
+	deadline := time.Now().Add(5 * time.Second)
+	for engine.Put([]byte("probe"), []byte("v")) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected writes to fail after the background flush failed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	engine.DestroySSTableEngine()
+
+	// Both the sealed segment and the active WAL are replayed
+	engine, err = NewSSTableEngine(testDir, walPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen SSTable engine: %v", err)
+	}
+	defer engine.DestroySSTableEngine()
+	expectValues(t, engine, want)
+
+	if err := engine.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if segments := walSegments(t, walPath); len(segments) != 0 {
+		t.Errorf("Expected recovered WAL segments to be retired, found %v", segments)
+	}
+	expectValues(t, engine, want)
+}
+
+// Run with -race: writers, readers, iterators, flushes and compactions all
+// share one engine
+func TestSSTableEngine_ConcurrentStress(t *testing.T) {
+	testDir := t.TempDir()
+	walPath := filepath.Join(testDir, "wal.txt")
+	engine, err := NewSSTableEngine(testDir, walPath)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable engine: %v", err)
+	}
+
+	const writers = 8
+	const opsPerWriter = 400
+	errs := make(chan error, writers+3)
+	models := make([]map[string]string, writers)
+	stop := make(chan struct{})
+
+	// Each writer owns its keys, so it can check that it reads its own
+	// writes while every goroutine also hammers one shared key
+	var writersWG sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		models[w] = make(map[string]string)
+		writersWG.Add(1)
+		go func(w int) {
+			defer writersWG.Done()
+			rng := rand.New(rand.NewSource(int64(w)))
+			model := models[w]
+			for i := 0; i < opsPerWriter; i++ {
+				key := fmt.Sprintf("w%d-k%03d", w, rng.Intn(50))
+				switch op := rng.Intn(10); {
+				case op < 6:
+					value := fmt.Sprintf("%s-%d-%s", key, i, strings.Repeat("x", rng.Intn(2048)))
+					if err := engine.Put([]byte(key), []byte(value)); err != nil {
+						errs <- fmt.Errorf("Put %s: %v", key, err)
+						return
+					}
+					model[key] = value
+				case op < 8:
+					if err := engine.Delete([]byte(key)); err != nil {
+						errs <- fmt.Errorf("Delete %s: %v", key, err)
+						return
+					}
+					delete(model, key)
+				default:
+					value, found, err := engine.Get([]byte(key))
+					want, exists := model[key]
+					if err != nil || found != exists || string(value) != want {
+						errs <- fmt.Errorf("Get %s: got found=%v err=%v, want found=%v", key, found, err, exists)
+						return
+					}
+				}
+				if err := engine.Put([]byte("shared"), []byte(key)); err != nil {
+					errs <- fmt.Errorf("Put shared: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+
+	var background sync.WaitGroup
+	run := func(name string, interval time.Duration, fn func() error) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-time.After(interval):
+				}
+				if err := fn(); err != nil {
+					errs <- fmt.Errorf("%s: %v", name, err)
+					return
+				}
+			}
+		}()
+	}
+	run("Flush", 20*time.Millisecond, engine.Flush)
+	run("Compact", 50*time.Millisecond, engine.Compact)
+	run("scan", time.Millisecond, func() error {
+		it, err := engine.NewIterator()
+		if err != nil {
+			return err
+		}
+		defer it.Close()
+		var prev []byte
+		for it.SeekToFirst(); it.Valid(); it.Next() {
+			if prev != nil && bytes.Compare(prev, it.Key()) >= 0 {
+				return fmt.Errorf("keys out of order: %q then %q", prev, it.Key())
+			}
+			prev = it.Key()
+		}
+		if _, _, err := engine.Get([]byte("shared")); err != nil {
+			return err
+		}
+		_, err = engine.Stats()
+		return err
+	})
+
+	writersWG.Wait()
+	close(stop)
+	background.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if t.Failed() {
+		engine.DestroySSTableEngine()
+		return
+	}
+
+	verify := func(engine *SSTableEngine) {
+		t.Helper()
+		live := 1 // the shared key
+		for w := 0; w < writers; w++ {
+			expectValues(t, engine, models[w])
+			live += len(models[w])
+			for i := 0; i < 50; i++ {
+				key := fmt.Sprintf("w%d-k%03d", w, i)
+				if _, exists := models[w][key]; !exists {
+					if _, found, _ := engine.Get([]byte(key)); found {
+						t.Errorf("Expected deleted key %s to stay deleted", key)
+					}
+				}
+			}
+		}
+		it, err := engine.NewIterator()
+		if err != nil {
+			t.Fatalf("NewIterator failed: %v", err)
+		}
+		defer it.Close()
+		count := 0
+		for it.SeekToFirst(); it.Valid(); it.Next() {
+			count++
+		}
+		if count != live {
+			t.Errorf("Expected %d live keys, iterated %d", live, count)
+		}
+	}
+	verify(engine)
+
+	// Everything acknowledged survives a restart
+	engine.DestroySSTableEngine()
+	engine, err = NewSSTableEngine(testDir, walPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen SSTable engine: %v", err)
+	}
+	defer engine.DestroySSTableEngine()
+	verify(engine)
+}
+
+func TestSSTableEngine_DestroyWaitsForOperations(t *testing.T) {
+	engine := setupTestEngine(t)
+	defer cleanupTestEngine(t, engine)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				key := []byte(fmt.Sprintf("g%d-%d", g, i))
+				if err := engine.Put(key, key); err != nil {
+					return // the engine was destroyed
+				}
+				engine.Get(key)
+			}
+		}(g)
+	}
+	time.Sleep(50 * time.Millisecond)
+	engine.DestroySSTableEngine()
+	wg.Wait()
+
+	if _, _, err := engine.Get([]byte("g0-0")); err == nil {
+		t.Error("Expected operations on a destroyed engine to fail")
+	}
+}
+
+// Expiry times are kept through WAL replay, flushes and compactions, which
+// finally remove the expired values.
+func TestSSTableEngine_TTLSurvivesRestartAndCompaction(t *testing.T) {
+	snapshotBackends(t, func(t *testing.T, opts Options) {
+		dir := t.TempDir()
+		walPath := filepath.Join(dir, "wal.txt")
+		open := func() *SSTableEngine {
+			t.Helper()
+			engine, err := NewSSTableEngineWithOptions(dir, walPath, opts)
+			if err != nil {
+				t.Fatalf("Failed to open engine: %v", err)
+			}
+			return engine
+		}
+
+		engine := open()
+		engine.Put([]byte("shadowed"), []byte("old"))
+		engine.PutWithTTL([]byte("shadowed"), []byte("new"), time.Millisecond)
+		engine.PutWithTTL([]byte("short"), []byte("1"), time.Millisecond)
+		engine.PutWithTTL([]byte("long"), []byte("2"), time.Hour)
+		engine.Put([]byte("plain"), []byte("3"))
+		time.Sleep(10 * time.Millisecond)
+
+		check := func(stage string, engine *SSTableEngine) {
+			t.Helper()
+			expectValues(t, engine, map[string]string{"long": "2", "plain": "3"})
+			for _, key := range []string{"shadowed", "short"} {
+				if value, found, _ := engine.Get([]byte(key)); found {
+					t.Errorf("%s: expired key %s read as %q", stage, key, value)
+				}
+			}
+			it, err := engine.NewIterator()
+			if err != nil {
+				t.Fatalf("NewIterator failed: %v", err)
+			}
+			defer it.Close()
+			it.SeekToFirst()
+			expectEntries(t, stage, collect(it, true), []string{"long=2", "plain=3"})
+		}
+		check("memtable", engine)
+		engine.DestroySSTableEngine()
+
+		engine = open()
+		check("replayed", engine)
+		if err := engine.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		engine.DestroySSTableEngine()
+
+		engine = open()
+		defer engine.DestroySSTableEngine()
+		check("flushed", engine)
+		if err := engine.Compact(); err != nil {
+			t.Fatalf("Compact failed: %v", err)
+		}
+		check("compacted", engine)
+		stats, _ := engine.Stats()
+		// Reads found both keys expired once; after compaction they are
+		// plain tombstones
+		if stats.KeysExpired != 2 {
+			t.Errorf("Expected 2 lookups to find a key expired, got %d", stats.KeysExpired)
+		}
+		if stats.CompactionEntriesExpired != 2 {
+			t.Errorf("Expected 2 expired values removed, got %d", stats.CompactionEntriesExpired)
+		}
+		if stats.CompactionTombstonesDropped != 0 {
+			t.Errorf("Expected expirations not to count as tombstones, got %d", stats.CompactionTombstonesDropped)
+		}
+	})
+}
+
+// A range tombstone is kept through WAL replay, flushes and the MANIFEST,
+// and compactions remove what it covers, then the tombstone itself, once no
+// snapshot still reads below it.
+func TestSSTableEngine_DeleteRangeSurvivesRestartAndCompaction(t *testing.T) {
+	snapshotBackends(t, func(t *testing.T, opts Options) {
+		dir := t.TempDir()
+		walPath := filepath.Join(dir, "wal.txt")
+		open := func() *SSTableEngine {
+			t.Helper()
+			engine, err := NewSSTableEngineWithOptions(dir, walPath, opts)
+			if err != nil {
+				t.Fatalf("Failed to open engine: %v", err)
+			}
+			return engine
+		}
+
+		engine := open()
+		for _, key := range []string{"t1/a", "t1/b", "t2/a", "u/x"} {
+			engine.Put([]byte(key), []byte("old"))
+		}
+		if err := engine.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		engine.Put([]byte("t1/c"), []byte("old"))
+		snap, err := engine.Snapshot()
+		if err != nil {
+			t.Fatalf("Snapshot failed: %v", err)
+		}
+		if err := engine.DeleteRange([]byte("t1/"), []byte("t2/")); err != nil {
+			t.Fatalf("DeleteRange failed: %v", err)
+		}
+		engine.Put([]byte("t1/new"), []byte("new"))
+		expectSnapshotGet(t, snap, "t1/a", "old")
+		expectSnapshotGet(t, snap, "t1/c", "old")
+		snap.Release()
+
+		check := func(stage string, engine *SSTableEngine) {
+			t.Helper()
+			expectValues(t, engine, map[string]string{"t1/new": "new", "t2/a": "old", "u/x": "old"})
+			for _, key := range []string{"t1/a", "t1/b", "t1/c"} {
+				if value, found, _ := engine.Get([]byte(key)); found {
+					t.Errorf("%s: deleted key %s read as %q", stage, key, value)
+				}
+			}
+			it, err := engine.NewIterator()
+			if err != nil {
+				t.Fatalf("NewIterator failed: %v", err)
+			}
+			defer it.Close()
+			it.Seek([]byte("t1/"))
+			expectEntries(t, stage, collect(it, true), []string{"t1/new=new", "t2/a=old", "u/x=old"})
+		}
+		check("memtable", engine)
+		engine.DestroySSTableEngine()
+
+		engine = open()
+		check("replayed", engine)
+		if err := engine.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		engine.DestroySSTableEngine()
+
+		engine = open()
+		check("flushed", engine)
+		if err := engine.Compact(); err != nil {
+			t.Fatalf("Compact failed: %v", err)
+		}
+		check("compacted", engine)
+		stats, _ := engine.Stats()
+		if stats.CompactionEntriesDropped != 3 {
+			t.Errorf("Expected the 3 deleted values dropped, got %d", stats.CompactionEntriesDropped)
+		}
+
+		// A memtable holding only a range tombstone flushes to no table,
+		// and a snapshot keeps what the tombstone covers
+		snap, err = engine.Snapshot()
+		if err != nil {
+			t.Fatalf("Snapshot failed: %v", err)
+		}
+		engine.DeleteRange([]byte("u/"), []byte("v/"))
+		if err := engine.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		if after, _ := engine.Stats(); after.SSTables != stats.SSTables {
+			t.Errorf("Expected %d tables after flushing a range tombstone, got %d", stats.SSTables, after.SSTables)
+		}
+		if err := engine.Compact(); err != nil {
+			t.Fatalf("Compact failed: %v", err)
+		}
+		expectSnapshotGet(t, snap, "u/x", "old")
+		snap.Release()
+		if err := engine.Compact(); err != nil {
+			t.Fatalf("Compact failed: %v", err)
+		}
+		expectValues(t, engine, map[string]string{"t1/new": "new", "t2/a": "old"})
+		if _, found, _ := engine.Get([]byte("u/x")); found {
+			t.Errorf("Deleted key u/x found")
+		}
+		stats, _ = engine.Stats()
+		if stats.CompactionEntriesDropped != 4 {
+			t.Errorf("Expected 4 deleted values dropped in all, got %d", stats.CompactionEntriesDropped)
+		}
+		engine.DestroySSTableEngine()
+
+		// Both tombstones are gone from the MANIFEST, which either backend
+		// reads
+		core, err := newGoEngine(dir, opts)
+		if err != nil {
+			t.Fatalf("Failed to open the data directory: %v", err)
+		}
+		defer core.close()
+		if tombstones := core.(*goEngine).current.rangeDels; len(tombstones) != 0 {
+			t.Errorf("Expected no range tombstones left, got %+v", tombstones)
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package storage
+
+import "time"
+
+// EngineStats is a point-in-time snapshot of an engine's table and
+// compaction counters. Compaction counters are cumulative since the engine
+// was opened.
+type EngineStats struct {
+	SSTables      uint64
+	SSTableBytes  uint64
+	MemtableBytes uint64
+
+	// Full memtables waiting for the background flush, and writes that had
+	// to wait because too many were waiting.
+	ImmutableMemtables uint64
+	WriteStalls        uint64
+
+	Compactions                 uint64
+	CompactionBytesRead         uint64
+	CompactionBytesWritten      uint64
+	CompactionEntriesDropped    uint64
+	CompactionTombstonesDropped uint64
+	CompactionTime              time.Duration
+
+	// Values compactions removed because their TTL had passed.
+	CompactionEntriesExpired uint64
+
+	// Point lookups that found the key's value expired, before a compaction
+	// removed it. Redis reports its expired_keys here, which also counts
+	// keys it expired in the background.
+	KeysExpired uint64
+
+	// Point lookups where a table's Bloom filter matched the key, so the
+	// table was searched, or ruled it out, so the table was skipped.
+	FilterHits   uint64
+	FilterMisses uint64
+
+	// Table lookups served by an already open table, or that had to open
+	// the file and load its index and filter.
+	TableCacheHits   uint64
+	TableCacheMisses uint64
+
+	// Data block reads served from the block cache or from disk, and the
+	// bytes of cached blocks out of the configured budget.
+	BlockCacheHits     uint64
+	BlockCacheMisses   uint64
+	BlockCacheUsage    uint64
+	BlockCacheCapacity uint64
+}
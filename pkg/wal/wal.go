@@ -49,14 +49,56 @@ func (wal *WriteAheadLog) Close() error {
 	return err
 }
 
+// Record payloads start with an op type byte. Records written with a
+// sequence number set opSequenced in it and follow it with the number, and
+// sets that expire also set opExpiring and follow the number with the
+// expiry time. A range deletion stores the start of the range as its key
+// and the end as its value, and a merge stores its operand as the value:
+//
+//	<op type><u32 key length><u32 value length><key><value>
+//	<op type | opSequenced><u64 seq><u32 key length><u32 value length><key><value>
+//	<opSet | opSequenced | opExpiring><u64 seq><i64 expires at><u32 key length>...
+const (
+	opSet         = 0x01
+	opDelete      = 0x02
+	opDeleteRange = 0x03
+	opMerge       = 0x04
+	opExpiring    = 0x40
+	opSequenced   = 0x80
+)
+
 func SerializeOperation(operation string, key, value []byte) ([]byte, error) {
+	return serialize(operation, 0, false, 0, key, value)
+}
+
+// SerializeSequencedOperation is SerializeOperation for a write that carries
+// its sequence number, which replay hands back.
+func SerializeSequencedOperation(operation string, seq uint64, key, value []byte) ([]byte, error) {
+	return serialize(operation, seq, true, 0, key, value)
+}
+
+// SerializeExpiringSet is SerializeSequencedOperation for a set whose value
+// expires at expiresAt, in microseconds since the Unix epoch. An expiresAt
+// of 0 writes a set that never expires.
+func SerializeExpiringSet(seq uint64, expiresAt int64, key, value []byte) ([]byte, error) {
+	if expiresAt < 0 {
+		return nil, fmt.Errorf("invalid expiry time %d", expiresAt)
+	}
+	return serialize("set", seq, true, expiresAt, key, value)
+}
+
+func serialize(operation string, seq uint64, sequenced bool, expiresAt int64, key, value []byte) ([]byte, error) {
 	var opType byte
 
 	switch operation {
 	case "set":
-		opType = 0x01
+		opType = opSet
 	case "delete":
-		opType = 0x02
+		opType = opDelete
+	case "delete_range":
+		opType = opDeleteRange
+	case "merge":
+		opType = opMerge
 	default:
 		return nil, fmt.Errorf("unknown operation %q", operation)
 	}
@@ -65,8 +107,18 @@ func SerializeOperation(operation string, key, value []byte) ([]byte, error) {
 	valueLen := uint32(len(value))
 
 	// build payload
-	payload := make([]byte, 0, 1+4+4+len(key)+len(value))
-	payload = append(payload, opType)
+	payload := make([]byte, 0, 1+8+8+4+4+len(key)+len(value))
+	switch {
+	case expiresAt != 0:
+		payload = append(payload, opType|opSequenced|opExpiring)
+		payload = binary.BigEndian.AppendUint64(payload, seq)
+		payload = binary.BigEndian.AppendUint64(payload, uint64(expiresAt))
+	case sequenced:
+		payload = append(payload, opType|opSequenced)
+		payload = binary.BigEndian.AppendUint64(payload, seq)
+	default:
+		payload = append(payload, opType)
+	}
 
 	tmp := make([]byte, 4)
 	binary.BigEndian.PutUint32(tmp, keyLen)
@@ -91,8 +143,23 @@ func SerializeOperation(operation string, key, value []byte) ([]byte, error) {
 }
 
 func DeserializeOperation(entry []byte) (op string, key, value []byte, err error) {
+	op, _, key, value, err = DeserializeSequencedOperation(entry)
+	return op, key, value, err
+}
+
+// DeserializeSequencedOperation decodes a record written by either
+// SerializeOperation or SerializeSequencedOperation. seq is 0 for records
+// without a sequence number.
+func DeserializeSequencedOperation(entry []byte) (op string, seq uint64, key, value []byte, err error) {
+	op, seq, _, key, value, err = DeserializeExpiringOperation(entry)
+	return op, seq, key, value, err
+}
+
+// DeserializeExpiringOperation decodes any record, including those written
+// by SerializeExpiringSet. expiresAt is 0 for records that do not expire.
+func DeserializeExpiringOperation(entry []byte) (op string, seq uint64, expiresAt int64, key, value []byte, err error) {
 	if len(entry) < 13 {
-		return "", nil, nil, fmt.Errorf("entry too short")
+		return "", 0, 0, nil, nil, fmt.Errorf("entry too short")
 	}
 
 	recordLength := binary.BigEndian.Uint32(entry[0:4])
@@ -101,34 +168,56 @@ func DeserializeOperation(entry []byte) (op string, key, value []byte, err error
 	payload := entry[8:]
 
 	if uint32(len(payload)) != recordLength {
-		return "", nil, nil, fmt.Errorf("record length mismatch")
+		return "", 0, 0, nil, nil, fmt.Errorf("record length mismatch")
 	}
 
 	if crc32.ChecksumIEEE(payload) != check {
-		return "", nil, nil, fmt.Errorf("checksum mismatch")
+		return "", 0, 0, nil, nil, fmt.Errorf("checksum mismatch")
 	}
 
 	opType := payload[0]
-	keyLen := binary.BigEndian.Uint32(payload[1:5])
-	valLen := binary.BigEndian.Uint32(payload[5:9])
+	payload = payload[1:]
+	if opType&opSequenced != 0 {
+		if len(payload) < 16 {
+			return "", 0, 0, nil, nil, fmt.Errorf("entry too short")
+		}
+		seq = binary.BigEndian.Uint64(payload[0:8])
+		payload = payload[8:]
+		opType &^= opSequenced
+	}
+	if opType&opExpiring != 0 {
+		if len(payload) < 16 {
+			return "", 0, 0, nil, nil, fmt.Errorf("entry too short")
+		}
+		expiresAt = int64(binary.BigEndian.Uint64(payload[0:8]))
+		payload = payload[8:]
+		opType &^= opExpiring
+	}
+
+	keyLen := binary.BigEndian.Uint32(payload[0:4])
+	valLen := binary.BigEndian.Uint32(payload[4:8])
 
-	if int(keyLen)+int(valLen)+9 != len(payload) {
-		return "", nil, nil, fmt.Errorf("payload lengths inconsistent")
+	if int(keyLen)+int(valLen)+8 != len(payload) {
+		return "", 0, 0, nil, nil, fmt.Errorf("payload lengths inconsistent")
 	}
 
-	key = payload[9 : 9+keyLen]
-	value = payload[9+keyLen : 9+keyLen+valLen]
+	key = payload[8 : 8+keyLen]
+	value = payload[8+keyLen : 8+keyLen+valLen]
 
 	switch opType {
-	case 0x01:
+	case opSet:
 		op = "set"
-	case 0x02:
+	case opDelete:
 		op = "delete"
+	case opDeleteRange:
+		op = "delete_range"
+	case opMerge:
+		op = "merge"
 	default:
-		return "", nil, nil, fmt.Errorf("unknown op type")
+		return "", 0, 0, nil, nil, fmt.Errorf("unknown op type")
 	}
 
-	return op, key, value, nil
+	return op, seq, expiresAt, key, value, nil
 }
 
 func (wal *WriteAheadLog) Append(entry []byte) error {
@@ -71,3 +71,119 @@ func TestWrite(t *testing.T) {
         t.Errorf("Expected value %q, got %q", value, outValue)
     }
 }
+
+func TestSerializeBinaryRoundTrip(t *testing.T) {
+    key := []byte{0x00, 'k', 0x00, 0xff}
+    value := []byte{'v', 0x00, 0x00, 0x01}
+
+    entry, err := SerializeOperation("set", key, value)
+    if err != nil {
+        t.Fatalf("SerializeOperation failed: %v", err)
+    }
+
+    op, outKey, outValue, err := DeserializeOperation(entry)
+    if err != nil {
+        t.Fatalf("DeserializeOperation failed: %v", err)
+    }
+
+    if op != "set" {
+        t.Errorf("Expected op 'set', got %q", op)
+    }
+    if !bytes.Equal(outKey, key) {
+        t.Errorf("Expected key %x, got %x", key, outKey)
+    }
+    if !bytes.Equal(outValue, value) {
+        t.Errorf("Expected value %x, got %x", value, outValue)
+    }
+}
+
+func TestSequencedRoundTrip(t *testing.T) {
+    entry, err := SerializeSequencedOperation("delete", 1<<40+7, []byte("k"), nil)
+    if err != nil {
+        t.Fatalf("SerializeSequencedOperation failed: %v", err)
+    }
+
+    op, seq, key, value, err := DeserializeSequencedOperation(entry)
+    if err != nil {
+        t.Fatalf("DeserializeSequencedOperation failed: %v", err)
+    }
+    if op != "delete" || seq != 1<<40+7 || string(key) != "k" || len(value) != 0 {
+        t.Errorf("Got %q, %d, %q, %q", op, seq, key, value)
+    }
+
+    // Records without a sequence number still decode, with seq 0
+    entry, err = SerializeOperation("set", []byte("k"), []byte("v"))
+    if err != nil {
+        t.Fatalf("SerializeOperation failed: %v", err)
+    }
+    op, seq, key, value, err = DeserializeSequencedOperation(entry)
+    if err != nil {
+        t.Fatalf("DeserializeSequencedOperation failed: %v", err)
+    }
+    if op != "set" || seq != 0 || string(key) != "k" || string(value) != "v" {
+        t.Errorf("Got %q, %d, %q, %q", op, seq, key, value)
+    }
+}
+
+func TestExpiringRoundTrip(t *testing.T) {
+    entry, err := SerializeExpiringSet(42, 1_700_000_000_000_000, []byte("k"), []byte("v"))
+    if err != nil {
+        t.Fatalf("SerializeExpiringSet failed: %v", err)
+    }
+
+    op, seq, expiresAt, key, value, err := DeserializeExpiringOperation(entry)
+    if err != nil {
+        t.Fatalf("DeserializeExpiringOperation failed: %v", err)
+    }
+    if op != "set" || seq != 42 || expiresAt != 1_700_000_000_000_000 || string(key) != "k" || string(value) != "v" {
+        t.Errorf("Got %q, %d, %d, %q, %q", op, seq, expiresAt, key, value)
+    }
+
+    // Readers that ignore expiry still decode the set
+    op, seq, key, value, err = DeserializeSequencedOperation(entry)
+    if err != nil || op != "set" || seq != 42 || string(key) != "k" || string(value) != "v" {
+        t.Errorf("Got %q, %d, %q, %q, %v", op, seq, key, value, err)
+    }
+
+    // Other records never expire
+    entry, _ = SerializeSequencedOperation("delete", 7, []byte("k"), nil)
+    if _, _, expiresAt, _, _, err = DeserializeExpiringOperation(entry); err != nil || expiresAt != 0 {
+        t.Errorf("Expected a delete to have no expiry, got %d, %v", expiresAt, err)
+    }
+    if _, err := SerializeExpiringSet(1, -5, []byte("k"), nil); err == nil {
+        t.Errorf("Expected a negative expiry time to be rejected")
+    }
+}
+
+func TestDeleteRangeRoundTrip(t *testing.T) {
+    entry, err := SerializeSequencedOperation("delete_range", 9, []byte("tenant1/"), []byte("tenant2/"))
+    if err != nil {
+        t.Fatalf("SerializeSequencedOperation failed: %v", err)
+    }
+
+    op, seq, start, end, err := DeserializeSequencedOperation(entry)
+    if err != nil {
+        t.Fatalf("DeserializeSequencedOperation failed: %v", err)
+    }
+    if op != "delete_range" || seq != 9 || string(start) != "tenant1/" || string(end) != "tenant2/" {
+        t.Errorf("Got %q, %d, %q, %q", op, seq, start, end)
+    }
+}
+
+func TestMergeRoundTrip(t *testing.T) {
+    entry, err := SerializeSequencedOperation("merge", 12, []byte("counter"), []byte{0, 0, 0, 0, 0, 0, 0, 5})
+    if err != nil {
+        t.Fatalf("SerializeSequencedOperation failed: %v", err)
+    }
+    if entry[8] != opMerge|opSequenced {
+        t.Errorf("Expected op type %#x, got %#x", opMerge|opSequenced, entry[8])
+    }
+
+    op, seq, key, operand, err := DeserializeSequencedOperation(entry)
+    if err != nil {
+        t.Fatalf("DeserializeSequencedOperation failed: %v", err)
+    }
+    if op != "merge" || seq != 12 || string(key) != "counter" || len(operand) != 8 || operand[7] != 5 {
+        t.Errorf("Got %q, %d, %q, %v", op, seq, key, operand)
+    }
+}
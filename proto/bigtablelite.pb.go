@@ -21,11 +21,69 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
-// Set request message
+// Kind of change a Mutation makes to a row
+type MutationType int32
+
+const (
+	MutationType_SET_CELL      MutationType = 0
+	MutationType_DELETE_CELL   MutationType = 1
+	MutationType_DELETE_COLUMN MutationType = 2
+	MutationType_DELETE_FAMILY MutationType = 3
+	MutationType_DELETE_ROW    MutationType = 4
+)
+
+// Enum value maps for MutationType.
+var (
+	MutationType_name = map[int32]string{
+		0: "SET_CELL",
+		1: "DELETE_CELL",
+		2: "DELETE_COLUMN",
+		3: "DELETE_FAMILY",
+		4: "DELETE_ROW",
+	}
+	MutationType_value = map[string]int32{
+		"SET_CELL":      0,
+		"DELETE_CELL":   1,
+		"DELETE_COLUMN": 2,
+		"DELETE_FAMILY": 3,
+		"DELETE_ROW":    4,
+	}
+)
+
+func (x MutationType) Enum() *MutationType {
+	p := new(MutationType)
+	*p = x
+	return p
+}
+
+func (x MutationType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (MutationType) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_bigtablelite_proto_enumTypes[0].Descriptor()
+}
+
+func (MutationType) Type() protoreflect.EnumType {
+	return &file_proto_bigtablelite_proto_enumTypes[0]
+}
+
+func (x MutationType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use MutationType.Descriptor instead.
+func (MutationType) EnumDescriptor() ([]byte, []int) {
+	return file_proto_bigtablelite_proto_rawDescGZIP(), []int{0}
+}
+
+// Set request message. A positive ttl_millis makes the key expire that
+// many milliseconds after the write; 0 keeps it until it is deleted.
 type SetRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
-	Value         string                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Key           []byte                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value         []byte                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	TtlMillis     int64                  `protobuf:"varint,3,opt,name=ttl_millis,json=ttlMillis,proto3" json:"ttl_millis,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -60,18 +118,25 @@ func (*SetRequest) Descriptor() ([]byte, []int) {
 	return file_proto_bigtablelite_proto_rawDescGZIP(), []int{0}
 }
 
-func (x *SetRequest) GetKey() string {
+func (x *SetRequest) GetKey() []byte {
 	if x != nil {
 		return x.Key
 	}
-	return ""
+	return nil
 }
 
-func (x *SetRequest) GetValue() string {
+func (x *SetRequest) GetValue() []byte {
 	if x != nil {
 		return x.Value
 	}
-	return ""
+	return nil
+}
+
+func (x *SetRequest) GetTtlMillis() int64 {
+	if x != nil {
+		return x.TtlMillis
+	}
+	return 0
 }
 
 // Set response message
@@ -130,7 +195,7 @@ func (x *SetResponse) GetMessage() string {
 // Get request message
 type GetRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Key           []byte                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -165,18 +230,18 @@ func (*GetRequest) Descriptor() ([]byte, []int) {
 	return file_proto_bigtablelite_proto_rawDescGZIP(), []int{2}
 }
 
-func (x *GetRequest) GetKey() string {
+func (x *GetRequest) GetKey() []byte {
 	if x != nil {
 		return x.Key
 	}
-	return ""
+	return nil
 }
 
 // Get response message
 type GetResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Found         bool                   `protobuf:"varint,1,opt,name=found,proto3" json:"found,omitempty"`
-	Value         string                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Value         []byte                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
 	Message       string                 `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
@@ -219,11 +284,11 @@ func (x *GetResponse) GetFound() bool {
 	return false
 }
 
-func (x *GetResponse) GetValue() string {
+func (x *GetResponse) GetValue() []byte {
 	if x != nil {
 		return x.Value
 	}
-	return ""
+	return nil
 }
 
 func (x *GetResponse) GetMessage() string {
@@ -236,7 +301,7 @@ func (x *GetResponse) GetMessage() string {
 // Delete request message
 type DeleteRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Key           []byte                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -271,11 +336,11 @@ func (*DeleteRequest) Descriptor() ([]byte, []int) {
 	return file_proto_bigtablelite_proto_rawDescGZIP(), []int{4}
 }
 
-func (x *DeleteRequest) GetKey() string {
+func (x *DeleteRequest) GetKey() []byte {
 	if x != nil {
 		return x.Key
 	}
-	return ""
+	return nil
 }
 
 // Delete response message
@@ -331,34 +396,974 @@ func (x *DeleteResponse) GetMessage() string {
 	return ""
 }
 
-var File_proto_bigtablelite_proto protoreflect.FileDescriptor
+// DeleteRange request message. end_key is exclusive and must sort after
+// start_key.
+type DeleteRangeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	StartKey      []byte                 `protobuf:"bytes,1,opt,name=start_key,json=startKey,proto3" json:"start_key,omitempty"`
+	EndKey        []byte                 `protobuf:"bytes,2,opt,name=end_key,json=endKey,proto3" json:"end_key,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
 
-const file_proto_bigtablelite_proto_rawDesc = "" +
-	"\n" +
-	"\x18proto/bigtablelite.proto\x12\fbigtablelite\"4\n" +
-	"\n" +
-	"SetRequest\x12\x10\n" +
-	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
-	"\x05value\x18\x02 \x01(\tR\x05value\"A\n" +
-	"\vSetResponse\x12\x18\n" +
-	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x18\n" +
-	"\amessage\x18\x02 \x01(\tR\amessage\"\x1e\n" +
-	"\n" +
-	"GetRequest\x12\x10\n" +
-	"\x03key\x18\x01 \x01(\tR\x03key\"S\n" +
-	"\vGetResponse\x12\x14\n" +
-	"\x05found\x18\x01 \x01(\bR\x05found\x12\x14\n" +
-	"\x05value\x18\x02 \x01(\tR\x05value\x12\x18\n" +
-	"\amessage\x18\x03 \x01(\tR\amessage\"!\n" +
-	"\rDeleteRequest\x12\x10\n" +
-	"\x03key\x18\x01 \x01(\tR\x03key\"D\n" +
-	"\x0eDeleteResponse\x12\x18\n" +
-	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x18\n" +
-	"\amessage\x18\x02 \x01(\tR\amessage2\xcb\x01\n" +
-	"\fBigTableLite\x12:\n" +
-	"\x03Set\x12\x18.bigtablelite.SetRequest\x1a\x19.bigtablelite.SetResponse\x12:\n" +
-	"\x03Get\x12\x18.bigtablelite.GetRequest\x1a\x19.bigtablelite.GetResponse\x12C\n" +
-	"\x06Delete\x12\x1b.bigtablelite.DeleteRequest\x1a\x1c.bigtablelite.DeleteResponseB\tZ\a./protob\x06proto3"
+func (x *DeleteRangeRequest) Reset() {
+	*x = DeleteRangeRequest{}
+	mi := &file_proto_bigtablelite_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteRangeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteRangeRequest) ProtoMessage() {}
+
+func (x *DeleteRangeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_bigtablelite_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteRangeRequest.ProtoReflect.Descriptor instead.
+func (*DeleteRangeRequest) Descriptor() ([]byte, []int) {
+	return file_proto_bigtablelite_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *DeleteRangeRequest) GetStartKey() []byte {
+	if x != nil {
+		return x.StartKey
+	}
+	return nil
+}
+
+func (x *DeleteRangeRequest) GetEndKey() []byte {
+	if x != nil {
+		return x.EndKey
+	}
+	return nil
+}
+
+// DeleteRange response message
+type DeleteRangeResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteRangeResponse) Reset() {
+	*x = DeleteRangeResponse{}
+	mi := &file_proto_bigtablelite_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteRangeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteRangeResponse) ProtoMessage() {}
+
+func (x *DeleteRangeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_bigtablelite_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteRangeResponse.ProtoReflect.Descriptor instead.
+func (*DeleteRangeResponse) Descriptor() ([]byte, []int) {
+	return file_proto_bigtablelite_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *DeleteRangeResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *DeleteRangeResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+// Merge request message. operand must suit the shard's merge_operator:
+// int64add takes 8-byte big-endian integers.
+type MergeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           []byte                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Operand       []byte                 `protobuf:"bytes,2,opt,name=operand,proto3" json:"operand,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MergeRequest) Reset() {
+	*x = MergeRequest{}
+	mi := &file_proto_bigtablelite_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MergeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MergeRequest) ProtoMessage() {}
+
+func (x *MergeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_bigtablelite_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MergeRequest.ProtoReflect.Descriptor instead.
+func (*MergeRequest) Descriptor() ([]byte, []int) {
+	return file_proto_bigtablelite_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *MergeRequest) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+func (x *MergeRequest) GetOperand() []byte {
+	if x != nil {
+		return x.Operand
+	}
+	return nil
+}
+
+// Merge response message
+type MergeResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MergeResponse) Reset() {
+	*x = MergeResponse{}
+	mi := &file_proto_bigtablelite_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MergeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MergeResponse) ProtoMessage() {}
+
+func (x *MergeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_bigtablelite_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MergeResponse.ProtoReflect.Descriptor instead.
+func (*MergeResponse) Descriptor() ([]byte, []int) {
+	return file_proto_bigtablelite_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *MergeResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *MergeResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+// One change to a row. SET_CELL uses every field, DELETE_CELL all but
+// value, DELETE_COLUMN family and qualifier, DELETE_FAMILY family, and
+// DELETE_ROW none. A SET_CELL timestamp of -1 stamps the cell with the
+// server's time.
+type Mutation struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Type            MutationType           `protobuf:"varint,1,opt,name=type,proto3,enum=bigtablelite.MutationType" json:"type,omitempty"`
+	Family          string                 `protobuf:"bytes,2,opt,name=family,proto3" json:"family,omitempty"`
+	Qualifier       []byte                 `protobuf:"bytes,3,opt,name=qualifier,proto3" json:"qualifier,omitempty"`
+	TimestampMicros int64                  `protobuf:"varint,4,opt,name=timestamp_micros,json=timestampMicros,proto3" json:"timestamp_micros,omitempty"`
+	Value           []byte                 `protobuf:"bytes,5,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *Mutation) Reset() {
+	*x = Mutation{}
+	mi := &file_proto_bigtablelite_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Mutation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Mutation) ProtoMessage() {}
+
+func (x *Mutation) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_bigtablelite_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Mutation.ProtoReflect.Descriptor instead.
+func (*Mutation) Descriptor() ([]byte, []int) {
+	return file_proto_bigtablelite_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *Mutation) GetType() MutationType {
+	if x != nil {
+		return x.Type
+	}
+	return MutationType_SET_CELL
+}
+
+func (x *Mutation) GetFamily() string {
+	if x != nil {
+		return x.Family
+	}
+	return ""
+}
+
+func (x *Mutation) GetQualifier() []byte {
+	if x != nil {
+		return x.Qualifier
+	}
+	return nil
+}
+
+func (x *Mutation) GetTimestampMicros() int64 {
+	if x != nil {
+		return x.TimestampMicros
+	}
+	return 0
+}
+
+func (x *Mutation) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+// MutateRow request message
+type MutateRowRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RowKey        []byte                 `protobuf:"bytes,1,opt,name=row_key,json=rowKey,proto3" json:"row_key,omitempty"`
+	Mutations     []*Mutation            `protobuf:"bytes,2,rep,name=mutations,proto3" json:"mutations,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MutateRowRequest) Reset() {
+	*x = MutateRowRequest{}
+	mi := &file_proto_bigtablelite_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MutateRowRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MutateRowRequest) ProtoMessage() {}
+
+func (x *MutateRowRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_bigtablelite_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MutateRowRequest.ProtoReflect.Descriptor instead.
+func (*MutateRowRequest) Descriptor() ([]byte, []int) {
+	return file_proto_bigtablelite_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *MutateRowRequest) GetRowKey() []byte {
+	if x != nil {
+		return x.RowKey
+	}
+	return nil
+}
+
+func (x *MutateRowRequest) GetMutations() []*Mutation {
+	if x != nil {
+		return x.Mutations
+	}
+	return nil
+}
+
+// MutateRow response message
+type MutateRowResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MutateRowResponse) Reset() {
+	*x = MutateRowResponse{}
+	mi := &file_proto_bigtablelite_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MutateRowResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MutateRowResponse) ProtoMessage() {}
+
+func (x *MutateRowResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_bigtablelite_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MutateRowResponse.ProtoReflect.Descriptor instead.
+func (*MutateRowResponse) Descriptor() ([]byte, []int) {
+	return file_proto_bigtablelite_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *MutateRowResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *MutateRowResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+// A column of a row
+type Column struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Family        string                 `protobuf:"bytes,1,opt,name=family,proto3" json:"family,omitempty"`
+	Qualifier     []byte                 `protobuf:"bytes,2,opt,name=qualifier,proto3" json:"qualifier,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Column) Reset() {
+	*x = Column{}
+	mi := &file_proto_bigtablelite_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Column) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Column) ProtoMessage() {}
+
+func (x *Column) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_bigtablelite_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Column.ProtoReflect.Descriptor instead.
+func (*Column) Descriptor() ([]byte, []int) {
+	return file_proto_bigtablelite_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *Column) GetFamily() string {
+	if x != nil {
+		return x.Family
+	}
+	return ""
+}
+
+func (x *Column) GetQualifier() []byte {
+	if x != nil {
+		return x.Qualifier
+	}
+	return nil
+}
+
+// ReadRow request message. Cells in any of families or columns are
+// returned; with neither set, the whole row is. Versions outside
+// [start_timestamp_micros, end_timestamp_micros) are left out, an end of 0
+// meaning no upper bound, and a positive latest_n keeps only the newest
+// latest_n versions of each column.
+type ReadRowRequest struct {
+	state                protoimpl.MessageState `protogen:"open.v1"`
+	RowKey               []byte                 `protobuf:"bytes,1,opt,name=row_key,json=rowKey,proto3" json:"row_key,omitempty"`
+	Families             []string               `protobuf:"bytes,2,rep,name=families,proto3" json:"families,omitempty"`
+	Columns              []*Column              `protobuf:"bytes,3,rep,name=columns,proto3" json:"columns,omitempty"`
+	LatestN              int32                  `protobuf:"varint,4,opt,name=latest_n,json=latestN,proto3" json:"latest_n,omitempty"`
+	StartTimestampMicros int64                  `protobuf:"varint,5,opt,name=start_timestamp_micros,json=startTimestampMicros,proto3" json:"start_timestamp_micros,omitempty"`
+	EndTimestampMicros   int64                  `protobuf:"varint,6,opt,name=end_timestamp_micros,json=endTimestampMicros,proto3" json:"end_timestamp_micros,omitempty"`
+	unknownFields        protoimpl.UnknownFields
+	sizeCache            protoimpl.SizeCache
+}
+
+func (x *ReadRowRequest) Reset() {
+	*x = ReadRowRequest{}
+	mi := &file_proto_bigtablelite_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReadRowRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReadRowRequest) ProtoMessage() {}
+
+func (x *ReadRowRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_bigtablelite_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReadRowRequest.ProtoReflect.Descriptor instead.
+func (*ReadRowRequest) Descriptor() ([]byte, []int) {
+	return file_proto_bigtablelite_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *ReadRowRequest) GetRowKey() []byte {
+	if x != nil {
+		return x.RowKey
+	}
+	return nil
+}
+
+func (x *ReadRowRequest) GetFamilies() []string {
+	if x != nil {
+		return x.Families
+	}
+	return nil
+}
+
+func (x *ReadRowRequest) GetColumns() []*Column {
+	if x != nil {
+		return x.Columns
+	}
+	return nil
+}
+
+func (x *ReadRowRequest) GetLatestN() int32 {
+	if x != nil {
+		return x.LatestN
+	}
+	return 0
+}
+
+func (x *ReadRowRequest) GetStartTimestampMicros() int64 {
+	if x != nil {
+		return x.StartTimestampMicros
+	}
+	return 0
+}
+
+func (x *ReadRowRequest) GetEndTimestampMicros() int64 {
+	if x != nil {
+		return x.EndTimestampMicros
+	}
+	return 0
+}
+
+// One timestamped value of a column
+type Cell struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Family          string                 `protobuf:"bytes,1,opt,name=family,proto3" json:"family,omitempty"`
+	Qualifier       []byte                 `protobuf:"bytes,2,opt,name=qualifier,proto3" json:"qualifier,omitempty"`
+	TimestampMicros int64                  `protobuf:"varint,3,opt,name=timestamp_micros,json=timestampMicros,proto3" json:"timestamp_micros,omitempty"`
+	Value           []byte                 `protobuf:"bytes,4,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *Cell) Reset() {
+	*x = Cell{}
+	mi := &file_proto_bigtablelite_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Cell) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Cell) ProtoMessage() {}
+
+func (x *Cell) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_bigtablelite_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Cell.ProtoReflect.Descriptor instead.
+func (*Cell) Descriptor() ([]byte, []int) {
+	return file_proto_bigtablelite_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *Cell) GetFamily() string {
+	if x != nil {
+		return x.Family
+	}
+	return ""
+}
+
+func (x *Cell) GetQualifier() []byte {
+	if x != nil {
+		return x.Qualifier
+	}
+	return nil
+}
+
+func (x *Cell) GetTimestampMicros() int64 {
+	if x != nil {
+		return x.TimestampMicros
+	}
+	return 0
+}
+
+func (x *Cell) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+// ReadRow response message. Cells are ordered by family, then qualifier,
+// then newest timestamp first.
+type ReadRowResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Found         bool                   `protobuf:"varint,1,opt,name=found,proto3" json:"found,omitempty"`
+	Cells         []*Cell                `protobuf:"bytes,2,rep,name=cells,proto3" json:"cells,omitempty"`
+	Message       string                 `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReadRowResponse) Reset() {
+	*x = ReadRowResponse{}
+	mi := &file_proto_bigtablelite_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReadRowResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReadRowResponse) ProtoMessage() {}
+
+func (x *ReadRowResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_bigtablelite_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReadRowResponse.ProtoReflect.Descriptor instead.
+func (*ReadRowResponse) Descriptor() ([]byte, []int) {
+	return file_proto_bigtablelite_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *ReadRowResponse) GetFound() bool {
+	if x != nil {
+		return x.Found
+	}
+	return false
+}
+
+func (x *ReadRowResponse) GetCells() []*Cell {
+	if x != nil {
+		return x.Cells
+	}
+	return nil
+}
+
+func (x *ReadRowResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+// Checkpoint request message. dir names a directory relative to the shard
+// server's checkpoint_dir that must not exist yet; it cannot leave it.
+type CheckpointRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Dir           string                 `protobuf:"bytes,1,opt,name=dir,proto3" json:"dir,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckpointRequest) Reset() {
+	*x = CheckpointRequest{}
+	mi := &file_proto_bigtablelite_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckpointRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckpointRequest) ProtoMessage() {}
+
+func (x *CheckpointRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_bigtablelite_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckpointRequest.ProtoReflect.Descriptor instead.
+func (*CheckpointRequest) Descriptor() ([]byte, []int) {
+	return file_proto_bigtablelite_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *CheckpointRequest) GetDir() string {
+	if x != nil {
+		return x.Dir
+	}
+	return ""
+}
+
+// Checkpoint response message. dir is the absolute path of the checkpoint
+// on the shard server.
+type CheckpointResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	Dir           string                 `protobuf:"bytes,3,opt,name=dir,proto3" json:"dir,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckpointResponse) Reset() {
+	*x = CheckpointResponse{}
+	mi := &file_proto_bigtablelite_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckpointResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckpointResponse) ProtoMessage() {}
+
+func (x *CheckpointResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_bigtablelite_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckpointResponse.ProtoReflect.Descriptor instead.
+func (*CheckpointResponse) Descriptor() ([]byte, []int) {
+	return file_proto_bigtablelite_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *CheckpointResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *CheckpointResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *CheckpointResponse) GetDir() string {
+	if x != nil {
+		return x.Dir
+	}
+	return ""
+}
+
+// Compact request message
+type CompactRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CompactRequest) Reset() {
+	*x = CompactRequest{}
+	mi := &file_proto_bigtablelite_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CompactRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CompactRequest) ProtoMessage() {}
+
+func (x *CompactRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_bigtablelite_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CompactRequest.ProtoReflect.Descriptor instead.
+func (*CompactRequest) Descriptor() ([]byte, []int) {
+	return file_proto_bigtablelite_proto_rawDescGZIP(), []int{19}
+}
+
+// Compact response message
+type CompactResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CompactResponse) Reset() {
+	*x = CompactResponse{}
+	mi := &file_proto_bigtablelite_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CompactResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CompactResponse) ProtoMessage() {}
+
+func (x *CompactResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_bigtablelite_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CompactResponse.ProtoReflect.Descriptor instead.
+func (*CompactResponse) Descriptor() ([]byte, []int) {
+	return file_proto_bigtablelite_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *CompactResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *CompactResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+var File_proto_bigtablelite_proto protoreflect.FileDescriptor
+
+const file_proto_bigtablelite_proto_rawDesc = "" +
+	"\n" +
+	"\x18proto/bigtablelite.proto\x12\fbigtablelite\"S\n" +
+	"\n" +
+	"SetRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\fR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\fR\x05value\x12\x1d\n" +
+	"\n" +
+	"ttl_millis\x18\x03 \x01(\x03R\tttlMillis\"A\n" +
+	"\vSetResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\"\x1e\n" +
+	"\n" +
+	"GetRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\fR\x03key\"S\n" +
+	"\vGetResponse\x12\x14\n" +
+	"\x05found\x18\x01 \x01(\bR\x05found\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\fR\x05value\x12\x18\n" +
+	"\amessage\x18\x03 \x01(\tR\amessage\"!\n" +
+	"\rDeleteRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\fR\x03key\"D\n" +
+	"\x0eDeleteResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\"J\n" +
+	"\x12DeleteRangeRequest\x12\x1b\n" +
+	"\tstart_key\x18\x01 \x01(\fR\bstartKey\x12\x17\n" +
+	"\aend_key\x18\x02 \x01(\fR\x06endKey\"I\n" +
+	"\x13DeleteRangeResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\":\n" +
+	"\fMergeRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\fR\x03key\x12\x18\n" +
+	"\aoperand\x18\x02 \x01(\fR\aoperand\"C\n" +
+	"\rMergeResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\"\xb1\x01\n" +
+	"\bMutation\x12.\n" +
+	"\x04type\x18\x01 \x01(\x0e2\x1a.bigtablelite.MutationTypeR\x04type\x12\x16\n" +
+	"\x06family\x18\x02 \x01(\tR\x06family\x12\x1c\n" +
+	"\tqualifier\x18\x03 \x01(\fR\tqualifier\x12)\n" +
+	"\x10timestamp_micros\x18\x04 \x01(\x03R\x0ftimestampMicros\x12\x14\n" +
+	"\x05value\x18\x05 \x01(\fR\x05value\"a\n" +
+	"\x10MutateRowRequest\x12\x17\n" +
+	"\arow_key\x18\x01 \x01(\fR\x06rowKey\x124\n" +
+	"\tmutations\x18\x02 \x03(\v2\x16.bigtablelite.MutationR\tmutations\"G\n" +
+	"\x11MutateRowResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\">\n" +
+	"\x06Column\x12\x16\n" +
+	"\x06family\x18\x01 \x01(\tR\x06family\x12\x1c\n" +
+	"\tqualifier\x18\x02 \x01(\fR\tqualifier\"\xf8\x01\n" +
+	"\x0eReadRowRequest\x12\x17\n" +
+	"\arow_key\x18\x01 \x01(\fR\x06rowKey\x12\x1a\n" +
+	"\bfamilies\x18\x02 \x03(\tR\bfamilies\x12.\n" +
+	"\acolumns\x18\x03 \x03(\v2\x14.bigtablelite.ColumnR\acolumns\x12\x19\n" +
+	"\blatest_n\x18\x04 \x01(\x05R\alatestN\x124\n" +
+	"\x16start_timestamp_micros\x18\x05 \x01(\x03R\x14startTimestampMicros\x120\n" +
+	"\x14end_timestamp_micros\x18\x06 \x01(\x03R\x12endTimestampMicros\"}\n" +
+	"\x04Cell\x12\x16\n" +
+	"\x06family\x18\x01 \x01(\tR\x06family\x12\x1c\n" +
+	"\tqualifier\x18\x02 \x01(\fR\tqualifier\x12)\n" +
+	"\x10timestamp_micros\x18\x03 \x01(\x03R\x0ftimestampMicros\x12\x14\n" +
+	"\x05value\x18\x04 \x01(\fR\x05value\"k\n" +
+	"\x0fReadRowResponse\x12\x14\n" +
+	"\x05found\x18\x01 \x01(\bR\x05found\x12(\n" +
+	"\x05cells\x18\x02 \x03(\v2\x12.bigtablelite.CellR\x05cells\x12\x18\n" +
+	"\amessage\x18\x03 \x01(\tR\amessage\"%\n" +
+	"\x11CheckpointRequest\x12\x10\n" +
+	"\x03dir\x18\x01 \x01(\tR\x03dir\"Z\n" +
+	"\x12CheckpointResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\x12\x10\n" +
+	"\x03dir\x18\x03 \x01(\tR\x03dir\"\x10\n" +
+	"\x0eCompactRequest\"E\n" +
+	"\x0fCompactResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage*c\n" +
+	"\fMutationType\x12\f\n" +
+	"\bSET_CELL\x10\x00\x12\x0f\n" +
+	"\vDELETE_CELL\x10\x01\x12\x11\n" +
+	"\rDELETE_COLUMN\x10\x02\x12\x11\n" +
+	"\rDELETE_FAMILY\x10\x03\x12\x0e\n" +
+	"\n" +
+	"DELETE_ROW\x10\x042\x90\x05\n" +
+	"\fBigTableLite\x12:\n" +
+	"\x03Set\x12\x18.bigtablelite.SetRequest\x1a\x19.bigtablelite.SetResponse\x12:\n" +
+	"\x03Get\x12\x18.bigtablelite.GetRequest\x1a\x19.bigtablelite.GetResponse\x12C\n" +
+	"\x06Delete\x12\x1b.bigtablelite.DeleteRequest\x1a\x1c.bigtablelite.DeleteResponse\x12R\n" +
+	"\vDeleteRange\x12 .bigtablelite.DeleteRangeRequest\x1a!.bigtablelite.DeleteRangeResponse\x12@\n" +
+	"\x05Merge\x12\x1a.bigtablelite.MergeRequest\x1a\x1b.bigtablelite.MergeResponse\x12L\n" +
+	"\tMutateRow\x12\x1e.bigtablelite.MutateRowRequest\x1a\x1f.bigtablelite.MutateRowResponse\x12F\n" +
+	"\aReadRow\x12\x1c.bigtablelite.ReadRowRequest\x1a\x1d.bigtablelite.ReadRowResponse\x12O\n" +
+	"\n" +
+	"Checkpoint\x12\x1f.bigtablelite.CheckpointRequest\x1a .bigtablelite.CheckpointResponse\x12F\n" +
+	"\aCompact\x12\x1c.bigtablelite.CompactRequest\x1a\x1d.bigtablelite.CompactResponseB\tZ\a./protob\x06proto3"
 
 var (
 	file_proto_bigtablelite_proto_rawDescOnce sync.Once
@@ -372,27 +1377,60 @@ func file_proto_bigtablelite_proto_rawDescGZIP() []byte {
 	return file_proto_bigtablelite_proto_rawDescData
 }
 
-var file_proto_bigtablelite_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_proto_bigtablelite_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_proto_bigtablelite_proto_msgTypes = make([]protoimpl.MessageInfo, 21)
 var file_proto_bigtablelite_proto_goTypes = []any{
-	(*SetRequest)(nil),     // 0: bigtablelite.SetRequest
-	(*SetResponse)(nil),    // 1: bigtablelite.SetResponse
-	(*GetRequest)(nil),     // 2: bigtablelite.GetRequest
-	(*GetResponse)(nil),    // 3: bigtablelite.GetResponse
-	(*DeleteRequest)(nil),  // 4: bigtablelite.DeleteRequest
-	(*DeleteResponse)(nil), // 5: bigtablelite.DeleteResponse
+	(MutationType)(0),           // 0: bigtablelite.MutationType
+	(*SetRequest)(nil),          // 1: bigtablelite.SetRequest
+	(*SetResponse)(nil),         // 2: bigtablelite.SetResponse
+	(*GetRequest)(nil),          // 3: bigtablelite.GetRequest
+	(*GetResponse)(nil),         // 4: bigtablelite.GetResponse
+	(*DeleteRequest)(nil),       // 5: bigtablelite.DeleteRequest
+	(*DeleteResponse)(nil),      // 6: bigtablelite.DeleteResponse
+	(*DeleteRangeRequest)(nil),  // 7: bigtablelite.DeleteRangeRequest
+	(*DeleteRangeResponse)(nil), // 8: bigtablelite.DeleteRangeResponse
+	(*MergeRequest)(nil),        // 9: bigtablelite.MergeRequest
+	(*MergeResponse)(nil),       // 10: bigtablelite.MergeResponse
+	(*Mutation)(nil),            // 11: bigtablelite.Mutation
+	(*MutateRowRequest)(nil),    // 12: bigtablelite.MutateRowRequest
+	(*MutateRowResponse)(nil),   // 13: bigtablelite.MutateRowResponse
+	(*Column)(nil),              // 14: bigtablelite.Column
+	(*ReadRowRequest)(nil),      // 15: bigtablelite.ReadRowRequest
+	(*Cell)(nil),                // 16: bigtablelite.Cell
+	(*ReadRowResponse)(nil),     // 17: bigtablelite.ReadRowResponse
+	(*CheckpointRequest)(nil),   // 18: bigtablelite.CheckpointRequest
+	(*CheckpointResponse)(nil),  // 19: bigtablelite.CheckpointResponse
+	(*CompactRequest)(nil),      // 20: bigtablelite.CompactRequest
+	(*CompactResponse)(nil),     // 21: bigtablelite.CompactResponse
 }
 var file_proto_bigtablelite_proto_depIdxs = []int32{
-	0, // 0: bigtablelite.BigTableLite.Set:input_type -> bigtablelite.SetRequest
-	2, // 1: bigtablelite.BigTableLite.Get:input_type -> bigtablelite.GetRequest
-	4, // 2: bigtablelite.BigTableLite.Delete:input_type -> bigtablelite.DeleteRequest
-	1, // 3: bigtablelite.BigTableLite.Set:output_type -> bigtablelite.SetResponse
-	3, // 4: bigtablelite.BigTableLite.Get:output_type -> bigtablelite.GetResponse
-	5, // 5: bigtablelite.BigTableLite.Delete:output_type -> bigtablelite.DeleteResponse
-	3, // [3:6] is the sub-list for method output_type
-	0, // [0:3] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	0,  // 0: bigtablelite.Mutation.type:type_name -> bigtablelite.MutationType
+	11, // 1: bigtablelite.MutateRowRequest.mutations:type_name -> bigtablelite.Mutation
+	14, // 2: bigtablelite.ReadRowRequest.columns:type_name -> bigtablelite.Column
+	16, // 3: bigtablelite.ReadRowResponse.cells:type_name -> bigtablelite.Cell
+	1,  // 4: bigtablelite.BigTableLite.Set:input_type -> bigtablelite.SetRequest
+	3,  // 5: bigtablelite.BigTableLite.Get:input_type -> bigtablelite.GetRequest
+	5,  // 6: bigtablelite.BigTableLite.Delete:input_type -> bigtablelite.DeleteRequest
+	7,  // 7: bigtablelite.BigTableLite.DeleteRange:input_type -> bigtablelite.DeleteRangeRequest
+	9,  // 8: bigtablelite.BigTableLite.Merge:input_type -> bigtablelite.MergeRequest
+	12, // 9: bigtablelite.BigTableLite.MutateRow:input_type -> bigtablelite.MutateRowRequest
+	15, // 10: bigtablelite.BigTableLite.ReadRow:input_type -> bigtablelite.ReadRowRequest
+	18, // 11: bigtablelite.BigTableLite.Checkpoint:input_type -> bigtablelite.CheckpointRequest
+	20, // 12: bigtablelite.BigTableLite.Compact:input_type -> bigtablelite.CompactRequest
+	2,  // 13: bigtablelite.BigTableLite.Set:output_type -> bigtablelite.SetResponse
+	4,  // 14: bigtablelite.BigTableLite.Get:output_type -> bigtablelite.GetResponse
+	6,  // 15: bigtablelite.BigTableLite.Delete:output_type -> bigtablelite.DeleteResponse
+	8,  // 16: bigtablelite.BigTableLite.DeleteRange:output_type -> bigtablelite.DeleteRangeResponse
+	10, // 17: bigtablelite.BigTableLite.Merge:output_type -> bigtablelite.MergeResponse
+	13, // 18: bigtablelite.BigTableLite.MutateRow:output_type -> bigtablelite.MutateRowResponse
+	17, // 19: bigtablelite.BigTableLite.ReadRow:output_type -> bigtablelite.ReadRowResponse
+	19, // 20: bigtablelite.BigTableLite.Checkpoint:output_type -> bigtablelite.CheckpointResponse
+	21, // 21: bigtablelite.BigTableLite.Compact:output_type -> bigtablelite.CompactResponse
+	13, // [13:22] is the sub-list for method output_type
+	4,  // [4:13] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
 }
 
 func init() { file_proto_bigtablelite_proto_init() }
@@ -405,13 +1443,14 @@ func file_proto_bigtablelite_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_bigtablelite_proto_rawDesc), len(file_proto_bigtablelite_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   6,
+			NumEnums:      1,
+			NumMessages:   21,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_proto_bigtablelite_proto_goTypes,
 		DependencyIndexes: file_proto_bigtablelite_proto_depIdxs,
+		EnumInfos:         file_proto_bigtablelite_proto_enumTypes,
 		MessageInfos:      file_proto_bigtablelite_proto_msgTypes,
 	}.Build()
 	File_proto_bigtablelite_proto = out.File
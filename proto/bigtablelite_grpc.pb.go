@@ -19,9 +19,15 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	BigTableLite_Set_FullMethodName    = "/bigtablelite.BigTableLite/Set"
-	BigTableLite_Get_FullMethodName    = "/bigtablelite.BigTableLite/Get"
-	BigTableLite_Delete_FullMethodName = "/bigtablelite.BigTableLite/Delete"
+	BigTableLite_Set_FullMethodName         = "/bigtablelite.BigTableLite/Set"
+	BigTableLite_Get_FullMethodName         = "/bigtablelite.BigTableLite/Get"
+	BigTableLite_Delete_FullMethodName      = "/bigtablelite.BigTableLite/Delete"
+	BigTableLite_DeleteRange_FullMethodName = "/bigtablelite.BigTableLite/DeleteRange"
+	BigTableLite_Merge_FullMethodName       = "/bigtablelite.BigTableLite/Merge"
+	BigTableLite_MutateRow_FullMethodName   = "/bigtablelite.BigTableLite/MutateRow"
+	BigTableLite_ReadRow_FullMethodName     = "/bigtablelite.BigTableLite/ReadRow"
+	BigTableLite_Checkpoint_FullMethodName  = "/bigtablelite.BigTableLite/Checkpoint"
+	BigTableLite_Compact_FullMethodName     = "/bigtablelite.BigTableLite/Compact"
 )
 
 // BigTableLiteClient is the client API for BigTableLite service.
@@ -36,6 +42,21 @@ type BigTableLiteClient interface {
 	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
 	// Delete a key value pair
 	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	// Delete every key in [start_key, end_key) with one range tombstone
+	DeleteRange(ctx context.Context, in *DeleteRangeRequest, opts ...grpc.CallOption) (*DeleteRangeResponse, error)
+	// Fold an operand into a key's value with the shard's merge_operator,
+	// without reading the key first
+	Merge(ctx context.Context, in *MergeRequest, opts ...grpc.CallOption) (*MergeResponse, error)
+	// Apply mutations to the cells of one row, atomically
+	MutateRow(ctx context.Context, in *MutateRowRequest, opts ...grpc.CallOption) (*MutateRowResponse, error)
+	// Read the cells of one row
+	ReadRow(ctx context.Context, in *ReadRowRequest, opts ...grpc.CallOption) (*ReadRowResponse, error)
+	// Admin: write a checkpoint of the shard's storage to a new directory in
+	// the shard server's checkpoint_dir
+	Checkpoint(ctx context.Context, in *CheckpointRequest, opts ...grpc.CallOption) (*CheckpointResponse, error)
+	// Admin: compact all of the shard's SSTables now, without waiting for the
+	// background compaction
+	Compact(ctx context.Context, in *CompactRequest, opts ...grpc.CallOption) (*CompactResponse, error)
 }
 
 type bigTableLiteClient struct {
@@ -76,6 +97,66 @@ func (c *bigTableLiteClient) Delete(ctx context.Context, in *DeleteRequest, opts
 	return out, nil
 }
 
+func (c *bigTableLiteClient) DeleteRange(ctx context.Context, in *DeleteRangeRequest, opts ...grpc.CallOption) (*DeleteRangeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteRangeResponse)
+	err := c.cc.Invoke(ctx, BigTableLite_DeleteRange_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bigTableLiteClient) Merge(ctx context.Context, in *MergeRequest, opts ...grpc.CallOption) (*MergeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MergeResponse)
+	err := c.cc.Invoke(ctx, BigTableLite_Merge_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bigTableLiteClient) MutateRow(ctx context.Context, in *MutateRowRequest, opts ...grpc.CallOption) (*MutateRowResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MutateRowResponse)
+	err := c.cc.Invoke(ctx, BigTableLite_MutateRow_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bigTableLiteClient) ReadRow(ctx context.Context, in *ReadRowRequest, opts ...grpc.CallOption) (*ReadRowResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReadRowResponse)
+	err := c.cc.Invoke(ctx, BigTableLite_ReadRow_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bigTableLiteClient) Checkpoint(ctx context.Context, in *CheckpointRequest, opts ...grpc.CallOption) (*CheckpointResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CheckpointResponse)
+	err := c.cc.Invoke(ctx, BigTableLite_Checkpoint_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bigTableLiteClient) Compact(ctx context.Context, in *CompactRequest, opts ...grpc.CallOption) (*CompactResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CompactResponse)
+	err := c.cc.Invoke(ctx, BigTableLite_Compact_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // BigTableLiteServer is the server API for BigTableLite service.
 // All implementations must embed UnimplementedBigTableLiteServer
 // for forward compatibility.
@@ -88,6 +169,21 @@ type BigTableLiteServer interface {
 	Get(context.Context, *GetRequest) (*GetResponse, error)
 	// Delete a key value pair
 	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	// Delete every key in [start_key, end_key) with one range tombstone
+	DeleteRange(context.Context, *DeleteRangeRequest) (*DeleteRangeResponse, error)
+	// Fold an operand into a key's value with the shard's merge_operator,
+	// without reading the key first
+	Merge(context.Context, *MergeRequest) (*MergeResponse, error)
+	// Apply mutations to the cells of one row, atomically
+	MutateRow(context.Context, *MutateRowRequest) (*MutateRowResponse, error)
+	// Read the cells of one row
+	ReadRow(context.Context, *ReadRowRequest) (*ReadRowResponse, error)
+	// Admin: write a checkpoint of the shard's storage to a new directory in
+	// the shard server's checkpoint_dir
+	Checkpoint(context.Context, *CheckpointRequest) (*CheckpointResponse, error)
+	// Admin: compact all of the shard's SSTables now, without waiting for the
+	// background compaction
+	Compact(context.Context, *CompactRequest) (*CompactResponse, error)
 	mustEmbedUnimplementedBigTableLiteServer()
 }
 
@@ -107,6 +203,24 @@ func (UnimplementedBigTableLiteServer) Get(context.Context, *GetRequest) (*GetRe
 func (UnimplementedBigTableLiteServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
 }
+func (UnimplementedBigTableLiteServer) DeleteRange(context.Context, *DeleteRangeRequest) (*DeleteRangeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteRange not implemented")
+}
+func (UnimplementedBigTableLiteServer) Merge(context.Context, *MergeRequest) (*MergeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Merge not implemented")
+}
+func (UnimplementedBigTableLiteServer) MutateRow(context.Context, *MutateRowRequest) (*MutateRowResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MutateRow not implemented")
+}
+func (UnimplementedBigTableLiteServer) ReadRow(context.Context, *ReadRowRequest) (*ReadRowResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReadRow not implemented")
+}
+func (UnimplementedBigTableLiteServer) Checkpoint(context.Context, *CheckpointRequest) (*CheckpointResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Checkpoint not implemented")
+}
+func (UnimplementedBigTableLiteServer) Compact(context.Context, *CompactRequest) (*CompactResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Compact not implemented")
+}
 func (UnimplementedBigTableLiteServer) mustEmbedUnimplementedBigTableLiteServer() {}
 func (UnimplementedBigTableLiteServer) testEmbeddedByValue()                      {}
 
@@ -182,6 +296,114 @@ func _BigTableLite_Delete_Handler(srv interface{}, ctx context.Context, dec func
 	return interceptor(ctx, in, info, handler)
 }
 
+func _BigTableLite_DeleteRange_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BigTableLiteServer).DeleteRange(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BigTableLite_DeleteRange_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BigTableLiteServer).DeleteRange(ctx, req.(*DeleteRangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BigTableLite_Merge_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MergeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BigTableLiteServer).Merge(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BigTableLite_Merge_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BigTableLiteServer).Merge(ctx, req.(*MergeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BigTableLite_MutateRow_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MutateRowRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BigTableLiteServer).MutateRow(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BigTableLite_MutateRow_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BigTableLiteServer).MutateRow(ctx, req.(*MutateRowRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BigTableLite_ReadRow_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReadRowRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BigTableLiteServer).ReadRow(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BigTableLite_ReadRow_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BigTableLiteServer).ReadRow(ctx, req.(*ReadRowRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BigTableLite_Checkpoint_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckpointRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BigTableLiteServer).Checkpoint(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BigTableLite_Checkpoint_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BigTableLiteServer).Checkpoint(ctx, req.(*CheckpointRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BigTableLite_Compact_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CompactRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BigTableLiteServer).Compact(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BigTableLite_Compact_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BigTableLiteServer).Compact(ctx, req.(*CompactRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // BigTableLite_ServiceDesc is the grpc.ServiceDesc for BigTableLite service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -201,6 +423,30 @@ var BigTableLite_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Delete",
 			Handler:    _BigTableLite_Delete_Handler,
 		},
+		{
+			MethodName: "DeleteRange",
+			Handler:    _BigTableLite_DeleteRange_Handler,
+		},
+		{
+			MethodName: "Merge",
+			Handler:    _BigTableLite_Merge_Handler,
+		},
+		{
+			MethodName: "MutateRow",
+			Handler:    _BigTableLite_MutateRow_Handler,
+		},
+		{
+			MethodName: "ReadRow",
+			Handler:    _BigTableLite_ReadRow_Handler,
+		},
+		{
+			MethodName: "Checkpoint",
+			Handler:    _BigTableLite_Checkpoint_Handler,
+		},
+		{
+			MethodName: "Compact",
+			Handler:    _BigTableLite_Compact_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/bigtablelite.proto",
@@ -0,0 +1,61 @@
+#include "sstable_internal.h"
+
+BlockRef BlockCache::lookup(uint32_t number, uint64_t offset) {
+    if (capacity_ == 0) {
+        return nullptr;
+    }
+
+    std::lock_guard<std::mutex> lock(mu_);
+    auto it = entries_.find(Key(number, offset));
+    if (it == entries_.end()) {
+        misses_++;
+        return nullptr;
+    }
+    lru_.splice(lru_.begin(), lru_, it->second);
+    hits_++;
+    return it->second->second;
+}
+
+void BlockCache::insert(uint32_t number, uint64_t offset, const BlockRef& block) {
+    // A block bigger than the whole budget would only flush the cache
+    if (block->size() > capacity_) {
+        return;
+    }
+
+    std::lock_guard<std::mutex> lock(mu_);
+    Key key(number, offset);
+    auto it = entries_.find(key);
+    if (it != entries_.end()) {
+        return; // Another lookup cached it first
+    }
+
+    lru_.emplace_front(key, block);
+    entries_[key] = lru_.begin();
+    usage_ += block->size();
+    while (usage_ > capacity_) {
+        erase(std::prev(lru_.end()));
+    }
+}
+
+void BlockCache::erase_table(uint32_t number) {
+    std::lock_guard<std::mutex> lock(mu_);
+    for (auto it = lru_.begin(); it != lru_.end();) {
+        auto next = std::next(it);
+        if (it->first.first == number) {
+            erase(it);
+        }
+        it = next;
+    }
+}
+
+uint64_t BlockCache::usage() {
+    std::lock_guard<std::mutex> lock(mu_);
+    return usage_;
+}
+
+// Caller holds mu_
+void BlockCache::erase(std::list<Entry>::iterator it) {
+    usage_ -= it->second->size();
+    entries_.erase(it->first);
+    lru_.erase(it);
+}
@@ -0,0 +1,94 @@
+#include "sstable_internal.h"
+#include <cstring>
+
+// Bloom filter over the keys of one SSTable, in the style of LevelDB:
+// k probes are derived from a single 32-bit hash by double hashing, and the
+// number of probes is stored in the last byte of the filter so tables
+// written with different bits-per-key settings can be read side by side.
+
+uint32_t bloom_hash(const std::string& key) {
+    // Murmur-like hash, as used by LevelDB
+    const uint32_t seed = 0xbc9f1d34;
+    const uint32_t m = 0xc6a4a793;
+    const uint32_t r = 24;
+    const char* data = key.data();
+    size_t n = key.size();
+    const char* limit = data + n;
+    uint32_t h = seed ^ (n * m);
+
+    while (data + 4 <= limit) {
+        uint32_t w;
+        std::memcpy(&w, data, sizeof(w));
+        data += 4;
+        h += w;
+        h *= m;
+        h ^= (h >> 16);
+    }
+
+    switch (limit - data) {
+    case 3:
+        h += static_cast<uint8_t>(data[2]) << 16;
+        // fall through
+    case 2:
+        h += static_cast<uint8_t>(data[1]) << 8;
+        // fall through
+    case 1:
+        h += static_cast<uint8_t>(data[0]);
+        h *= m;
+        h ^= (h >> r);
+        break;
+    }
+    return h;
+}
+
+std::string bloom_build(const std::vector<uint32_t>& hashes, uint32_t bits_per_key) {
+    if (bits_per_key == 0 || hashes.empty()) {
+        return std::string();
+    }
+
+    // ln(2) * bits_per_key probes minimises the false positive rate
+    uint32_t k = static_cast<uint32_t>(bits_per_key * 0.69);
+    if (k < 1) k = 1;
+    if (k > 30) k = 30;
+
+    // Very small filters have a high false positive rate whatever k is
+    size_t bits = hashes.size() * bits_per_key;
+    if (bits < 64) bits = 64;
+    size_t bytes = (bits + 7) / 8;
+    bits = bytes * 8;
+
+    std::string filter(bytes, '\0');
+    for (uint32_t h : hashes) {
+        const uint32_t delta = (h >> 17) | (h << 15);
+        for (uint32_t j = 0; j < k; j++) {
+            uint32_t bitpos = h % bits;
+            filter[bitpos / 8] |= (1 << (bitpos % 8));
+            h += delta;
+        }
+    }
+    filter.push_back(static_cast<char>(k));
+    return filter;
+}
+
+bool bloom_may_contain(const std::string& filter, const std::string& key) {
+    if (filter.size() < 2) {
+        return true; // No filter: every table may hold the key
+    }
+
+    size_t bits = (filter.size() - 1) * 8;
+    uint32_t k = static_cast<uint8_t>(filter.back());
+    if (k > 30) {
+        return true; // Reserved for other encodings
+    }
+
+    uint32_t h = bloom_hash(key);
+    const uint32_t delta = (h >> 17) | (h << 15);
+    for (uint32_t j = 0; j < k; j++) {
+        uint32_t bitpos = h % bits;
+        if ((filter[bitpos / 8] & (1 << (bitpos % 8))) == 0) {
+            return false;
+        }
+        h += delta;
+    }
+    return true;
+}
@@ -0,0 +1,636 @@
+#include "sstable_internal.h"
+#include <algorithm>
+#include <chrono>
+#include <cstdio>
+#include <queue>
+
+// Size-tiered compaction: runs of consecutive tables (by age) whose sizes
+// fall in the same bucket are merged into a single table once the run is
+// long enough. Tables below STCS_MIN_SSTABLE_SIZE all share one bucket so
+// freshly flushed tables are merged quickly.
+static const size_t STCS_MIN_THRESHOLD = 4;
+static const size_t STCS_MAX_THRESHOLD = 32;
+static const double STCS_BUCKET_LOW = 0.5;
+static const double STCS_BUCKET_HIGH = 1.5;
+static const uint64_t STCS_MIN_SSTABLE_SIZE = 4 * MEMTABLE_FLUSH_THRESHOLD;
+
+static bool similar_size(uint64_t size, double bucket_avg) {
+    if (size < STCS_MIN_SSTABLE_SIZE && bucket_avg < STCS_MIN_SSTABLE_SIZE) {
+        return true;
+    }
+    return size >= bucket_avg * STCS_BUCKET_LOW && size <= bucket_avg * STCS_BUCKET_HIGH;
+}
+
+// Pick the longest run of consecutive similarly sized level-0 tables. Inputs
+// must be adjacent in age so the merged table can take the place of the run
+// without reordering it against tables outside the compaction.
+static std::vector<TableRef> pick_size_tiered(const std::vector<TableRef>& tables) {
+    std::vector<TableRef> best;
+    double best_avg = 0;
+
+    size_t i = 0;
+    while (i < tables.size()) {
+        double total = tables[i]->file_size;
+        size_t j = i + 1;
+        while (j < tables.size() && j - i < STCS_MAX_THRESHOLD &&
+               similar_size(tables[j]->file_size, total / (j - i))) {
+            total += tables[j]->file_size;
+            j++;
+        }
+
+        size_t count = j - i;
+        double avg = total / count;
+        if (count >= STCS_MIN_THRESHOLD &&
+            (count > best.size() || (count == best.size() && avg < best_avg))) {
+            best.assign(tables.begin() + i, tables.begin() + j);
+            best_avg = avg;
+        }
+        i = j;
+    }
+
+    return best;
+}
+
+// One unit of compaction work
+struct CompactionJob {
+    // Tables merged by the job, ordered oldest to newest
+    std::vector<TableRef> inputs;
+    // Tables outside the job that may hold older data for its keys; a
+    // tombstone covered by one of them has to be kept
+    std::vector<TableRef> older;
+    int output_level = 0;
+    // Split the output into tables of about target_file_size
+    bool split = false;
+    // Move the single input to output_level without rewriting it
+    bool trivial_move = false;
+};
+
+// Append the tables of level that intersect [lo, hi]
+static void add_overlapping(const Version& version, int level, const std::string& lo,
+                            const std::string& hi, std::vector<TableRef>& out) {
+    for (const auto& table : version.levels[level]) {
+        if (table_overlaps(*table, lo, hi)) {
+            out.push_back(table);
+        }
+    }
+}
+
+// Widen [lo, hi] to cover every table in tables
+static void key_range(const std::vector<TableRef>& tables, std::string& lo, std::string& hi) {
+    for (size_t i = 0; i < tables.size(); i++) {
+        if (i == 0 || tables[i]->smallest < lo) {
+            lo = tables[i]->smallest;
+        }
+        if (i == 0 || tables[i]->largest > hi) {
+            hi = tables[i]->largest;
+        }
+    }
+}
+
+static uint64_t level_max_bytes(const sstable_options& opts, int level) {
+    uint64_t max = opts.level1_max_bytes;
+    for (int i = 1; i < level; i++) {
+        max *= opts.level_size_ratio;
+    }
+    return max;
+}
+
+static bool pick_size_tiered_job(const Version& version, CompactionJob& job) {
+    job.inputs = pick_size_tiered(version.levels[0]);
+    if (job.inputs.empty()) {
+        return false;
+    }
+    for (const auto& table : version.levels[0]) {
+        if (table->seq < job.inputs.front()->seq) {
+            job.older.push_back(table);
+        }
+    }
+    for (int level = 1; level < NUM_LEVELS; level++) {
+        job.older.insert(job.older.end(), version.levels[level].begin(), version.levels[level].end());
+    }
+    job.output_level = 0;
+    return true;
+}
+
+// Leveled compaction: level 0 is merged into level 1 once it holds
+// level0_file_trigger tables. Each deeper level has a size budget; the level
+// furthest over budget pushes one table (chosen round-robin through its key
+// space) into the next level, merging it with the tables it overlaps there.
+static bool pick_leveled_job(sstable_engine* engine, const Version& version, CompactionJob& job) {
+    const sstable_options& opts = engine->options;
+
+    int source = -1;
+    std::vector<TableRef> source_inputs;
+    if (version.levels[0].size() >= opts.level0_file_trigger) {
+        source = 0;
+        source_inputs = version.levels[0];
+    } else {
+        double best_score = 1.0;
+        for (int level = 1; level < NUM_LEVELS - 1; level++) {
+            double score = static_cast<double>(version_level_bytes(version, level)) /
+                           level_max_bytes(opts, level);
+            if (score >= best_score) {
+                best_score = score;
+                source = level;
+            }
+        }
+        if (source < 0) {
+            return false;
+        }
+
+        const std::vector<TableRef>& files = version.levels[source];
+        TableRef picked = files.front();
+        for (const auto& table : files) {
+            if (table->smallest > engine->compact_pointer[source]) {
+                picked = table;
+                break;
+            }
+        }
+        source_inputs.push_back(picked);
+        engine->compact_pointer[source] = picked->largest;
+    }
+
+    std::string lo, hi;
+    key_range(source_inputs, lo, hi);
+    std::vector<TableRef> target_inputs;
+    add_overlapping(version, source + 1, lo, hi, target_inputs);
+
+    // Tables in the target level are older than anything in the source
+    job.inputs = target_inputs;
+    job.inputs.insert(job.inputs.end(), source_inputs.begin(), source_inputs.end());
+    job.output_level = source + 1;
+    job.split = true;
+    job.trivial_move = source_inputs.size() == 1 && target_inputs.empty();
+
+    key_range(job.inputs, lo, hi);
+    for (int level = source + 2; level < NUM_LEVELS; level++) {
+        add_overlapping(version, level, lo, hi, job.older);
+    }
+    return true;
+}
+
+// Merge heap entry: the iterator index doubles as recency (higher is newer)
+struct MergeItem {
+    TableIterator* iter;
+    size_t age;
+};
+
+struct MergeItemGreater {
+    bool operator()(const MergeItem& a, const MergeItem& b) const {
+        int cmp = a.iter->key().compare(b.iter->key());
+        if (cmp != 0) {
+            return cmp > 0;
+        }
+        // Newer entry first for equal keys. Tables written before sequence
+        // numbers were stored give every entry seq 0; their age decides.
+        if (a.iter->seq() != b.iter->seq()) {
+            return a.iter->seq() < b.iter->seq();
+        }
+        return a.age < b.age;
+    }
+};
+
+// A tombstone can only be dropped if no table older than the compaction
+// could still hold a value for its key.
+static bool tombstone_shadows_nothing(const std::string& key, const std::vector<TableRef>& older) {
+    for (const auto& table : older) {
+        if (key >= table->smallest && key <= table->largest) {
+            return false;
+        }
+    }
+    return true;
+}
+
+typedef std::priority_queue<MergeItem, std::vector<MergeItem>, MergeItemGreater> MergeHeap;
+
+// Step the iterator at the top of the heap and restore the heap order
+static void advance(MergeHeap& heap) {
+    MergeItem top = heap.top();
+    heap.pop();
+    top.iter->next();
+    if (top.iter->valid()) {
+        heap.push(top);
+    }
+}
+
+// Fold the merge operand entry, the version of key a read at the oldest
+// snapshot starts from, with the older versions of key in the heap, taking
+// them out of it. Folding stops at the first version that is not an
+// operand. The result is a value if that version is a value or a
+// tombstone, or if no older table may hold the key; otherwise it is a
+// single operand. A value that expires stays in the heap, as the operands
+// apply to whatever is below it once it has expired. Returns the number of
+// versions folded away.
+static uint64_t fold_operands(MergeHeap& heap, const std::string& key, MemEntry& entry, int merge_operator,
+                              const RangeTombstones& range_dels, int64_t now,
+                              const std::vector<TableRef>& older) {
+    std::vector<std::string> operands{entry.value};
+    std::string base;
+    bool has_base = false;
+    bool complete = false;
+    bool expiring = false;
+    uint64_t folded = 0;
+    while (!complete && !heap.empty() && heap.top().iter->key() == key) {
+        uint64_t seq = heap.top().iter->seq();
+        const MemEntry& next = heap.top().iter->entry();
+        bool deleted = range_deleted(range_dels, key, seq) || !next.live(now);
+        expiring = !deleted && !next.merge && next.expires_at != 0;
+        if (expiring) {
+            break;
+        }
+        if (deleted) {
+            complete = true;
+        } else if (next.merge) {
+            operands.push_back(next.value);
+        } else {
+            base = next.value;
+            has_base = true;
+            complete = true;
+        }
+        advance(heap);
+        folded++;
+    }
+
+    // Whatever is left of the key is hidden by the value
+    if (complete) {
+        while (!heap.empty() && heap.top().iter->key() == key) {
+            advance(heap);
+            folded++;
+        }
+    }
+    std::reverse(operands.begin(), operands.end());
+    entry.value = merge_operands(merge_operator, has_base ? &base : nullptr, operands);
+    entry.merge = expiring || (!complete && !tombstone_shadows_nothing(key, older));
+    return folded;
+}
+
+// Output tables of a compaction, written under fresh file numbers. They
+// stay invisible until the MANIFEST records them.
+class OutputSet {
+public:
+    explicit OutputSet(sstable_engine* engine) : engine_(engine) {}
+
+    ~OutputSet() {
+        if (!committed_) {
+            abandon();
+        }
+    }
+
+    // Start a new output table if none is open
+    bool open() {
+        if (writer_) {
+            return true;
+        }
+        uint32_t number;
+        {
+            std::lock_guard<std::mutex> lock(engine_->mu);
+            number = ++engine_->sstable_counter;
+        }
+        auto meta = std::make_shared<TableMeta>();
+        meta->number = number;
+        meta->path = table_path(engine_->data_dir, number);
+        writer_.reset(new TableWriter(meta->path, engine_->options));
+        current_ = meta;
+        return writer_->ok();
+    }
+
+    TableWriter* writer() { return writer_.get(); }
+
+    // Finish the open output table, if any
+    bool close(uint64_t seq) {
+        if (!writer_) {
+            return true;
+        }
+        // Outputs must be durable before the MANIFEST can refer to them
+        bool ok = writer_->finish() && writer_->sync();
+        current_->seq = seq;
+        current_->file_size = writer_->file_size();
+        current_->smallest = writer_->smallest();
+        current_->largest = writer_->largest();
+        tables_.push_back(current_);
+        writer_.reset();
+        return ok;
+    }
+
+    const std::vector<TableRef>& tables() const { return tables_; }
+
+    void commit() { committed_ = true; }
+
+    // Remove every output written so far
+    void abandon() {
+        if (writer_) {
+            writer_.reset();
+            std::remove(current_->path.c_str());
+        }
+        for (const auto& table : tables_) {
+            std::remove(table->path.c_str());
+        }
+        tables_.clear();
+    }
+
+private:
+    sstable_engine* engine_;
+    std::unique_ptr<TableWriter> writer_;
+    TableRef current_;
+    std::vector<TableRef> tables_;
+    bool committed_ = false;
+};
+
+// Swap the job's inputs for its outputs in the current version and persist
+// the new table set. range_dels are the range tombstones whose covered data
+// the merge dropped. Must be called with engine->mu held.
+static bool install_version(sstable_engine* engine, const CompactionJob& job,
+                            const std::vector<TableRef>& outputs, const RangeTombstones& range_dels) {
+    auto is_input = [&job](const TableRef& table) {
+        return std::find(job.inputs.begin(), job.inputs.end(), table) != job.inputs.end();
+    };
+    VersionEdit edit;
+    for (int level = 0; level < NUM_LEVELS; level++) {
+        for (const auto& table : engine->current->levels[level]) {
+            if (is_input(table)) {
+                edit.deleted.emplace_back(level, table->number);
+            }
+        }
+    }
+    for (const auto& table : outputs) {
+        edit.added.emplace_back(job.output_level, table);
+    }
+
+    // A range tombstone has deleted everything it covers once every table
+    // that could hold a key in its range went through the merge
+    for (const auto& tombstone : range_dels) {
+        bool settled = true;
+        for (int level = 0; level < NUM_LEVELS; level++) {
+            for (const auto& table : engine->current->levels[level]) {
+                if (!is_input(table) && table_overlaps(*table, tombstone.start, tombstone.end)) {
+                    settled = false;
+                }
+            }
+        }
+        if (settled) {
+            edit.deleted_range_dels.push_back(tombstone.seq);
+        }
+    }
+    return version_apply(engine, edit);
+}
+
+// Move a single table one level down. Nothing is rewritten, so the table
+// keeps its file and only the recorded table set changes.
+static bool run_trivial_move(sstable_engine* engine, const CompactionJob& job) {
+    std::lock_guard<std::mutex> lock(engine->mu);
+    if (!install_version(engine, job, job.inputs, RangeTombstones())) {
+        return false;
+    }
+    engine->compaction_stats.compactions++;
+    return true;
+}
+
+static bool run_compaction(sstable_engine* engine, const CompactionJob& job) {
+    if (job.trivial_move) {
+        return run_trivial_move(engine, job);
+    }
+
+    auto start = std::chrono::steady_clock::now();
+    const std::vector<TableRef>& inputs = job.inputs;
+
+    std::vector<std::unique_ptr<TableIterator>> iters;
+    MergeHeap heap;
+    uint64_t bytes_read = 0;
+    uint64_t output_seq = 0;
+    for (size_t i = 0; i < inputs.size(); i++) {
+        iters.emplace_back(new TableIterator(inputs[i]->path));
+        if (iters.back()->valid()) {
+            heap.push(MergeItem{iters.back().get(), i});
+        }
+        bytes_read += inputs[i]->file_size;
+        output_seq = std::max(output_seq, inputs[i]->seq);
+    }
+
+    // Range tombstones no snapshot reads below delete what they cover
+    uint64_t snapshot;
+    GcPolicies policies;
+    RangeTombstones range_dels;
+    {
+        std::lock_guard<std::mutex> lock(engine->mu);
+        snapshot = smallest_snapshot(engine);
+        policies = engine->gc_policies;
+        visible_range_tombstones(engine->current->range_dels, snapshot, range_dels);
+    }
+    int64_t now = now_micros();
+
+    OutputSet outputs(engine);
+    int merge_operator = engine->options.merge_operator;
+    VersionFilter filter(snapshot, merge_operator != SSTABLE_MERGE_NONE);
+    CellCollector collector(std::move(policies), now, snapshot);
+    std::string previous_key;
+    uint64_t entries_dropped = 0;
+    uint64_t tombstones_dropped = 0;
+    uint64_t entries_expired = 0;
+    while (!heap.empty()) {
+        std::string key = heap.top().iter->key();
+        uint64_t seq = heap.top().iter->seq();
+        MemEntry entry = heap.top().iter->entry();
+        advance(heap);
+
+        // Versions overwritten before the oldest snapshot are seen by no one
+        if (filter.shadowed(key, seq, entry)) {
+            entries_dropped++;
+            continue;
+        }
+        // Nor are versions a range tombstone below the oldest snapshot deletes
+        if (range_deleted(range_dels, key, seq)) {
+            entries_dropped++;
+            continue;
+        }
+        // Every snapshot reads the operands below the oldest one together,
+        // so they are folded into one
+        if (entry.merge && merge_operator != SSTABLE_MERGE_NONE && seq <= snapshot) {
+            entries_dropped += fold_operands(heap, key, entry, merge_operator, range_dels, now, job.older);
+        }
+        bool newest_version = key != previous_key;
+        previous_key = key;
+
+        // An expired value reads exactly like a tombstone at its sequence
+        // number, so it becomes one and the tombstone rule below applies
+        bool expired = !entry.deleted && !entry.live(now);
+        if (expired) {
+            entries_expired++;
+            entry = MemEntry{true, std::string(), 0, false};
+        }
+
+        // Cells their family's policy collects go; a tombstone takes the
+        // place of the newest version if an older table may hold the key
+        if (collector.collected(key, seq, entry)) {
+            if (!newest_version || tombstone_shadows_nothing(key, job.older)) {
+                entries_dropped++;
+                continue;
+            }
+            entry = MemEntry{true, std::string(), 0, false};
+        }
+        if (entry.deleted && seq <= snapshot && tombstone_shadows_nothing(key, job.older)) {
+            if (!expired) {
+                tombstones_dropped++;
+            }
+            continue;
+        }
+
+        // Split outputs between keys, so a key's versions stay in one table
+        if (job.split && outputs.writer() != nullptr && key != outputs.writer()->largest() &&
+            outputs.writer()->file_size() >= engine->options.target_file_size &&
+            !outputs.close(output_seq)) {
+            return false;
+        }
+        if (!outputs.open()) {
+            return false;
+        }
+        outputs.writer()->add(key, seq, entry);
+    }
+    if (!outputs.close(output_seq) || !sync_dir(engine->data_dir)) {
+        return false;
+    }
+
+    // Install the new table set
+    {
+        std::lock_guard<std::mutex> lock(engine->mu);
+        if (!install_version(engine, job, outputs.tables(), range_dels)) {
+            return false;
+        }
+        outputs.commit();
+
+        // Readers may still see the inputs, so they are deleted once the
+        // last version listing them is released
+        engine->obsolete.insert(engine->obsolete.end(), inputs.begin(), inputs.end());
+        engine->has_obsolete = true;
+
+        CompactionStats& stats = engine->compaction_stats;
+        stats.compactions++;
+        stats.bytes_read += bytes_read;
+        for (const auto& table : outputs.tables()) {
+            stats.bytes_written += table->file_size;
+        }
+        stats.entries_dropped += entries_dropped;
+        stats.tombstones_dropped += tombstones_dropped;
+        stats.entries_expired += entries_expired;
+        stats.micros += std::chrono::duration_cast<std::chrono::microseconds>(
+            std::chrono::steady_clock::now() - start).count();
+    }
+
+    return true;
+}
+
+// Run job, then delete the inputs no reader holds any more. The job itself
+// holds them until then.
+static bool run_job(sstable_engine* engine, CompactionJob& job) {
+    bool ok = run_compaction(engine, job);
+    job = CompactionJob();
+    std::lock_guard<std::mutex> lock(engine->mu);
+    delete_obsolete_tables(engine);
+    return ok;
+}
+
+// Pick and run one compaction for the configured strategy. Returns false if
+// there was nothing to do or the compaction failed.
+static bool compaction_run_once(sstable_engine* engine) {
+    std::lock_guard<std::mutex> compaction_lock(engine->compaction_mu);
+
+    VersionRef version;
+    {
+        std::lock_guard<std::mutex> lock(engine->mu);
+        version = engine->current;
+    }
+
+    CompactionJob job;
+    bool picked;
+    if (engine->options.compaction_strategy == SSTABLE_COMPACTION_LEVELED) {
+        picked = pick_leveled_job(engine, *version, job);
+    } else {
+        picked = pick_size_tiered_job(*version, job);
+    }
+    version.reset();
+    if (!picked) {
+        return false;
+    }
+
+    return run_job(engine, job);
+}
+
+bool compaction_run_major(sstable_engine* engine) {
+    std::lock_guard<std::mutex> compaction_lock(engine->compaction_mu);
+
+    CompactionJob job;
+    {
+        std::lock_guard<std::mutex> lock(engine->mu);
+        const Version& version = *engine->current;
+
+        // Deeper levels hold older data, so they go first
+        for (int level = NUM_LEVELS - 1; level >= 0; level--) {
+            const std::vector<TableRef>& files = version.levels[level];
+            job.inputs.insert(job.inputs.end(), files.begin(), files.end());
+            if (job.output_level == 0 && level > 0 && !files.empty()) {
+                job.output_level = level;
+            }
+        }
+
+        // With no tables, range tombstones have nothing left to delete
+        if (job.inputs.empty()) {
+            RangeTombstones range_dels;
+            visible_range_tombstones(version.range_dels, smallest_snapshot(engine), range_dels);
+            return range_dels.empty() || install_version(engine, job, {}, range_dels);
+        }
+    }
+
+    if (engine->options.compaction_strategy == SSTABLE_COMPACTION_LEVELED) {
+        job.output_level = std::max(job.output_level, 1);
+        job.split = true;
+    } else {
+        job.output_level = 0;
+    }
+    return run_job(engine, job);
+}
+
+static void compaction_loop(sstable_engine* engine) {
+    for (;;) {
+        {
+            std::unique_lock<std::mutex> lock(engine->mu);
+            engine->compaction_cv.wait(lock, [engine] {
+                return engine->shutting_down || engine->compaction_scheduled;
+            });
+            if (engine->shutting_down) {
+                return;
+            }
+            engine->compaction_scheduled = false;
+        }
+
+        // Keep compacting while the picker finds work
+        while (compaction_run_once(engine)) {
+            std::lock_guard<std::mutex> lock(engine->mu);
+            if (engine->shutting_down) {
+                return;
+            }
+        }
+    }
+}
+
+void compaction_start(sstable_engine* engine) {
+    engine->compaction_thread = std::thread(compaction_loop, engine);
+    compaction_schedule(engine);
+}
+
+void compaction_stop(sstable_engine* engine) {
+    {
+        std::lock_guard<std::mutex> lock(engine->mu);
+        engine->shutting_down = true;
+    }
+    engine->compaction_cv.notify_all();
+    if (engine->compaction_thread.joinable()) {
+        engine->compaction_thread.join();
+    }
+}
+
+void compaction_schedule(sstable_engine* engine) {
+    {
+        std::lock_guard<std::mutex> lock(engine->mu);
+        engine->compaction_scheduled = true;
+    }
+    engine->compaction_cv.notify_all();
+}
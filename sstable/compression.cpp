@@ -0,0 +1,62 @@
+#include "sstable_internal.h"
+#include <zlib.h>
+
+// Block compression. Each block records the codec it was written with, so
+// changing the engine's codec only affects newly written blocks.
+
+// Compression must save at least 1/8 of the block to be worth the cost of
+// decompressing it on every read
+static bool worth_compressing(size_t raw_size, size_t compressed_size) {
+    return compressed_size < raw_size - raw_size / 8;
+}
+
+static bool zlib_compress(const std::string& raw, std::string& out) {
+    uLongf len = compressBound(raw.size());
+    out.resize(len);
+    int rc = compress2(reinterpret_cast<Bytef*>(&out[0]), &len,
+                       reinterpret_cast<const Bytef*>(raw.data()), raw.size(), Z_DEFAULT_COMPRESSION);
+    if (rc != Z_OK) {
+        return false;
+    }
+    out.resize(len);
+    return true;
+}
+
+static bool zlib_decompress(const char* data, size_t len, uint32_t raw_size, std::string& out) {
+    out.resize(raw_size);
+    uLongf out_len = raw_size;
+    if (raw_size == 0) {
+        return true;
+    }
+    int rc = uncompress(reinterpret_cast<Bytef*>(&out[0]), &out_len,
+                        reinterpret_cast<const Bytef*>(data), len);
+    return rc == Z_OK && out_len == raw_size;
+}
+
+bool compression_supported(int codec) {
+    return codec == SSTABLE_COMPRESSION_NONE || codec == SSTABLE_COMPRESSION_ZLIB;
+}
+
+uint8_t compress_block(int codec, const std::string& raw, std::string& out) {
+    if (codec == SSTABLE_COMPRESSION_ZLIB && zlib_compress(raw, out) &&
+        worth_compressing(raw.size(), out.size())) {
+        return SSTABLE_COMPRESSION_ZLIB;
+    }
+    out = raw;
+    return SSTABLE_COMPRESSION_NONE;
+}
+
+bool decompress_block(uint8_t codec, const char* data, size_t len, uint32_t raw_size, std::string& out) {
+    switch (codec) {
+    case SSTABLE_COMPRESSION_NONE:
+        if (len != raw_size) {
+            return false;
+        }
+        out.assign(data, len);
+        return true;
+    case SSTABLE_COMPRESSION_ZLIB:
+        return zlib_decompress(data, len, raw_size, out);
+    default:
+        return false; // Unknown codec
+    }
+}
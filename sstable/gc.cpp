@@ -0,0 +1,93 @@
+#include "sstable_internal.h"
+
+// Garbage collection of cell versions by column family policy. Cells are
+// written by the Go cell layer as ordinary keys; compaction decodes just
+// enough of each key to find its family, column and timestamp.
+
+static const char CELL_KEY_PREFIX[] = {'\x00', 'c'};
+
+bool GcPolicy::collects(uint64_t newer, int64_t timestamp, int64_t now) const {
+    bool too_many = max_versions > 0 && newer >= max_versions;
+    bool too_old = max_age_micros > 0 && timestamp < now - max_age_micros;
+    if (intersection && max_versions > 0 && max_age_micros > 0) {
+        return too_many && too_old;
+    }
+    return too_many || too_old;
+}
+
+// Decode one escaped component starting at pos, leaving pos after its
+// terminator
+static bool read_escaped(const std::string& key, size_t& pos, std::string* component) {
+    for (; pos + 1 < key.size(); pos++) {
+        if (key[pos] != '\x00') {
+            if (component != nullptr) {
+                component->push_back(key[pos]);
+            }
+            continue;
+        }
+        if (key[pos + 1] == '\x01') {
+            pos += 2;
+            return true;
+        }
+        if (key[pos + 1] != '\xFF') {
+            return false;
+        }
+        if (component != nullptr) {
+            component->push_back('\x00');
+        }
+        pos++;
+    }
+    return false;
+}
+
+bool decode_cell_key(const std::string& key, std::string* family, int64_t* timestamp) {
+    if (key.compare(0, sizeof(CELL_KEY_PREFIX), CELL_KEY_PREFIX, sizeof(CELL_KEY_PREFIX)) != 0) {
+        return false;
+    }
+    size_t pos = sizeof(CELL_KEY_PREFIX);
+    family->clear();
+    if (!read_escaped(key, pos, nullptr) || !read_escaped(key, pos, family) ||
+        !read_escaped(key, pos, nullptr) || key.size() - pos != 8) {
+        return false;
+    }
+    uint64_t inverted = 0;
+    for (size_t i = 0; i < 8; i++) {
+        inverted = (inverted << 8) | static_cast<uint8_t>(key[pos + i]);
+    }
+    *timestamp = static_cast<int64_t>(~inverted);
+    return true;
+}
+
+bool CellCollector::collected(const std::string& key, uint64_t seq, const MemEntry& entry) {
+    if (in_column_ && key == key_) {
+        return collect_;
+    }
+    key_ = key;
+    collect_ = false;
+    std::string family;
+    int64_t timestamp;
+    auto policy = policies_.end();
+    if (decode_cell_key(key, &family, &timestamp)) {
+        policy = policies_.find(family);
+    }
+    if (policy == policies_.end()) {
+        in_column_ = false;
+        return false;
+    }
+    std::string column = key.substr(0, key.size() - 8);
+    if (!in_column_ || column != column_) {
+        column_ = column;
+        counted_ = 0;
+    }
+    in_column_ = true;
+
+    // Tombstones are left to the tombstone rules
+    if (entry.deleted) {
+        return false;
+    }
+    collect_ = policy->second.collects(counted_, timestamp, now_);
+    if (!collect_ && seq <= smallest_snapshot_) {
+        counted_++;
+    }
+    return collect_;
+}
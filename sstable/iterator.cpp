@@ -0,0 +1,463 @@
+#include "sstable_internal.h"
+
+// Ordered iteration over the engine, or over the keys in [lower, upper). An
+// iterator merges a copy of the memtable's part of the range with the
+// immutable memtables and every table of the version current when it was
+// created. Each source only shows, for every key, its
+// newest version at or below the sequence number the iterator reads at.
+// Sources are ordered newest first (memtable, immutable memtables from
+// newest to oldest, level 0 from newest to oldest, then each deeper level),
+// and for every key only the entry from the newest source that holds it is
+// considered. Keys whose newest entry is a tombstone, or older than a range
+// tombstone over the key, are skipped. A newest entry that is a merge
+// operand is folded with the older versions of its key, which are looked up
+// in the sources' memtables and tables.
+//
+// Iteration keeps every source positioned relative to the current key. Going
+// forward, each source sits at its first entry not smaller than the key;
+// going backward, at its last entry not larger than it. Changing direction
+// re-seeks every source.
+
+class MemTableIterator : public InternalIterator {
+public:
+    explicit MemTableIterator(MemTableRef memtable)
+        : memtable_(std::move(memtable)), it_(memtable_->end()) {}
+
+    bool valid() const override { return it_ != memtable_->end(); }
+    const std::string& key() const override { return it_->first.first; }
+    uint64_t seq() const override { return it_->first.second; }
+    const MemEntry& entry() const override { return it_->second; }
+
+    void seek_to_first() override { it_ = memtable_->begin(); }
+    void seek_to_last() override {
+        it_ = memtable_->end();
+        if (!memtable_->empty()) {
+            --it_;
+        }
+    }
+    void seek(const std::string& target) override {
+        it_ = memtable_->lower_bound(InternalKey(target, UINT64_MAX));
+    }
+    void next() override {
+        if (valid()) {
+            ++it_;
+        }
+    }
+    void prev() override {
+        if (!valid()) {
+            return;
+        }
+        if (it_ == memtable_->begin()) {
+            it_ = memtable_->end();
+        } else {
+            --it_;
+        }
+    }
+
+private:
+    MemTableRef memtable_;
+    MemTable::const_iterator it_;
+};
+
+std::unique_ptr<InternalIterator> memtable_iterator(MemTableRef memtable) {
+    return std::unique_ptr<InternalIterator>(new MemTableIterator(std::move(memtable)));
+}
+
+// Shows one entry per key: the newest version at or below seq. Keys with
+// no such version are skipped. The current entry is copied out, since
+// going backward leaves the source positioned before it.
+class VisibleIterator : public InternalIterator {
+public:
+    VisibleIterator(std::unique_ptr<InternalIterator> source, uint64_t seq)
+        : source_(std::move(source)), seq_(seq) {}
+
+    bool valid() const override { return valid_; }
+    const std::string& key() const override { return key_; }
+    uint64_t seq() const override { return entry_seq_; }
+    const MemEntry& entry() const override { return entry_; }
+
+    void seek_to_first() override {
+        source_->seek_to_first();
+        find_next();
+    }
+    void seek_to_last() override {
+        source_->seek_to_last();
+        find_prev();
+    }
+    void seek(const std::string& target) override {
+        source_->seek(target);
+        find_next();
+    }
+    void next() override {
+        if (!valid_) {
+            return;
+        }
+        if (!forward_) {
+            source_->seek(key_);
+        }
+        skip_key(true);
+        find_next();
+    }
+    void prev() override {
+        if (!valid_) {
+            return;
+        }
+        if (forward_) {
+            skip_key(false);
+        }
+        find_prev();
+    }
+
+private:
+    // Step the source past every remaining version of the current key
+    void skip_key(bool forward) {
+        while (source_->valid() && source_->key() == key_) {
+            if (forward) {
+                source_->next();
+            } else {
+                source_->prev();
+            }
+        }
+    }
+
+    void take() {
+        key_ = source_->key();
+        entry_seq_ = source_->seq();
+        entry_ = source_->entry();
+        valid_ = true;
+    }
+
+    // Versions come newest first, so the first one not newer than seq_ is
+    // the visible one. The source stays on it.
+    void find_next() {
+        forward_ = true;
+        valid_ = false;
+        while (source_->valid() && source_->seq() > seq_) {
+            source_->next();
+        }
+        if (source_->valid()) {
+            take();
+        }
+    }
+
+    // Going backward, versions come oldest first: the visible one is the
+    // last not newer than seq_ before the key changes. The source ends up
+    // on the previous key.
+    void find_prev() {
+        forward_ = false;
+        valid_ = false;
+        while (source_->valid()) {
+            if (valid_ && source_->key() != key_) {
+                return;
+            }
+            if (source_->seq() <= seq_) {
+                take();
+            }
+            source_->prev();
+        }
+    }
+
+    std::unique_ptr<InternalIterator> source_;
+    uint64_t seq_;
+    bool forward_ = true;
+    bool valid_ = false;
+    std::string key_;
+    uint64_t entry_seq_ = 0;
+    MemEntry entry_;
+};
+
+std::unique_ptr<InternalIterator> visible_iterator(std::unique_ptr<InternalIterator> source, uint64_t seq) {
+    return std::unique_ptr<InternalIterator>(new VisibleIterator(std::move(source), seq));
+}
+
+struct sstable_iterator {
+    std::vector<std::unique_ptr<InternalIterator>> sources; // newest first
+    RangeTombstones range_dels; // visible to the iterator
+    int64_t now = 0; // values expired by then read as deleted
+    bool forward = true;
+    bool valid = false;
+    std::string key;
+    std::string value;
+
+    // What merge operands are folded with: the memtables and tables behind
+    // sources, in the same order, read at seq
+    uint64_t seq = 0;
+    int merge_operator = SSTABLE_MERGE_NONE;
+    std::vector<MemTableRef> memtables;
+    std::vector<std::shared_ptr<Table>> tables;
+
+    // Keys outside [lower, upper) are never settled on
+    std::string lower;
+    std::string upper;
+    bool has_upper = false;
+};
+
+// Whether the newest entry of a key, which source is at, is a value neither
+// expired nor deleted by a range tombstone
+static bool live(const sstable_iterator* iter, const InternalIterator* source) {
+    return source->entry().live(iter->now) && !range_deleted(iter->range_dels, source->key(), source->seq());
+}
+
+// Make the newest entry of a key, which source is at, the current one. A
+// merge operand is folded with the older versions of the key.
+static void settle(sstable_iterator* iter, const InternalIterator* source) {
+    iter->key = source->key();
+    iter->value = source->entry().value;
+    iter->valid = true;
+    if (!source->entry().merge || iter->merge_operator == SSTABLE_MERGE_NONE) {
+        return;
+    }
+    PointRead read(iter->key, iter->seq, covering_seq(iter->range_dels, iter->key, iter->seq), iter->now,
+                   iter->merge_operator);
+    for (size_t i = 0; !read.done() && i < iter->memtables.size(); i++) {
+        read.search(*iter->memtables[i]);
+    }
+    for (size_t i = 0; !read.done() && i < iter->tables.size(); i++) {
+        read.search(*iter->tables[i]);
+    }
+    read.result(iter->value);
+}
+
+// Settle on the smallest key any source is at, skipping deleted and
+// expired keys
+static void find_next_live(sstable_iterator* iter) {
+    iter->valid = false;
+    while (true) {
+        InternalIterator* newest = nullptr;
+        for (const auto& source : iter->sources) {
+            if (source->valid() && (newest == nullptr || source->key() < newest->key())) {
+                newest = source.get();
+            }
+        }
+        if (newest == nullptr || (iter->has_upper && newest->key() >= iter->upper)) {
+            return;
+        }
+        if (live(iter, newest)) {
+            settle(iter, newest);
+            return;
+        }
+
+        // Step every source past the deleted key
+        std::string key = newest->key();
+        for (const auto& source : iter->sources) {
+            if (source->valid() && source->key() == key) {
+                source->next();
+            }
+        }
+    }
+}
+
+// Settle on the largest key any source is at, skipping deleted and expired
+// keys
+static void find_prev_live(sstable_iterator* iter) {
+    iter->valid = false;
+    while (true) {
+        InternalIterator* newest = nullptr;
+        for (const auto& source : iter->sources) {
+            if (source->valid() && (newest == nullptr || source->key() > newest->key())) {
+                newest = source.get();
+            }
+        }
+        if (newest == nullptr || newest->key() < iter->lower) {
+            return;
+        }
+        if (live(iter, newest)) {
+            settle(iter, newest);
+            return;
+        }
+
+        std::string key = newest->key();
+        for (const auto& source : iter->sources) {
+            if (source->valid() && source->key() == key) {
+                source->prev();
+            }
+        }
+    }
+}
+
+extern "C" sstable_iterator* sstable_iter_new(sstable_engine* engine, uint64_t seq) {
+    return sstable_iter_new_bounded(engine, seq, nullptr, 0, nullptr, 0);
+}
+
+extern "C" sstable_iterator* sstable_iter_new_bounded(sstable_engine* engine, uint64_t seq, const char* lower,
+                                                      size_t lower_len, const char* upper, size_t upper_len) {
+    if (engine == nullptr || (lower == nullptr && lower_len != 0)) {
+        return nullptr;
+    }
+    std::unique_ptr<sstable_iterator> iter(new sstable_iterator());
+    if (lower != nullptr) {
+        iter->lower.assign(lower, lower_len);
+    }
+    if (upper != nullptr) {
+        iter->upper.assign(upper, upper_len);
+        iter->has_upper = true;
+    }
+
+    MemTableRef memtable;
+    std::deque<SealedMemTable> immutables;
+    VersionRef current;
+    {
+        std::lock_guard<std::mutex> lock(engine->mu);
+        seq = std::min(seq, engine->visible_seq);
+        // Copying only the range keeps the engine locked for as short as
+        // the scan is
+        MemTable::const_iterator first = engine->memtable.lower_bound(InternalKey(iter->lower, UINT64_MAX));
+        MemTable::const_iterator last = iter->has_upper
+            ? engine->memtable.lower_bound(InternalKey(iter->upper, UINT64_MAX))
+            : engine->memtable.end();
+        if (iter->has_upper && iter->upper < iter->lower) {
+            last = first;
+        }
+        memtable = std::make_shared<const MemTable>(first, last);
+        immutables = engine->immutables;
+        current = engine->current;
+        visible_range_tombstones(engine->range_dels, seq, iter->range_dels);
+    }
+
+    VersionPin version(engine, std::move(current));
+
+    iter->now = now_micros();
+    iter->seq = seq;
+    iter->merge_operator = engine->options.merge_operator;
+    iter->sources.push_back(visible_iterator(memtable_iterator(memtable), seq));
+    iter->memtables.push_back(memtable);
+    for (auto it = immutables.rbegin(); it != immutables.rend(); ++it) {
+        iter->sources.push_back(visible_iterator(memtable_iterator(it->entries), seq));
+        iter->memtables.push_back(it->entries);
+        visible_range_tombstones(it->range_dels, seq, iter->range_dels);
+    }
+    visible_range_tombstones(version->range_dels, seq, iter->range_dels);
+
+    // Holding the open tables rather than the version keeps their files
+    // readable after a compaction deletes them, without making compaction
+    // wait for the iterator to be destroyed
+    std::vector<TableRef> tables(version->levels[0].rbegin(), version->levels[0].rend());
+    for (int level = 1; level < NUM_LEVELS; level++) {
+        tables.insert(tables.end(), version->levels[level].begin(), version->levels[level].end());
+    }
+    for (const auto& meta : tables) {
+        std::shared_ptr<Table> table = engine->table_cache->find(*meta);
+        if (!table) {
+            return nullptr;
+        }
+        iter->sources.push_back(visible_iterator(
+            std::unique_ptr<InternalIterator>(new TableIterator(table)), seq));
+        iter->tables.push_back(table);
+    }
+    return iter.release();
+}
+
+extern "C" void sstable_iter_destroy(sstable_iterator* iter) {
+    delete iter;
+}
+
+extern "C" bool sstable_iter_valid(const sstable_iterator* iter) {
+    return iter != nullptr && iter->valid;
+}
+
+extern "C" void sstable_iter_seek_to_first(sstable_iterator* iter) {
+    if (iter == nullptr) {
+        return;
+    }
+    for (const auto& source : iter->sources) {
+        source->seek(iter->lower);
+    }
+    iter->forward = true;
+    find_next_live(iter);
+}
+
+extern "C" void sstable_iter_seek_to_last(sstable_iterator* iter) {
+    if (iter == nullptr) {
+        return;
+    }
+    for (const auto& source : iter->sources) {
+        if (!iter->has_upper) {
+            source->seek_to_last();
+            continue;
+        }
+        // The last entry before upper
+        source->seek(iter->upper);
+        if (source->valid()) {
+            source->prev();
+        } else {
+            source->seek_to_last();
+        }
+    }
+    iter->forward = false;
+    find_prev_live(iter);
+}
+
+extern "C" void sstable_iter_seek(sstable_iterator* iter, const char* key, size_t key_len) {
+    if (iter == nullptr || (key == nullptr && key_len != 0)) {
+        return;
+    }
+    std::string target = key == nullptr ? std::string() : std::string(key, key_len);
+    target = std::max(target, iter->lower);
+    for (const auto& source : iter->sources) {
+        source->seek(target);
+    }
+    iter->forward = true;
+    find_next_live(iter);
+}
+
+extern "C" void sstable_iter_next(sstable_iterator* iter) {
+    if (!sstable_iter_valid(iter)) {
+        return;
+    }
+    if (!iter->forward) {
+        // Sources behind the current key move up to it
+        for (const auto& source : iter->sources) {
+            source->seek(iter->key);
+        }
+        iter->forward = true;
+    }
+    for (const auto& source : iter->sources) {
+        if (source->valid() && source->key() == iter->key) {
+            source->next();
+        }
+    }
+    find_next_live(iter);
+}
+
+extern "C" void sstable_iter_prev(sstable_iterator* iter) {
+    if (!sstable_iter_valid(iter)) {
+        return;
+    }
+    if (!iter->forward) {
+        for (const auto& source : iter->sources) {
+            if (source->valid() && source->key() == iter->key) {
+                source->prev();
+            }
+        }
+    } else {
+        // Move every source to its last entry before the current key
+        for (const auto& source : iter->sources) {
+            source->seek(iter->key);
+            if (source->valid()) {
+                source->prev();
+            } else {
+                source->seek_to_last();
+            }
+        }
+        iter->forward = false;
+    }
+    find_prev_live(iter);
+}
+
+extern "C" bool sstable_iter_key(const sstable_iterator* iter, sstable_bytes* out) {
+    if (!sstable_iter_valid(iter) || out == nullptr) {
+        return false;
+    }
+    out->data = iter->key.data();
+    out->len = iter->key.size();
+    return true;
+}
+
+extern "C" bool sstable_iter_value(const sstable_iterator* iter, sstable_bytes* out) {
+    if (!sstable_iter_valid(iter) || out == nullptr) {
+        return false;
+    }
+    out->data = iter->value.data();
+    out->len = iter->value.size();
+    return true;
+}
@@ -0,0 +1,110 @@
+#include "sstable_internal.h"
+
+// Merge operators and the read path that folds merge operands. The Go
+// backend has the same operators in pkg/storage/merge.go.
+
+static bool decode_int64(const std::string& value, uint64_t& out) {
+    if (value.size() != 8) {
+        return false;
+    }
+    out = 0;
+    for (size_t i = 0; i < 8; i++) {
+        out = (out << 8) | static_cast<uint8_t>(value[i]);
+    }
+    return true;
+}
+
+static std::string encode_int64(uint64_t value) {
+    std::string out(8, '\0');
+    for (int i = 7; i >= 0; i--) {
+        out[i] = static_cast<char>(value & 0xff);
+        value >>= 8;
+    }
+    return out;
+}
+
+std::string merge_operands(int merge_operator, const std::string* existing,
+                           const std::vector<std::string>& operands) {
+    switch (merge_operator) {
+    case SSTABLE_MERGE_INT64_ADD: {
+        // Unsigned arithmetic wraps like two's complement addition
+        uint64_t sum = 0;
+        uint64_t value;
+        if (existing != nullptr && decode_int64(*existing, value)) {
+            sum = value;
+        }
+        for (const auto& operand : operands) {
+            if (decode_int64(operand, value)) {
+                sum += value;
+            }
+        }
+        return encode_int64(sum);
+    }
+    case SSTABLE_MERGE_STRING_APPEND: {
+        std::string out = existing != nullptr ? *existing : std::string();
+        for (const auto& operand : operands) {
+            out.append(operand);
+        }
+        return out;
+    }
+    case SSTABLE_MERGE_MAX: {
+        const std::string* largest = existing;
+        for (const auto& operand : operands) {
+            if (largest == nullptr || operand > *largest) {
+                largest = &operand;
+            }
+        }
+        return largest != nullptr ? *largest : std::string();
+    }
+    }
+    // Without an operator the newest operand is the value
+    return operands.empty() ? std::string() : operands.back();
+}
+
+void PointRead::search(const MemTable& memtable) {
+    while (!done_) {
+        auto it = memtable.lower_bound(InternalKey(key_, seq_));
+        if (it == memtable.end() || it->first.first != key_) {
+            return;
+        }
+        add(it->first.second, it->second);
+    }
+}
+
+void PointRead::search(const Table& table) {
+    uint64_t seq;
+    MemEntry entry;
+    while (!done_ && table.get(key_, seq_, seq, entry)) {
+        add(seq, entry);
+    }
+}
+
+void PointRead::add(uint64_t seq, const MemEntry& entry) {
+    if (seq < covering_) {
+        done_ = true;
+    } else if (!entry.live(now_)) {
+        expired_ = !entry.deleted;
+        done_ = true;
+    } else if (!entry.merge || merge_operator_ == SSTABLE_MERGE_NONE) {
+        base_ = entry.value;
+        has_base_ = true;
+        done_ = true;
+    } else {
+        operands_.push_back(entry.value);
+        if (seq == 0) {
+            done_ = true;
+        } else {
+            seq_ = seq - 1;
+        }
+    }
+}
+
+bool PointRead::result(std::string& out) const {
+    if (operands_.empty()) {
+        out = base_;
+        return has_base_;
+    }
+    std::vector<std::string> operands(operands_.rbegin(), operands_.rend());
+    out = merge_operands(merge_operator_, has_base_ ? &base_ : nullptr, operands);
+    return true;
+}
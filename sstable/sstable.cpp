@@ -1,295 +1,618 @@
-#include "sstable.h"
-#include <map>
-#include <string>
-#include <fstream>
-#include <iostream>
+#include "sstable_internal.h"
 #include <algorithm>
+#include <cstdio>
 #include <cstring>
-#include <vector>
-#include <cstdint>
-
-// Memtable implementation using std::map
-static std::map<std::string, std::string> memtable;
-static size_t memtable_size = 0;
-static const size_t MEMTABLE_FLUSH_THRESHOLD = 1024 * 1024; // 1 MB
-static uint32_t sstable_counter = 0;
-static std::string data_dir = "./data";
 
 // Helper to calculate size of a key-value pair
 static size_t calculate_kv_size(const std::string& key, const std::string& value) {
     return key.size() + sizeof(uint32_t) + value.size();
 }
 
+// Helper to calculate size of a memtable entry (tombstones have no value)
+static size_t calculate_entry_size(const std::string& key, const MemEntry& entry) {
+    return calculate_kv_size(key, entry.value);
+}
+
+// Add a version of key to the memtable, keeping memtable_size in sync.
+// Older versions stay until a flush finds no snapshot that can see them.
+static void memtable_add(sstable_engine* engine, const std::string& key, uint64_t seq, const MemEntry& entry) {
+    if (engine->memtable.emplace(InternalKey(key, seq), entry).second) {
+        engine->memtable_size += calculate_entry_size(key, entry);
+    }
+    engine->last_seq = std::max(engine->last_seq, seq);
+}
+
+// Build a std::string from a pointer+length pair coming from the C API.
+// Returns false if a non-empty buffer is passed as NULL.
+static bool to_string(const char* data, size_t len, std::string& out) {
+    if (data == nullptr) {
+        if (len != 0) {
+            return false;
+        }
+        out.clear();
+        return true;
+    }
+    out.assign(data, len);
+    return true;
+}
+
+// Copy a value into a newly allocated sstable_bytes buffer
+static void copy_to_bytes(const std::string& value, sstable_bytes* out) {
+    size_t len = value.size();
+    char* data = new char[len];
+    std::memcpy(data, value.data(), len);
+
+    out->data = data;
+    out->len = len;
+}
+
+// Follow the versions of a key through the memtable, then the immutable
+// memtables from newest to oldest. Must be called with engine->mu held.
+static void search_memtables(sstable_engine* engine, PointRead& read) {
+    read.search(engine->memtable);
+    for (auto it = engine->immutables.rbegin(); !read.done() && it != engine->immutables.rend(); ++it) {
+        read.search(*it->entries);
+    }
+}
+
+// Sequence number of the newest range tombstone visible at seq that covers
+// key, in any memtable or the current version. Must be called with
+// engine->mu held.
+static uint64_t covering_range_tombstone(sstable_engine* engine, const std::string& key, uint64_t seq) {
+    uint64_t covering = std::max(covering_seq(engine->range_dels, key, seq),
+                                 covering_seq(engine->current->range_dels, key, seq));
+    for (const auto& immutable : engine->immutables) {
+        covering = std::max(covering, covering_seq(immutable.range_dels, key, seq));
+    }
+    return covering;
+}
+
+// Fill opts with the default options
+extern "C" void sstable_default_options(sstable_options* opts) {
+    if (opts == nullptr) {
+        return;
+    }
+    opts->compaction_strategy = SSTABLE_COMPACTION_SIZE_TIERED;
+    opts->level0_file_trigger = 4;
+    opts->level1_max_bytes = 10 * MEMTABLE_FLUSH_THRESHOLD;
+    opts->level_size_ratio = 10;
+    opts->target_file_size = 2 * MEMTABLE_FLUSH_THRESHOLD;
+    opts->bloom_bits_per_key = 10;
+    opts->block_size = 4096;
+    opts->compression = SSTABLE_COMPRESSION_NONE;
+    opts->max_open_tables = 1000;
+    opts->block_cache_bytes = 8 * 1024 * 1024;
+    opts->merge_operator = SSTABLE_MERGE_NONE;
+}
+
+// Reject options the compaction code cannot work with
+static bool valid_options(const sstable_options& opts) {
+    if (opts.compaction_strategy != SSTABLE_COMPACTION_SIZE_TIERED &&
+        opts.compaction_strategy != SSTABLE_COMPACTION_LEVELED) {
+        return false;
+    }
+    return opts.level0_file_trigger > 0 && opts.level1_max_bytes > 0 &&
+           opts.level_size_ratio > 1 && opts.target_file_size > 0 && opts.block_size > 0 &&
+           compression_supported(opts.compression) && opts.max_open_tables > 0 &&
+           opts.merge_operator >= SSTABLE_MERGE_NONE && opts.merge_operator <= SSTABLE_MERGE_MAX;
+}
+
 // Initialize SSTable engine
-extern "C" bool sstable_init(const char* dir) {
+extern "C" sstable_engine* sstable_init(const char* dir, const sstable_options* opts) {
+    sstable_engine* engine = new sstable_engine();
+    sstable_default_options(&engine->options);
+    if (opts != nullptr) {
+        engine->options = *opts;
+    }
+    if (!valid_options(engine->options)) {
+        delete engine;
+        return nullptr;
+    }
     if (dir != nullptr) {
-        data_dir = std::string(dir);
+        engine->data_dir = std::string(dir);
     }
+    engine->block_cache.reset(new BlockCache(engine->options.block_cache_bytes));
+    engine->table_cache.reset(new TableCache(engine->options.max_open_tables, engine->block_cache.get()));
     
     // Ensure data directory exists
-    std::string mkdir_cmd = "mkdir -p " + data_dir;
-    system(mkdir_cmd.c_str());
+    std::string mkdir_cmd = "mkdir -p " + engine->data_dir;
+    if (system(mkdir_cmd.c_str()) != 0) {
+        delete engine;
+        return nullptr;
+    }
     
-    // Find the highest existing SSTable number
-    sstable_counter = 0;
-    for (uint32_t i = 1; i < 10000; i++) {
-        char filename[256];
-        snprintf(filename, sizeof(filename), "%s/sstable_%04u.sst", data_dir.c_str(), i);
-        std::ifstream file(filename);
-        if (file.good()) {
-            sstable_counter = i;
-        } else {
-            break;
-        }
+    // Load the table set, dropping files left behind by a crash
+    if (!version_load(engine)) {
+        delete engine;
+        return nullptr;
     }
+    engine->visible_seq = engine->last_seq;
 
-    memtable.clear();
-    memtable_size = 0;
+    compaction_start(engine);
     
-    return true;
+    return engine;
 }
 
 // sstable destructor
-extern "C" void sstable_destroy() {
-    memtable.clear();
-    memtable_size = 0;
-    sstable_counter = 0;
+extern "C" void sstable_destroy(sstable_engine* engine) {
+    if (engine == nullptr) {
+        return;
+    }
+    compaction_stop(engine);
+    {
+        // No reader is left to hold an obsolete table
+        std::lock_guard<std::mutex> lock(engine->mu);
+        delete_obsolete_tables(engine);
+    }
+    delete engine;
 }
 
 // Put a key-value pair into memtable
-extern "C" bool sstable_put(const char* key, const char* value) {
-    if (key == nullptr || value == nullptr) {
+extern "C" bool sstable_put(sstable_engine* engine, uint64_t seq, const char* key, size_t key_len,
+                            const char* value, size_t value_len) {
+    return sstable_put_expiring(engine, seq, key, key_len, value, value_len, 0);
+}
+
+extern "C" bool sstable_put_expiring(sstable_engine* engine, uint64_t seq, const char* key, size_t key_len,
+                                     const char* value, size_t value_len, int64_t expires_at) {
+    std::string key_str, value_str;
+    if (engine == nullptr || expires_at < 0 || !to_string(key, key_len, key_str) ||
+        !to_string(value, value_len, value_str)) {
         return false;
     }
-    
-    std::string key_str(key);
-    std::string value_str(value);
-    
-    // Calculate size change
-    size_t old_size = 0;
-    if (memtable.find(key_str) != memtable.end()) {
-        old_size = calculate_kv_size(key_str, memtable[key_str]);
-    }
-    size_t new_size = calculate_kv_size(key_str, value_str);
-    
-    memtable_size = memtable_size - old_size + new_size;
-    memtable[key_str] = value_str;
-    
+
+    std::lock_guard<std::mutex> lock(engine->mu);
+    memtable_add(engine, key_str, seq, MemEntry{false, value_str, expires_at, false});
+
     return true;
 }
 
 // Get a value from memtable
-extern "C" bool sstable_get_memtable(const char* key, sstable_bytes* out) {
-    if (key == nullptr || out == nullptr) {
+extern "C" bool sstable_get_memtable(sstable_engine* engine, const char* key, size_t key_len, sstable_bytes* out) {
+    std::string key_str;
+    if (engine == nullptr || out == nullptr || !to_string(key, key_len, key_str)) {
         return false;
     }
     
-    std::string key_str(key);
-    auto it = memtable.find(key_str);
-    if (it != memtable.end()) {
-        // Allocate memory for the value
-        size_t len = it->second.size();
-        char* data = new char[len];
-        std::memcpy(data, it->second.c_str(), len);
-        
-        out->data = data;
-        out->len = len;
+    std::string value;
+    bool found;
+    {
+        std::lock_guard<std::mutex> lock(engine->mu);
+        uint64_t covering = covering_seq(engine->range_dels, key_str, engine->visible_seq);
+        PointRead read(key_str, engine->visible_seq, covering, now_micros(), engine->options.merge_operator);
+        read.search(engine->memtable);
+        found = read.result(value);
+    }
+    if (found) {
+        copy_to_bytes(value, out);
         return true;
     }
     
     return false;
 }
 
-// Delete a value in sstable
-extern "C" bool sstable_delete(const char* key) {
-    if (key == nullptr) {
+// Delete a value in sstable. The key may live in an older SSTable, so a
+// tombstone is always recorded instead of just erasing the memtable slot.
+extern "C" bool sstable_delete(sstable_engine* engine, uint64_t seq, const char* key, size_t key_len) {
+    std::string key_str;
+    if (engine == nullptr || !to_string(key, key_len, key_str)) {
         return false;
     }
 
-    std::string key_str(key);
-    auto it = memtable.find(key_str);
+    std::lock_guard<std::mutex> lock(engine->mu);
+    memtable_add(engine, key_str, seq, MemEntry{true, std::string(), 0, false});
 
-    if (it == memtable.end()) {
-        return false; // Key not found
+    return true;
+}
+
+// Add a merge operand to the memtable. Reads fold it into the older
+// versions of the key.
+extern "C" bool sstable_merge(sstable_engine* engine, uint64_t seq, const char* key, size_t key_len,
+                              const char* operand, size_t operand_len) {
+    std::string key_str, operand_str;
+    if (engine == nullptr || !to_string(key, key_len, key_str) ||
+        !to_string(operand, operand_len, operand_str)) {
+        return false;
     }
 
-    // Decrease memtable size (remove old key+value size)
-    size_t old_size = calculate_kv_size(key_str, it->second);
-    memtable_size -= old_size;
+    std::lock_guard<std::mutex> lock(engine->mu);
+    memtable_add(engine, key_str, seq, MemEntry{false, operand_str, 0, true});
+
+    return true;
+}
+
+// Record a range tombstone in the memtable. It counts towards the flush
+// threshold like an entry holding both keys.
+extern "C" bool sstable_delete_range(sstable_engine* engine, uint64_t seq, const char* start, size_t start_len,
+                                     const char* end, size_t end_len) {
+    RangeTombstone tombstone;
+    if (engine == nullptr || !to_string(start, start_len, tombstone.start) ||
+        !to_string(end, end_len, tombstone.end)) {
+        return false;
+    }
+    tombstone.seq = seq;
 
-    // Erase from memtable
-    memtable.erase(it);
+    std::lock_guard<std::mutex> lock(engine->mu);
+    engine->memtable_size += tombstone.start.size() + tombstone.end.size() + sizeof(uint64_t);
+    engine->range_dels.push_back(std::move(tombstone));
+    engine->last_seq = std::max(engine->last_seq, seq);
 
     return true;
 }
 
+// Publish writes up to seq to readers
+extern "C" void sstable_set_visible_seq(sstable_engine* engine, uint64_t seq) {
+    if (engine == nullptr) {
+        return;
+    }
+    std::lock_guard<std::mutex> lock(engine->mu);
+    engine->visible_seq = std::max(engine->visible_seq, seq);
+    engine->last_seq = std::max(engine->last_seq, seq);
+}
+
+// Highest sequence number written or recovered
+extern "C" uint64_t sstable_last_seq(sstable_engine* engine) {
+    if (engine == nullptr) {
+        return 0;
+    }
+    std::lock_guard<std::mutex> lock(engine->mu);
+    return engine->last_seq;
+}
+
+// Pin the visible sequence number. Taking it under mu means no flush or
+// compaction can have dropped a version it sees: they only ever drop
+// versions hidden by a newer one that was already visible.
+extern "C" uint64_t sstable_snapshot_acquire(sstable_engine* engine) {
+    if (engine == nullptr) {
+        return 0;
+    }
+    std::lock_guard<std::mutex> lock(engine->mu);
+    engine->snapshots.insert(engine->visible_seq);
+    return engine->visible_seq;
+}
+
+extern "C" void sstable_snapshot_release(sstable_engine* engine, uint64_t seq) {
+    if (engine == nullptr) {
+        return;
+    }
+    std::lock_guard<std::mutex> lock(engine->mu);
+    auto it = engine->snapshots.find(seq);
+    if (it != engine->snapshots.end()) {
+        engine->snapshots.erase(it);
+    }
+}
+
+extern "C" bool sstable_set_gc_policy(sstable_engine* engine, const char* family, size_t family_len,
+                                      uint64_t max_versions, int64_t max_age_micros, bool intersection) {
+    if (engine == nullptr || (family == nullptr && family_len != 0) || max_age_micros < 0) {
+        return false;
+    }
+    GcPolicy policy;
+    policy.max_versions = max_versions;
+    policy.max_age_micros = max_age_micros;
+    policy.intersection = intersection;
+
+    std::lock_guard<std::mutex> lock(engine->mu);
+    engine->gc_policies[std::string(family == nullptr ? "" : family, family_len)] = policy;
+    return true;
+}
+
 
 // Check if memtable needs flushing
-extern "C" bool sstable_needs_flush() {
-    return memtable_size >= MEMTABLE_FLUSH_THRESHOLD;
+extern "C" bool sstable_needs_flush(sstable_engine* engine) {
+    if (engine == nullptr) {
+        return false;
+    }
+    std::lock_guard<std::mutex> lock(engine->mu);
+    return engine->memtable_size >= MEMTABLE_FLUSH_THRESHOLD;
 }
 
-// Write memtable to SSTable file
-extern "C" bool sstable_flush() {
-    if (memtable.empty()) {
-        return true; // Nothing to flush
+// Check if the memtable holds nothing
+extern "C" bool sstable_memtable_empty(sstable_engine* engine) {
+    if (engine == nullptr) {
+        return true;
     }
-    
-    // Generate filename
-    sstable_counter++;
-    char filename[256];
-    snprintf(filename, sizeof(filename), "%s/sstable_%04u.sst", data_dir.c_str(), sstable_counter);
-    
-    std::ofstream file(filename, std::ios::binary);
-    if (!file.is_open()) {
+    std::lock_guard<std::mutex> lock(engine->mu);
+    return engine->memtable.empty() && engine->range_dels.empty();
+}
+
+// Seal the memtable and start an empty one
+extern "C" bool sstable_make_immutable(sstable_engine* engine) {
+    if (engine == nullptr) {
         return false;
     }
-    
-    // Write data section: <key><value_length><value>...
-    std::vector<std::pair<std::string, size_t>> index; // key -> offset
-    size_t current_offset = 0;
-    
+    std::lock_guard<std::mutex> lock(engine->mu);
+    if (engine->memtable.empty() && engine->range_dels.empty()) {
+        return false;
+    }
+    SealedMemTable sealed;
+    sealed.entries = std::make_shared<const MemTable>(std::move(engine->memtable));
+    sealed.range_dels = std::move(engine->range_dels);
+    engine->immutables.push_back(std::move(sealed));
+    engine->memtable.clear();
+    engine->range_dels.clear();
+    engine->memtable_size = 0;
+    return true;
+}
+
+// Number of immutable memtables waiting to be flushed
+extern "C" size_t sstable_num_immutable(sstable_engine* engine) {
+    if (engine == nullptr) {
+        return 0;
+    }
+    std::lock_guard<std::mutex> lock(engine->mu);
+    return engine->immutables.size();
+}
+
+// Write memtable to a new level-0 table under a temporary name and make it
+// durable before it takes its real name, so a crash never leaves a
+// half-written file that looks like a table. Reads keep using the immutable
+// memtable meanwhile.
+static TableRef write_level0_table(sstable_engine* engine, const MemTable& memtable, uint32_t number,
+                                   int crash_point, uint64_t snapshot) {
+    std::string filename = table_path(engine->data_dir, number);
+    std::string tmp = filename + ".tmp";
+    TableWriter writer(tmp, engine->options);
+    if (!writer.ok()) {
+        return nullptr;
+    }
+    // Versions no snapshot can see any more are left out; tombstones stay,
+    // as older tables may hold values they shadow. Merge operands are left
+    // for compaction to fold.
+    VersionFilter filter(snapshot, engine->options.merge_operator != SSTABLE_MERGE_NONE);
+    uint64_t max_seq = 0;
     for (const auto& kv : memtable) {
-        // Record offset for index
-        index.push_back({kv.first, current_offset});
-        
-        // Write key
-        uint32_t key_len = kv.first.size();
-        file.write(reinterpret_cast<const char*>(&key_len), sizeof(key_len));
-        file.write(kv.first.c_str(), key_len);
-        
-        // Write value length and value
-        uint32_t value_len = kv.second.size();
-        file.write(reinterpret_cast<const char*>(&value_len), sizeof(value_len));
-        file.write(kv.second.c_str(), value_len);
-        
-        current_offset += sizeof(key_len) + key_len + sizeof(value_len) + value_len;
+        const std::string& key = kv.first.first;
+        uint64_t seq = kv.first.second;
+        max_seq = std::max(max_seq, seq);
+        if (!filter.shadowed(key, seq, kv.second)) {
+            writer.add(key, seq, kv.second);
+        }
     }
-    
-    // Write index section: <num_entries><key><offset>...
-    size_t index_start = current_offset;
-    uint32_t num_entries = index.size();
-    file.write(reinterpret_cast<const char*>(&num_entries), sizeof(num_entries));
-    
-    for (const auto& entry : index) {
-        uint32_t key_len = entry.first.size();
-        file.write(reinterpret_cast<const char*>(&key_len), sizeof(key_len));
-        file.write(entry.first.c_str(), key_len);
-        
-        size_t offset = entry.second;
-        file.write(reinterpret_cast<const char*>(&offset), sizeof(offset));
+    if (!writer.finish()) {
+        std::remove(tmp.c_str());
+        return nullptr;
     }
-    
-    // Write index start offset at the end
-    file.write(reinterpret_cast<const char*>(&index_start), sizeof(index_start));
-    
-    file.close();
-    
-    // Clear memtable
-    memtable.clear();
-    memtable_size = 0;
-    
-    return true;
+    if (crash_point == SSTABLE_FLUSH_CRASH_AFTER_WRITE) {
+        return nullptr;
+    }
+    if (!writer.sync()) {
+        std::remove(tmp.c_str());
+        return nullptr;
+    }
+    if (crash_point == SSTABLE_FLUSH_CRASH_AFTER_SYNC) {
+        return nullptr;
+    }
+    if (std::rename(tmp.c_str(), filename.c_str()) != 0) {
+        std::remove(tmp.c_str());
+        return nullptr;
+    }
+    if (crash_point == SSTABLE_FLUSH_CRASH_AFTER_RENAME) {
+        return nullptr;
+    }
+    if (!sync_dir(engine->data_dir)) {
+        std::remove(filename.c_str());
+        return nullptr;
+    }
+    if (crash_point == SSTABLE_FLUSH_CRASH_AFTER_DIR_SYNC) {
+        return nullptr;
+    }
+
+    auto meta = std::make_shared<TableMeta>();
+    meta->number = number;
+    meta->path = filename;
+    meta->file_size = writer.file_size();
+    meta->smallest = writer.smallest();
+    meta->largest = writer.largest();
+
+    // Memtables hold ever newer writes, so the table is newer than any
+    // flushed before it
+    meta->seq = max_seq;
+    return meta;
 }
 
-// Read from a single SSTable file
-static bool read_sstable(const char* filename, const char* key, std::string& out_value) {
-    std::ifstream file(filename, std::ios::binary);
-    if (!file.is_open()) {
+// Write the oldest immutable memtable's entries to an SSTable file and move
+// its range tombstones into the version. A memtable holding only range
+// tombstones needs no table.
+extern "C" bool sstable_flush_immutable(sstable_engine* engine) {
+    if (engine == nullptr) {
         return false;
     }
-    
-    // Read index start offset from end of file
-    file.seekg(-static_cast<int>(sizeof(size_t)), std::ios::end);
-    size_t index_start;
-    file.read(reinterpret_cast<char*>(&index_start), sizeof(index_start));
-    
-    // Read index into memory
-    file.seekg(index_start, std::ios::beg);
-    uint32_t num_entries;
-    file.read(reinterpret_cast<char*>(&num_entries), sizeof(num_entries));
-    
-    // Load entire index into memory
-    std::vector<std::pair<std::string, size_t>> index;
-    for (uint32_t i = 0; i < num_entries; i++) {
-        uint32_t klen;
-        file.read(reinterpret_cast<char*>(&klen), sizeof(klen));
-        std::string index_key(klen, '\0');
-        file.read(&index_key[0], klen);
-        size_t offset;
-        file.read(reinterpret_cast<char*>(&offset), sizeof(offset));
-        index.push_back({index_key, offset});
+
+    std::lock_guard<std::mutex> flush_lock(engine->flush_mu);
+    SealedMemTable immutable;
+    uint32_t number = 0;
+    int crash_point;
+    uint64_t snapshot;
+    {
+        std::lock_guard<std::mutex> lock(engine->mu);
+        if (engine->immutables.empty()) {
+            return true; // Nothing to flush
+        }
+        immutable = engine->immutables.front();
+        if (!immutable.entries->empty()) {
+            number = ++engine->sstable_counter;
+        }
+        crash_point = engine->flush_crash_point;
+        snapshot = smallest_snapshot(engine);
     }
-    
-    // Binary search in loaded index
-    std::string key_str(key);
-    int left = 0, right = index.size() - 1;
-    size_t target_offset = SIZE_MAX;
-    
-    while (left <= right) {
-        int mid = (left + right) / 2;
-        int cmp = key_str.compare(index[mid].first);
-        if (cmp == 0) {
-            target_offset = index[mid].second;
-            break;
-        } else if (cmp < 0) {
-            right = mid - 1;
-        } else {
-            left = mid + 1;
+
+    VersionEdit edit;
+    edit.added_range_dels = immutable.range_dels;
+    TableRef meta;
+    if (!immutable.entries->empty()) {
+        meta = write_level0_table(engine, *immutable.entries, number, crash_point, snapshot);
+        if (!meta) {
+            return false;
         }
+        edit.added.emplace_back(0, meta);
     }
-    
-    if (target_offset == SIZE_MAX) {
-        return false; // Key not found
+
+    {
+        std::lock_guard<std::mutex> lock(engine->mu);
+
+        // The table only becomes live once the MANIFEST records it, and
+        // replaces the immutable memtable in the same step
+        if (!version_apply(engine, edit)) {
+            if (meta) {
+                std::remove(meta->path.c_str());
+            }
+            return false;
+        }
+        engine->immutables.pop_front();
     }
-    
-    // Read value at target_offset
-    file.seekg(target_offset, std::ios::beg);
-    
-    // Read key (skip it)
-    uint32_t key_len;
-    file.read(reinterpret_cast<char*>(&key_len), sizeof(key_len));
-    file.seekg(key_len, std::ios::cur);
-    
-    // Read value
-    uint32_t value_len;
-    file.read(reinterpret_cast<char*>(&value_len), sizeof(value_len));
-    out_value.resize(value_len);
-    file.read(&out_value[0], value_len);
+
+    // A new table may complete a size tier or fill level 0
+    compaction_schedule(engine);
     
     return true;
 }
 
-// Get value from SSTables (newest to oldest)
-extern "C" bool sstable_get(const char* key, sstable_bytes* out) {
-    if (key == nullptr || out == nullptr) {
+// Write the memtable and every immutable memtable to SSTable files
+extern "C" bool sstable_flush(sstable_engine* engine) {
+    if (engine == nullptr) {
         return false;
     }
-    
-    // First check memtable
-    if (sstable_get_memtable(key, out)) {
-        return true;
+    sstable_make_immutable(engine);
+    while (sstable_num_immutable(engine) > 0) {
+        if (!sstable_flush_immutable(engine)) {
+            return false;
+        }
+    }
+    return true;
+}
+
+// Get value from SSTables (newest to oldest)
+extern "C" int sstable_get(sstable_engine* engine, const char* key, size_t key_len, uint64_t seq,
+                           sstable_bytes* out) {
+    std::string key_str;
+    if (engine == nullptr || out == nullptr || !to_string(key, key_len, key_str)) {
+        return SSTABLE_GET_ERROR;
     }
     
-    // Then check SSTables from newest to oldest
-    std::string value;
-    for (uint32_t i = sstable_counter; i >= 1; i--) {
-        char filename[256];
-        snprintf(filename, sizeof(filename), "%s/sstable_%04u.sst", data_dir.c_str(), i);
-        
-        if (read_sstable(filename, key, value)) {
-            // Allocate memory for the value
-            size_t len = value.size();
-            char* data = new char[len];
-            std::memcpy(data, value.c_str(), len);
-            
-            out->data = data;
-            out->len = len;
-            return true;
+    // First check the memtables; a tombstone or an expired value there
+    // hides every SSTable. A newer range tombstone over the key deletes
+    // whatever version is found. Merge operands on the way are folded into
+    // the value below them.
+    std::unique_lock<std::mutex> lock(engine->mu);
+    seq = std::min(seq, engine->visible_seq);
+    PointRead read(key_str, seq, covering_range_tombstone(engine, key_str, seq), now_micros(),
+                   engine->options.merge_operator);
+    search_memtables(engine, read);
+    VersionPin version(engine, engine->current);
+    lock.unlock();
+    
+    // Then check SSTables from newest to oldest, stopping at the newest
+    // visible record for the key whether it is a value or a tombstone. Level 0
+    // tables may overlap, so each one is checked; deeper levels have at
+    // most one candidate table each.
+    std::vector<TableRef> candidates;
+    if (!read.done()) {
+        const std::vector<TableRef>& level0 = version->levels[0];
+        for (auto it = level0.rbegin(); it != level0.rend(); ++it) {
+            if (key_str >= (*it)->smallest && key_str <= (*it)->largest) {
+                candidates.push_back(*it);
+            }
+        }
+        for (int level = 1; level < NUM_LEVELS; level++) {
+            TableRef table = version_find_table(*version, level, key_str);
+            if (table) {
+                candidates.push_back(table);
+            }
         }
     }
+
+    for (const auto& meta : candidates) {
+        if (read.done()) {
+            break;
+        }
+        // Skipping a table that cannot be opened would return an older
+        // version, or none, as if it were current
+        std::shared_ptr<Table> table = engine->table_cache->find(*meta);
+        if (!table) {
+            return SSTABLE_GET_ERROR;
+        }
+
+        // The filter rules most absent keys out without reading a block
+        if (!table->may_contain(key_str)) {
+            engine->filter_misses++;
+            continue;
+        }
+        if (table->has_filter()) {
+            engine->filter_hits++;
+        }
+        read.search(*table);
+    }
     
-    return false;
+    if (read.expired()) {
+        engine->keys_expired++;
+    }
+    std::string value;
+    if (!read.result(value)) {
+        return SSTABLE_GET_NOT_FOUND;
+    }
+    copy_to_bytes(value, out);
+    return SSTABLE_GET_FOUND;
+}
+
+// Merge every SSTable into a single sorted run
+extern "C" bool sstable_compact(sstable_engine* engine) {
+    if (engine == nullptr) {
+        return false;
+    }
+    return compaction_run_major(engine);
+}
+
+// Link the live tables and copy the MANIFEST into dir
+extern "C" bool sstable_checkpoint(sstable_engine* engine, const char* dir) {
+    if (engine == nullptr || dir == nullptr) {
+        return false;
+    }
+    return version_checkpoint(engine, dir);
+}
+
+// Report table and compaction counters
+extern "C" bool sstable_get_stats(sstable_engine* engine, sstable_stats* out) {
+    if (engine == nullptr || out == nullptr) {
+        return false;
+    }
+
+    std::lock_guard<std::mutex> lock(engine->mu);
+    out->num_sstables = 0;
+    out->sstable_bytes = 0;
+    for (int level = 0; level < NUM_LEVELS; level++) {
+        out->num_sstables += engine->current->levels[level].size();
+        out->sstable_bytes += version_level_bytes(*engine->current, level);
+    }
+    out->memtable_bytes = engine->memtable_size;
+    out->immutable_memtables = engine->immutables.size();
+
+    const CompactionStats& stats = engine->compaction_stats;
+    out->compactions = stats.compactions;
+    out->compaction_bytes_read = stats.bytes_read;
+    out->compaction_bytes_written = stats.bytes_written;
+    out->compaction_entries_dropped = stats.entries_dropped;
+    out->compaction_tombstones_dropped = stats.tombstones_dropped;
+    out->compaction_entries_expired = stats.entries_expired;
+    out->compaction_micros = stats.micros;
+    out->filter_hits = engine->filter_hits;
+    out->filter_misses = engine->filter_misses;
+    out->keys_expired = engine->keys_expired;
+    out->table_cache_hits = engine->table_cache->hits();
+    out->table_cache_misses = engine->table_cache->misses();
+    out->block_cache_hits = engine->block_cache->hits();
+    out->block_cache_misses = engine->block_cache->misses();
+    out->block_cache_usage = engine->block_cache->usage();
+    out->block_cache_capacity = engine->block_cache->capacity();
+    return true;
+}
+
+// Testing hook: stop later flushes at the given step
+extern "C" void sstable_set_flush_crash_point(sstable_engine* engine, int point) {
+    if (engine != nullptr) {
+        std::lock_guard<std::mutex> lock(engine->mu);
+        engine->flush_crash_point = point;
+    }
 }
 
 // Free memory allocated by sstable_get
@@ -7,6 +7,7 @@ extern "C" {
 
 #include <stdbool.h>
 #include <stddef.h>
+#include <stdint.h>
 
 // Structure to hold byte data returned from C++ to Go
 typedef struct {
@@ -14,36 +15,268 @@ typedef struct {
     size_t len;
 } sstable_bytes;
 
-// Initialize SSTable engine with data directory
-bool sstable_init(const char* data_dir);
+// Table and compaction counters reported by sstable_get_stats. Compaction
+// counters are cumulative since the engine was opened.
+typedef struct {
+    uint64_t num_sstables;
+    uint64_t sstable_bytes;
+    uint64_t memtable_bytes;
+    // Full memtables waiting for a background flush
+    uint64_t immutable_memtables;
+    uint64_t compactions;
+    uint64_t compaction_bytes_read;
+    uint64_t compaction_bytes_written;
+    uint64_t compaction_entries_dropped;
+    uint64_t compaction_tombstones_dropped;
+    // Values compaction removed because they had expired
+    uint64_t compaction_entries_expired;
+    uint64_t compaction_micros;
+    // Point lookups that found the key's value expired
+    uint64_t keys_expired;
+    // Point lookups where a table's Bloom filter matched the key (the table
+    // was read) or ruled it out (the table was skipped)
+    uint64_t filter_hits;
+    uint64_t filter_misses;
+    // Lookups that found a table already open in the table cache, or had to
+    // open it
+    uint64_t table_cache_hits;
+    uint64_t table_cache_misses;
+    // Data block reads served from the block cache or from disk, and the
+    // bytes of blocks currently cached out of the configured budget
+    uint64_t block_cache_hits;
+    uint64_t block_cache_misses;
+    uint64_t block_cache_usage;
+    uint64_t block_cache_capacity;
+} sstable_stats;
+
+// Compaction strategies selectable through sstable_options
+#define SSTABLE_COMPACTION_SIZE_TIERED 0
+#define SSTABLE_COMPACTION_LEVELED 1
+
+// Block compression codecs selectable through sstable_options. The codec
+// is recorded in every block, so tables written with different codecs can
+// be read side by side.
+#define SSTABLE_COMPRESSION_NONE 0
+#define SSTABLE_COMPRESSION_ZLIB 1
+
+// Merge operators selectable through sstable_options. Operands written with
+// sstable_merge are folded into the value below them by reads and
+// compactions:
+//   INT64_ADD adds signed 64-bit integers stored as 8 bytes, big-endian; a
+//             value that is not 8 bytes long counts as 0
+//   STRING_APPEND appends each operand to the value
+//   MAX keeps the largest of the value and the operands, compared as bytes
+// With SSTABLE_MERGE_NONE an operand reads as a plain value.
+#define SSTABLE_MERGE_NONE 0
+#define SSTABLE_MERGE_INT64_ADD 1
+#define SSTABLE_MERGE_STRING_APPEND 2
+#define SSTABLE_MERGE_MAX 3
+
+// Per-engine tuning. Fill with sstable_default_options before overriding
+// individual fields so new fields keep sensible defaults.
+typedef struct {
+    int compaction_strategy;
+    // Leveled compaction: number of level-0 tables that triggers a
+    // compaction into level 1
+    uint32_t level0_file_trigger;
+    // Leveled compaction: size budget of level 1; each deeper level gets
+    // level_size_ratio times the budget of the one above it
+    uint64_t level1_max_bytes;
+    uint32_t level_size_ratio;
+    // Leveled compaction: outputs are split into tables of about this size
+    uint64_t target_file_size;
+    // Bloom filter bits per key written into each table; 0 disables filters
+    uint32_t bloom_bits_per_key;
+    // Target size of the data blocks a table is cut into
+    uint32_t block_size;
+    // Codec used to compress newly written blocks
+    int compression;
+    // Number of SSTables kept open, with their index and filter in memory
+    uint32_t max_open_tables;
+    // Memory budget for cached data blocks; 0 disables the block cache
+    uint64_t block_cache_bytes;
+    // Operator folding the operands written with sstable_merge
+    int merge_operator;
+} sstable_options;
+
+// Fill opts with the default options (size-tiered compaction)
+void sstable_default_options(sstable_options* opts);
+
+// Opaque handle to an SSTable engine instance. Each handle owns its own
+// memtable and data directory, so several engines can live in one process.
+typedef struct sstable_engine sstable_engine;
+
+// Initialize SSTable engine with data directory. opts may be NULL to use the
+// defaults. Returns NULL on failure.
+sstable_engine* sstable_init(const char* data_dir, const sstable_options* opts);
+
+// destroy sstable engine and release the handle
+void sstable_destroy(sstable_engine* engine);
 
-// destroy sstable engine
-void sstable_destroy();
+// Keys and values are passed as pointer+length pairs so they may contain
+// arbitrary bytes, including NUL. A NULL pointer is allowed when the length
+// is zero.
+
+// Every write carries a sequence number chosen by the caller, which must
+// increase from one write to the next. Writes only become visible to reads
+// once sstable_set_visible_seq covers them, so a group of writes can be
+// published at once. Reads take the sequence number to read at; they see,
+// for every key, its newest write at or below it. Reading at
+// SSTABLE_LATEST_SEQ sees every visible write.
+#define SSTABLE_LATEST_SEQ UINT64_MAX
 
 // Put a key-value pair into memtable
-bool sstable_put(const char* key, const char* value);
+bool sstable_put(sstable_engine* engine, uint64_t seq, const char* key, size_t key_len,
+                 const char* value, size_t value_len);
+
+// Put a key-value pair that expires at expires_at, in microseconds since
+// the Unix epoch; 0 never expires. Once expired, the key reads as deleted.
+bool sstable_put_expiring(sstable_engine* engine, uint64_t seq, const char* key, size_t key_len,
+                          const char* value, size_t value_len, int64_t expires_at);
+
+// Results of sstable_get
+#define SSTABLE_GET_NOT_FOUND 0
+#define SSTABLE_GET_FOUND 1
+#define SSTABLE_GET_ERROR 2 // bad arguments, or a live table could not be opened
+
+// Get a value as of seq (checks memtable first, then SSTables). out is only
+// filled in if the result is SSTABLE_GET_FOUND.
+int sstable_get(sstable_engine* engine, const char* key, size_t key_len, uint64_t seq,
+                sstable_bytes* out);
+
+// Delete a value by writing a tombstone that shadows older SSTables
+bool sstable_delete(sstable_engine* engine, uint64_t seq, const char* key, size_t key_len);
+
+// Add a merge operand for key, which reads fold into its older versions
+// with the configured merge operator
+bool sstable_merge(sstable_engine* engine, uint64_t seq, const char* key, size_t key_len,
+                   const char* operand, size_t operand_len);
+
+// Delete every key in [start, end) with a single range tombstone, which
+// hides the versions written before seq
+bool sstable_delete_range(sstable_engine* engine, uint64_t seq, const char* start, size_t start_len,
+                          const char* end, size_t end_len);
+
+// Get the newest visible value from memtable only
+bool sstable_get_memtable(sstable_engine* engine, const char* key, size_t key_len, sstable_bytes* out);
+
+// Make writes with sequence numbers up to seq visible to reads
+void sstable_set_visible_seq(sstable_engine* engine, uint64_t seq);
 
-// Get a value (checks memtable first, then SSTables)
-bool sstable_get(const char* key, sstable_bytes* out);
+// Highest sequence number written, or recorded in the data directory when
+// the engine was opened. Writes replayed from a log start after it.
+uint64_t sstable_last_seq(sstable_engine* engine);
 
-// Delete a value (checks memtable first, then SSTables)
-bool sstable_delete(const char* key);
+// Pin the newest visible sequence number and return it. Until the snapshot
+// is released, flushes and compactions keep every version a read at that
+// sequence number can see.
+uint64_t sstable_snapshot_acquire(sstable_engine* engine);
 
-// Get a value from memtable only
-bool sstable_get_memtable(const char* key, sstable_bytes* out);
+// Release a snapshot returned by sstable_snapshot_acquire
+void sstable_snapshot_release(sstable_engine* engine, uint64_t seq);
+
+// Set the garbage-collection policy of a column family of cell keys (see
+// pkg/storage/cells.go). Compaction drops the versions of a column beyond
+// its newest max_versions, or older than max_age_micros before now;
+// with intersection set, only versions breaking both limits. A zero limit
+// is unset.
+bool sstable_set_gc_policy(sstable_engine* engine, const char* family, size_t family_len,
+                           uint64_t max_versions, int64_t max_age_micros, bool intersection);
 
 // Check if memtable needs flushing
-bool sstable_needs_flush();
+bool sstable_needs_flush(sstable_engine* engine);
+
+// Check if the memtable holds nothing
+bool sstable_memtable_empty(sstable_engine* engine);
+
+// Turn the memtable into an immutable memtable, which reads still see but
+// writes no longer change, and start an empty memtable. Returns false if
+// the memtable was empty.
+bool sstable_make_immutable(sstable_engine* engine);
+
+// Number of immutable memtables waiting to be flushed
+size_t sstable_num_immutable(sstable_engine* engine);
+
+// Write the oldest immutable memtable to disk as a new SSTable and drop it.
+// Flushes are serialised, so concurrent callers flush memtables in order.
+// Returns true if there was nothing to flush.
+bool sstable_flush_immutable(sstable_engine* engine);
 
-// Flush memtable to disk as new SSTable
-bool sstable_flush();
+// Flush memtable and all immutable memtables to disk as new SSTables
+bool sstable_flush(sstable_engine* engine);
+
+// Merge every SSTable into a single sorted run, dropping overwritten values
+// and tombstones. The configured compaction strategy also runs on a
+// background thread after flushes; this call forces a full compaction and
+// blocks until it completes.
+bool sstable_compact(sstable_engine* engine);
+
+// Hard-link every live SSTable into the existing directory dir and copy the
+// MANIFEST describing them. dir must be on the same filesystem as the data
+// directory. The memtables are not written: the caller keeps their writes
+// in the WAL.
+bool sstable_checkpoint(sstable_engine* engine, const char* dir);
+
+// Fill out with the engine's current table and compaction counters
+bool sstable_get_stats(sstable_engine* engine, sstable_stats* out);
 
 // Free memory allocated by sstable_get
 void sstable_free_bytes(sstable_bytes* bytes);
 
+// Steps of a memtable flush, in order, at which a test can make it stop as if
+// the process crashed there. Nothing written up to that point is cleaned
+// up, so reopening the directory exercises crash recovery.
+#define SSTABLE_FLUSH_CRASH_NONE 0
+#define SSTABLE_FLUSH_CRASH_AFTER_WRITE 1    // temp table written, not synced
+#define SSTABLE_FLUSH_CRASH_AFTER_SYNC 2     // temp table synced, not renamed
+#define SSTABLE_FLUSH_CRASH_AFTER_RENAME 3   // renamed, directory not synced
+#define SSTABLE_FLUSH_CRASH_AFTER_DIR_SYNC 4 // durable, not in the MANIFEST
+
+// Testing hook: make every later memtable flush stop at point and fail
+void sstable_set_flush_crash_point(sstable_engine* engine, int point);
+
+// Opaque handle to an ordered iterator over an engine's live keys. An
+// iterator sees the memtable and tables as they were when it was created,
+// as of the sequence number it was created at; later writes, flushes and
+// compactions do not affect it. Iterators must be destroyed before their
+// engine.
+typedef struct sstable_iterator sstable_iterator;
+
+// Create an unpositioned iterator reading as of seq. Returns NULL on
+// failure.
+sstable_iterator* sstable_iter_new(sstable_engine* engine, uint64_t seq);
+
+// Like sstable_iter_new, but the iterator only sees the keys in
+// [lower, upper); a NULL upper leaves it unbounded above. Only that part of
+// the memtable is copied, so a short scan stays cheap however full the
+// memtable is.
+sstable_iterator* sstable_iter_new_bounded(sstable_engine* engine, uint64_t seq, const char* lower,
+                                           size_t lower_len, const char* upper, size_t upper_len);
+
+// destroy an iterator and release the handle
+void sstable_iter_destroy(sstable_iterator* iter);
+
+// True if the iterator is positioned at a key
+bool sstable_iter_valid(const sstable_iterator* iter);
+
+// Position at the first key, the last key, or the first key not smaller
+// than key
+void sstable_iter_seek_to_first(sstable_iterator* iter);
+void sstable_iter_seek_to_last(sstable_iterator* iter);
+void sstable_iter_seek(sstable_iterator* iter, const char* key, size_t key_len);
+
+// Step to the next or previous key
+void sstable_iter_next(sstable_iterator* iter);
+void sstable_iter_prev(sstable_iterator* iter);
+
+// Point out at the current key or value. The bytes belong to the iterator
+// and stay valid until it moves; they must not be passed to
+// sstable_free_bytes.
+bool sstable_iter_key(const sstable_iterator* iter, sstable_bytes* out);
+bool sstable_iter_value(const sstable_iterator* iter, sstable_bytes* out);
+
 #ifdef __cplusplus
 }
 #endif
 
 #endif // SSTABLE_H
-
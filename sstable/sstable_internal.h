@@ -0,0 +1,776 @@
+#ifndef SSTABLE_INTERNAL_H
+#define SSTABLE_INTERNAL_H
+
+// Internal declarations shared by the SSTable engine translation units.
+// Nothing in here is part of the C API exposed to Go.
+
+#include "sstable.h"
+#include <algorithm>
+#include <atomic>
+#include <chrono>
+#include <condition_variable>
+#include <cstdint>
+#include <cstring>
+#include <deque>
+#include <fstream>
+#include <list>
+#include <map>
+#include <memory>
+#include <mutex>
+#include <set>
+#include <string>
+#include <thread>
+#include <unistd.h>
+#include <unordered_map>
+#include <vector>
+
+static const size_t MEMTABLE_FLUSH_THRESHOLD = 1024 * 1024; // 1 MB
+
+// Number of levels in the table layout. Level 0 holds flushed tables, which
+// may overlap; leveled compaction pushes data into levels 1 and deeper,
+// where tables within a level never overlap.
+static const int NUM_LEVELS = 7;
+
+// Value length written to an SSTable in place of a real length to mark a
+// tombstone. Tombstone records carry no value bytes.
+static const uint32_t TOMBSTONE_VALUE_LEN = UINT32_MAX;
+
+// Value length written in place of a real length to mark a value that
+// expires. The expiry time and then the real length follow it.
+static const uint32_t EXPIRING_VALUE_LEN = UINT32_MAX - 1;
+
+// Value length written in place of a real length to mark a merge operand.
+// The real length follows it.
+static const uint32_t MERGE_VALUE_LEN = UINT32_MAX - 2;
+
+// A memtable slot is either a value, a tombstone that shadows any older
+// value of the same key stored in SSTables, or a merge operand that applies
+// to the older versions. A value that has expired shadows them just like a
+// tombstone.
+struct MemEntry {
+    bool deleted;
+    std::string value;
+    // When the value expires, in microseconds since the Unix epoch; 0 if it
+    // never does
+    int64_t expires_at;
+    bool merge;
+
+    // Whether the entry holds a value that has not expired by now
+    bool live(int64_t now) const { return !deleted && (expires_at == 0 || now < expires_at); }
+};
+
+// Wall-clock time in microseconds since the Unix epoch, which expiry times
+// and cell timestamps are measured in
+inline int64_t now_micros() {
+    return std::chrono::duration_cast<std::chrono::microseconds>(
+               std::chrono::system_clock::now().time_since_epoch()).count();
+}
+
+// Memtable key: a user key and the sequence number of the write that
+// stored it. Several versions of a key can be live at once; they sort
+// newest first.
+typedef std::pair<std::string, uint64_t> InternalKey;
+
+struct InternalKeyLess {
+    bool operator()(const InternalKey& a, const InternalKey& b) const {
+        int cmp = a.first.compare(b.first);
+        if (cmp != 0) {
+            return cmp < 0;
+        }
+        return a.second > b.second;
+    }
+};
+
+typedef std::map<InternalKey, MemEntry, InternalKeyLess> MemTable;
+typedef std::shared_ptr<const MemTable> MemTableRef;
+
+// Deletes every version of the keys in [start, end) written before seq. It
+// lives with the memtable it was written to until that is flushed, then
+// with the version until a compaction has removed everything it covers.
+struct RangeTombstone {
+    std::string start;
+    std::string end;
+    uint64_t seq;
+};
+
+typedef std::vector<RangeTombstone> RangeTombstones;
+
+// Sequence number of the newest tombstone visible at seq whose range holds
+// key, or 0 if there is none. Versions of key older than it are deleted.
+inline uint64_t covering_seq(const RangeTombstones& tombstones, const std::string& key, uint64_t seq) {
+    uint64_t covering = 0;
+    for (const auto& t : tombstones) {
+        if (t.seq <= seq && key >= t.start && key < t.end) {
+            covering = std::max(covering, t.seq);
+        }
+    }
+    return covering;
+}
+
+// Whether one of tombstones deletes version seq of key
+inline bool range_deleted(const RangeTombstones& tombstones, const std::string& key, uint64_t seq) {
+    for (const auto& t : tombstones) {
+        if (seq < t.seq && key >= t.start && key < t.end) {
+            return true;
+        }
+    }
+    return false;
+}
+
+// Append the tombstones visible at seq to dst
+inline void visible_range_tombstones(const RangeTombstones& tombstones, uint64_t seq, RangeTombstones& dst) {
+    for (const auto& t : tombstones) {
+        if (t.seq <= seq) {
+            dst.push_back(t);
+        }
+    }
+}
+
+// Immutable memtable waiting to be flushed: its entries and the range
+// tombstones written to it
+struct SealedMemTable {
+    MemTableRef entries;
+    RangeTombstones range_dels;
+};
+
+// Metadata for one live SSTable file. Tables are shared between the
+// engine's current version and any reader that grabbed a copy of it, so
+// compaction can tell when the last reader of an obsolete input is gone.
+struct TableMeta {
+    uint32_t number;
+    uint64_t seq;         // highest write sequence number; orders level 0
+    uint64_t file_size;
+    std::string smallest; // first key in the table
+    std::string largest;  // last key in the table
+    std::string path;
+};
+
+typedef std::shared_ptr<TableMeta> TableRef;
+
+// Immutable snapshot of the live table set. Level 0 is ordered oldest to
+// newest by seq; deeper levels are ordered by smallest key. Data in a
+// shallower level is always newer than overlapping data in a deeper one.
+// range_dels are the flushed range tombstones, which may cover data in any
+// table.
+struct Version {
+    std::vector<TableRef> levels[NUM_LEVELS];
+    RangeTombstones range_dels;
+};
+
+typedef std::shared_ptr<const Version> VersionRef;
+
+// Change from one version to the next, as recorded in the MANIFEST.
+// version_apply fills in the counters.
+struct VersionEdit {
+    uint32_t sstable_counter = 0;
+    uint64_t last_seq = 0;
+    std::vector<std::pair<int, TableRef>> added;     // level, table
+    std::vector<std::pair<int, uint32_t>> deleted;   // level, file number
+    RangeTombstones added_range_dels;                // flushed
+    std::vector<uint64_t> deleted_range_dels;        // by sequence number
+};
+
+class TableCache;
+class BlockCache;
+
+// Cumulative compaction counters reported through sstable_get_stats
+struct CompactionStats {
+    uint64_t compactions = 0;
+    uint64_t bytes_read = 0;
+    uint64_t bytes_written = 0;
+    uint64_t entries_dropped = 0;
+    uint64_t tombstones_dropped = 0;
+    uint64_t entries_expired = 0;
+    uint64_t micros = 0;
+};
+
+// Garbage-collection policy of a column family, set with
+// sstable_set_gc_policy. Zero limits are unset.
+struct GcPolicy {
+    uint64_t max_versions = 0;
+    int64_t max_age_micros = 0;
+    bool intersection = false;
+
+    // Whether a version written at timestamp is garbage when newer versions
+    // of its column are kept, at time now (microseconds)
+    bool collects(uint64_t newer, int64_t timestamp, int64_t now) const;
+};
+
+using GcPolicies = std::map<std::string, GcPolicy>;
+
+// Per-instance engine state. Everything that used to be a static global
+// lives here so that independent engines can share a process.
+struct sstable_engine {
+    sstable_options options;
+
+    // mu guards the memtables, the current version, the GC policies and the
+    // compaction flags
+    std::mutex mu;
+
+    // Memtable implementation using std::map, and the range tombstones
+    // written to it
+    MemTable memtable;
+    RangeTombstones range_dels;
+    size_t memtable_size = 0;
+
+    // Full memtables waiting to be flushed, oldest first. They are never
+    // modified, so readers can share them without holding mu.
+    std::deque<SealedMemTable> immutables;
+
+    // Serialises memtable flushes so immutables are flushed in order
+    std::mutex flush_mu;
+    uint32_t sstable_counter = 0;
+    std::string data_dir = "./data";
+
+    // Highest write sequence number seen, the newest one reads may see, and
+    // the sequence numbers pinned by snapshots
+    uint64_t last_seq = 0;
+    uint64_t visible_seq = 0;
+    std::multiset<uint64_t> snapshots;
+
+    // GC policies of the column families of cell keys
+    GcPolicies gc_policies;
+
+    // Current set of live tables. Replaced copy-on-write so readers can keep
+    // using the version they grabbed without holding mu.
+    VersionRef current = std::make_shared<Version>();
+
+    // Tables a compaction replaced, deleted once no version a reader pins
+    // lists them
+    std::vector<TableRef> obsolete;
+    // Whether obsolete holds any table, so releasing a pin only takes mu
+    // when it might free one. Written under mu.
+    std::atomic<bool> has_obsolete{false};
+
+    // Flush step at which to stop as if the process crashed (testing only)
+    int flush_crash_point = SSTABLE_FLUSH_CRASH_NONE;
+
+    // MANIFEST open for appending version edits, and its size so far
+    int manifest_fd = -1;
+    uint64_t manifest_size = 0;
+
+    // Per-level key where the next leveled compaction starts, so repeated
+    // compactions of a level rotate through its key space
+    std::string compact_pointer[NUM_LEVELS];
+
+    // Compaction state. compaction_mu serialises compactions so the
+    // background thread and manual compactions never pick the same inputs.
+    std::mutex compaction_mu;
+    std::condition_variable compaction_cv;
+    bool compaction_scheduled = false;
+    bool shutting_down = false;
+    std::thread compaction_thread;
+    CompactionStats compaction_stats;
+
+    // Open tables and decompressed data blocks shared by lookups; both are
+    // internally synchronised
+    std::unique_ptr<TableCache> table_cache;
+    std::unique_ptr<BlockCache> block_cache;
+
+    // Bloom filter outcomes on point lookups. Updated without holding mu.
+    std::atomic<uint64_t> filter_hits{0};
+    std::atomic<uint64_t> filter_misses{0};
+
+    // Point lookups that found the key's value expired. Updated without
+    // holding mu.
+    std::atomic<uint64_t> keys_expired{0};
+
+    ~sstable_engine() {
+        if (manifest_fd >= 0) {
+            ::close(manifest_fd);
+        }
+    }
+};
+
+// bloom.cpp
+
+// Hash a key for the Bloom filter
+uint32_t bloom_hash(const std::string& key);
+
+// Build a filter from key hashes. Returns an empty filter if disabled.
+std::string bloom_build(const std::vector<uint32_t>& hashes, uint32_t bits_per_key);
+
+// False only if the key is definitely not in the filtered table
+bool bloom_may_contain(const std::string& filter, const std::string& key);
+
+// Fixed-width fields are stored in host byte order by every on-disk format
+
+template <typename T>
+inline void put_fixed(std::string& dst, T value) {
+    dst.append(reinterpret_cast<const char*>(&value), sizeof(value));
+}
+
+inline void put_string(std::string& dst, const std::string& value) {
+    put_fixed(dst, static_cast<uint32_t>(value.size()));
+    dst.append(value);
+}
+
+template <typename T>
+inline bool get_fixed(const std::string& src, size_t& pos, T& value) {
+    if (src.size() - pos < sizeof(value)) {
+        return false;
+    }
+    std::memcpy(&value, src.data() + pos, sizeof(value));
+    pos += sizeof(value);
+    return true;
+}
+
+inline bool get_string(const std::string& src, size_t& pos, uint32_t len, std::string& out) {
+    if (src.size() - pos < len) {
+        return false;
+    }
+    out.assign(src, pos, len);
+    pos += len;
+    return true;
+}
+
+// Oldest sequence number a read may still happen at: that of the oldest
+// snapshot, or the newest write if there is none. Must be called with
+// engine->mu held.
+inline uint64_t smallest_snapshot(const sstable_engine* engine) {
+    return engine->snapshots.empty() ? engine->last_seq : *engine->snapshots.begin();
+}
+
+// Decides which versions a flush or compaction writes out. Fed every entry
+// in internal key order, it reports those hidden from every possible read
+// by a newer version of the same key. With merging set, a merge operand
+// does not hide the versions it applies to.
+class VersionFilter {
+public:
+    VersionFilter(uint64_t smallest_snapshot, bool merging)
+        : smallest_snapshot_(smallest_snapshot), merging_(merging) {}
+
+    bool shadowed(const std::string& key, uint64_t seq, const MemEntry& entry) {
+        if (!has_key_ || key != key_) {
+            key_ = key;
+            has_key_ = true;
+            hiding_ = false;
+        }
+        bool hidden = hiding_;
+        if (seq <= smallest_snapshot_ && !(merging_ && entry.merge)) {
+            hiding_ = true;
+        }
+        return hidden;
+    }
+
+private:
+    uint64_t smallest_snapshot_;
+    bool merging_;
+    bool has_key_ = false;
+    std::string key_;
+    bool hiding_ = false; // a newer version of key_ hides the older ones
+};
+
+// Split a cell key written by the cell layer in pkg/storage/cells.go:
+//   "\x00c" <row> 00 01 <family> 00 01 <qualifier> 00 01 <^timestamp>
+// with components escaped (00 as 00 FF). False for any other key.
+bool decode_cell_key(const std::string& key, std::string* family, int64_t* timestamp);
+
+// Applies column family GC policies to the entries of a compaction, which
+// come in key order with the versions of each key newest first. Versions
+// written after the oldest snapshot are left out of the count of newer
+// versions, so a snapshot never loses a version its own reads would return.
+class CellCollector {
+public:
+    CellCollector(GcPolicies policies, int64_t now, uint64_t smallest_snapshot)
+        : policies_(std::move(policies)), now_(now), smallest_snapshot_(smallest_snapshot) {}
+
+    // Whether the policy of its family collects key. Every version of a key
+    // shares the decision made for its newest one.
+    bool collected(const std::string& key, uint64_t seq, const MemEntry& entry);
+
+private:
+    GcPolicies policies_;
+    int64_t now_;
+    uint64_t smallest_snapshot_;
+
+    bool in_column_ = false;
+    std::string column_; // column of the current key, up to its timestamp
+    uint64_t counted_ = 0; // versions of column_ kept so far
+    std::string key_;
+    bool collect_ = false; // whether key_ is collected
+};
+
+// Cursor over one sorted source of entries (the memtable or a table), in
+// internal key order, so every version of a key is returned newest first.
+// Tombstones are returned like any other entry; resolving them across
+// sources is up to the caller. key, seq and entry require valid().
+class InternalIterator {
+public:
+    virtual ~InternalIterator() {}
+
+    virtual bool valid() const = 0;
+    virtual const std::string& key() const = 0;
+    virtual uint64_t seq() const = 0;
+    virtual const MemEntry& entry() const = 0;
+
+    virtual void seek_to_first() = 0;
+    virtual void seek_to_last() = 0;
+    // Position at the newest entry of the first key not smaller than target
+    virtual void seek(const std::string& target) = 0;
+    virtual void next() = 0;
+    virtual void prev() = 0;
+};
+
+// table.cpp
+
+// Build the path of the SSTable with the given file number
+std::string table_path(const std::string& data_dir, uint32_t number);
+
+// fsync a file, or a directory so that entries created, renamed or removed
+// in it survive a crash
+bool sync_file(const std::string& path);
+bool sync_dir(const std::string& dir);
+
+// Location of one data block, as recorded in a table's index
+struct BlockHandle {
+    std::string last_key; // last key stored in the block
+    uint64_t offset;
+    uint32_t size;
+};
+
+// Streams sorted entries into a new SSTable file, cutting the data section
+// into blocks of about options.block_size bytes
+class TableWriter {
+public:
+    TableWriter(const std::string& path, const sstable_options& options);
+
+    bool ok() const { return file_.good(); }
+    uint64_t num_entries() const { return num_entries_; }
+
+    // Entries must be added in internal key order: by key, then newest
+    // version first
+    void add(const std::string& key, uint64_t seq, const MemEntry& entry);
+
+    // Write the filter, index and trailer. Returns false on any I/O error.
+    bool finish();
+
+    // Force the finished file to disk
+    bool sync() { return sync_file(path_); }
+
+    const std::string& smallest() const { return smallest_; }
+    const std::string& largest() const { return largest_; }
+    uint64_t file_size() const { return offset_; }
+private:
+    // Write out the open block and record it in the index
+    void flush_block();
+
+    std::string path_;
+    std::ofstream file_;
+    uint32_t block_size_;
+    int compression_;
+    uint32_t bloom_bits_per_key_;
+    std::string block_;               // records of the block being built
+    std::vector<BlockHandle> index_;  // one entry per written block
+    std::vector<uint32_t> key_hashes_;
+    uint64_t num_entries_ = 0;
+    uint64_t offset_ = 0;
+    std::string smallest_;
+    std::string largest_;
+};
+
+typedef std::shared_ptr<const std::string> BlockRef;
+
+// An open SSTable with its block index and Bloom filter held in memory.
+// Reads use pread, so one Table can serve concurrent lookups.
+class Table {
+public:
+    // Returns nullptr if the file is missing or not a valid table. Lookups
+    // go through block_cache when it is not null; number identifies the
+    // table's blocks in the cache.
+    static std::shared_ptr<Table> open(const std::string& path, uint32_t number = 0,
+                                       BlockCache* block_cache = nullptr);
+    ~Table();
+
+    Table(const Table&) = delete;
+    Table& operator=(const Table&) = delete;
+
+    // Look up the newest version of key at or below seq, whatever it is.
+    // False if the table holds none.
+    bool get(const std::string& key, uint64_t seq, uint64_t& out_seq, MemEntry& out_entry) const;
+
+    // False only if the table definitely does not hold key
+    bool may_contain(const std::string& key) const { return bloom_may_contain(filter_, key); }
+    bool has_filter() const { return !filter_.empty(); }
+
+    // Read a data block and undo its compression, bypassing the block cache
+    bool read_block(const BlockHandle& handle, std::string& out) const;
+
+    // Return a data block, from the block cache if possible. Blocks read
+    // from disk are only added to the cache when fill_cache is set.
+    BlockRef block(const BlockHandle& handle, bool fill_cache = true) const;
+
+    const std::vector<BlockHandle>& index() const { return index_; }
+    uint64_t file_size() const { return file_size_; }
+    uint32_t version() const { return version_; }
+
+private:
+    Table() = default;
+
+    int fd_ = -1;
+    uint32_t number_ = 0;
+    BlockCache* block_cache_ = nullptr;
+    uint32_t version_ = 0;
+    uint64_t file_size_ = 0;
+    std::vector<BlockHandle> index_;
+    std::string filter_;
+};
+
+// One decoded table record. Tables written before sequence numbers were
+// stored read with seq 0, older than any write since.
+struct TableRecord {
+    std::string key;
+    uint64_t seq;
+    MemEntry entry;
+};
+
+// Walks the entries of an SSTable in key order in either direction. The
+// current block is decoded in full so stepping back within it is cheap.
+class TableIterator : public InternalIterator {
+public:
+    // Open the table at path outside any cache and position at its first
+    // entry, as compaction does
+    explicit TableIterator(const std::string& path);
+
+    // Iterate over an already open table. The iterator is unpositioned.
+    explicit TableIterator(std::shared_ptr<Table> table);
+
+    bool valid() const override { return valid_; }
+    const std::string& key() const override { return entries_[pos_].key; }
+    uint64_t seq() const override { return entries_[pos_].seq; }
+    const MemEntry& entry() const override { return entries_[pos_].entry; }
+    void seek_to_first() override;
+    void seek_to_last() override;
+    void seek(const std::string& target) override;
+    void next() override;
+    void prev() override;
+
+private:
+    // Decode block number index into entries_. Invalidates the iterator if
+    // the block is missing or corrupt.
+    bool load_block(size_t index);
+
+    std::shared_ptr<Table> table_;
+    size_t block_index_ = 0;
+    std::vector<TableRecord> entries_;
+    size_t pos_ = 0;
+    bool valid_ = false;
+};
+
+// Read the key range and size of an existing SSTable
+bool load_table_meta(const std::string& path, uint32_t number, TableMeta& meta);
+
+// table_cache.cpp
+
+// LRU cache of open tables, keyed by file number. Entries are shared, so a
+// table evicted while a lookup is using it stays open until that lookup is
+// done.
+class TableCache {
+public:
+    TableCache(size_t capacity, BlockCache* block_cache)
+        : capacity_(capacity), block_cache_(block_cache) {}
+
+    // Return the open table, opening it on a miss. nullptr if it cannot be
+    // opened.
+    std::shared_ptr<Table> find(const TableMeta& meta);
+
+    // Drop a table whose file is about to be deleted
+    void evict(uint32_t number);
+
+    uint64_t hits() const { return hits_; }
+    uint64_t misses() const { return misses_; }
+    size_t size();
+
+private:
+    typedef std::pair<uint32_t, std::shared_ptr<Table>> Entry;
+
+    std::mutex mu_;
+    size_t capacity_;
+    BlockCache* block_cache_;
+    std::list<Entry> lru_; // most recently used first
+    std::unordered_map<uint32_t, std::list<Entry>::iterator> entries_;
+    std::atomic<uint64_t> hits_{0};
+    std::atomic<uint64_t> misses_{0};
+};
+
+// block_cache.cpp
+
+// LRU cache of decompressed data blocks, bounded by the total size of the
+// cached blocks. A capacity of 0 disables caching.
+class BlockCache {
+public:
+    explicit BlockCache(uint64_t capacity) : capacity_(capacity) {}
+
+    // Return the cached block at offset in table number, or nullptr
+    BlockRef lookup(uint32_t number, uint64_t offset);
+
+    // Cache a block, evicting the least recently used blocks to make room
+    void insert(uint32_t number, uint64_t offset, const BlockRef& block);
+
+    // Drop every block of a table whose file is about to be deleted
+    void erase_table(uint32_t number);
+
+    uint64_t capacity() const { return capacity_; }
+    uint64_t hits() const { return hits_; }
+    uint64_t misses() const { return misses_; }
+    uint64_t usage();
+
+private:
+    typedef std::pair<uint32_t, uint64_t> Key; // table number, block offset
+    struct KeyHash {
+        size_t operator()(const Key& key) const {
+            return std::hash<uint64_t>()(key.second * 0x9e3779b97f4a7c15ull ^ key.first);
+        }
+    };
+    typedef std::pair<Key, BlockRef> Entry;
+
+    void erase(std::list<Entry>::iterator it);
+
+    std::mutex mu_;
+    uint64_t capacity_;
+    uint64_t usage_ = 0;
+    std::list<Entry> lru_; // most recently used first
+    std::unordered_map<Key, std::list<Entry>::iterator, KeyHash> entries_;
+    std::atomic<uint64_t> hits_{0};
+    std::atomic<uint64_t> misses_{0};
+};
+
+// compression.cpp
+
+// True if codec is one of the SSTABLE_COMPRESSION_* values
+bool compression_supported(int codec);
+
+// Compress a block with codec. Falls back to storing the block
+// uncompressed when compression does not pay off; returns the codec used.
+uint8_t compress_block(int codec, const std::string& raw, std::string& out);
+
+// Decompress a block written with codec into exactly raw_size bytes
+bool decompress_block(uint8_t codec, const char* data, size_t len, uint32_t raw_size, std::string& out);
+
+// version.cpp
+
+// Sort a level into its canonical order (by seq for level 0, by key otherwise)
+void version_sort_level(Version& version, int level);
+
+// Total file size of the tables in a level
+uint64_t version_level_bytes(const Version& version, int level);
+
+// True if the table's key range intersects [lo, hi]
+bool table_overlaps(const TableMeta& table, const std::string& lo, const std::string& hi);
+
+// Find the only table in a non-overlapping level that may contain key
+TableRef version_find_table(const Version& version, int level, const std::string& key);
+
+// Log edit to the MANIFEST and install the resulting version as current.
+// Must be called with engine->mu held so edits are logged in the order
+// versions are installed. On failure the current version is unchanged.
+bool version_apply(sstable_engine* engine, const VersionEdit& edit);
+
+// Recover the table set from the data directory, remove orphaned tables and
+// start a new MANIFEST
+bool version_load(sstable_engine* engine);
+
+// Hard-link the tables of the current version into dir and copy the
+// MANIFEST describing them
+bool version_checkpoint(sstable_engine* engine, const std::string& dir);
+
+// Delete the obsolete tables no version lists any more, so only the
+// obsolete list holds them. Must be called with engine->mu held.
+void delete_obsolete_tables(sstable_engine* engine);
+
+// A reader's hold on a version, taken from engine->current under mu. The
+// tables it lists stay on disk while it is held; releasing the last hold on
+// an obsolete table deletes it.
+class VersionPin {
+public:
+    VersionPin(sstable_engine* engine, VersionRef version) : engine_(engine), version_(std::move(version)) {}
+    ~VersionPin();
+
+    VersionPin(const VersionPin&) = delete;
+    VersionPin& operator=(const VersionPin&) = delete;
+
+    const Version& operator*() const { return *version_; }
+    const Version* operator->() const { return version_.get(); }
+
+private:
+    sstable_engine* engine_;
+    VersionRef version_;
+};
+
+// iterator.cpp
+
+// Iterator over a memtable that is no longer modified
+std::unique_ptr<InternalIterator> memtable_iterator(MemTableRef memtable);
+
+// Iterator that shows only the newest version of each key in source at or
+// below seq
+std::unique_ptr<InternalIterator> visible_iterator(std::unique_ptr<InternalIterator> source, uint64_t seq);
+
+// merge.cpp
+
+// Fold operands, oldest first, onto existing (nullptr if the key has no
+// value) with one of the SSTABLE_MERGE_* operators. Every operator is
+// associative with a missing value as its identity, so compaction can fold
+// operands without the value they apply to.
+std::string merge_operands(int merge_operator, const std::string* existing,
+                           const std::vector<std::string>& operands);
+
+// Follows the versions of one key that a read at seq sees, newest first,
+// over sources from newest to oldest. It stops at the first version that is
+// not a merge operand, collecting the operands on the way, and folds them
+// into the key's value.
+class PointRead {
+public:
+    // Versions older than covering, the newest range tombstone over the key,
+    // are deleted; values expired by now too. With SSTABLE_MERGE_NONE an
+    // operand reads as a plain value.
+    PointRead(const std::string& key, uint64_t seq, uint64_t covering, int64_t now, int merge_operator)
+        : key_(key), seq_(seq), covering_(covering), now_(now), merge_operator_(merge_operator) {}
+
+    // True once a version that is not a merge operand was found
+    bool done() const { return done_; }
+
+    // True if the read ended at a value that had expired
+    bool expired() const { return expired_; }
+
+    // Take the versions of the key memtable or table holds until done
+    void search(const MemTable& memtable);
+    void search(const Table& table);
+
+    // Take the next older version of the key
+    void add(uint64_t seq, const MemEntry& entry);
+
+    // The value of the key; false if it has none
+    bool result(std::string& out) const;
+
+private:
+    std::string key_;
+    uint64_t seq_; // the next version wanted is at or below seq_
+    uint64_t covering_;
+    int64_t now_;
+    int merge_operator_;
+
+    bool done_ = false;
+    bool expired_ = false;
+    bool has_base_ = false;
+    std::string base_; // the value the operands apply to, if has_base_
+    std::vector<std::string> operands_; // newest first
+};
+
+// compaction.cpp
+
+// Start the background compaction thread
+void compaction_start(sstable_engine* engine);
+
+// Stop the background compaction thread, waiting for a running compaction
+void compaction_stop(sstable_engine* engine);
+
+// Wake the background thread after the table set changed
+void compaction_schedule(sstable_engine* engine);
+
+// Merge every live table into a single sorted run, dropping all shadowed
+// data. Size-tiered engines get one level-0 table; leveled engines get
+// non-overlapping tables in the deepest populated level.
+bool compaction_run_major(sstable_engine* engine);
+
+#endif // SSTABLE_INTERNAL_H
@@ -0,0 +1,523 @@
+#include "sstable_internal.h"
+#include <algorithm>
+#include <cstdio>
+#include <cstring>
+#include <fcntl.h>
+#include <sys/stat.h>
+#include <unistd.h>
+
+// SSTable file format (version 6):
+//   data section:   blocks, each <codec><raw_size><payload>. The payload,
+//                   compressed with codec, decodes to raw_size bytes (about
+//                   block_size) of <key_len><key><seq><value_len><value>...
+//                   sorted by key, then newest seq first (value_len ==
+//                   TOMBSTONE_VALUE_LEN marks a tombstone, value_len ==
+//                   EXPIRING_VALUE_LEN a value that expires, written as
+//                   <expires_at><value_len><value>, and value_len ==
+//                   MERGE_VALUE_LEN a merge operand, written as
+//                   <value_len><value>). All versions of a key are in the
+//                   same block.
+//   filter section: Bloom filter over every key (empty if disabled)
+//   index section:  <num_blocks>{<key_len><last_key><offset><size>}...
+//   footer:         <filter_start><index_start><version><FOOTER_MAGIC>
+//
+// Older tables are still readable. Version 5 has no merge operands,
+// version 4 no expiring values either, and
+// before it records have no <seq> and hold one version per key:
+//   version 3 has the same layout otherwise
+//   version 2 has blocks without a header
+//   version 1 ends with <filter_start><index_start><FILTER_MAGIC> and has
+//             one index entry per key: {<key_len><key><offset>}
+//   version 0 has no filter and ends with just <index_start>
+// Their per-key index is read as one block per entry.
+
+static const uint64_t FOOTER_MAGIC = 0x3242545353544c42ull;
+static const uint64_t FILTER_MAGIC = 0x53535442464c5452ull;
+static const uint32_t TABLE_FORMAT_VERSION = 6;
+
+// Size of the footer of version 2 and later tables
+static const size_t FOOTER_SIZE = 2 * sizeof(uint64_t) + sizeof(uint32_t) + sizeof(uint64_t);
+
+std::string table_path(const std::string& data_dir, uint32_t number) {
+    char filename[256];
+    snprintf(filename, sizeof(filename), "%s/sstable_%04u.sst", data_dir.c_str(), number);
+    return std::string(filename);
+}
+
+static bool sync_path(const std::string& path, int flags) {
+    int fd = ::open(path.c_str(), flags);
+    if (fd < 0) {
+        return false;
+    }
+    bool ok = fsync(fd) == 0;
+    ::close(fd);
+    return ok;
+}
+
+bool sync_file(const std::string& path) {
+    return sync_path(path, O_RDONLY);
+}
+
+bool sync_dir(const std::string& dir) {
+    return sync_path(dir, O_RDONLY | O_DIRECTORY);
+}
+
+TableWriter::TableWriter(const std::string& path, const sstable_options& options)
+    : path_(path),
+      file_(path, std::ios::binary | std::ios::trunc),
+      block_size_(options.block_size),
+      compression_(options.compression),
+      bloom_bits_per_key_(options.bloom_bits_per_key) {}
+
+void TableWriter::add(const std::string& key, uint64_t seq, const MemEntry& entry) {
+    // A full block is only cut between keys, so a lookup finds every
+    // version of its key in one block
+    bool new_key = num_entries_ == 0 || key != largest_;
+    if (new_key && block_.size() >= block_size_) {
+        flush_block();
+    }
+
+    if (num_entries_ == 0) {
+        smallest_ = key;
+    }
+    largest_ = key;
+    num_entries_++;
+
+    if (new_key && bloom_bits_per_key_ > 0) {
+        key_hashes_.push_back(bloom_hash(key));
+    }
+
+    // Append the record to the open block; tombstones carry no value bytes
+    put_fixed<uint32_t>(block_, key.size());
+    block_.append(key);
+    put_fixed<uint64_t>(block_, seq);
+    if (entry.deleted) {
+        put_fixed<uint32_t>(block_, TOMBSTONE_VALUE_LEN);
+        return;
+    }
+    if (entry.merge) {
+        put_fixed<uint32_t>(block_, MERGE_VALUE_LEN);
+    }
+    if (entry.expires_at != 0) {
+        put_fixed<uint32_t>(block_, EXPIRING_VALUE_LEN);
+        put_fixed<int64_t>(block_, entry.expires_at);
+    }
+    put_fixed<uint32_t>(block_, entry.value.size());
+    block_.append(entry.value);
+}
+
+void TableWriter::flush_block() {
+    if (block_.empty()) {
+        return;
+    }
+
+    std::string payload;
+    std::string header;
+    put_fixed<uint8_t>(header, compress_block(compression_, block_, payload));
+    put_fixed<uint32_t>(header, block_.size());
+
+    file_.write(header.data(), header.size());
+    file_.write(payload.data(), payload.size());
+    uint32_t size = header.size() + payload.size();
+    index_.push_back(BlockHandle{largest_, offset_, size});
+    offset_ += size;
+    block_.clear();
+}
+
+bool TableWriter::finish() {
+    flush_block();
+
+    // Write filter section
+    uint64_t filter_start = offset_;
+    std::string filter = bloom_build(key_hashes_, bloom_bits_per_key_);
+    key_hashes_.clear();
+    file_.write(filter.data(), filter.size());
+    offset_ += filter.size();
+
+    // Write index section: one entry per block
+    uint64_t index_start = offset_;
+    std::string index;
+    put_fixed<uint32_t>(index, index_.size());
+    for (const auto& handle : index_) {
+        put_fixed<uint32_t>(index, handle.last_key.size());
+        index.append(handle.last_key);
+        put_fixed<uint64_t>(index, handle.offset);
+        put_fixed<uint32_t>(index, handle.size);
+    }
+
+    // Write footer
+    put_fixed<uint64_t>(index, filter_start);
+    put_fixed<uint64_t>(index, index_start);
+    put_fixed<uint32_t>(index, TABLE_FORMAT_VERSION);
+    put_fixed<uint64_t>(index, FOOTER_MAGIC);
+    file_.write(index.data(), index.size());
+    offset_ += index.size();
+
+    file_.close();
+    return !file_.fail();
+}
+
+// Read exactly size bytes at offset. pread keeps a shared Table safe for
+// concurrent readers.
+static bool read_range(int fd, uint64_t offset, uint64_t size, std::string& out) {
+    out.resize(size);
+    uint64_t done = 0;
+    while (done < size) {
+        ssize_t n = pread(fd, &out[done], size - done, offset + done);
+        if (n <= 0) {
+            return false;
+        }
+        done += n;
+    }
+    return true;
+}
+
+// Section offsets read from the end of a table
+struct Footer {
+    uint32_t version = 0;
+    uint64_t filter_start = 0;
+    uint64_t index_start = 0;
+    uint64_t index_end = 0;
+};
+
+static bool read_footer(int fd, uint64_t file_size, Footer& footer) {
+    if (file_size < sizeof(uint64_t)) {
+        return false;
+    }
+
+    std::string raw;
+    size_t tail_size = std::min<uint64_t>(file_size, FOOTER_SIZE);
+    if (!read_range(fd, file_size - tail_size, tail_size, raw)) {
+        return false;
+    }
+    uint64_t magic = 0;
+    size_t pos = tail_size - sizeof(magic);
+    get_fixed(raw, pos, magic);
+
+    if (magic == FOOTER_MAGIC) {
+        if (file_size < FOOTER_SIZE) {
+            return false;
+        }
+        pos = 0;
+        get_fixed(raw, pos, footer.filter_start);
+        get_fixed(raw, pos, footer.index_start);
+        get_fixed(raw, pos, footer.version);
+        footer.index_end = file_size - FOOTER_SIZE;
+        if (footer.version < 2 || footer.version > TABLE_FORMAT_VERSION) {
+            return false; // Unknown or newer format version
+        }
+    } else if (magic == FILTER_MAGIC) {
+        if (file_size < 3 * sizeof(uint64_t)) {
+            return false;
+        }
+        pos = tail_size - 3 * sizeof(uint64_t);
+        get_fixed(raw, pos, footer.filter_start);
+        get_fixed(raw, pos, footer.index_start);
+        footer.version = 1;
+        footer.index_end = file_size - 3 * sizeof(uint64_t);
+    } else {
+        // The last word of a version 0 table is its index offset
+        footer.version = 0;
+        footer.filter_start = magic;
+        footer.index_start = magic;
+        footer.index_end = file_size - sizeof(magic);
+    }
+
+    return footer.filter_start <= footer.index_start && footer.index_start <= footer.index_end;
+}
+
+// Load the block index of a table. Versions before 2 index every key; each
+// entry is turned into a block holding that single record.
+static bool read_block_index(int fd, const Footer& footer, std::vector<BlockHandle>& index) {
+    std::string raw;
+    if (!read_range(fd, footer.index_start, footer.index_end - footer.index_start, raw)) {
+        return false;
+    }
+
+    size_t pos = 0;
+    uint32_t count;
+    if (!get_fixed(raw, pos, count)) {
+        return false;
+    }
+    for (uint32_t i = 0; i < count; i++) {
+        BlockHandle handle;
+        uint32_t key_len;
+        if (!get_fixed(raw, pos, key_len) || !get_string(raw, pos, key_len, handle.last_key) ||
+            !get_fixed(raw, pos, handle.offset)) {
+            return false;
+        }
+        if (footer.version >= 2 && !get_fixed(raw, pos, handle.size)) {
+            return false;
+        }
+        index.push_back(handle);
+    }
+
+    if (footer.version < 2) {
+        for (size_t i = 0; i < index.size(); i++) {
+            uint64_t end = i + 1 < index.size() ? index[i + 1].offset : footer.filter_start;
+            index[i].size = static_cast<uint32_t>(end - index[i].offset);
+        }
+    }
+    return true;
+}
+
+// Decode the record at pos in a block of a table of the given format
+// version and advance past it
+static bool parse_record(const std::string& block, size_t& pos, uint32_t version, TableRecord& record) {
+    uint32_t key_len, value_len;
+    record.seq = 0;
+    if (!get_fixed(block, pos, key_len) || !get_string(block, pos, key_len, record.key) ||
+        (version >= 4 && !get_fixed(block, pos, record.seq)) || !get_fixed(block, pos, value_len)) {
+        return false;
+    }
+    MemEntry& entry = record.entry;
+    entry.expires_at = 0;
+    entry.merge = false;
+    if (value_len == TOMBSTONE_VALUE_LEN) {
+        entry.deleted = true;
+        entry.value.clear();
+        return true;
+    }
+    entry.deleted = false;
+    if (version >= 6 && value_len == MERGE_VALUE_LEN) {
+        entry.merge = true;
+        if (!get_fixed(block, pos, value_len)) {
+            return false;
+        }
+    }
+    if (version >= 5 && value_len == EXPIRING_VALUE_LEN &&
+        (!get_fixed(block, pos, entry.expires_at) || !get_fixed(block, pos, value_len))) {
+        return false;
+    }
+    return get_string(block, pos, value_len, entry.value);
+}
+
+std::shared_ptr<Table> Table::open(const std::string& path, uint32_t number, BlockCache* block_cache) {
+    std::shared_ptr<Table> table(new Table());
+    table->fd_ = ::open(path.c_str(), O_RDONLY);
+    if (table->fd_ < 0) {
+        return nullptr;
+    }
+    table->number_ = number;
+    table->block_cache_ = block_cache;
+
+    struct stat st;
+    if (fstat(table->fd_, &st) != 0) {
+        return nullptr;
+    }
+    table->file_size_ = static_cast<uint64_t>(st.st_size);
+
+    Footer footer;
+    if (!read_footer(table->fd_, table->file_size_, footer) ||
+        !read_range(table->fd_, footer.filter_start, footer.index_start - footer.filter_start, table->filter_) ||
+        !read_block_index(table->fd_, footer, table->index_)) {
+        return nullptr;
+    }
+    table->version_ = footer.version;
+    return table;
+}
+
+Table::~Table() {
+    if (fd_ >= 0) {
+        close(fd_);
+    }
+}
+
+bool Table::read_block(const BlockHandle& handle, std::string& out) const {
+    if (version_ < 3) {
+        return read_range(fd_, handle.offset, handle.size, out);
+    }
+
+    // Undo the block's compression
+    std::string raw;
+    if (!read_range(fd_, handle.offset, handle.size, raw)) {
+        return false;
+    }
+    size_t pos = 0;
+    uint8_t codec;
+    uint32_t raw_size;
+    if (!get_fixed(raw, pos, codec) || !get_fixed(raw, pos, raw_size)) {
+        return false;
+    }
+    return decompress_block(codec, raw.data() + pos, raw.size() - pos, raw_size, out);
+}
+
+BlockRef Table::block(const BlockHandle& handle, bool fill_cache) const {
+    if (block_cache_ != nullptr) {
+        BlockRef cached = block_cache_->lookup(number_, handle.offset);
+        if (cached) {
+            return cached;
+        }
+    }
+
+    auto block = std::make_shared<std::string>();
+    if (!read_block(handle, *block)) {
+        return nullptr;
+    }
+    if (block_cache_ != nullptr && fill_cache) {
+        block_cache_->insert(number_, handle.offset, block);
+    }
+    return block;
+}
+
+bool Table::get(const std::string& key_str, uint64_t seq, uint64_t& out_seq, MemEntry& out_entry) const {
+    // The only block that can hold the key is the first whose last key is
+    // not smaller than it
+    auto it = std::lower_bound(index_.begin(), index_.end(), key_str,
+                               [](const BlockHandle& handle, const std::string& key) {
+                                   return handle.last_key < key;
+                               });
+    if (it == index_.end()) {
+        return false;
+    }
+
+    BlockRef block = this->block(*it);
+    if (!block) {
+        return false;
+    }
+
+    // Scan the block; records are sorted, so stop once past the key. The
+    // first version not newer than seq is the one visible at seq.
+    size_t pos = 0;
+    TableRecord record;
+    while (pos < block->size() && parse_record(*block, pos, version_, record)) {
+        int cmp = record.key.compare(key_str);
+        if (cmp > 0) {
+            break;
+        }
+        if (cmp == 0 && record.seq <= seq) {
+            out_seq = record.seq;
+            out_entry = std::move(record.entry);
+            return true;
+        }
+    }
+
+    return false; // Key not found
+}
+
+TableIterator::TableIterator(const std::string& path) : table_(Table::open(path)) {
+    seek_to_first();
+}
+
+TableIterator::TableIterator(std::shared_ptr<Table> table) : table_(std::move(table)) {}
+
+bool TableIterator::load_block(size_t index) {
+    valid_ = false;
+    entries_.clear();
+    pos_ = 0;
+    if (!table_ || index >= table_->index().size()) {
+        return false;
+    }
+
+    // Scans should not push the blocks point lookups rely on out of the cache
+    BlockRef block = table_->block(table_->index()[index], false);
+    if (!block) {
+        return false;
+    }
+    size_t pos = 0;
+    while (pos < block->size()) {
+        TableRecord record;
+        if (!parse_record(*block, pos, table_->version(), record)) {
+            entries_.clear();
+            return false; // Stop at a corrupt block
+        }
+        entries_.push_back(std::move(record));
+    }
+    block_index_ = index;
+    return !entries_.empty();
+}
+
+void TableIterator::seek_to_first() {
+    valid_ = load_block(0);
+}
+
+void TableIterator::seek_to_last() {
+    if (!table_ || table_->index().empty()) {
+        valid_ = false;
+        return;
+    }
+    valid_ = load_block(table_->index().size() - 1);
+    pos_ = entries_.empty() ? 0 : entries_.size() - 1;
+}
+
+void TableIterator::seek(const std::string& target) {
+    valid_ = false;
+    if (!table_) {
+        return;
+    }
+
+    // Same block choice as a point lookup, then the first record at or
+    // after target within it
+    const std::vector<BlockHandle>& index = table_->index();
+    auto it = std::lower_bound(index.begin(), index.end(), target,
+                               [](const BlockHandle& handle, const std::string& key) {
+                                   return handle.last_key < key;
+                               });
+    if (it == index.end() || !load_block(it - index.begin())) {
+        return;
+    }
+    auto record = std::lower_bound(entries_.begin(), entries_.end(), target,
+                                   [](const TableRecord& e, const std::string& key) {
+                                       return e.key < key;
+                                   });
+    if (record == entries_.end()) {
+        // Every record in the block is smaller; continue in the next one
+        valid_ = load_block(block_index_ + 1);
+        return;
+    }
+    pos_ = record - entries_.begin();
+    valid_ = true;
+}
+
+void TableIterator::next() {
+    if (!valid_) {
+        return;
+    }
+    if (++pos_ < entries_.size()) {
+        return;
+    }
+    valid_ = load_block(block_index_ + 1);
+}
+
+void TableIterator::prev() {
+    if (!valid_) {
+        return;
+    }
+    if (pos_ > 0) {
+        pos_--;
+        return;
+    }
+    if (block_index_ == 0) {
+        valid_ = false;
+        return;
+    }
+    valid_ = load_block(block_index_ - 1);
+    pos_ = entries_.empty() ? 0 : entries_.size() - 1;
+}
+
+bool load_table_meta(const std::string& path, uint32_t number, TableMeta& meta) {
+    std::shared_ptr<Table> table = Table::open(path);
+    if (!table) {
+        return false;
+    }
+
+    meta.number = number;
+    meta.path = path;
+    meta.file_size = table->file_size();
+
+    const std::vector<BlockHandle>& index = table->index();
+    if (index.empty()) {
+        return true;
+    }
+
+    // The smallest key is the first record of the first block
+    std::string block;
+    size_t pos = 0;
+    TableRecord record;
+    if (!table->read_block(index.front(), block) || !parse_record(block, pos, table->version(), record)) {
+        return false;
+    }
+    meta.smallest = record.key;
+    meta.largest = index.back().last_key;
+    return true;
+}
@@ -0,0 +1,49 @@
+#include "sstable_internal.h"
+
+std::shared_ptr<Table> TableCache::find(const TableMeta& meta) {
+    {
+        std::lock_guard<std::mutex> lock(mu_);
+        auto it = entries_.find(meta.number);
+        if (it != entries_.end()) {
+            lru_.splice(lru_.begin(), lru_, it->second);
+            hits_++;
+            return it->second->second;
+        }
+    }
+
+    // Open outside the lock so a slow open does not block cached lookups. Two
+    // lookups racing on the same table may both open it; the first insert wins.
+    misses_++;
+    std::shared_ptr<Table> table = Table::open(meta.path, meta.number, block_cache_);
+    if (!table) {
+        return nullptr;
+    }
+
+    std::lock_guard<std::mutex> lock(mu_);
+    auto it = entries_.find(meta.number);
+    if (it != entries_.end()) {
+        return it->second->second;
+    }
+    lru_.emplace_front(meta.number, table);
+    entries_[meta.number] = lru_.begin();
+    while (lru_.size() > capacity_) {
+        entries_.erase(lru_.back().first);
+        lru_.pop_back();
+    }
+    return table;
+}
+
+void TableCache::evict(uint32_t number) {
+    std::lock_guard<std::mutex> lock(mu_);
+    auto it = entries_.find(number);
+    if (it == entries_.end()) {
+        return;
+    }
+    lru_.erase(it->second);
+    entries_.erase(it);
+}
+
+size_t TableCache::size() {
+    std::lock_guard<std::mutex> lock(mu_);
+    return lru_.size();
+}
@@ -0,0 +1,598 @@
+#include "sstable_internal.h"
+#include <algorithm>
+#include <cstdio>
+#include <cstring>
+#include <dirent.h>
+#include <fcntl.h>
+#include <set>
+#include <sstream>
+#include <unistd.h>
+#include <zlib.h>
+
+// The live table set is recorded in an append-only MANIFEST of version
+// edits. Every flush or compaction appends one record listing the tables it
+// added and removed, and the version it describes only becomes current once
+// that record is on disk, so the append is the commit point:
+//
+//   record:  <u32 payload length><u32 crc32 of payload><payload>
+//   payload: a sequence of tagged fields
+//     COUNTER       <u32 highest file number handed out>
+//     LAST_SEQ      <u64 highest write sequence number>
+//     ADD_TABLE     <u8 level><u32 number><u64 seq><u64 file size>
+//                   <u32 len><smallest key><u32 len><largest key>
+//     DELETE_TABLE  <u8 level><u32 number>
+//     ADD_RANGE_DEL <u64 seq><u32 len><start key><u32 len><end key>
+//     DEL_RANGE_DEL <u64 seq>
+//
+// Range tombstones join the version when the memtable they were written to
+// is flushed, and leave it once a compaction has removed all they cover.
+//
+// Replaying the records from the start rebuilds the table set without
+// opening any table. A torn final record is an edit that never committed and
+// is ignored; table files that no record lists belong to such an edit, or to
+// inputs whose deletion was interrupted, and are removed on startup.
+//
+// Every open, and every append that would take the log past
+// MANIFEST_MAX_BYTES, starts a new MANIFEST holding a single edit that adds
+// the whole table set and every range tombstone; it is written to
+// MANIFEST.tmp and renamed into place.
+//
+// Directories written by older versions record the table set in a TABLES
+// snapshot file, or not at all; they are migrated on first open.
+
+static const uint8_t TAG_COUNTER = 1;
+static const uint8_t TAG_LAST_SEQ = 2;
+static const uint8_t TAG_ADD_TABLE = 3;
+static const uint8_t TAG_DELETE_TABLE = 4;
+static const uint8_t TAG_ADD_RANGE_DEL = 5;
+static const uint8_t TAG_DEL_RANGE_DEL = 6;
+
+static const uint64_t MANIFEST_MAX_BYTES = 4 * 1024 * 1024;
+
+static std::string manifest_path(const std::string& data_dir) {
+    return data_dir + "/MANIFEST";
+}
+
+static std::string tables_path(const std::string& data_dir) {
+    return data_dir + "/TABLES";
+}
+
+void version_sort_level(Version& version, int level) {
+    std::vector<TableRef>& files = version.levels[level];
+    if (level == 0) {
+        std::sort(files.begin(), files.end(), [](const TableRef& a, const TableRef& b) {
+            return a->seq < b->seq;
+        });
+    } else {
+        std::sort(files.begin(), files.end(), [](const TableRef& a, const TableRef& b) {
+            return a->smallest < b->smallest;
+        });
+    }
+}
+
+uint64_t version_level_bytes(const Version& version, int level) {
+    uint64_t total = 0;
+    for (const auto& table : version.levels[level]) {
+        total += table->file_size;
+    }
+    return total;
+}
+
+bool table_overlaps(const TableMeta& table, const std::string& lo, const std::string& hi) {
+    return !(table.largest < lo || table.smallest > hi);
+}
+
+TableRef version_find_table(const Version& version, int level, const std::string& key) {
+    const std::vector<TableRef>& files = version.levels[level];
+    auto it = std::lower_bound(files.begin(), files.end(), key,
+                               [](const TableRef& table, const std::string& k) {
+                                   return table->largest < k;
+                               });
+    if (it == files.end() || key < (*it)->smallest) {
+        return nullptr;
+    }
+    return *it;
+}
+
+// Apply an edit to a version. Levels the edit touches are re-sorted.
+static void apply_edit(Version& version, const VersionEdit& edit) {
+    bool touched[NUM_LEVELS] = {};
+    for (const auto& deleted : edit.deleted) {
+        std::vector<TableRef>& files = version.levels[deleted.first];
+        files.erase(std::remove_if(files.begin(), files.end(), [&deleted](const TableRef& table) {
+            return table->number == deleted.second;
+        }), files.end());
+    }
+    for (const auto& added : edit.added) {
+        version.levels[added.first].push_back(added.second);
+        touched[added.first] = true;
+    }
+    for (int level = 0; level < NUM_LEVELS; level++) {
+        if (touched[level]) {
+            version_sort_level(version, level);
+        }
+    }
+
+    RangeTombstones& range_dels = version.range_dels;
+    for (uint64_t seq : edit.deleted_range_dels) {
+        range_dels.erase(std::remove_if(range_dels.begin(), range_dels.end(), [seq](const RangeTombstone& t) {
+            return t.seq == seq;
+        }), range_dels.end());
+    }
+    range_dels.insert(range_dels.end(), edit.added_range_dels.begin(), edit.added_range_dels.end());
+}
+
+static void encode_edit(const VersionEdit& edit, std::string& payload) {
+    put_fixed(payload, TAG_COUNTER);
+    put_fixed(payload, edit.sstable_counter);
+    put_fixed(payload, TAG_LAST_SEQ);
+    put_fixed(payload, edit.last_seq);
+    for (const auto& deleted : edit.deleted) {
+        put_fixed(payload, TAG_DELETE_TABLE);
+        put_fixed(payload, static_cast<uint8_t>(deleted.first));
+        put_fixed(payload, deleted.second);
+    }
+    for (const auto& added : edit.added) {
+        const TableMeta& table = *added.second;
+        put_fixed(payload, TAG_ADD_TABLE);
+        put_fixed(payload, static_cast<uint8_t>(added.first));
+        put_fixed(payload, table.number);
+        put_fixed(payload, table.seq);
+        put_fixed(payload, table.file_size);
+        put_string(payload, table.smallest);
+        put_string(payload, table.largest);
+    }
+    for (uint64_t seq : edit.deleted_range_dels) {
+        put_fixed(payload, TAG_DEL_RANGE_DEL);
+        put_fixed(payload, seq);
+    }
+    for (const auto& tombstone : edit.added_range_dels) {
+        put_fixed(payload, TAG_ADD_RANGE_DEL);
+        put_fixed(payload, tombstone.seq);
+        put_string(payload, tombstone.start);
+        put_string(payload, tombstone.end);
+    }
+}
+
+static bool decode_edit(const std::string& data_dir, const std::string& payload, VersionEdit& edit) {
+    size_t pos = 0;
+    while (pos < payload.size()) {
+        uint8_t tag, level;
+        uint32_t len;
+        if (!get_fixed(payload, pos, tag)) {
+            return false;
+        }
+        switch (tag) {
+        case TAG_COUNTER:
+            if (!get_fixed(payload, pos, edit.sstable_counter)) {
+                return false;
+            }
+            break;
+        case TAG_LAST_SEQ:
+            if (!get_fixed(payload, pos, edit.last_seq)) {
+                return false;
+            }
+            break;
+        case TAG_ADD_TABLE: {
+            auto table = std::make_shared<TableMeta>();
+            if (!get_fixed(payload, pos, level) || level >= NUM_LEVELS ||
+                !get_fixed(payload, pos, table->number) || !get_fixed(payload, pos, table->seq) ||
+                !get_fixed(payload, pos, table->file_size) ||
+                !get_fixed(payload, pos, len) || !get_string(payload, pos, len, table->smallest) ||
+                !get_fixed(payload, pos, len) || !get_string(payload, pos, len, table->largest)) {
+                return false;
+            }
+            table->path = table_path(data_dir, table->number);
+            edit.added.emplace_back(level, table);
+            break;
+        }
+        case TAG_DELETE_TABLE: {
+            uint32_t number;
+            if (!get_fixed(payload, pos, level) || level >= NUM_LEVELS ||
+                !get_fixed(payload, pos, number)) {
+                return false;
+            }
+            edit.deleted.emplace_back(level, number);
+            break;
+        }
+        case TAG_ADD_RANGE_DEL: {
+            RangeTombstone tombstone;
+            if (!get_fixed(payload, pos, tombstone.seq) ||
+                !get_fixed(payload, pos, len) || !get_string(payload, pos, len, tombstone.start) ||
+                !get_fixed(payload, pos, len) || !get_string(payload, pos, len, tombstone.end)) {
+                return false;
+            }
+            edit.added_range_dels.push_back(tombstone);
+            break;
+        }
+        case TAG_DEL_RANGE_DEL: {
+            uint64_t seq;
+            if (!get_fixed(payload, pos, seq)) {
+                return false;
+            }
+            edit.deleted_range_dels.push_back(seq);
+            break;
+        }
+        default:
+            return false;
+        }
+    }
+    return true;
+}
+
+static void encode_record(const VersionEdit& edit, std::string& record) {
+    std::string payload;
+    encode_edit(edit, payload);
+    put_fixed(record, static_cast<uint32_t>(payload.size()));
+    put_fixed(record, static_cast<uint32_t>(crc32(0, reinterpret_cast<const Bytef*>(payload.data()), payload.size())));
+    record.append(payload);
+}
+
+static bool write_all(int fd, const std::string& data) {
+    size_t done = 0;
+    while (done < data.size()) {
+        ssize_t n = ::write(fd, data.data() + done, data.size() - done);
+        if (n <= 0) {
+            return false;
+        }
+        done += n;
+    }
+    return true;
+}
+
+// Replace the MANIFEST with a single edit that adds every table and range
+// tombstone in version, and reopen it for appending
+static bool write_snapshot(sstable_engine* engine, const Version& version) {
+    VersionEdit edit;
+    edit.sstable_counter = engine->sstable_counter;
+    edit.last_seq = engine->last_seq;
+    edit.added_range_dels = version.range_dels;
+    for (int level = 0; level < NUM_LEVELS; level++) {
+        for (const auto& table : version.levels[level]) {
+            edit.added.emplace_back(level, table);
+        }
+    }
+    std::string record;
+    encode_record(edit, record);
+
+    std::string path = manifest_path(engine->data_dir);
+    std::string tmp = path + ".tmp";
+    int fd = ::open(tmp.c_str(), O_WRONLY | O_CREAT | O_TRUNC, 0644);
+    if (fd < 0) {
+        return false;
+    }
+    bool ok = write_all(fd, record) && fsync(fd) == 0;
+    ::close(fd);
+    if (!ok || std::rename(tmp.c_str(), path.c_str()) != 0 || !sync_dir(engine->data_dir)) {
+        std::remove(tmp.c_str());
+        return false;
+    }
+
+    fd = ::open(path.c_str(), O_WRONLY | O_APPEND);
+    if (fd < 0) {
+        return false;
+    }
+    if (engine->manifest_fd >= 0) {
+        ::close(engine->manifest_fd);
+    }
+    engine->manifest_fd = fd;
+    engine->manifest_size = record.size();
+    return true;
+}
+
+bool version_apply(sstable_engine* engine, const VersionEdit& edit) {
+    VersionEdit logged = edit;
+    logged.sstable_counter = engine->sstable_counter;
+    logged.last_seq = engine->last_seq;
+
+    auto next = std::make_shared<Version>(*engine->current);
+    apply_edit(*next, logged);
+
+    if (engine->manifest_size >= MANIFEST_MAX_BYTES) {
+        // Start a new log from the resulting version instead of growing it
+        if (!write_snapshot(engine, *next)) {
+            return false;
+        }
+    } else {
+        std::string record;
+        encode_record(logged, record);
+        if (!write_all(engine->manifest_fd, record) || fdatasync(engine->manifest_fd) != 0) {
+            // A partial record may have been written; make the next edit
+            // start a new log rather than append after it
+            engine->manifest_size = MANIFEST_MAX_BYTES;
+            return false;
+        }
+        engine->manifest_size += record.size();
+    }
+
+    engine->current = next;
+    return true;
+}
+
+static bool read_file(const std::string& path, std::string& out) {
+    std::ifstream file(path, std::ios::binary);
+    if (!file.is_open()) {
+        return false;
+    }
+    std::ostringstream contents;
+    contents << file.rdbuf();
+    out = contents.str();
+    return true;
+}
+
+// Rebuild the table set from the MANIFEST records
+static bool replay_manifest(sstable_engine* engine, const std::string& contents, Version& version) {
+    size_t pos = 0;
+    while (pos < contents.size()) {
+        uint32_t len = 0, crc = 0;
+        bool complete = get_fixed(contents, pos, len) && get_fixed(contents, pos, crc) &&
+                        contents.size() - pos >= len;
+        std::string payload;
+        if (complete) {
+            payload.assign(contents, pos, len);
+            pos += len;
+        }
+        if (!complete) {
+            return true; // Torn final record
+        }
+        if (crc32(0, reinterpret_cast<const Bytef*>(payload.data()), payload.size()) != crc) {
+            // Only the last record can be torn by a crash during an append;
+            // damage anywhere else would lose committed edits
+            return pos == contents.size();
+        }
+
+        VersionEdit edit;
+        if (!decode_edit(engine->data_dir, payload, edit)) {
+            return false;
+        }
+        apply_edit(version, edit);
+        engine->sstable_counter = std::max(engine->sstable_counter, edit.sstable_counter);
+        engine->last_seq = std::max(engine->last_seq, edit.last_seq);
+    }
+    return true;
+}
+
+// Parse "sstable_<number>.sst" followed by suffix; anything else in the
+// data directory is ignored
+static bool parse_table_name(const char* name, const char* suffix, uint32_t& number) {
+    unsigned int parsed;
+    int consumed = 0;
+    if (sscanf(name, "sstable_%u.sst%n", &parsed, &consumed) != 1) {
+        return false;
+    }
+    if (std::strcmp(name + consumed, suffix) != 0) {
+        return false;
+    }
+    number = parsed;
+    return true;
+}
+
+// List the file numbers of every SSTable in the data directory, or of every
+// table a flush was still writing under its temporary name
+static bool list_table_files(const std::string& data_dir, std::vector<uint32_t>& numbers,
+                             const char* suffix = "") {
+    DIR* dir = opendir(data_dir.c_str());
+    if (dir == nullptr) {
+        return false;
+    }
+    while (struct dirent* ent = readdir(dir)) {
+        uint32_t number;
+        if (parse_table_name(ent->d_name, suffix, number)) {
+            numbers.push_back(number);
+        }
+    }
+    closedir(dir);
+    std::sort(numbers.begin(), numbers.end());
+    return true;
+}
+
+// Directories written before the table set was recorded settled an
+// interrupted compaction from a marker listing the inputs still to delete.
+static void recover_legacy_compaction(const std::string& data_dir) {
+    std::string tmp = data_dir + "/compaction.tmp";
+    std::string marker = data_dir + "/COMPACTION_PENDING";
+
+    std::ifstream tmp_file(tmp);
+    bool output_pending = tmp_file.good();
+    tmp_file.close();
+
+    std::ifstream marker_file(marker);
+    if (marker_file.good() && !output_pending) {
+        uint32_t number;
+        while (marker_file >> number) {
+            std::remove(table_path(data_dir, number).c_str());
+        }
+    }
+    marker_file.close();
+
+    std::remove(tmp.c_str());
+    std::remove(marker.c_str());
+}
+
+static bool load_table(sstable_engine* engine, uint32_t number, uint64_t seq, TableRef& out) {
+    auto meta = std::make_shared<TableMeta>();
+    if (!load_table_meta(table_path(engine->data_dir, number), number, *meta)) {
+        return false;
+    }
+    meta->seq = seq;
+    out = meta;
+    return true;
+}
+
+// Read the table set from a TABLES snapshot file:
+//
+//   counter <highest file number> <last seq>
+//   <level> <file number> <seq>
+//   ...
+static bool load_tables_file(sstable_engine* engine, std::ifstream& file, Version& version) {
+    std::string line;
+    while (std::getline(file, line)) {
+        if (line.empty()) {
+            continue;
+        }
+        std::istringstream fields(line);
+        if (line.compare(0, 8, "counter ") == 0) {
+            std::string tag;
+            fields >> tag >> engine->sstable_counter >> engine->last_seq;
+            continue;
+        }
+
+        int level;
+        uint32_t number;
+        uint64_t seq;
+        if (!(fields >> level >> number >> seq) || level < 0 || level >= NUM_LEVELS) {
+            return false;
+        }
+        TableRef table;
+        if (!load_table(engine, number, seq, table)) {
+            return false;
+        }
+        version.levels[level].push_back(table);
+    }
+    return true;
+}
+
+// Directories created before any table set was recorded hold only flushed
+// level-0 tables, whose file numbers give their age
+static void load_unrecorded_tables(sstable_engine* engine, Version& version) {
+    recover_legacy_compaction(engine->data_dir);
+    std::vector<uint32_t> on_disk;
+    if (!list_table_files(engine->data_dir, on_disk)) {
+        return;
+    }
+    for (uint32_t number : on_disk) {
+        TableRef table;
+        if (!load_table(engine, number, number, table)) {
+            continue;
+        }
+        version.levels[0].push_back(table);
+        engine->last_seq = std::max<uint64_t>(engine->last_seq, number);
+    }
+}
+
+bool version_load(sstable_engine* engine) {
+    // A flush that crashed before renaming its table never committed; its
+    // data is still in the WAL
+    std::vector<uint32_t> temp_tables;
+    if (!list_table_files(engine->data_dir, temp_tables, ".tmp")) {
+        return false;
+    }
+    for (uint32_t number : temp_tables) {
+        std::remove((table_path(engine->data_dir, number) + ".tmp").c_str());
+    }
+
+    auto version = std::make_shared<Version>();
+    std::string manifest;
+    bool recorded = true;
+    if (read_file(manifest_path(engine->data_dir), manifest)) {
+        if (!replay_manifest(engine, manifest, *version)) {
+            return false;
+        }
+    } else {
+        std::ifstream tables(tables_path(engine->data_dir));
+        if (tables.is_open()) {
+            if (!load_tables_file(engine, tables, *version)) {
+                return false;
+            }
+        } else {
+            load_unrecorded_tables(engine, *version);
+            recorded = false;
+        }
+    }
+    for (int level = 0; level < NUM_LEVELS; level++) {
+        version_sort_level(*version, level);
+    }
+
+    std::vector<uint32_t> on_disk;
+    if (!list_table_files(engine->data_dir, on_disk)) {
+        return false;
+    }
+    std::set<uint32_t> live;
+    for (int level = 0; level < NUM_LEVELS; level++) {
+        for (const auto& table : version->levels[level]) {
+            if (!std::binary_search(on_disk.begin(), on_disk.end(), table->number)) {
+                return false; // A committed table is missing
+            }
+            live.insert(table->number);
+        }
+    }
+
+    // Anything not in the table set never committed or was already replaced
+    for (uint32_t number : on_disk) {
+        if (recorded && live.count(number) == 0) {
+            std::remove(table_path(engine->data_dir, number).c_str());
+        }
+        engine->sstable_counter = std::max(engine->sstable_counter, number);
+    }
+
+    // Start a fresh log; this also drops a torn final record
+    engine->current = version;
+    if (!write_snapshot(engine, *version)) {
+        return false;
+    }
+    std::remove(tables_path(engine->data_dir).c_str());
+    return true;
+}
+
+void delete_obsolete_tables(sstable_engine* engine) {
+    // Pairs with the fence in ~VersionPin: either the pin sees has_obsolete
+    // set, or this sees the reference it dropped
+    std::atomic_thread_fence(std::memory_order_seq_cst);
+    std::vector<TableRef> remaining;
+    for (auto& table : engine->obsolete) {
+        if (table.use_count() > 1) {
+            remaining.push_back(std::move(table));
+            continue;
+        }
+        engine->table_cache->evict(table->number);
+        engine->block_cache->erase_table(table->number);
+        std::remove(table->path.c_str());
+    }
+    engine->obsolete.swap(remaining);
+    engine->has_obsolete = !engine->obsolete.empty();
+}
+
+VersionPin::~VersionPin() {
+    version_.reset();
+    // Lookups release pins all the time; only take mu if a compaction left
+    // tables behind that this pin may have been the last to hold
+    std::atomic_thread_fence(std::memory_order_seq_cst);
+    if (!engine_->has_obsolete) {
+        return;
+    }
+    std::lock_guard<std::mutex> lock(engine_->mu);
+    delete_obsolete_tables(engine_);
+}
+
+bool version_checkpoint(sstable_engine* engine, const std::string& dir) {
+    // The manifest is read under mu, so it describes exactly this version
+    VersionRef current;
+    std::string manifest;
+    {
+        std::lock_guard<std::mutex> lock(engine->mu);
+        if (!read_file(manifest_path(engine->data_dir), manifest)) {
+            return false;
+        }
+        current = engine->current;
+    }
+
+    // Pinning the version keeps a compaction from deleting its tables
+    // before they are linked
+    VersionPin version(engine, std::move(current));
+    for (int level = 0; level < NUM_LEVELS; level++) {
+        for (const auto& table : version->levels[level]) {
+            if (::link(table->path.c_str(), table_path(dir, table->number).c_str()) != 0) {
+                return false;
+            }
+        }
+    }
+
+    std::string path = manifest_path(dir);
+    int fd = ::open(path.c_str(), O_WRONLY | O_CREAT | O_TRUNC, 0644);
+    if (fd < 0) {
+        return false;
+    }
+    bool ok = write_all(fd, manifest) && fsync(fd) == 0;
+    ::close(fd);
+    return ok;
+}